@@ -0,0 +1,121 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Command rpcsoak runs a long-lived mix of RPC traffic against an in-process
+// server while injecting faults, and checks that the rpc package keeps its
+// invariants:
+//
+//   - every call completes, either with the reply the server sent or with an
+//     error, before the call timeout;
+//   - no goroutines are leaked once clients and server are shut down;
+//   - the heap stays below a configured bound.
+//
+// The traffic mix covers plain calls, slow calls, calls that fail, call
+// groups, pooled calls, streaming calls and duplex calls. Streaming calls
+// must deliver every value in order and end with io.EOF, and duplex calls
+// must answer every value sent, in order, and end once the client stops
+// sending. The json codec does not carry streams, so with it neither is
+// run. Faults close connections from either side at random, and clients
+// reconnect periodically.
+//
+// rpcsoak exits with status 1 if any invariant was violated.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type config struct {
+	duration       time.Duration
+	workers        int
+	codec          string
+	mix            map[string]int
+	callTimeout    time.Duration
+	reconnectEvery time.Duration
+	faultRate      float64
+	poolConns      int
+	reportEvery    time.Duration
+	maxHeapMB      uint64
+	goroutineSlack int
+	seed           int64
+}
+
+func main() {
+	cfg := config{}
+	var mix string
+	flag.DurationVar(&cfg.duration, "duration", time.Hour, "how long to run")
+	flag.IntVar(&cfg.workers, "workers", 16, "number of concurrent workers, each with its own connection")
+	flag.StringVar(&cfg.codec, "codec", "msgpack", "codec to use: msgpack or json")
+	flag.StringVar(&mix, "mix", "call=50,slow=10,error=10,group=10,pool=10,stream=5,duplex=5", "relative weights of the operations to run")
+	flag.DurationVar(&cfg.callTimeout, "call-timeout", 10*time.Second, "time after which an unanswered call counts as a lost response")
+	flag.DurationVar(&cfg.reconnectEvery, "reconnect-every", 30*time.Second, "how often each worker replaces its connection; 0 disables")
+	flag.Float64Var(&cfg.faultRate, "fault-rate", 0.001, "probability that an operation is preceded by an injected fault")
+	flag.IntVar(&cfg.poolConns, "pool-conns", 4, "maximum connections of the shared client pool")
+	flag.DurationVar(&cfg.reportEvery, "report-every", 10*time.Second, "how often to log progress")
+	flag.Uint64Var(&cfg.maxHeapMB, "max-heap-mb", 512, "heap in use, in MiB, above which memory counts as unbounded")
+	flag.IntVar(&cfg.goroutineSlack, "goroutine-slack", 5, "goroutines allowed above the starting count after shutdown")
+	flag.Int64Var(&cfg.seed, "seed", time.Now().UnixNano(), "seed for the random number generator")
+	flag.Parse()
+
+	var err error
+	if cfg.mix, err = parseMix(mix); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.codec != "msgpack" && cfg.codec != "json" {
+		log.Fatalf("unknown codec %q", cfg.codec)
+	}
+	if cfg.codec == "json" {
+		for _, op := range streamOps {
+			if cfg.mix[op] > 0 {
+				log.Printf("rpcsoak: the json codec does not carry streams, not running %s", op)
+			}
+			delete(cfg.mix, op)
+		}
+	}
+	total := 0
+	for _, w := range cfg.mix {
+		total += w
+	}
+	if total == 0 {
+		log.Fatal("the mix has no operations to run")
+	}
+
+	log.Printf("rpcsoak: running for %s with seed %d", cfg.duration, cfg.seed)
+	violations, err := run(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(violations) > 0 {
+		for _, v := range violations {
+			log.Println("VIOLATION:", v)
+		}
+		os.Exit(1)
+	}
+	log.Println("rpcsoak: all invariants held")
+}
+
+// parseMix parses a comma separated list of op=weight pairs.
+func parseMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		op, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q", part)
+		}
+		if _, known := ops[op]; !known {
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", op, weight)
+		}
+		mix[op] = w
+	}
+	return mix, nil
+}
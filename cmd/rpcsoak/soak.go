@@ -0,0 +1,513 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/net-rpc-msgpackrpc"
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+	"github.com/hashicorp/consul-net-rpc/net/rpc/jsonrpc"
+)
+
+// maxViolations is the number of violations kept for the final report.
+const maxViolations = 100
+
+var errRequestedFailure = errors.New("soak: requested failure")
+
+// Soak is the service the workers call.
+type Soak struct{}
+
+type EchoArgs struct {
+	ID      uint64
+	Payload []byte
+	Delay   time.Duration
+	Fail    bool
+}
+
+type EchoReply struct {
+	ID  uint64
+	Sum uint32
+}
+
+// Echo replies with the call's ID and a checksum of its payload, after
+// sleeping for the requested delay.
+func (Soak) Echo(args *EchoArgs, reply *EchoReply) error {
+	time.Sleep(args.Delay)
+	if args.Fail {
+		return errRequestedFailure
+	}
+	reply.ID = args.ID
+	reply.Sum = crc32.ChecksumIEEE(args.Payload)
+	return nil
+}
+
+type CountArgs struct {
+	ID uint64
+	N  int
+}
+
+type CountItem struct {
+	ID    uint64
+	Index int
+}
+
+// Count sends N items carrying the call's ID, numbered from 0.
+func (Soak) Count(ctx context.Context, args *CountArgs, stream *rpc.SendStream[CountItem]) error {
+	for i := 0; i < args.N; i++ {
+		if err := stream.Send(CountItem{ID: args.ID, Index: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EchoStream answers each value the caller sends as Echo does, in order,
+// until the caller stops sending.
+func (Soak) EchoStream(ctx context.Context, _ struct{}, stream *rpc.DuplexStream[EchoArgs, EchoReply]) error {
+	for {
+		args, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply := EchoReply{ID: args.ID, Sum: crc32.ChecksumIEEE(args.Payload)}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// streamOps are the operations that need a codec carrying the stream fields.
+var streamOps = []string{"stream", "duplex"}
+
+// ops are the operations a worker can run, by the name used in -mix.
+var ops = map[string]func(w *worker) error{
+	"call": func(w *worker) error {
+		return w.call(w.newArgs(0, false))
+	},
+	"slow": func(w *worker) error {
+		return w.call(w.newArgs(time.Duration(w.rng.Intn(50))*time.Millisecond, false))
+	},
+	"error": func(w *worker) error {
+		return w.call(w.newArgs(0, true))
+	},
+	"group":  (*worker).group,
+	"pool":   (*worker).pooled,
+	"stream": (*worker).stream,
+	"duplex": (*worker).duplex,
+}
+
+type harness struct {
+	cfg      config
+	server   *rpc.Server
+	listener net.Listener
+	pool     *rpc.ClientPool
+	serving  sync.WaitGroup
+	nextID   atomic.Uint64
+
+	calls      atomic.Uint64
+	transport  atomic.Uint64 // calls failed by injected faults or reconnects
+	faults     atomic.Uint64
+	reconnects atomic.Uint64
+
+	mu         sync.Mutex // protects following
+	violations []string
+	violated   int
+}
+
+func (h *harness) violate(format string, args ...interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.violated++
+	if len(h.violations) < maxViolations {
+		h.violations = append(h.violations, fmt.Sprintf(format, args...))
+	}
+}
+
+func (h *harness) dial() (*rpc.Client, error) {
+	conn, err := net.Dial("tcp", h.listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	if h.cfg.codec == "json" {
+		return jsonrpc.NewClient(conn), nil
+	}
+	return msgpackrpc.NewClient(conn), nil
+}
+
+func (h *harness) serve() {
+	defer h.serving.Done()
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		var codec rpc.ServerCodec
+		if h.cfg.codec == "json" {
+			codec = jsonrpc.NewServerCodec(conn)
+		} else {
+			codec = msgpackrpc.NewServerCodec(conn)
+		}
+		h.serving.Add(1)
+		go func() {
+			defer h.serving.Done()
+			// Requests are served concurrently so that duplex calls can
+			// read the values sent to them.
+			h.server.ServeCodecContext(context.Background(), codec)
+		}()
+	}
+}
+
+// run soaks a server for cfg.duration and returns the invariant violations
+// it observed.
+func run(cfg config) ([]string, error) {
+	baseline := runtime.NumGoroutine()
+
+	h := &harness{cfg: cfg, server: rpc.NewServer()}
+	if err := h.server.Register(Soak{}); err != nil {
+		return nil, err
+	}
+	var err error
+	if h.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	h.serving.Add(1)
+	go h.serve()
+	h.pool = rpc.NewClientPool(h.dial,
+		rpc.WithPoolMaxConns(cfg.poolConns),
+		rpc.WithPoolIdleTimeout(time.Second))
+
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+	for i := 0; i < cfg.workers; i++ {
+		w := &worker{h: h, rng: rand.New(rand.NewSource(cfg.seed + int64(i)))}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			w.run(stop)
+		}()
+	}
+
+	ticker := time.NewTicker(cfg.reportEvery)
+	deadline := time.After(cfg.duration)
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			h.report()
+		case <-deadline:
+			break loop
+		}
+	}
+	ticker.Stop()
+	close(stop)
+	workers.Wait()
+	h.pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.callTimeout)
+	defer cancel()
+	if err := h.server.Shutdown(ctx); err != nil {
+		h.violate("server shutdown: %v", err)
+	}
+	h.listener.Close()
+	h.serving.Wait()
+	h.report()
+	h.checkGoroutines(baseline)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.violated > len(h.violations) {
+		h.violations = append(h.violations, fmt.Sprintf("and %d more", h.violated-len(h.violations)))
+	}
+	return h.violations, nil
+}
+
+func (h *harness) report() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	heapMB := ms.HeapInuse >> 20
+	if heapMB > h.cfg.maxHeapMB {
+		h.violate("heap in use is %d MiB, above the %d MiB bound", heapMB, h.cfg.maxHeapMB)
+	}
+	h.mu.Lock()
+	violated := h.violated
+	h.mu.Unlock()
+	log.Printf("calls=%d transport-errors=%d faults=%d reconnects=%d conns=%d goroutines=%d heap=%dMiB violations=%d",
+		h.calls.Load(), h.transport.Load(), h.faults.Load(), h.reconnects.Load(),
+		len(h.server.ActiveConns()), runtime.NumGoroutine(), heapMB, violated)
+}
+
+// checkGoroutines waits for goroutines to exit after shutdown and reports a
+// leak if more than the allowed slack remain.
+func (h *harness) checkGoroutines(baseline int) {
+	limit := baseline + h.cfg.goroutineSlack
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > limit && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > limit {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		log.Printf("goroutines after shutdown:\n%s", buf)
+		h.violate("%d goroutines after shutdown, %d at start", n, baseline)
+	}
+}
+
+type worker struct {
+	h        *harness
+	rng      *rand.Rand
+	client   *rpc.Client
+	dialedAt time.Time
+}
+
+func (w *worker) run(stop <-chan struct{}) {
+	defer func() {
+		if w.client != nil {
+			w.client.Close()
+		}
+	}()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if w.client == nil || (w.h.cfg.reconnectEvery > 0 && time.Since(w.dialedAt) > w.h.cfg.reconnectEvery) {
+			if !w.reconnect() {
+				continue
+			}
+		}
+		if w.rng.Float64() < w.h.cfg.faultRate {
+			w.injectFault()
+		}
+		if err := ops[w.pick()](w); err != nil {
+			// The connection may have been closed by a fault.
+			w.h.transport.Add(1)
+			w.client.Close()
+			w.client = nil
+		}
+	}
+}
+
+func (w *worker) reconnect() bool {
+	if w.client != nil {
+		w.client.Close()
+		w.h.reconnects.Add(1)
+	}
+	client, err := w.h.dial()
+	if err != nil {
+		w.h.violate("dial: %v", err)
+		time.Sleep(100 * time.Millisecond)
+		w.client = nil
+		return false
+	}
+	w.client, w.dialedAt = client, time.Now()
+	return true
+}
+
+// injectFault closes a random connection from the server's side, or this
+// worker's connection from the client's side.
+func (w *worker) injectFault() {
+	w.h.faults.Add(1)
+	if w.rng.Intn(2) == 0 {
+		w.client.Close()
+		return
+	}
+	addrs := w.h.server.ActiveConns()
+	if len(addrs) == 0 {
+		return
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+	w.h.server.CloseConn(addrs[w.rng.Intn(len(addrs))])
+}
+
+func (w *worker) pick() string {
+	names := make([]string, 0, len(w.h.cfg.mix))
+	total := 0
+	for name, weight := range w.h.cfg.mix {
+		names = append(names, name)
+		total += weight
+	}
+	sort.Strings(names)
+	n := w.rng.Intn(total)
+	for _, name := range names {
+		if n < w.h.cfg.mix[name] {
+			return name
+		}
+		n -= w.h.cfg.mix[name]
+	}
+	return names[len(names)-1]
+}
+
+func (w *worker) newArgs(delay time.Duration, fail bool) *EchoArgs {
+	payload := make([]byte, w.rng.Intn(1024))
+	w.rng.Read(payload)
+	return &EchoArgs{ID: w.h.nextID.Add(1), Payload: payload, Delay: delay, Fail: fail}
+}
+
+// check validates the outcome of a call made with args. It returns err if
+// the call failed for a reason other than the requested failure.
+func (w *worker) check(args *EchoArgs, reply *EchoReply, err error) error {
+	w.h.calls.Add(1)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		w.h.violate("lost response to call %d", args.ID)
+		return nil
+	case args.Fail && err != nil && err.Error() == errRequestedFailure.Error():
+		return nil
+	case err != nil:
+		return err
+	case args.Fail:
+		w.h.violate("call %d succeeded but should have failed", args.ID)
+	case reply.ID != args.ID || reply.Sum != crc32.ChecksumIEEE(args.Payload):
+		w.h.violate("call %d got the reply to call %d (checksum %x)", args.ID, reply.ID, reply.Sum)
+	}
+	return nil
+}
+
+func (w *worker) call(args *EchoArgs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.h.cfg.callTimeout)
+	defer cancel()
+	reply := new(EchoReply)
+	err := w.client.CallContext(ctx, "Soak.Echo", args, reply)
+	return w.check(args, reply, err)
+}
+
+func (w *worker) pooled() error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.h.cfg.callTimeout)
+	defer cancel()
+	args, reply := w.newArgs(0, false), new(EchoReply)
+	err := w.h.pool.CallContext(ctx, "Soak.Echo", args, reply)
+	if err := w.check(args, reply, err); err != nil {
+		// The pooled connection failed, not this worker's.
+		w.h.transport.Add(1)
+	}
+	return nil
+}
+
+func (w *worker) group() error {
+	const size = 4
+	g := w.client.NewCallGroup(2)
+	args := make([]*EchoArgs, size)
+	replies := make([]*EchoReply, size)
+	for i := range args {
+		args[i], replies[i] = w.newArgs(0, false), new(EchoReply)
+		g.Go("Soak.Echo", args[i], replies[i])
+	}
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			w.h.calls.Add(size)
+			return err
+		}
+	case <-time.After(w.h.cfg.callTimeout):
+		w.h.violate("lost response in call group starting at call %d", args[0].ID)
+		return errors.New("call group timed out")
+	}
+	for i := range args {
+		w.check(args[i], replies[i], nil)
+	}
+	return nil
+}
+
+// stream checks that a streaming call delivers every value the server sends,
+// in order, and then ends.
+func (w *worker) stream() error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.h.cfg.callTimeout)
+	defer cancel()
+	args := &CountArgs{ID: w.h.nextID.Add(1), N: 1 + w.rng.Intn(64)}
+	s, err := rpc.OpenStream[CountItem](ctx, w.client, "Soak.Count", args)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	w.h.calls.Add(1)
+	for i := 0; ; i++ {
+		item, err := s.Recv()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			w.h.violate("stream %d stalled after %d of %d values", args.ID, i, args.N)
+			return nil
+		case err == io.EOF:
+			if i != args.N {
+				w.h.violate("stream %d delivered %d values, want %d", args.ID, i, args.N)
+			}
+			return nil
+		case err != nil:
+			return err
+		case item.ID != args.ID || item.Index != i:
+			w.h.violate("stream %d got value %d of stream %d in place of value %d", args.ID, item.Index, item.ID, i)
+			return nil
+		}
+	}
+}
+
+// duplex checks that a duplex call answers every value sent to it, in order,
+// and ends once the worker stops sending.
+func (w *worker) duplex() error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.h.cfg.callTimeout)
+	defer cancel()
+	sent := make([]*EchoArgs, 1+w.rng.Intn(64))
+	for i := range sent {
+		sent[i] = w.newArgs(0, false)
+	}
+	d, err := rpc.OpenDuplex[EchoArgs, EchoReply](ctx, w.client, "Soak.EchoStream", struct{}{})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	w.h.calls.Add(1)
+
+	// Values are sent while replies are read, since the call only lets
+	// either side get a small window ahead of the other.
+	sendErr := make(chan error, 1)
+	go func() {
+		for _, args := range sent {
+			if err := d.Send(*args); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- d.CloseSend()
+	}()
+
+	for i := 0; ; i++ {
+		reply, err := d.Recv()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			w.h.violate("duplex call starting at call %d stalled after %d of %d replies", sent[0].ID, i, len(sent))
+			return nil
+		case err == io.EOF:
+			if i != len(sent) {
+				w.h.violate("duplex call starting at call %d ended after %d of %d replies", sent[0].ID, i, len(sent))
+			}
+			return <-sendErr
+		case err != nil:
+			return err
+		case i >= len(sent):
+			w.h.violate("duplex call starting at call %d got reply %d to %d values", sent[0].ID, i+1, len(sent))
+			return nil
+		case reply.ID != sent[i].ID || reply.Sum != crc32.ChecksumIEEE(sent[i].Payload):
+			w.h.violate("duplex call %d got the reply to call %d (checksum %x)", sent[i].ID, reply.ID, reply.Sum)
+			return nil
+		}
+	}
+}
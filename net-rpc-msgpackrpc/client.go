@@ -33,11 +33,11 @@ func CallWithCodec(cc rpc.ClientCodec, method string, args interface{}, resp int
 		return err
 	}
 	if response.Error != "" {
-		err := errors.New(response.Error)
 		if readErr := cc.ReadResponseBody(nil); readErr != nil {
-			err = multierror.Append(err, readErr)
+			err := multierror.Append(errors.New(response.Error), readErr)
+			return rpc.ServerError(err.Error())
 		}
-		return rpc.ServerError(err.Error())
+		return rpc.ResponseError(&response)
 	}
 	if err := cc.ReadResponseBody(resp); err != nil {
 		return err
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/consul-net-rpc/go-msgpack/codec"
 	"github.com/hashicorp/consul-net-rpc/net/rpc"
@@ -16,18 +18,26 @@ import (
 var (
 	// msgpackHandle is shared handle for decoding
 	msgpackHandle = &codec.MsgpackHandle{}
+
+	// DefaultRawEncoding encodes rpc.RawValue contents using the default handle.
+	DefaultRawEncoding = RawEncoding(msgpackHandle)
 )
 
 // MsgpackCodec implements the rpc.ClientCodec and rpc.ServerCodec
 // using the msgpack encoding
 type MsgpackCodec struct {
-	closed    bool
+	closed    atomic.Bool
 	conn      net.Conn
 	bufR      *bufio.Reader
 	bufW      *bufio.Writer
 	enc       *codec.Encoder
 	dec       *codec.Decoder
 	writeLock sync.Mutex
+
+	h               *codec.MsgpackHandle
+	maxContainerLen int                 // only set by NewCodecWithOpts
+	maxRequestBytes int64               // set by SetMaxRequestBytes
+	flusher         *rpc.DelayedFlusher // set by SetWriteCoalescing
 }
 
 // NewCodec returns a MsgpackCodec that can be used as either a Client or Server
@@ -44,6 +54,7 @@ func NewCodecFromHandle(bufReads, bufWrites bool, conn net.Conn,
 	h *codec.MsgpackHandle) *MsgpackCodec {
 	cc := &MsgpackCodec{
 		conn: conn,
+		h:    h,
 	}
 	if bufReads {
 		cc.bufR = bufio.NewReader(conn)
@@ -88,20 +99,41 @@ func (cc *MsgpackCodec) WriteRequest(r *rpc.Request, body interface{}) error {
 	return cc.write(r, body)
 }
 
+// SetMaxRequestBytes makes the codec refuse messages larger than n bytes,
+// implementing rpc.RequestSizeLimiter. Each message is checked before it is
+// decoded, which costs an extra copy.
+func (cc *MsgpackCodec) SetMaxRequestBytes(n int64) {
+	cc.maxRequestBytes = n
+}
+
+// SetWriteCoalescing makes the codec flush its writes once maxResponses are
+// buffered or maxDelay after the first of them was, implementing
+// rpc.WriteCoalescer. Codecs that do not buffer writes flush each one.
+func (cc *MsgpackCodec) SetWriteCoalescing(maxResponses int, maxDelay time.Duration) {
+	if cc.bufW != nil {
+		cc.flusher = rpc.NewDelayedFlusher(&cc.writeLock, maxResponses, maxDelay, cc.bufW.Flush)
+	}
+}
+
 func (cc *MsgpackCodec) SourceAddr() net.Addr {
 	return cc.conn.RemoteAddr()
 }
 
 func (cc *MsgpackCodec) Close() error {
-	if cc.closed {
+	if !cc.closed.CompareAndSwap(false, true) {
 		return nil
 	}
-	cc.closed = true
+	// A write holding the lock may be waiting for the connection, which
+	// closing it breaks, so its buffered writes are then dropped.
+	if cc.flusher != nil && cc.writeLock.TryLock() {
+		cc.flusher.Flush()
+		cc.writeLock.Unlock()
+	}
 	return cc.conn.Close()
 }
 
 func (cc *MsgpackCodec) write(obj1, obj2 interface{}) (err error) {
-	if cc.closed {
+	if cc.closed.Load() {
 		return io.EOF
 	}
 	if err = cc.enc.Encode(obj1); err != nil {
@@ -110,6 +142,9 @@ func (cc *MsgpackCodec) write(obj1, obj2 interface{}) (err error) {
 	if err = cc.enc.Encode(obj2); err != nil {
 		return
 	}
+	if cc.flusher != nil {
+		return cc.flusher.Wrote()
+	}
 	if cc.bufW != nil {
 		return cc.bufW.Flush()
 	}
@@ -117,10 +152,14 @@ func (cc *MsgpackCodec) write(obj1, obj2 interface{}) (err error) {
 }
 
 func (cc *MsgpackCodec) read(obj interface{}) (err error) {
-	if cc.closed {
+	if cc.closed.Load() {
 		return io.EOF
 	}
 
+	if cc.maxContainerLen > 0 || cc.maxRequestBytes > 0 {
+		return cc.readLimited(obj)
+	}
+
 	// If nil is passed in, we should still attempt to read content to nowhere.
 	if obj == nil {
 		var obj2 interface{}
@@ -128,3 +167,41 @@ func (cc *MsgpackCodec) read(obj interface{}) (err error) {
 	}
 	return cc.dec.Decode(obj)
 }
+
+// rawEncoding is an rpc.RawEncoding using a msgpack handle.
+type rawEncoding struct {
+	h *codec.MsgpackHandle
+}
+
+// RawEncoding returns an rpc.RawEncoding that encodes RawValue contents with
+// msgpack using the passed handle.
+func RawEncoding(h *codec.MsgpackHandle) rpc.RawEncoding {
+	return rawEncoding{h: h}
+}
+
+func (e rawEncoding) Marshal(v interface{}) ([]byte, error) {
+	var out []byte
+	err := codec.NewEncoderBytes(&out, e.h).Encode(v)
+	return out, err
+}
+
+func (e rawEncoding) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, e.h).Decode(v)
+}
+
+// readLimited checks the lengths and size of the next message against the
+// codec's limits before decoding it.
+func (cc *MsgpackCodec) readLimited(obj interface{}) error {
+	var r io.Reader = cc.conn
+	if cc.bufR != nil {
+		r = cc.bufR
+	}
+	s := rawScanner{r: r, maxLen: cc.maxContainerLen, maxBytes: cc.maxRequestBytes}
+	if err := s.scan(); err != nil {
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+	return codec.NewDecoderBytes(s.buf, cc.h).Decode(obj)
+}
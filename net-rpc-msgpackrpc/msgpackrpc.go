@@ -8,6 +8,8 @@ package msgpackrpc
 
 import (
 	"net"
+	"reflect"
+	"strings"
 
 	"github.com/hashicorp/consul-net-rpc/net/rpc"
 )
@@ -36,3 +38,16 @@ func NewClientCodec(conn net.Conn) rpc.ClientCodec {
 func NewServerCodec(conn net.Conn) rpc.ServerCodec {
 	return NewCodec(true, true, conn)
 }
+
+// WireName is an rpc.WireNamer that reports struct field names the way the
+// msgpack codec encodes them, honoring the "codec" struct tag.
+func WireName(f reflect.StructField) string {
+	tag := f.Tag.Get("codec")
+	if tag == "-" || f.Name == "_struct" || !f.IsExported() {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return f.Name
+}
@@ -2,3 +2,612 @@
 // SPDX-License-Identifier: MPL-2.0
 
 package msgpackrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/go-msgpack/codec"
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type ForwardArgs struct {
+	Datacenter string
+	Payload    rpc.RawValue
+}
+
+type ForwardReply struct {
+	Datacenter string
+	Payload    rpc.RawValue
+}
+
+type Echo struct{}
+
+func (Echo) Echo(args *ForwardArgs, reply *ForwardReply) error {
+	reply.Datacenter = args.Datacenter
+	reply.Payload = args.Payload
+	return nil
+}
+
+// startServer serves srv with the msgpack codec on a local listener and
+// returns its address.
+func startServer(t *testing.T, srv *rpc.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				codec := NewServerCodec(conn)
+				defer codec.Close()
+				for {
+					if err := srv.ServeRequest(codec); err == io.EOF {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRawValue(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.Register(Echo{}); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	payload, err := rpc.NewRawValue(DefaultRawEncoding, &Args{7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply ForwardReply
+	if err := client.Call("Echo.Echo", &ForwardArgs{Datacenter: "dc2", Payload: payload}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Datacenter != "dc2" {
+		t.Errorf("expected datacenter dc2, got %q", reply.Datacenter)
+	}
+	var args Args
+	if err := reply.Payload.Decode(DefaultRawEncoding, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args != (Args{7, 8}) {
+		t.Errorf("expected payload {7 8}, got %+v", args)
+	}
+}
+
+type Arith struct{}
+
+func (Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (Arith) Div(args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func TestRequestRouter(t *testing.T) {
+	backend := rpc.NewServer()
+	if err := backend.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", startServer(t, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendCodec := NewCodec(true, true, conn)
+	defer backendCodec.Close()
+
+	var (
+		lock      sync.Mutex
+		forwarded []string
+	)
+	router := func(req *rpc.Request, _ net.Addr) rpc.ForwardFunc {
+		return func(_ context.Context, req *rpc.Request, body rpc.RawValue) (rpc.RawValue, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			forwarded = append(forwarded, req.ServiceMethod)
+			return CallRawWithCodec(backendCodec, req.ServiceMethod, body)
+		}
+	}
+	// The front server has no services of its own.
+	front := rpc.NewServerWithOpts(rpc.WithRequestRouter(router))
+	client, err := Dial("tcp", startServer(t, front))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	if err := client.Call("Arith.Add", &Args{7, 8}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != 15 {
+		t.Errorf("expected 15, got %d", reply)
+	}
+	err = client.Call("Arith.Div", &Args{7, 0}, &reply)
+	if err == nil || err.Error() != "divide by zero" {
+		t.Errorf("expected divide by zero, got %v", err)
+	}
+	if err := client.Call("Arith.Add", &Args{1, 2}, &reply); err != nil || reply != 3 {
+		t.Errorf("expected 3, got %d, %v", reply, err)
+	}
+	if len(forwarded) != 3 {
+		t.Errorf("expected 3 forwarded calls, got %v", forwarded)
+	}
+}
+
+type headerAdmission struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (a *headerAdmission) Admit(_ context.Context, adm rpc.Admission) (func(), error) {
+	if adm.Stage == rpc.AdmitPostHeader {
+		a.mu.Lock()
+		a.methods = append(a.methods, adm.ServiceMethod)
+		a.mu.Unlock()
+	}
+	return nil, nil
+}
+
+func TestRequestRouterPreBodyChecks(t *testing.T) {
+	backend := rpc.NewServer()
+	if err := backend.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", startServer(t, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendCodec := NewCodec(true, true, conn)
+	defer backendCodec.Close()
+
+	var forwarded atomic.Int32
+	router := func(req *rpc.Request, _ net.Addr) rpc.ForwardFunc {
+		return func(_ context.Context, req *rpc.Request, body rpc.RawValue) (rpc.RawValue, error) {
+			forwarded.Add(1)
+			return CallRawWithCodec(backendCodec, req.ServiceMethod, body)
+		}
+	}
+	admission := new(headerAdmission)
+	front := rpc.NewServerWithOpts(
+		rpc.WithRequestRouter(router),
+		rpc.WithAdmissionController(admission),
+		rpc.WithPreBodyInterceptor(func(serviceMethod string, _ net.Addr) error {
+			if serviceMethod == "Arith.Div" {
+				return errors.New("permission denied")
+			}
+			return nil
+		}),
+	)
+	client, err := Dial("tcp", startServer(t, front))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	err = client.Call("Arith.Div", &Args{7, 1}, &reply)
+	if err == nil || err.Error() != "permission denied" {
+		t.Errorf("expected the interceptor to deny the call, got %v", err)
+	}
+	if n := forwarded.Load(); n != 0 {
+		t.Errorf("expected the denied call not to be forwarded, got %d forwarded calls", n)
+	}
+	client2, err := Dial("tcp", startServer(t, front))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	if err := client2.Call("Arith.Add", &Args{1, 2}, &reply); err != nil || reply != 3 {
+		t.Errorf("expected 3, got %d, %v", reply, err)
+	}
+	admission.mu.Lock()
+	defer admission.mu.Unlock()
+	if len(admission.methods) != 1 || admission.methods[0] != "Arith.Add" {
+		t.Errorf("expected the forwarded call to be admitted after its header, got %v", admission.methods)
+	}
+}
+
+func TestCallRawWithCodecSeqMismatch(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	sc := NewServerCodec(serverConn)
+	defer sc.Close()
+	go func() {
+		var req rpc.Request
+		if err := sc.ReadRequestHeader(&req); err != nil {
+			return
+		}
+		sc.ReadRequestBody(nil)
+		sc.WriteResponse(&rpc.Response{Seq: req.Seq + 1, ServiceMethod: req.ServiceMethod}, 3)
+	}()
+
+	var body []byte
+	if err := codec.NewEncoderBytes(&body, msgpackHandle).Encode(&Args{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	cc := NewCodec(true, true, clientConn)
+	_, err := CallRawWithCodec(cc, "Arith.Add", rpc.RawValueFromBytes(body))
+	if err == nil || !strings.Contains(err.Error(), "got the response to request") {
+		t.Errorf("expected a seq mismatch error, got %v", err)
+	}
+}
+
+func TestRawScanner(t *testing.T) {
+	values := []interface{}{
+		nil, true, 1, -1, 300, -70000, 1 << 40, 1.5, "short", strings.Repeat("x", 300),
+		[]byte{1, 2, 3}, []int{1, 2, 3}, map[string]interface{}{"a": 1, "b": []string{"c"}},
+		make([]int, 70000),
+	}
+	for _, v := range values {
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		trailer := []byte{0xc3}
+		s := rawScanner{r: bytes.NewReader(append(append([]byte(nil), data...), trailer...))}
+		if err := s.scan(); err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if !bytes.Equal(s.buf, data) {
+			t.Errorf("%T: scanned %d bytes, expected %d", v, len(s.buf), len(data))
+		}
+	}
+}
+
+func TestNewCodecWithOpts(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.Register(new(Echo)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		cc := NewCodecWithOpts(conn, WithMaxContainerLen(16), WithRawToString(true))
+		defer cc.Close()
+		for {
+			if err := srv.ServeRequest(cc); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewCodecWithOpts(conn, WithBuffering(false, false)))
+	defer client.Close()
+
+	var reply ForwardReply
+	if err := client.Call("Echo.Echo", &ForwardArgs{Datacenter: "dc1"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Datacenter != "dc1" {
+		t.Errorf("expected dc1, got %q", reply.Datacenter)
+	}
+
+	// The server rejects a body holding a string longer than the limit and
+	// closes the connection.
+	err = client.Call("Echo.Echo", &ForwardArgs{Datacenter: "too-long-datacenter"}, &reply)
+	if err == nil {
+		t.Fatal("expected an error for an oversized string")
+	}
+}
+
+func TestLimitsCloseConn(t *testing.T) {
+	var long []byte
+	if err := codec.NewEncoderBytes(&long, msgpackHandle).Encode(make([]int, 40)); err != nil {
+		t.Fatal(err)
+	}
+	deep := append(bytes.Repeat([]byte{0x91}, maxScanDepth+1), 0xc0)
+	var small []byte
+	if err := codec.NewEncoderBytes(&small, msgpackHandle).Encode(&ForwardArgs{Datacenter: "dc1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range map[string][]byte{"container": long, "depth": deep} {
+		t.Run(name, func(t *testing.T) {
+			srv := rpc.NewServer()
+			if err := srv.Register(Echo{}); err != nil {
+				t.Fatal(err)
+			}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				cc := NewCodecWithOpts(conn, WithMaxContainerLen(16))
+				defer cc.Close()
+				for srv.ServeRequest(cc) != io.EOF {
+				}
+			}()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc := NewCodec(true, true, conn)
+			defer cc.Close()
+
+			_, err = CallRawWithCodec(cc, "Echo.Echo", rpc.RawValueFromBytes(body))
+			if err == nil || !strings.Contains(err.Error(), rpc.ErrRequestTooLarge.Error()) {
+				t.Errorf("expected ErrRequestTooLarge, got %v", err)
+			}
+
+			// The rest of the refused body is never read as the next
+			// request: the server closes the connection instead.
+			done := make(chan error, 1)
+			go func() {
+				_, err := CallRawWithCodec(cc, "Echo.Echo", rpc.RawValueFromBytes(small))
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("expected the connection to be closed")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("call after a refused request hung")
+			}
+		})
+	}
+}
+
+func TestRawScannerMaxLen(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		ok    bool
+	}{
+		{"abc", true},
+		{"abcde", false},
+		{strings.Repeat("x", 300), false},
+		{[]int{1, 2, 3, 4}, true},
+		{[]int{1, 2, 3, 4, 5}, false},
+		{make([]int, 70000), false},
+		{map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}, false},
+		{1.5, true},
+	}
+	for _, tc := range cases {
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(tc.value); err != nil {
+			t.Fatal(err)
+		}
+		s := rawScanner{r: bytes.NewReader(data), maxLen: 4}
+		err := s.scan()
+		if tc.ok && err != nil {
+			t.Errorf("%v: unexpected error %v", tc.value, err)
+		}
+		if !tc.ok && err != ErrContainerTooLarge {
+			t.Errorf("%T: expected ErrContainerTooLarge, got %v", tc.value, err)
+		}
+	}
+}
+
+func TestRawScannerLimits(t *testing.T) {
+	// Deeply nested arrays are refused rather than overflowing the stack.
+	deep := append(bytes.Repeat([]byte{0x91}, 1<<20), 0xc0)
+	s := rawScanner{r: bytes.NewReader(deep)}
+	if err := s.scan(); err != errTooDeep {
+		t.Errorf("expected errTooDeep, got %v", err)
+	}
+	nested := append(bytes.Repeat([]byte{0x91}, maxScanDepth), 0xc0)
+	s = rawScanner{r: bytes.NewReader(nested)}
+	if err := s.scan(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// A length beyond the default limit is refused before anything is read.
+	s = rawScanner{r: bytes.NewReader([]byte{0xc6, 0xff, 0xff, 0xff, 0xff})}
+	if err := s.scan(); !errors.Is(err, rpc.ErrRequestTooLarge) {
+		t.Errorf("expected ErrRequestTooLarge, got %v", err)
+	}
+
+	// The buffer grows with the bytes received, not with the length claimed.
+	s = rawScanner{r: bytes.NewReader([]byte{0xc6, 0x01, 0x00, 0x00, 0x00, 'x'})}
+	if err := s.scan(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if cap(s.buf) > 2*scanChunk {
+		t.Errorf("buffer grew to %d bytes", cap(s.buf))
+	}
+}
+
+// SlowArgs blocks while being decoded until decodeRelease is closed.
+type SlowArgs struct {
+	Payload []byte
+}
+
+var (
+	decodeStarted = make(chan struct{}, 1)
+	decodeRelease = make(chan struct{})
+)
+
+func (s SlowArgs) MarshalBinary() ([]byte, error) {
+	return s.Payload, nil
+}
+
+func (s *SlowArgs) UnmarshalBinary(data []byte) error {
+	decodeStarted <- struct{}{}
+	<-decodeRelease
+	s.Payload = append([]byte(nil), data...)
+	return nil
+}
+
+type Slow struct{}
+
+func (Slow) Len(args *SlowArgs, reply *int) error {
+	*reply = len(args.Payload)
+	return nil
+}
+
+func TestServeRequestAsyncDeferredDecode(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterAll(Slow{}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		cc := NewServerCodec(conn)
+		defer cc.Close()
+		for srv.ServeRequestAsync(context.Background(), cc) == nil {
+		}
+	}()
+
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	slow := client.Go("Slow.Len", &SlowArgs{Payload: []byte("abc")}, new(int), nil)
+	<-decodeStarted
+
+	// The next request is read and served while the first is decoded.
+	var sum int
+	if err := client.Call("Arith.Add", &Args{A: 1, B: 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("Add: got %d, %v", sum, err)
+	}
+
+	close(decodeRelease)
+	if call := <-slow.Done; call.Error != nil || *call.Reply.(*int) != 3 {
+		t.Errorf("Len: got %d, %v", *call.Reply.(*int), call.Error)
+	}
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	srv := rpc.NewServerWithOpts(rpc.WithMaxRequestBytes(128))
+	if err := srv.Register(Echo{}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", startServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(conn))
+	defer client.Close()
+
+	var reply ForwardReply
+	if err := client.Call("Echo.Echo", &ForwardArgs{Datacenter: "dc1"}, &reply); err != nil || reply.Datacenter != "dc1" {
+		t.Fatalf("expected a small request to succeed, got %v, %v", reply, err)
+	}
+	err = client.Call("Echo.Echo", &ForwardArgs{Datacenter: strings.Repeat("x", 200)}, &reply)
+	if err == nil || !strings.Contains(err.Error(), rpc.ErrRequestTooLarge.Error()) {
+		t.Errorf("expected ErrRequestTooLarge, got %v", err)
+	}
+	if err := client.Call("Echo.Echo", &ForwardArgs{Datacenter: "dc1"}, &reply); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+type Restore struct{}
+
+func (Restore) Run(ctx context.Context, steps int, reply *int) error {
+	for i := 1; i <= steps; i++ {
+		if err := rpc.ReportProgress(ctx, float64(i*100/steps), ""); err != nil {
+			return err
+		}
+	}
+	*reply = steps
+	return nil
+}
+
+func TestProgress(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.Register(Restore{}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", startServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(conn))
+	defer client.Close()
+
+	var got []float64
+	ctx := rpc.ContextWithProgress(context.Background(), func(p rpc.Progress) {
+		got = append(got, p.Percent)
+	})
+	var reply int
+	if err := client.CallContext(ctx, "Restore.Run", 2, &reply); err != nil || reply != 2 {
+		t.Fatalf("Run: got %d, %v", reply, err)
+	}
+	if len(got) != 2 || got[0] != 50 || got[1] != 100 {
+		t.Errorf("unexpected progress %v", got)
+	}
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	srv := rpc.NewServerWithOpts(rpc.WithWriteCoalescing(8, 10*time.Millisecond))
+	if err := srv.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", startServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(conn))
+	defer client.Close()
+
+	// Fewer responses than the limit are flushed after the delay.
+	calls := make([]*rpc.Call, 3)
+	for i := range calls {
+		calls[i] = client.Go("Arith.Add", &Args{A: i, B: 1}, new(int), nil)
+	}
+	for i, call := range calls {
+		if call = <-call.Done; call.Error != nil || *call.Reply.(*int) != i+1 {
+			t.Errorf("call %d: expected %d, got %d, %v", i, i+1, *call.Reply.(*int), call.Error)
+		}
+	}
+}
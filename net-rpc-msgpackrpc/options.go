@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package msgpackrpc
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/hashicorp/consul-net-rpc/go-msgpack/codec"
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// ErrContainerTooLarge is returned when a decoded string, byte slice, array
+// or map is longer than the limit set with WithMaxContainerLen. It wraps
+// rpc.ErrRequestTooLarge, so a server closes a connection whose request was
+// refused partway through.
+var ErrContainerTooLarge = fmt.Errorf("msgpackrpc: container length exceeds limit: %w", rpc.ErrRequestTooLarge)
+
+// errRequestTooLarge is returned for messages larger than the limit set with
+// SetMaxRequestBytes.
+var errRequestTooLarge = fmt.Errorf("msgpackrpc: %w", rpc.ErrRequestTooLarge)
+
+// CodecConfig holds the settings used by NewCodecWithOpts.
+type CodecConfig struct {
+	// Handle is the handle shared by every codec built with this config.
+	// It defaults to the package's default handle.
+	Handle *codec.MsgpackHandle
+	// BufferReads and BufferWrites enable buffering of the connection. Both
+	// default to true.
+	BufferReads  bool
+	BufferWrites bool
+	// MaxContainerLen, if positive, is the maximum length of any string, byte
+	// slice, array or map the codec decodes.
+	MaxContainerLen int
+
+	writeExt    *bool
+	rawToString *bool
+}
+
+// WithHandle sets the handle used to encode and decode messages.
+func WithHandle(h *codec.MsgpackHandle) func(*CodecConfig) {
+	return func(c *CodecConfig) {
+		c.Handle = h
+	}
+}
+
+// WithBuffering controls buffering of reads and writes.
+func WithBuffering(bufReads, bufWrites bool) func(*CodecConfig) {
+	return func(c *CodecConfig) {
+		c.BufferReads = bufReads
+		c.BufferWrites = bufWrites
+	}
+}
+
+// WithWriteExt overrides the handle's WriteExt setting for this codec.
+func WithWriteExt(writeExt bool) func(*CodecConfig) {
+	return func(c *CodecConfig) {
+		c.writeExt = &writeExt
+	}
+}
+
+// WithRawToString overrides the handle's RawToString setting for this codec.
+func WithRawToString(rawToString bool) func(*CodecConfig) {
+	return func(c *CodecConfig) {
+		c.rawToString = &rawToString
+	}
+}
+
+// WithMaxContainerLen bounds the length of strings, byte slices, arrays and
+// maps the codec decodes, so a peer cannot make it allocate unbounded memory.
+// Each message is checked before it is decoded, which costs an extra copy.
+func WithMaxContainerLen(n int) func(*CodecConfig) {
+	return func(c *CodecConfig) {
+		c.MaxContainerLen = n
+	}
+}
+
+// NewCodecWithOpts returns a MsgpackCodec configured by options. Without
+// options it is equivalent to NewCodec(true, true, conn).
+func NewCodecWithOpts(conn net.Conn, options ...func(*CodecConfig)) *MsgpackCodec {
+	cfg := CodecConfig{
+		Handle:       msgpackHandle,
+		BufferReads:  true,
+		BufferWrites: true,
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	h := cfg.Handle
+	if cfg.writeExt != nil || cfg.rawToString != nil {
+		copied := *h
+		if cfg.writeExt != nil {
+			copied.WriteExt = *cfg.writeExt
+		}
+		if cfg.rawToString != nil {
+			copied.RawToString = *cfg.rawToString
+		}
+		h = &copied
+	}
+
+	cc := NewCodecFromHandle(cfg.BufferReads, cfg.BufferWrites, conn, h)
+	cc.maxContainerLen = cfg.MaxContainerLen
+	return cc
+}
@@ -0,0 +1,306 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package msgpackrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+	"github.com/hashicorp/go-multierror"
+)
+
+// ReadRequestBodyRaw reads the next request body without decoding it. Bodies
+// are limited to the size set with SetMaxRequestBytes, or to 64 MiB if it was
+// not set, and to 100 levels of nested arrays and maps.
+func (cc *MsgpackCodec) ReadRequestBodyRaw() (rpc.RawValue, error) {
+	return cc.readRaw()
+}
+
+// ReadResponseBodyRaw reads the next response body without decoding it,
+// within the limits of ReadRequestBodyRaw.
+func (cc *MsgpackCodec) ReadResponseBodyRaw() (rpc.RawValue, error) {
+	return cc.readRaw()
+}
+
+// WriteResponseRaw writes a response whose body is already msgpack encoded.
+func (cc *MsgpackCodec) WriteResponseRaw(r *rpc.Response, body rpc.RawValue) error {
+	cc.writeLock.Lock()
+	defer cc.writeLock.Unlock()
+	return cc.writeRaw(r, body)
+}
+
+// WriteRequestRaw writes a request whose body is already msgpack encoded.
+func (cc *MsgpackCodec) WriteRequestRaw(r *rpc.Request, body rpc.RawValue) error {
+	cc.writeLock.Lock()
+	defer cc.writeLock.Unlock()
+	return cc.writeRaw(r, body)
+}
+
+// CallRawWithCodec is like CallWithCodec but sends and returns bodies that
+// are already encoded. It is meant for forwarding requests received through
+// an rpc.RequestRouter. As with CallWithCodec, the caller owns the codec:
+// calls must not be made on it concurrently, and a response to another
+// request than the one sent is an error.
+func CallRawWithCodec(cc rpc.RawClientCodec, method string, body rpc.RawValue) (rpc.RawValue, error) {
+	request := rpc.Request{
+		Seq:           atomic.AddUint64(&nextCallSeq, 1),
+		ServiceMethod: method,
+	}
+	if err := cc.WriteRequestRaw(&request, body); err != nil {
+		return rpc.RawValue{}, err
+	}
+	var response rpc.Response
+	if err := cc.ReadResponseHeader(&response); err != nil {
+		return rpc.RawValue{}, err
+	}
+	if response.Seq != request.Seq {
+		err := fmt.Errorf("msgpackrpc: got the response to request %d, want %d", response.Seq, request.Seq)
+		if readErr := cc.ReadResponseBody(nil); readErr != nil {
+			err = multierror.Append(err, readErr)
+		}
+		return rpc.RawValue{}, err
+	}
+	if response.Error != "" {
+		if readErr := cc.ReadResponseBody(nil); readErr != nil {
+			err := multierror.Append(errors.New(response.Error), readErr)
+			return rpc.RawValue{}, rpc.ServerError(err.Error())
+		}
+		return rpc.RawValue{}, rpc.ResponseError(&response)
+	}
+	return cc.ReadResponseBodyRaw()
+}
+
+func (cc *MsgpackCodec) writeRaw(header interface{}, body rpc.RawValue) (err error) {
+	if cc.closed.Load() {
+		return io.EOF
+	}
+	if err = cc.enc.Encode(header); err != nil {
+		return
+	}
+	var w io.Writer = cc.conn
+	if cc.bufW != nil {
+		w = cc.bufW
+	}
+	if _, err = w.Write(body.Bytes()); err != nil {
+		return
+	}
+	if cc.bufW != nil {
+		return cc.bufW.Flush()
+	}
+	return
+}
+
+func (cc *MsgpackCodec) readRaw() (rpc.RawValue, error) {
+	if cc.closed.Load() {
+		return rpc.RawValue{}, io.EOF
+	}
+	var r io.Reader = cc.conn
+	if cc.bufR != nil {
+		r = cc.bufR
+	}
+	s := rawScanner{r: r, maxLen: cc.maxContainerLen, maxBytes: cc.maxRequestBytes}
+	if err := s.scan(); err != nil {
+		return rpc.RawValue{}, err
+	}
+	return rpc.RawValueFromBytes(s.buf), nil
+}
+
+// BodyEncoding returns the encoding of request bodies read with
+// ReadRequestBodyRaw, implementing rpc.DeferredDecodeCodec.
+func (cc *MsgpackCodec) BodyEncoding() rpc.RawEncoding {
+	return RawEncoding(cc.h)
+}
+
+const (
+	// defaultMaxScanBytes is the largest message a rawScanner copies when
+	// no smaller limit is set.
+	defaultMaxScanBytes = 64 << 20
+	// maxScanDepth is the deepest nesting of arrays and maps a rawScanner
+	// follows.
+	maxScanDepth = 100
+	// scanChunk is the most a rawScanner grows its buffer by before the
+	// bytes filling it arrive, so that a length read from the peer does not
+	// make it allocate more than the peer sends.
+	scanChunk = 64 << 10
+)
+
+// errTooDeep is returned for messages nested deeper than maxScanDepth. Like
+// ErrContainerTooLarge it leaves the rest of the message unread, so it wraps
+// rpc.ErrRequestTooLarge.
+var errTooDeep = fmt.Errorf("msgpackrpc: message nested too deeply: %w", rpc.ErrRequestTooLarge)
+
+// rawScanner copies exactly one msgpack object from r into buf, reading only
+// as much of the object's framing as is needed to find its end.
+type rawScanner struct {
+	r      io.Reader
+	buf    []byte
+	maxLen int // if positive, the longest container allowed
+
+	maxBytes int64 // if positive, the largest message allowed, otherwise defaultMaxScanBytes
+	depth    int   // of the object being scanned
+}
+
+func (s *rawScanner) read(n int) ([]byte, error) {
+	start := len(s.buf)
+	maxBytes := s.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxScanBytes
+	}
+	if int64(start)+int64(n) > maxBytes {
+		return nil, errRequestTooLarge
+	}
+	for len(s.buf) < start+n {
+		chunk := start + n - len(s.buf)
+		if chunk > scanChunk {
+			chunk = scanChunk
+		}
+		from := len(s.buf)
+		s.buf = append(s.buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(s.r, s.buf[from:]); err != nil {
+			if err == io.EOF && from > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			s.buf = s.buf[:from]
+			return nil, err
+		}
+	}
+	return s.buf[start:], nil
+}
+
+// readLen reads a big-endian length of size bytes.
+func (s *rawScanner) readLen(size int) (int, error) {
+	b, err := s.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	switch size {
+	case 1:
+		n = int(b[0])
+	case 2:
+		n = int(binary.BigEndian.Uint16(b))
+	default:
+		n = int(binary.BigEndian.Uint32(b))
+	}
+	if s.maxLen > 0 && n > s.maxLen {
+		return 0, ErrContainerTooLarge
+	}
+	return n, nil
+}
+
+func (s *rawScanner) scan() error {
+	b, err := s.read(1)
+	if err != nil {
+		return err
+	}
+	bd := b[0]
+	var (
+		skip   int // bytes of payload to copy
+		lenSz  int // size of a length prefix to read
+		extra  int // bytes following the length prefix but before the payload
+		items  int // nested objects to scan
+		mapped bool
+	)
+	switch {
+	case bd <= 0x7f, bd >= 0xe0, bd == 0xc0, bd == 0xc2, bd == 0xc3:
+		// fixint, nil and bool are a single byte
+	case bd >= 0x80 && bd <= 0x8f:
+		items, mapped = int(bd&0x0f), true
+	case bd >= 0x90 && bd <= 0x9f:
+		items = int(bd & 0x0f)
+	case bd >= 0xa0 && bd <= 0xbf:
+		skip = int(bd & 0x1f)
+	case bd == 0xc4 || bd == 0xd9:
+		lenSz = 1
+	case bd == 0xc5 || bd == 0xda:
+		lenSz = 2
+	case bd == 0xc6 || bd == 0xdb:
+		lenSz = 4
+	case bd == 0xc7:
+		lenSz, extra = 1, 1
+	case bd == 0xc8:
+		lenSz, extra = 2, 1
+	case bd == 0xc9:
+		lenSz, extra = 4, 1
+	case bd == 0xca:
+		skip = 4
+	case bd == 0xcb:
+		skip = 8
+	case bd == 0xcc || bd == 0xd0:
+		skip = 1
+	case bd == 0xcd || bd == 0xd1:
+		skip = 2
+	case bd == 0xce || bd == 0xd2:
+		skip = 4
+	case bd == 0xcf || bd == 0xd3:
+		skip = 8
+	case bd >= 0xd4 && bd <= 0xd8:
+		skip = 1 + 1<<(bd-0xd4)
+	case bd == 0xdc:
+		n, err := s.readLen(2)
+		if err != nil {
+			return err
+		}
+		items = n
+	case bd == 0xdd:
+		n, err := s.readLen(4)
+		if err != nil {
+			return err
+		}
+		items = n
+	case bd == 0xde:
+		n, err := s.readLen(2)
+		if err != nil {
+			return err
+		}
+		items, mapped = n, true
+	case bd == 0xdf:
+		n, err := s.readLen(4)
+		if err != nil {
+			return err
+		}
+		items, mapped = n, true
+	default:
+		return fmt.Errorf("msgpackrpc: unsupported msgpack descriptor 0x%x", bd)
+	}
+	if s.maxLen > 0 {
+		// Lengths read by readLen are already checked.
+		fixstr := bd >= 0xa0 && bd <= 0xbf
+		if items > s.maxLen || fixstr && skip > s.maxLen {
+			return ErrContainerTooLarge
+		}
+	}
+
+	if lenSz > 0 {
+		if skip, err = s.readLen(lenSz); err != nil {
+			return err
+		}
+		skip += extra
+	}
+	if skip > 0 {
+		if _, err := s.read(skip); err != nil {
+			return err
+		}
+	}
+	if mapped {
+		items *= 2
+	}
+	if items > 0 {
+		if s.depth == maxScanDepth {
+			return errTooDeep
+		}
+		s.depth++
+		defer func() { s.depth-- }()
+	}
+	for i := 0; i < items; i++ {
+		if err := s.scan(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// AccessRule restricts the peers that may call the methods whose
+// "Service.Method" starts with Prefix. A peer whose address is in a Deny
+// network is refused; otherwise, if Allow is not empty, the peer is refused
+// unless its address is in an Allow network. Networks are in CIDR notation,
+// such as "10.0.0.0/8"; peers that are not IP addresses, such as those of
+// pipes, are in none.
+type AccessRule struct {
+	Prefix string // for example "Internal." for a service; empty for all methods
+	Allow  []string
+	Deny   []string
+}
+
+// AccessControl enforces AccessRules on the source addresses of calls,
+// once the request header is read and before its body is. The rules can be
+// replaced with Reload while the server runs, for example when its
+// configuration is reloaded.
+type AccessControl struct {
+	rules atomic.Pointer[[]accessRule]
+}
+
+type accessRule struct {
+	prefix      string
+	allow, deny []netip.Prefix
+}
+
+// NewAccessControl returns an AccessControl enforcing rules. It fails if a
+// network of the rules is not valid CIDR notation.
+func NewAccessControl(rules []AccessRule) (*AccessControl, error) {
+	ac := new(AccessControl)
+	if err := ac.Reload(rules); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// Reload replaces the rules enforced by ac, for the calls whose header is
+// read from then on. If a network of rules is not valid CIDR notation, it
+// returns an error and the rules are left as they were.
+func (ac *AccessControl) Reload(rules []AccessRule) error {
+	compiled := make([]accessRule, len(rules))
+	for i, rule := range rules {
+		compiled[i].prefix = rule.Prefix
+		var err error
+		if compiled[i].allow, err = parseNetworks(rule.Allow); err != nil {
+			return fmt.Errorf("rpc: access rule for %q: %w", rule.Prefix, err)
+		}
+		if compiled[i].deny, err = parseNetworks(rule.Deny); err != nil {
+			return fmt.Errorf("rpc: access rule for %q: %w", rule.Prefix, err)
+		}
+	}
+	ac.rules.Store(&compiled)
+	return nil
+}
+
+func parseNetworks(cidrs []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, len(cidrs))
+	for i, cidr := range cidrs {
+		network, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks[i] = network.Masked()
+	}
+	return networks, nil
+}
+
+// Allowed reports whether peer may call serviceMethod. The rule with the
+// longest prefix of serviceMethod decides; methods that no rule covers may
+// be called by any peer.
+func (ac *AccessControl) Allowed(serviceMethod string, peer net.Addr) bool {
+	var match *accessRule
+	rules := *ac.rules.Load()
+	for i := range rules {
+		rule := &rules[i]
+		if strings.HasPrefix(serviceMethod, rule.prefix) && (match == nil || len(rule.prefix) > len(match.prefix)) {
+			match = rule
+		}
+	}
+	if match == nil {
+		return true
+	}
+	ip, ok := peerIP(peer)
+	if ok && containsAddr(match.deny, ip) {
+		return false
+	}
+	return len(match.allow) == 0 || ok && containsAddr(match.allow, ip)
+}
+
+// Check returns an error coded CodeNotPermitted if peer may not call
+// serviceMethod. It is a PreBodyInterceptor, for servers combining access
+// control with interceptors of their own.
+func (ac *AccessControl) Check(serviceMethod string, peer net.Addr) error {
+	if !ac.Allowed(serviceMethod, peer) {
+		return errNotPermitted(serviceMethod)
+	}
+	return nil
+}
+
+// WithAccessControl makes the server refuse the calls ac does not allow, as
+// a method filter set with WithPeerMethodFilter would.
+func WithAccessControl(ac *AccessControl) func(*Server) {
+	return WithPeerMethodFilter(ac.Allowed)
+}
+
+func containsAddr(networks []netip.Prefix, ip netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the IP address of peer, if it has one.
+func peerIP(peer net.Addr) (netip.Addr, bool) {
+	if p, ok := peer.(*Peer); ok {
+		peer = p.Addr
+	}
+	var ip net.IP
+	switch addr := peer.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.IPAddr:
+		ip = addr.IP
+	case nil:
+		return netip.Addr{}, false
+	default:
+		addrPort, err := netip.ParseAddrPort(peer.String())
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestAccessControlAllowed(t *testing.T) {
+	ac, err := NewAccessControl([]AccessRule{
+		{Prefix: "", Deny: []string{"192.0.2.0/24"}},
+		{Prefix: "Internal.", Allow: []string{"10.0.0.0/8", "::1/128"}, Deny: []string{"10.1.0.0/16"}},
+		{Prefix: "Internal.Ping", Allow: []string{"0.0.0.0/0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := func(s string) net.Addr {
+		return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s))
+	}
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	for _, tt := range []struct {
+		method string
+		peer   net.Addr
+		want   bool
+	}{
+		{"Arith.Add", addr("203.0.113.1:1234"), true},
+		{"Arith.Add", addr("192.0.2.1:1234"), false},
+		{"Arith.Add", pipe.RemoteAddr(), true},
+		{"Internal.Sync", addr("10.2.3.4:1234"), true},
+		{"Internal.Sync", addr("[::ffff:10.2.3.4]:1234"), true},
+		{"Internal.Sync", &Peer{Addr: addr("10.2.3.4:1234")}, true},
+		{"Internal.Sync", addr("[::1]:1234"), true},
+		{"Internal.Sync", addr("10.1.3.4:1234"), false},
+		{"Internal.Sync", addr("203.0.113.1:1234"), false},
+		{"Internal.Sync", pipe.RemoteAddr(), false},
+		{"Internal.Ping", addr("203.0.113.1:1234"), true},
+		// The longest prefix decides, so the global deny list no longer
+		// applies.
+		{"Internal.Ping", addr("192.0.2.1:1234"), true},
+	} {
+		if got := ac.Allowed(tt.method, tt.peer); got != tt.want {
+			t.Errorf("%s from %v: got %v, want %v", tt.method, tt.peer, got, tt.want)
+		}
+	}
+
+	if err := ac.Reload([]AccessRule{{Allow: []string{"10.0.0.0"}}}); err == nil {
+		t.Error("expected an invalid network to fail")
+	}
+	if ac.Allowed("Arith.Add", addr("192.0.2.1:1234")) {
+		t.Error("expected a failed reload to keep the rules")
+	}
+}
+
+func TestWithAccessControl(t *testing.T) {
+	ac, err := NewAccessControl([]AccessRule{{Prefix: "Arith.", Deny: []string{"127.0.0.0/8"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServerWithOpts(WithAccessControl(ac))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{7, 8}, reply); ErrorCode(err) != CodeNotPermitted {
+		t.Errorf("expected the call not to be permitted, got %v", err)
+	}
+	if err := ac.Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("expected 15 once reloaded, got %d, %v", reply.C, err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+)
+
+// AdmissionStage is a point in the reading of a request at which the
+// server consults its AdmissionControllers.
+type AdmissionStage int
+
+const (
+	// AdmitPreHeader is before the next request header is read from a
+	// connection. A controller that waits here stops the connection being
+	// read, pushing back on the client; one that fails ends the serving of
+	// the connection, as the request cannot be answered. InvokeMethod has no
+	// such stage.
+	AdmitPreHeader AdmissionStage = iota
+	// AdmitPostHeader is once the method is known, before the body is
+	// decoded. A rejected request's body is discarded unread and the error
+	// is sent to the client.
+	AdmitPostHeader
+	// AdmitPostBody is once the args are decoded, before the handler runs.
+	// The error of a rejected request is sent to the client.
+	AdmitPostBody
+)
+
+// Admission describes the request an AdmissionController is asked about.
+type Admission struct {
+	Stage         AdmissionStage
+	ServiceMethod string      // empty at AdmitPreHeader
+	Peer          *Peer       // the client, or nil if unknown
+	Codec         ServerCodec // nil for InvokeMethod
+	Args          interface{} // the decoded args at AdmitPostBody, else nil
+}
+
+// AdmissionController decides whether the server takes on a request. The
+// concurrency limits set by WithMaxConcurrentRequests and
+// WithMaxConcurrentRequestsPerConn are one, consulted before any added
+// with WithAdmissionController.
+type AdmissionController interface {
+	// Admit is called at each stage of each request. It may wait until the
+	// request can be admitted, giving up when ctx is done. It returns an
+	// error to reject the request, preferably a CodedError such as
+	// ErrTooManyRequests so clients know whether to retry. Otherwise the
+	// returned release function, if not nil, is called once the request is
+	// done.
+	Admit(ctx context.Context, a Admission) (release func(), err error)
+}
+
+// ConnForgetter is implemented by AdmissionControllers that keep state for
+// each connection. ForgetConn is called once the server stops serving
+// codec.
+type ConnForgetter interface {
+	ForgetConn(codec ServerCodec)
+}
+
+// WithAdmissionController adds c to the controllers consulted for each
+// request, in the order they were added. A request rejected by one is not
+// shown to the next, and the controllers that admitted it are released.
+func WithAdmissionController(c AdmissionController) func(*Server) {
+	return func(s *Server) {
+		s.admission = append(s.admission, c)
+	}
+}
+
+// admit consults the server's admission controllers about a request at
+// stage. The returned function releases the request and is never nil.
+func (server *Server) admit(ctx context.Context, stage AdmissionStage, serviceMethod string, sourceAddr net.Addr, codec ServerCodec, args interface{}) (func(), error) {
+	// The concurrency limits apply to requests read from connections.
+	limits := server.limits != nil && stage == AdmitPostBody && codec != nil && server.featureOn(ctx, FeatureConcurrencyLimit)
+	if !limits && len(server.admission) == 0 {
+		return func() {}, nil
+	}
+	a := Admission{
+		Stage:         stage,
+		ServiceMethod: serviceMethod,
+		Peer:          asPeer(sourceAddr),
+		Codec:         codec,
+		Args:          args,
+	}
+	var releases []func()
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	controllers := server.admission
+	if limits {
+		controllers = append([]AdmissionController{server.limits}, controllers...)
+	}
+	for _, c := range controllers {
+		r, err := c.Admit(ctx, a)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if r != nil {
+			releases = append(releases, r)
+		}
+	}
+	return release, nil
+}
+
+// forgetConn tells the admission controllers the server stopped serving
+// codec.
+func (server *Server) forgetConn(codec ServerCodec) {
+	if server.limits != nil {
+		server.limits.ForgetConn(codec)
+	}
+	for _, c := range server.admission {
+		if f, ok := c.(ConnForgetter); ok {
+			f.ForgetConn(codec)
+		}
+	}
+}
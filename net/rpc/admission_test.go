@@ -0,0 +1,111 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recordingAdmission admits every request but those to reject, recording
+// the stages it was consulted at.
+type recordingAdmission struct {
+	reject string // service method rejected after its header
+
+	mu       sync.Mutex
+	stages   []string
+	released int
+	forgot   int
+}
+
+func (r *recordingAdmission) Admit(ctx context.Context, a Admission) (func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a.Stage == AdmitPreHeader {
+		return nil, nil // consulted for each read, so not recorded
+	}
+	r.stages = append(r.stages, fmt.Sprintf("%d %s %v", a.Stage, a.ServiceMethod, a.Args))
+	if a.Stage == AdmitPostHeader && a.ServiceMethod == r.reject {
+		return nil, ErrTooManyRequests
+	}
+	return func() {
+		r.mu.Lock()
+		r.released++
+		r.mu.Unlock()
+	}, nil
+}
+
+func (r *recordingAdmission) ForgetConn(codec ServerCodec) {
+	r.mu.Lock()
+	r.forgot++
+	r.mu.Unlock()
+}
+
+func (r *recordingAdmission) snapshot() ([]string, int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.stages...), r.released, r.forgot
+}
+
+func TestAdmissionController(t *testing.T) {
+	admission := &recordingAdmission{reject: "Arith.Mul"}
+	srv := NewServerWithOpts(WithAdmissionController(admission))
+	srv.Register(new(Arith))
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply Reply
+	if err := client.Call("Arith.Mul", &Args{2, 3}, &reply); ErrorCode(err) != CodeTooManyRequests {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+	// The rejected request's body was discarded, so the connection is
+	// still usable.
+	if err := client.Call("Arith.Add", Args{2, 3}, &reply); err != nil || reply.C != 5 {
+		t.Fatalf("got %d, %v", reply.C, err)
+	}
+	client.Close()
+	waitFor(t, func() bool {
+		_, _, forgot := admission.snapshot()
+		return forgot == 1
+	})
+
+	stages, released, _ := admission.snapshot()
+	want := []string{
+		"1 Arith.Mul <nil>",
+		"1 Arith.Add <nil>",
+		"2 Arith.Add {2 3}",
+	}
+	if fmt.Sprint(stages) != fmt.Sprint(want) {
+		t.Errorf("consulted at %q, want %q", stages, want)
+	}
+	if released != 2 {
+		t.Errorf("released %d admissions, want 2", released)
+	}
+}
+
+func TestAdmissionControllerInvoke(t *testing.T) {
+	admission := &recordingAdmission{reject: "Arith.Mul"}
+	srv := NewServerWithOpts(WithAdmissionController(admission))
+	srv.Register(new(Arith))
+
+	decoded := false
+	decode := func(v any) error {
+		decoded = true
+		*v.(*Args) = Args{A: 2, B: 3}
+		return nil
+	}
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.Mul", decode, nil); err != ErrTooManyRequests || decoded {
+		t.Fatalf("expected rejection before decoding, got %v", err)
+	}
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.Add", decode, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, released, _ := admission.snapshot(); released != 2 {
+		t.Errorf("released %d admissions, want 2", released)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// DeferredDecodeCodec is implemented by RawServerCodecs whose request bodies
+// can be read without being decoded and decoded afterwards with
+// BodyEncoding. ServeRequestAsync uses it to decode bodies off the
+// connection's read loop.
+type DeferredDecodeCodec interface {
+	RawServerCodec
+	BodyEncoding() RawEncoding
+}
+
+// ServeRequestAsync reads the next request from codec and serves it in a new
+// goroutine. It returns as soon as the request has been read, so that the
+// caller can read the next one while this one is served; the codec must
+// allow reads concurrent with writes. If codec implements
+// DeferredDecodeCodec, the goroutine also decodes the request body, so a
+// request that is slow to decode does not hold up the requests behind it.
+//
+// Errors reading the request header are returned as by ServeRequestContext,
+// except that ErrServerClosed is returned once the server is shut down.
+// Errors after the request has been read, such as a body that cannot be
+// decoded, are only reported to the client.
+func (server *Server) ServeRequestAsync(ctx context.Context, codec ServerCodec) error {
+	read := make(chan struct{})
+	var once sync.Once
+	bodyRead := func() {
+		once.Do(func() { close(read) })
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.serveRequest(ctx, codec, bodyRead)
+	}()
+
+	select {
+	case <-read:
+		return nil
+	case err := <-done:
+		// bodyRead is called before serveRequest returns, so a request
+		// that was read is never reported as failed.
+		select {
+		case <-read:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// ServeCodecContext serves the requests read from codec concurrently with
+// ServeRequestAsync until the client closes the connection, reading a
+// request fails or ctx is done, and closes the codec before returning, so
+// that the lifetime of a connection can be tied to that of ctx. It returns
+// nil once the client has closed the connection, ctx.Err() once ctx is done,
+// and otherwise the error that ended reading, such as ErrServerClosed. The
+// responses of requests still being served when ctx is done are not sent.
+func (server *Server) ServeCodecContext(ctx context.Context, codec ServerCodec) error {
+	defer codec.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock the read of the next request.
+			codec.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		err := server.ServeRequestAsync(ctx, codec)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+}
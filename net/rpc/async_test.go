@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeRequestAsync(t *testing.T) {
+	srv := NewServer()
+	blocker := newBlocker()
+	if err := srv.RegisterAll(blocker, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		codec := newGobServerCodec(conn)
+		defer codec.Close()
+		for srv.ServeRequestAsync(context.Background(), codec) == nil {
+		}
+	}()
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	slow := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+
+	// The blocked call does not hold up the connection.
+	reply := new(Reply)
+	done := make(chan error, 1)
+	go func() { done <- client.Call("Arith.Add", Args{7, 8}, reply) }()
+	select {
+	case err := <-done:
+		if err != nil || reply.C != 15 {
+			t.Errorf("Add: got %d, %v", reply.C, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Add was held up by the blocked call")
+	}
+
+	close(blocker.release)
+	if call := <-slow.Done; call.Error != nil {
+		t.Errorf("Block: %v", call.Error)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestServeCodecContext(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(ctx context.Context) (*Client, <-chan error) {
+		clientConn, serverConn := net.Pipe()
+		served := make(chan error, 1)
+		go func() { served <- srv.ServeCodecContext(ctx, newGobServerCodec(serverConn)) }()
+		client := NewClient(clientConn)
+		reply := new(Reply)
+		if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil || reply.C != 15 {
+			t.Fatalf("Add: got %d, %v", reply.C, err)
+		}
+		return client, served
+	}
+
+	// The client closing the connection ends serving without an error.
+	client, served := serve(context.Background())
+	client.Close()
+	if err := <-served; err != nil {
+		t.Errorf("after the client closed: got %v, want nil", err)
+	}
+
+	// Cancelling ctx closes the connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	client, served = serve(ctx)
+	defer client.Close()
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("after cancelling: got %v, want %v", err, context.Canceled)
+	}
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err == nil {
+		t.Error("expected calls to fail once ctx is cancelled")
+	}
+}
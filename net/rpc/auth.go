@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// CodeUnauthenticated is the code of the errors sent for requests the
+// server's Authenticator rejects.
+const CodeUnauthenticated = "unauthenticated"
+
+// Identity is who the server's Authenticator found a request to come from.
+type Identity struct {
+	Name       string
+	Attributes map[string]string // optional, as set by the Authenticator
+}
+
+// Authenticator resolves the auth token of a request, as set by the
+// client's WithAuthToken, to the Identity making it. The token is empty if
+// the client sent none; the Authenticator decides whether such requests
+// are allowed. It runs once the request header is read, before the body
+// is, and is called for every request, so it should cache what is costly
+// to verify.
+type Authenticator func(ctx context.Context, token string, sourceAddr net.Addr) (Identity, error)
+
+// WithAuthenticator makes the server authenticate the requests it reads
+// from connections with auth. A request auth returns an error for is
+// refused with an error coded CodeUnauthenticated, and its body is
+// discarded. The Identity of the others is reachable with
+// IdentityFromContext from the contexts passed to pre-body context
+// interceptors, call interceptors and handlers. Calls made with
+// HTTPJSONHandler are authenticated with the token of their Authorization
+// header. Calls made with InvokeMethod are not authenticated: they carry the
+// Identity of their context, if any, such as that of the request they
+// forward.
+func WithAuthenticator(auth Authenticator) func(*Server) {
+	return func(s *Server) {
+		s.authenticator = auth
+	}
+}
+
+// AuthTokenProvider returns the auth token a client sends with a call made
+// with ctx.
+type AuthTokenProvider func(ctx context.Context) (string, error)
+
+// WithAuthToken makes the client send the token returned by provider with
+// each call, for the server's Authenticator. provider is given the call's
+// context, or context.Background() for calls made without one, and is
+// called before the request is written, so it may refresh an expired
+// token. A call fails without being sent if provider returns an error.
+func WithAuthToken(provider AuthTokenProvider) func(*Client) {
+	return func(c *Client) {
+		c.authToken = provider
+	}
+}
+
+// IdentityFromContext returns the Identity the server authenticated the
+// request of ctx as, if it has an Authenticator.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	rv := requestValuesFrom(ctx)
+	if rv == nil || rv.identity == nil {
+		return Identity{}, false
+	}
+	return *rv.identity, true
+}
+
+// authenticate resolves the identity of req, if the server has an
+// Authenticator.
+func (server *Server) authenticate(ctx context.Context, req *Request, sourceAddr net.Addr) error {
+	if server.authenticator == nil {
+		return nil
+	}
+	id, err := server.authenticator(ctx, req.AuthToken, sourceAddr)
+	if err != nil {
+		return &CodedError{Code: CodeUnauthenticated, Message: "rpc: unauthenticated: " + err.Error()}
+	}
+	req.identity = &id
+	if rv := requestValuesFrom(ctx); rv != nil {
+		rv.identity = req.identity
+	}
+	return nil
+}
+
+// authenticate sets the auth token sent with call, if the client has a
+// provider.
+func (client *Client) authenticate(ctx context.Context, call *Call) error {
+	if client.authToken == nil {
+		return nil
+	}
+	token, err := client.authToken(ctx)
+	if err != nil {
+		return fmt.Errorf("rpc: getting auth token: %w", err)
+	}
+	call.token = token
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type AuthEcho struct{}
+
+func (w *AuthEcho) Name(ctx context.Context, args struct{}, reply *string) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return errors.New("no identity")
+	}
+	*reply = id.Name
+	return nil
+}
+
+func TestAuthenticator(t *testing.T) {
+	var preBody atomic.Value
+	srv := NewServerWithOpts(
+		WithAuthenticator(func(ctx context.Context, token string, sourceAddr net.Addr) (Identity, error) {
+			if sourceAddr == nil {
+				return Identity{}, errors.New("no source address")
+			}
+			switch token {
+			case "secret":
+				return Identity{Name: "alice"}, nil
+			case "":
+				return Identity{Name: "anonymous"}, nil
+			}
+			return Identity{}, errors.New("bad token")
+		}),
+		WithPreBodyContextInterceptor(func(ctx context.Context, serviceMethod string, sourceAddr net.Addr) error {
+			id, _ := IdentityFromContext(ctx)
+			preBody.Store(id.Name)
+			return nil
+		}),
+	)
+	if err := srv.Register(new(AuthEcho)); err != nil {
+		t.Fatal(err)
+	}
+	addr := startAsyncServer(t, srv)
+
+	dial := func(opts ...func(*Client)) *Client {
+		t.Helper()
+		client, err := NewDialer(WithDialClientOptions(opts...)).DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	token := func(s string) func(*Client) {
+		return WithAuthToken(func(context.Context) (string, error) { return s, nil })
+	}
+
+	var name string
+	if err := dial().Call("AuthEcho.Name", struct{}{}, &name); err != nil || name != "anonymous" {
+		t.Errorf("expected anonymous, got %q, %v", name, err)
+	}
+	alice := dial(token("secret"))
+	if err := alice.CallContext(context.Background(), "AuthEcho.Name", struct{}{}, &name); err != nil || name != "alice" {
+		t.Errorf("expected alice, got %q, %v", name, err)
+	}
+	if got, _ := preBody.Load().(string); got != "alice" {
+		t.Errorf("expected pre-body interceptors to see alice, got %q", got)
+	}
+
+	bad := dial(token("wrong"))
+	err := bad.Call("AuthEcho.Name", struct{}{}, &name)
+	if ErrorCode(err) != CodeUnauthenticated || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("expected the call to be unauthenticated, got %v", err)
+	}
+	// The body of the refused request was discarded.
+	if err := bad.Call("AuthEcho.Name", struct{}{}, &name); ErrorCode(err) != CodeUnauthenticated {
+		t.Errorf("expected the connection to stay usable, got %v", err)
+	}
+
+	failing := dial(WithAuthToken(func(context.Context) (string, error) { return "", errors.New("expired") }))
+	if err := failing.Call("AuthEcho.Name", struct{}{}, &name); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected the provider's error, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+)
+
+// BufferedClientCodec is implemented by ClientCodecs that can write requests
+// without flushing them, so that a Batch sends its calls with a single
+// flush. The codec NewClient uses implements it.
+type BufferedClientCodec interface {
+	ClientCodec
+	// WriteRequestBuffered is like WriteRequest but may leave the request
+	// buffered until Flush is called.
+	WriteRequestBuffered(*Request, interface{}) error
+	Flush() error
+}
+
+// Batch queues calls to send them together, with one write to the
+// connection rather than one for each call, which dominates the cost of
+// bursts of small calls. Calls in a batch are made as with Client.Go: the
+// client's interceptors, retry policy and circuit breaker do not apply.
+// A Batch is not safe for concurrent use.
+type Batch struct {
+	client *Client
+	calls  []*Call
+}
+
+// Batch returns an empty Batch of calls on the client.
+func (client *Client) Batch() *Batch {
+	return &Batch{client: client}
+}
+
+// Call queues a call, returning it so that its error can be inspected once
+// Do returns. The reply is filled in by then.
+func (b *Batch) Call(serviceMethod string, args interface{}, reply interface{}) *Call {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Error:         b.client.checkDeadline(nil, serviceMethod),
+		Done:          make(chan *Call, 1),
+	}
+	if call.Error == nil {
+		call.Error = b.client.authenticate(context.Background(), call)
+	}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// Len returns the number of calls queued.
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// Do sends the queued calls and waits for all of them to complete. It
+// returns nil if all calls succeeded, or an error joining a CallError for
+// every call that failed. The batch is empty once Do returns.
+func (b *Batch) Do() error {
+	calls := b.calls
+	b.calls = nil
+	b.client.sendBatch(calls)
+	var errs []error
+	for _, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			errs = append(errs, &CallError{ServiceMethod: call.ServiceMethod, Err: call.Error})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendBatch sends calls, flushing the connection once after writing all of
+// their requests if the codec is a BufferedClientCodec.
+func (client *Client) sendBatch(calls []*Call) {
+	codec, buffered := client.codec.(BufferedClientCodec)
+	if !buffered {
+		for _, call := range calls {
+			if call.Error != nil {
+				call.done()
+				continue
+			}
+			client.send(call)
+		}
+		return
+	}
+
+	parts := make([][][]byte, len(calls))
+	failed := make([]bool, len(calls))
+	for i, call := range calls {
+		if call.Error != nil {
+			call.done()
+			failed[i] = true
+			continue
+		}
+		p, ok := client.splitArgs(call)
+		parts[i], failed[i] = p, !ok
+	}
+
+	client.reqMutex.Lock()
+	defer client.reqMutex.Unlock()
+	var written []*Call
+	for i, call := range calls {
+		if failed[i] || !client.register(call) {
+			continue
+		}
+		if err := client.writeCall(call, parts[i], codec.WriteRequestBuffered); err != nil {
+			client.wrote(call, err)
+			continue
+		}
+		written = append(written, call)
+	}
+	err := codec.Flush()
+	for _, call := range written {
+		client.wrote(call, err)
+	}
+}
+
+// WriteRequestBuffered implements BufferedClientCodec.
+func (c *gobClientCodec) WriteRequestBuffered(r *Request, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		return err
+	}
+	return c.enc.Encode(body)
+}
+
+// Flush implements BufferedClientCodec.
+func (c *gobClientCodec) Flush() error {
+	return c.encBuf.Flush()
+}
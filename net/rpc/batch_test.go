@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// writeCountingNetConn counts the writes made to its connection.
+type writeCountingNetConn struct {
+	net.Conn
+	writes atomic.Int64
+}
+
+func (c *writeCountingNetConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestBatch(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted := &writeCountingNetConn{Conn: conn}
+	client := NewClient(counted)
+	defer client.Close()
+
+	batch := client.Batch()
+	replies := make([]*Reply, 10)
+	for i := range replies {
+		replies[i] = new(Reply)
+		batch.Call("Arith.Add", Args{i, 1}, replies[i])
+	}
+	if batch.Len() != 10 {
+		t.Fatalf("expected 10 queued calls, got %d", batch.Len())
+	}
+	if err := batch.Do(); err != nil {
+		t.Fatal(err)
+	}
+	for i, reply := range replies {
+		if reply.C != i+1 {
+			t.Errorf("call %d: expected %d, got %d", i, i+1, reply.C)
+		}
+	}
+	if n := counted.writes.Load(); n != 1 {
+		t.Errorf("expected the batch to be written at once, got %d writes", n)
+	}
+	if batch.Len() != 0 {
+		t.Errorf("expected the batch to be empty, got %d calls", batch.Len())
+	}
+
+	// Failed calls are reported without failing the others.
+	reply := new(Reply)
+	batch.Call("Arith.Add", Args{1, 2}, reply)
+	unknown := batch.Call("Arith.Unknown", Args{}, new(Reply))
+	err = batch.Do()
+	var callErr *CallError
+	if !errors.As(err, &callErr) || callErr.ServiceMethod != "Arith.Unknown" || !strings.Contains(unknown.Error.Error(), "can't find method") {
+		t.Errorf("expected the unknown method to fail, got %v", err)
+	}
+	if reply.C != 3 {
+		t.Errorf("expected 3, got %d", reply.C)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for calls refused by a client whose circuit
+// breaker has tripped.
+var ErrCircuitOpen = errors.New("rpc: circuit breaker is open")
+
+// WithCircuitBreaker makes Call and CallContext fail fast with ErrCircuitOpen
+// after threshold consecutive calls failed because the server could not be
+// reached or did not answer in time. Once cooldown has passed, one call is let
+// through as a probe: if it succeeds, or the server answers it with an error,
+// calls flow again; otherwise the breaker stays open for another cooldown.
+// Calls made with Go are not affected.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.breaker = &circuitBreaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex // protects following
+	failures int        // consecutive transport failures
+	openedAt time.Time  // when the breaker tripped; zero while closed
+	probing  bool       // a probe call is in flight
+}
+
+// do makes call unless the breaker is open, and records its outcome.
+func (b *circuitBreaker) do(call func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = call()
+	b.record(probe, err)
+	return err
+}
+
+// allow reports whether a call may be made, and whether it is the probe.
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return false, nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false, ErrCircuitOpen
+	}
+	b.probing = true
+	return true, nil
+}
+
+// record updates the breaker with the outcome of a call. Calls the caller
+// cancelled say nothing about the server and are ignored.
+func (b *circuitBreaker) record(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if !isTransportFailure(err) {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// isTransportFailure reports whether err means the server could not be
+// reached or did not answer, as opposed to an error the server returned.
+func isTransportFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return isConnError(err) || errors.Is(err, context.DeadlineExceeded)
+}
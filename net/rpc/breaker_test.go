@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	_, addr, _ := startNewServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encBuf := bufio.NewWriter(conn)
+	codec := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	client := NewClientWithOpts(codec, WithCircuitBreaker(2, 50*time.Millisecond))
+	defer client.Close()
+
+	slow := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		return client.CallContext(ctx, "Arith.SleepMilli", &Args{A: 100}, new(Reply))
+	}
+	add := func() error {
+		return client.Call("Arith.Add", Args{1, 2}, new(Reply))
+	}
+
+	// Errors returned by the server don't trip the breaker.
+	for i := 0; i < 3; i++ {
+		if err := client.Call("Arith.Error", new(Args), new(Reply)); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("expected the server's error, got %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := slow(); err != context.DeadlineExceeded {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+	}
+	if err := add(); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	// A failed probe keeps the breaker open for another cooldown.
+	time.Sleep(60 * time.Millisecond)
+	if err := slow(); err != context.DeadlineExceeded {
+		t.Fatalf("expected the probe to be let through, got %v", err)
+	}
+	if err := add(); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen after a failed probe, got %v", err)
+	}
+
+	// A successful probe closes it.
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := add(); err != nil {
+			t.Fatalf("call %d: expected the breaker to close, got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"time"
+)
+
+// A ReplyViolation describes a misuse of a reply object caught by the reply
+// canary: a handler changing its reply after returning, or a client passing
+// a reply that an outstanding call is still decoding into.
+type ReplyViolation struct {
+	ServiceMethod string
+	Reason        string
+}
+
+func (v *ReplyViolation) Error() string {
+	return "rpc: " + v.ServiceMethod + ": " + v.Reason
+}
+
+// ReplyViolationHandler is called with each violation the reply canary
+// finds. It may be called from any goroutine.
+type ReplyViolationHandler func(*ReplyViolation)
+
+// WithReplyCanary is a debugging aid that makes the server check that
+// handlers leave their reply alone once they return. The reply is
+// fingerprinted when the handler returns, and checked again once the response
+// has been written and after linger has passed, catching handlers that keep
+// writing to the reply from another goroutine. Violations are passed to
+// handler, or logged if it is nil. Fingerprinting walks the whole reply, so
+// this is meant for tests and debugging rather than production.
+func WithReplyCanary(linger time.Duration, handler ReplyViolationHandler) func(*Server) {
+	return func(s *Server) {
+		s.replyCanary = &replyCanary{linger: linger, handler: handler}
+	}
+}
+
+// WithClientReplyCanary is a debugging aid that makes the client refuse
+// calls whose reply is already being used by an outstanding call, which would
+// otherwise have two responses decoded into it concurrently. Such calls fail
+// with a *ReplyViolation, which is also passed to handler, or logged if it is
+// nil.
+func WithClientReplyCanary(handler ReplyViolationHandler) func(*Client) {
+	return func(c *Client) {
+		c.replyCanary = &replyCanary{handler: handler}
+	}
+}
+
+type replyCanary struct {
+	linger  time.Duration
+	handler ReplyViolationHandler
+}
+
+func (rc *replyCanary) report(logger Logger, v *ReplyViolation) {
+	if rc.handler != nil {
+		rc.handler(v)
+		return
+	}
+	logger.Error("rpc: reply canary: "+v.Reason, "serviceMethod", v.ServiceMethod)
+}
+
+// watch fingerprints the reply a handler returned and returns a function to
+// call once the response has been written.
+func (rc *replyCanary) watch(logger Logger, serviceMethod string, replyv reflect.Value) func() {
+	want := fingerprint(replyv)
+	check := func(when string) bool {
+		if fingerprint(replyv) == want {
+			return true
+		}
+		rc.report(logger, &ReplyViolation{
+			ServiceMethod: serviceMethod,
+			Reason:        "reply was modified " + when + " the handler returned",
+		})
+		return false
+	}
+	return func() {
+		if !check("while the response was written after") || rc.linger <= 0 {
+			return
+		}
+		time.AfterFunc(rc.linger, func() {
+			check(fmt.Sprintf("more than %v after", rc.linger))
+		})
+	}
+}
+
+// checkOverlap returns a violation if call's reply is also the reply of one of
+// pending. The caller must hold the client's mutex.
+func (rc *replyCanary) checkOverlap(call *Call, pending map[uint64]*Call) *ReplyViolation {
+	v := reflect.ValueOf(call.Reply)
+	if !v.IsValid() || v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil
+	}
+	for _, other := range pending {
+		ov := reflect.ValueOf(other.Reply)
+		if ov.Kind() == reflect.Pointer && ov.Pointer() == v.Pointer() {
+			violation := &ReplyViolation{
+				ServiceMethod: call.ServiceMethod,
+				Reason:        "reply is still in use by a pending call to " + other.ServiceMethod,
+			}
+			rc.report(defaultLogger, violation)
+			return violation
+		}
+	}
+	return nil
+}
+
+// fingerprint hashes everything reachable from v, including unexported
+// fields.
+func fingerprint(v reflect.Value) uint64 {
+	h := fnv.New64a()
+	hashValue(h, v, make(map[canaryVisit]bool))
+	return h.Sum64()
+}
+
+// canaryVisit identifies a pointer already hashed. The type is included as a
+// struct and its first field share an address.
+type canaryVisit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func hashValue(h hash.Hash64, v reflect.Value, seen map[canaryVisit]bool) {
+	if !v.IsValid() {
+		h.Write([]byte{0})
+		return
+	}
+	var buf [8]byte
+	writeUint := func(n uint64) {
+		for i := range buf {
+			buf[i] = byte(n >> (8 * i))
+		}
+		h.Write(buf[:])
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			writeUint(1)
+		} else {
+			writeUint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeUint(math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		writeUint(math.Float64bits(real(v.Complex())))
+		writeUint(math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		writeUint(uint64(v.Len()))
+		h.Write([]byte(v.String()))
+	case reflect.Pointer:
+		if v.IsNil() {
+			writeUint(0)
+			return
+		}
+		writeUint(uint64(v.Pointer()))
+		visit := canaryVisit{v.Pointer(), v.Type()}
+		if seen[visit] {
+			return
+		}
+		seen[visit] = true
+		hashValue(h, v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			writeUint(0)
+			return
+		}
+		h.Write([]byte(v.Elem().Type().String()))
+		hashValue(h, v.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			hashValue(h, v.Field(i), seen)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i), seen)
+		}
+	case reflect.Slice:
+		writeUint(uint64(v.Len()))
+		if v.Len() == 0 {
+			return
+		}
+		writeUint(uint64(v.Pointer()))
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i), seen)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			writeUint(0)
+			return
+		}
+		// Combine the entries' hashes so iteration order does not matter.
+		var sum uint64
+		iter := v.MapRange()
+		for iter.Next() {
+			// Each entry gets its own copy of seen, as which entry reaches
+			// a shared pointer first depends on the iteration order.
+			entrySeen := make(map[canaryVisit]bool, len(seen))
+			for p := range seen {
+				entrySeen[p] = true
+			}
+			eh := fnv.New64a()
+			hashValue(eh, iter.Key(), entrySeen)
+			hashValue(eh, iter.Value(), entrySeen)
+			sum += eh.Sum64()
+		}
+		writeUint(uint64(v.Len()))
+		writeUint(sum)
+	default:
+		// Channels, functions and unsafe pointers are compared by identity.
+		writeUint(uint64(v.Pointer()))
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Table struct{}
+
+func (Table) Build(n int, reply *map[string]*Reply) error {
+	shared := &Reply{C: n}
+	for i := 0; i < n; i++ {
+		(*reply)[strconv.Itoa(i)] = shared
+	}
+	return nil
+}
+
+func TestReplyCanary(t *testing.T) {
+	var mu sync.Mutex
+	var violations []*ReplyViolation
+	srv := NewServerWithOpts(
+		WithReplyCanary(10*time.Millisecond, func(v *ReplyViolation) {
+			mu.Lock()
+			defer mu.Unlock()
+			violations = append(violations, v)
+		}),
+		// Stands in for a handler goroutine writing to the reply after the
+		// handler returned.
+		WithResponseInterceptor(func(resp *Response, reply interface{}, err error) error {
+			if resp.ServiceMethod == "Arith.Mul" {
+				reply.(*Reply).C++
+			}
+			return err
+		}),
+	)
+	if err := srv.RegisterAll(new(Arith), Table{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	table := make(map[string]*Reply)
+	if err := client.Call("Table.Build", 20, &table); err != nil || len(table) != 20 {
+		t.Fatal(err, len(table))
+	}
+	if err := client.Call("Arith.Mul", Args{3, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(violations) != 1 || violations[0].ServiceMethod != "Arith.Mul" {
+		t.Fatalf("expected one violation for Arith.Mul, got %v", violations)
+	}
+}
+
+func TestClientReplyCanary(t *testing.T) {
+	_, addr, _ := startNewServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []*ReplyViolation
+	encBuf := bufio.NewWriter(conn)
+	codec := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	client := NewClientWithOpts(codec, WithClientReplyCanary(func(v *ReplyViolation) {
+		reported = append(reported, v)
+	}))
+	defer client.Close()
+
+	reply := new(Reply)
+	slow := client.Go("Arith.SleepMilli", &Args{A: 50}, reply, nil)
+	err = client.Call("Arith.Add", Args{1, 2}, reply)
+	var violation *ReplyViolation
+	if !errors.As(err, &violation) || violation.ServiceMethod != "Arith.Add" {
+		t.Errorf("expected a reply violation, got %v", err)
+	}
+	if len(reported) != 1 {
+		t.Errorf("expected the violation to be reported, got %v", reported)
+	}
+	if call := <-slow.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+
+	// Once the first call is done, the reply may be reused.
+	if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("expected the reply to be reusable, got %v, %d", err, reply.C)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var errNoBodyEncoding = errors.New("rpc: codec cannot reassemble chunked bodies")
+
+var errChunkingOff = errors.New("rpc: server does not accept chunked requests")
+
+// ErrReplyTooLarge fails calls whose chunked reply is larger than the limit
+// set with WithClientMaxChunkedReply.
+var ErrReplyTooLarge = errors.New("rpc: chunked reply too large")
+
+// defaultMaxChunkedBody is the largest chunked body a client or server
+// reassembles unless WithClientMaxChunkedReply or WithMaxRequestBytes sets
+// another limit.
+const defaultMaxChunkedBody = 64 << 20
+
+// WithChunking makes the server send replies whose encoding is larger than
+// size bytes as parts of at most size bytes, each written as a message of
+// its own, so that writing a large reply does not hold up the responses of
+// the connection's other calls: those are written in between the parts.
+// The client reassembles the parts before decoding the reply.
+//
+// The server also reassembles the chunked requests of clients using
+// WithClientChunking, up to the limit set with WithMaxRequestBytes, or 64 MiB
+// if none is. Servers without chunking refuse chunked requests.
+//
+// Only connections whose codec implements BodyEncoding, as those of this
+// package, msgpackrpc and protorpc do, are chunked, and their clients must be
+// of a version that reassembles chunks. Every reply is encoded once more to
+// measure it, so size should be set well above the typical reply. Values
+// sent by streaming and duplex methods are not chunked.
+func WithChunking(size int) func(*Server) {
+	return func(s *Server) {
+		s.chunkSize = size
+	}
+}
+
+// WithClientChunking makes the client send the args of calls whose encoding
+// is larger than size bytes as parts of at most size bytes, as WithChunking
+// does for replies. Only servers using WithChunking accept chunked requests.
+func WithClientChunking(size int) func(*Client) {
+	return func(c *Client) {
+		c.chunkSize = size
+	}
+}
+
+// WithClientMaxChunkedReply makes the client fail calls whose chunked reply
+// is larger than n bytes with ErrReplyTooLarge, rather than keeping every
+// part the server sends. The rest of the reply is read and discarded. The
+// limit defaults to 64 MiB; n of zero or less removes it.
+func WithClientMaxChunkedReply(n int64) func(*Client) {
+	return func(c *Client) {
+		c.maxChunkedReply = n
+	}
+}
+
+// bodyEncoder is implemented by codecs whose bodies can be encoded and
+// decoded apart from the connection. Chunked bodies are encoded with it
+// before being split into parts, and decoded once the parts are joined.
+type bodyEncoder interface {
+	BodyEncoding() RawEncoding
+}
+
+// BodyEncoding returns GobEncoding.
+func (c *gobServerCodec) BodyEncoding() RawEncoding {
+	return GobEncoding
+}
+
+// BodyEncoding returns GobEncoding.
+func (c *gobClientCodec) BodyEncoding() RawEncoding {
+	return GobEncoding
+}
+
+// splitBody encodes body with codec's encoding and returns it in parts of
+// at most size bytes. It returns no parts if body should be sent whole:
+// chunking is off, codec has no encoding, or body fits in a single part.
+func splitBody(codec interface{}, size int, body interface{}) ([][]byte, error) {
+	enc, ok := codec.(bodyEncoder)
+	if size <= 0 || !ok {
+		return nil, nil
+	}
+	data, err := enc.BodyEncoding().Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= size {
+		return nil, nil
+	}
+	parts := make([][]byte, 0, (len(data)+size-1)/size)
+	for len(data) > size {
+		parts = append(parts, data[:size:size])
+		data = data[size:]
+	}
+	return append(parts, data), nil
+}
+
+// joinBody appends last to the parts received before it and decodes the
+// result into body with codec's encoding.
+func joinBody(codec interface{}, received, last []byte, body interface{}) error {
+	enc, ok := codec.(bodyEncoder)
+	if !ok {
+		return errNoBodyEncoding
+	}
+	return enc.BodyEncoding().Unmarshal(append(received, last...), body)
+}
+
+// receiveChunk reads the body of req, a part of a chunked request other
+// than the last, and keeps it until the last part arrives. The first part
+// of a request is only kept once the request has passed the method filters
+// and authentication; the parts of a refused request are discarded, and the
+// last part is refused with the error.
+func (server *Server) receiveChunk(ctx context.Context, codec ServerCodec, req *Request) error {
+	if server.chunkSize <= 0 {
+		return discardBody(codec, errChunkingOff)
+	}
+	key := duplexKey{codec: codec, seq: req.Seq}
+	v, started := server.chunks.Load(key)
+	if !started {
+		if err := server.checkMethodFilters(req.ServiceMethod, codec.SourceAddr()); err != nil {
+			return discardBody(codec, err)
+		}
+		if err := server.authenticate(ctx, req, codec.SourceAddr()); err != nil {
+			return discardBody(codec, err)
+		}
+	}
+	var part []byte
+	if err := codec.ReadRequestBody(&part); err != nil {
+		return err
+	}
+	var received []byte
+	if started {
+		received = v.([]byte)
+	}
+	received = append(received, part...)
+	if err := server.checkChunkedSize(len(received)); err != nil {
+		server.chunks.Delete(key)
+		return err
+	}
+	server.chunks.Store(key, received)
+	return nil
+}
+
+// readChunkedBody reads the last part of req, a chunked request, and
+// returns its whole body, still encoded.
+func (server *Server) readChunkedBody(codec ServerCodec, req *Request) ([]byte, error) {
+	var last []byte
+	if err := codec.ReadRequestBody(&last); err != nil {
+		return nil, err
+	}
+	v, _ := server.chunks.LoadAndDelete(duplexKey{codec: codec, seq: req.Seq})
+	received, _ := v.([]byte)
+	if err := server.checkChunkedSize(len(received) + len(last)); err != nil {
+		return nil, err
+	}
+	return append(received, last...), nil
+}
+
+// checkChunkedSize returns the error refusing a chunked request whose parts
+// add up to n bytes, if they exceed the server's limit.
+func (server *Server) checkChunkedSize(n int) error {
+	limit := server.maxRequestBytes
+	if limit <= 0 {
+		limit = defaultMaxChunkedBody
+	}
+	if int64(n) > limit {
+		return fmt.Errorf("%w: chunked body exceeds %d bytes", ErrRequestTooLarge, limit)
+	}
+	return nil
+}
+
+// discardBody reads and discards the body of a request refused with err,
+// and returns err, or the error reading the body if it was too large to be
+// read.
+func discardBody(codec ServerCodec, err error) error {
+	if derr := codec.ReadRequestBody(nil); errors.Is(derr, ErrRequestTooLarge) {
+		return derr
+	}
+	return err
+}
+
+// forgetChunks drops the parts of the requests read from codec that will
+// not be completed.
+func (server *Server) forgetChunks(codec ServerCodec) {
+	server.chunks.Range(func(k, _ interface{}) bool {
+		if k.(duplexKey).codec == codec {
+			server.chunks.Delete(k)
+		}
+		return true
+	})
+}
+
+// writeChunks writes all but the last of parts, the body of resp's reply,
+// each holding sending only while it is written. The caller writes the last
+// part with the response header.
+func (server *Server) writeChunks(sending *writeQueue, resp *Response, parts [][]byte, codec ServerCodec) error {
+	part := Response{ServiceMethod: resp.ServiceMethod, Seq: resp.Seq, Chunked: true, Continued: true}
+	for _, body := range parts[:len(parts)-1] {
+		sending.Lock(WritePriorityBulk)
+		err := codec.WriteResponse(&part, body)
+		sending.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChunks writes all but the last of parts, the args of call, letting
+// the requests of other calls be written in between. It is called, and
+// returns, with client.reqMutex held. The caller writes the last part with
+// the request header.
+func (client *Client) writeChunks(call *Call, parts [][]byte) error {
+	part := Request{ServiceMethod: call.ServiceMethod, Seq: call.seq, Chunked: true, Continued: true, AuthToken: call.token}
+	for i, body := range parts[:len(parts)-1] {
+		if i > 0 {
+			client.reqMutex.Unlock()
+			client.reqMutex.Lock()
+		}
+		if err := client.codec.WriteRequest(&part, body); err != nil {
+			return err
+		}
+	}
+	client.reqMutex.Unlock()
+	client.reqMutex.Lock()
+	return nil
+}
+
+// receiveChunk keeps part, a part of call's chunked reply other than the
+// last, until the last part arrives. If the parts grow past the client's
+// limit, the call fails and the rest of its reply is discarded as it
+// arrives, as for a call that is no longer pending.
+func (client *Client) receiveChunk(call *Call, part []byte) {
+	if !client.chunkFits(call, part) {
+		client.mutex.Lock()
+		pending := client.pending[call.seq] == call
+		if pending {
+			delete(client.pending, call.seq)
+		}
+		client.mutex.Unlock()
+		call.chunks = nil
+		if pending {
+			call.Error = fmt.Errorf("%w: exceeds %d bytes", ErrReplyTooLarge, client.maxChunkedReply)
+			call.done()
+		}
+		return
+	}
+	call.chunks = append(call.chunks, part...)
+}
+
+// chunkFits reports whether part can be added to the parts of call's reply
+// received so far without exceeding the client's limit.
+func (client *Client) chunkFits(call *Call, part []byte) bool {
+	return client.maxChunkedReply <= 0 || int64(len(call.chunks)+len(part)) <= client.maxChunkedReply
+}
+
+// readChunkedReply reads the last part of call's chunked reply and decodes
+// the joined parts into call.Reply. An error decoding them fails only the
+// call; an error reading the part is returned.
+func (client *Client) readChunkedReply(call *Call) error {
+	var last []byte
+	err := client.codec.ReadResponseBody(&last)
+	if err == nil && !client.chunkFits(call, last) {
+		call.Error = fmt.Errorf("%w: exceeds %d bytes", ErrReplyTooLarge, client.maxChunkedReply)
+	} else if err == nil {
+		if derr := joinBody(client.codec, call.chunks, last, call.Reply); derr != nil {
+			call.Error = errors.New("reading body " + derr.Error())
+		}
+	}
+	call.chunks = nil
+	return err
+}
@@ -0,0 +1,210 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+type Repeater struct{}
+
+func (Repeater) Repeat(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+func TestChunking(t *testing.T) {
+	srv := NewServerWithOpts(WithChunking(256))
+	if err := srv.RegisterAll(Sizer{}, Repeater{}); err != nil {
+		t.Fatal(err)
+	}
+	addr := startAsyncServer(t, srv)
+	d := NewDialer(WithDialClientOptions(WithClientChunking(256)))
+	client, err := d.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var n int
+	if err := client.Call("Sizer.Len", strings.Repeat("x", 4096), &n); err != nil || n != 4096 {
+		t.Errorf("expected chunked args to be reassembled, got %d, %v", n, err)
+	}
+	var s string
+	if err := client.Call("Repeater.Repeat", 4096, &s); err != nil || s != strings.Repeat("x", 4096) {
+		t.Errorf("expected a chunked reply to be reassembled, got %d bytes, %v", len(s), err)
+	}
+	if err := client.Call("Sizer.Len", "x", &n); err != nil || n != 1 {
+		t.Errorf("expected small args to be sent whole, got %d, %v", n, err)
+	}
+
+	// On the wire, other requests are served in between the parts.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := newGobClientCodec(conn)
+	defer codec.Close()
+	data, _ := GobEncoding.Marshal(strings.Repeat("y", 1000))
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Len", Seq: 1, Chunked: true, Continued: true}, data[:500])
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Len", Seq: 2}, "yy")
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Len", Seq: 1, Chunked: true}, data[500:])
+	for _, want := range []struct{ seq, n int }{{2, 2}, {1, 1000}} {
+		var resp Response
+		if err := codec.ReadResponseHeader(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if err := codec.ReadResponseBody(&n); err != nil || resp.Seq != uint64(want.seq) || n != want.n {
+			t.Fatalf("expected %d for call %d, got %d for call %d, %v", want.n, want.seq, n, resp.Seq, err)
+		}
+	}
+
+	// The reply is sent as parts of at most the chunk size.
+	codec.WriteRequest(&Request{ServiceMethod: "Repeater.Repeat", Seq: 3}, 1000)
+	var parts [][]byte
+	for {
+		var resp Response
+		var part []byte
+		if err := codec.ReadResponseHeader(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if err := codec.ReadResponseBody(&part); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Chunked || len(part) > 256 {
+			t.Fatalf("expected parts of at most 256 bytes, got %+v with %d bytes", resp, len(part))
+		}
+		parts = append(parts, part)
+		if !resp.Continued {
+			break
+		}
+	}
+	if len(parts) < 4 {
+		t.Errorf("expected at least 4 parts, got %d", len(parts))
+	}
+	if err := joinBody(codec, nil, joinParts(parts), &s); err != nil || len(s) != 1000 {
+		t.Errorf("expected the parts to join into the reply, got %d bytes, %v", len(s), err)
+	}
+}
+
+func joinParts(parts [][]byte) []byte {
+	var data []byte
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func TestChunkingMaxRequestBytes(t *testing.T) {
+	srv := NewServerWithOpts(WithChunking(256), WithMaxRequestBytes(1024))
+	srv.Register(Sizer{})
+	d := NewDialer(WithDialClientOptions(WithClientChunking(256)))
+	client, err := d.DialContext(context.Background(), "tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var n int
+	if err := client.Call("Sizer.Len", strings.Repeat("x", 512), &n); err != nil || n != 512 {
+		t.Fatalf("expected a request under the limit to succeed, got %d, %v", n, err)
+	}
+	// Each part is under the limit, but not the whole body, so the
+	// connection is closed.
+	err = client.Call("Sizer.Len", strings.Repeat("x", 4096), &n)
+	if err == nil {
+		t.Error("expected the connection to be closed")
+	}
+
+	// Without a limit set, chunked bodies are still limited.
+	if err := NewServer().checkChunkedSize(defaultMaxChunkedBody + 1); !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("expected ErrRequestTooLarge, got %v", err)
+	}
+}
+
+func TestChunkingOff(t *testing.T) {
+	srv := NewServer()
+	srv.Register(Sizer{})
+	d := NewDialer(WithDialClientOptions(WithClientChunking(256)))
+	client, err := d.DialContext(context.Background(), "tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var n int
+	if err := client.Call("Sizer.Len", strings.Repeat("x", 4096), &n); err == nil || err.Error() != errChunkingOff.Error() {
+		t.Errorf("expected chunked args to be refused, got %d, %v", n, err)
+	}
+	if err := client.Call("Sizer.Len", "x", &n); err != nil || n != 1 {
+		t.Errorf("expected small args to be accepted, got %d, %v", n, err)
+	}
+}
+
+func TestChunkingChecksFirstPart(t *testing.T) {
+	srv := NewServerWithOpts(WithChunking(256), WithMethodFilter(func(serviceMethod string) bool {
+		return serviceMethod != "Sizer.Secret"
+	}))
+	srv.Register(Sizer{})
+	addr := startAsyncServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := newGobClientCodec(conn)
+	defer codec.Close()
+	data, _ := GobEncoding.Marshal(strings.Repeat("y", 1000))
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Secret", Seq: 1, Chunked: true, Continued: true}, data[:500])
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Secret", Seq: 1, Chunked: true}, data[500:])
+	var resp Response
+	if err := codec.ReadResponseHeader(&resp); err != nil {
+		t.Fatal(err)
+	}
+	codec.ReadResponseBody(nil)
+	if resp.Seq != 1 || !strings.Contains(resp.Error, "not permitted") {
+		t.Errorf("expected the call to be refused, got %+v", resp)
+	}
+
+	// The refused part was never kept.
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Secret", Seq: 2, Chunked: true, Continued: true}, data[:500])
+	codec.WriteRequest(&Request{ServiceMethod: "Sizer.Len", Seq: 3}, "yy")
+	if err := codec.ReadResponseHeader(&resp); err != nil {
+		t.Fatal(err)
+	}
+	codec.ReadResponseBody(nil)
+	kept := 0
+	srv.chunks.Range(func(_, _ interface{}) bool {
+		kept++
+		return true
+	})
+	if kept != 0 {
+		t.Errorf("expected no parts to be kept, got %d", kept)
+	}
+}
+
+func TestChunkingMaxChunkedReply(t *testing.T) {
+	srv := NewServerWithOpts(WithChunking(256))
+	srv.Register(Repeater{})
+	d := NewDialer(WithDialClientOptions(WithClientMaxChunkedReply(1024)))
+	client, err := d.DialContext(context.Background(), "tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var s string
+	if err := client.Call("Repeater.Repeat", 4096, &s); !errors.Is(err, ErrReplyTooLarge) {
+		t.Errorf("expected ErrReplyTooLarge, got %v", err)
+	}
+	// The rest of the refused reply is discarded and the connection stays
+	// usable.
+	if err := client.Call("Repeater.Repeat", 512, &s); err != nil || len(s) != 512 {
+		t.Errorf("expected a reply under the limit, got %d bytes, %v", len(s), err)
+	}
+}
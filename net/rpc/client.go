@@ -6,13 +6,13 @@ package rpc
 
 import (
 	"bufio"
+	"context"
 	"encoding/gob"
 	"errors"
 	"io"
 	"log"
-	"net"
-	"net/http"
 	"sync"
+	"time"
 )
 
 // ServerError represents an error that has been returned from
@@ -25,6 +25,10 @@ func (e ServerError) Error() string {
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// ErrTimeout is returned by CallTimeout for calls that got no response in
+// time.
+var ErrTimeout = errors.New("rpc: call timed out")
+
 // Call represents an active RPC.
 type Call struct {
 	ServiceMethod string      // The name of the service and method to call.
@@ -32,6 +36,23 @@ type Call struct {
 	Reply         interface{} // The reply from the function (*struct).
 	Error         error       // After completion, the error status.
 	Done          chan *Call  // Receives *Call when Go is complete.
+
+	seq      uint64         // sequence number assigned by the Client
+	session  uint64         // ID of the Session making the call
+	timeout  time.Duration  // remaining time sent to the server, if any
+	metadata Metadata       // sent to the server, if set; else the client's
+	progress func(Progress) // receives progress notifications, if set
+	replyMD  *Metadata      // receives the response's metadata, if set
+	stream   streamReceiver // receives the values of a streaming call, if set
+	duplex   bool           // the call is to a duplex method
+	chunks   []byte         // the parts of a chunked reply received so far
+	token    string         // the auth token sent to the server, if any
+
+	// Protected by the Client's mutex, for PendingCalls.
+	sent       time.Time
+	written    bool   // the request was completely written
+	writeStart uint64 // the codec's byte count before and after writing
+	writeEnd   uint64
 }
 
 // Client represents an RPC Client.
@@ -41,14 +62,31 @@ type Call struct {
 type Client struct {
 	codec ClientCodec
 
-	reqMutex sync.Mutex // protects following
-	request  Request
+	callInterceptor ClientCallInterceptor
+	retryPolicy     *RetryPolicy
+	breaker         *circuitBreaker
+	metadata        Metadata
+	replyCanary     *replyCanary
+	sessionBits     uint
+	dedup           *seqWindow // set by WithResponseDedup
+	deadlineCheck   *deadlineCheck
+	authToken       AuthTokenProvider
+	chunkSize       int        // set by WithClientChunking
+	maxChunkedReply int64      // set by WithClientMaxChunkedReply
+	keepalive       *keepalive // set by WithKeepalive
+
+	reqMutex      sync.Mutex // protects following
+	request       Request
+	verifyReplies bool
 
-	mutex    sync.Mutex // protects following
-	seq      uint64
-	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	mutex       sync.Mutex // protects following
+	seq         uint64
+	sessionSeqs map[uint64]uint64 // next sequence number of each session
+	pending     map[uint64]*Call
+	writing     *Call // the call whose request is being written
+	closing     bool  // user has called Close
+	shutdown    bool  // server has told us to stop
+	broken      error // the error of the pending calls, if the connection was declared dead
 }
 
 // A ClientCodec implements writing of RPC requests and
@@ -69,26 +107,110 @@ type ClientCodec interface {
 }
 
 func (client *Client) send(call *Call) {
+	parts, ok := client.splitArgs(call)
+	if !ok {
+		return
+	}
+
 	client.reqMutex.Lock()
 	defer client.reqMutex.Unlock()
+	if !client.register(call) {
+		return
+	}
+	client.wrote(call, client.writeCall(call, parts, client.codec.WriteRequest))
+}
 
-	// Register this call.
+// splitArgs returns the parts the args of call are sent in, if they are
+// chunked. If they cannot be encoded, the call is completed with the error
+// and splitArgs reports false.
+func (client *Client) splitArgs(call *Call) ([][]byte, bool) {
+	if call.stream != nil {
+		return nil, true
+	}
+	parts, err := splitBody(client.codec, client.chunkSize, call.Args)
+	if err != nil {
+		call.Error = err
+		call.done()
+		return nil, false
+	}
+	return parts, true
+}
+
+// register adds call to the pending set, with a new sequence number, before
+// its request is written. If the call cannot be made, it is completed with
+// the error and register reports false. The caller must hold reqMutex.
+func (client *Client) register(call *Call) bool {
 	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if client.shutdown || client.closing {
-		client.mutex.Unlock()
 		call.Error = ErrShutdown
 		call.done()
-		return
+		return false
+	}
+	if client.replyCanary != nil {
+		if violation := client.replyCanary.checkOverlap(call, client.pending); violation != nil {
+			call.Error = violation
+			call.done()
+			return false
+		}
 	}
-	seq := client.seq
-	client.seq++
+	seq := client.nextSeq(call.session)
+	for client.dedup != nil && client.dedup.contains(seq) {
+		// The sequence numbers of a session have wrapped around.
+		seq = client.nextSeq(call.session)
+	}
+	call.seq = seq
 	client.pending[seq] = call
-	client.mutex.Unlock()
+	call.sent = time.Now()
+	if counter, counted := client.codec.(WriteCounter); counted {
+		call.writeStart = counter.BytesWritten()
+	}
+	client.writing = call
+	return true
+}
 
-	// Encode and send the request.
-	client.request.Seq = seq
+// writeCall encodes and sends the request of call, a registered call whose
+// args are sent as parts if there are any, with write. The caller must hold
+// reqMutex.
+func (client *Client) writeCall(call *Call, parts [][]byte, write func(*Request, interface{}) error) error {
+	args := call.Args
+	var err error
+	if len(parts) > 0 {
+		err = client.writeChunks(call, parts)
+		args = parts[len(parts)-1]
+	}
+	client.request.Seq = call.seq
 	client.request.ServiceMethod = call.ServiceMethod
-	err := client.codec.WriteRequest(&client.request, call.Args)
+	client.request.Timeout = call.timeout
+	client.request.VerifyReply = client.verifyReplies && call.stream == nil
+	client.request.Metadata = client.metadata
+	if call.metadata != nil {
+		client.request.Metadata = call.metadata
+	}
+	client.request.Progress = call.progress != nil
+	client.request.Stream = call.stream != nil
+	client.request.Duplex = call.duplex
+	client.request.Chunked = len(parts) > 0
+	client.request.AuthToken = call.token
+	if err == nil {
+		err = write(&client.request, args)
+	}
+	return err
+}
+
+// wrote records that the request of call was written, or failed with err,
+// in which case the call is completed with err.
+func (client *Client) wrote(call *Call, err error) {
+	seq := call.seq
+	client.mutex.Lock()
+	if client.writing == call {
+		client.writing = nil
+	}
+	call.written = err == nil
+	if counter, counted := client.codec.(WriteCounter); counted {
+		call.writeEnd = counter.BytesWritten()
+	}
+	client.mutex.Unlock()
 	if err != nil {
 		client.mutex.Lock()
 		call = client.pending[seq]
@@ -113,9 +235,48 @@ func (client *Client) input() {
 		seq := response.Seq
 		client.mutex.Lock()
 		call := client.pending[seq]
-		delete(client.pending, seq)
+		duplicate := call == nil && client.dedup != nil && client.dedup.contains(seq)
+		if duplicate {
+			client.dedup.duplicates++
+		} else if response.Progress == nil && !response.StreamItem && response.StreamCredit == 0 && !response.Continued {
+			delete(client.pending, seq)
+			if call != nil && client.dedup != nil {
+				client.dedup.add(seq)
+			}
+		}
 		client.mutex.Unlock()
 
+		if duplicate {
+			err = client.codec.ReadResponseBody(nil)
+			continue
+		}
+		if response.Progress != nil {
+			// A notification ahead of the response; the call stays pending.
+			err = client.codec.ReadResponseBody(nil)
+			if err == nil && call != nil && call.progress != nil {
+				call.progress(*response.Progress)
+			}
+			continue
+		}
+		if response.StreamItem || response.StreamCredit > 0 {
+			// A message of a streaming call; the call stays pending.
+			if call != nil && call.stream != nil {
+				err = call.stream.receive(client.codec, &response)
+			} else {
+				err = client.codec.ReadResponseBody(nil)
+			}
+			continue
+		}
+		if response.Continued {
+			// A part of a chunked reply; the call stays pending.
+			var part []byte
+			err = client.codec.ReadResponseBody(&part)
+			if call != nil {
+				client.receiveChunk(call, part)
+			}
+			continue
+		}
+
 		switch {
 		case call == nil:
 			// We've got no pending call. That usually means that
@@ -131,17 +292,27 @@ func (client *Client) input() {
 			// We've got an error response. Give this to the request;
 			// any subsequent requests will get the ReadResponseBody
 			// error if there is one.
-			call.Error = ServerError(response.Error)
+			call.setReplyMetadata(response.Metadata)
+			call.Error = ResponseError(&response)
 			err = client.codec.ReadResponseBody(nil)
 			if err != nil {
 				err = errors.New("reading error body: " + err.Error())
 			}
 			call.done()
 		default:
-			err = client.codec.ReadResponseBody(call.Reply)
+			call.setReplyMetadata(response.Metadata)
+			if response.Chunked {
+				err = client.readChunkedReply(call)
+			} else {
+				err = client.codec.ReadResponseBody(call.Reply)
+			}
 			if err != nil {
 				call.Error = errors.New("reading body " + err.Error())
 			}
+			if call.Error == nil && response.VerifyReply {
+				// Take the digest before the caller can modify the reply.
+				go client.sendReplyDigest(seq, digestReply(call.Reply))
+			}
 			call.done()
 		}
 	}
@@ -150,7 +321,9 @@ func (client *Client) input() {
 	client.mutex.Lock()
 	client.shutdown = true
 	closing := client.closing
-	if err == io.EOF {
+	if client.broken != nil {
+		err = client.broken
+	} else if err == io.EOF {
 		if closing {
 			err = ErrShutdown
 		} else {
@@ -168,7 +341,16 @@ func (client *Client) input() {
 	}
 }
 
+func (call *Call) setReplyMetadata(md Metadata) {
+	if call.replyMD != nil {
+		*call.replyMD = md
+	}
+}
+
 func (call *Call) done() {
+	if call.stream != nil {
+		call.stream.end(call.Error)
+	}
 	select {
 	case call.Done <- call:
 		// ok
@@ -191,22 +373,44 @@ func (call *Call) done() {
 // concurrently so the implementation of conn should protect against
 // concurrent reads or concurrent writes.
 func NewClient(conn io.ReadWriteCloser) *Client {
-	encBuf := bufio.NewWriter(conn)
-	client := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
-	return NewClientWithCodec(client)
+	return NewClientWithCodec(newGobClientCodec(conn))
 }
 
 // NewClientWithCodec is like NewClient but uses the specified
 // codec to encode requests and decode responses.
 func NewClientWithCodec(codec ClientCodec) *Client {
+	return NewClientWithOpts(codec)
+}
+
+// NewClientWithOpts is like NewClientWithCodec but applies the following
+// functional options.
+func NewClientWithOpts(codec ClientCodec, options ...func(*Client)) *Client {
 	client := &Client{
-		codec:   codec,
-		pending: make(map[uint64]*Call),
+		codec:           codec,
+		pending:         make(map[uint64]*Call),
+		maxChunkedReply: defaultMaxChunkedBody,
+	}
+	for _, option := range options {
+		option(client)
 	}
 	go client.input()
+	if client.keepalive != nil {
+		go client.ping(client.keepalive)
+	}
 	return client
 }
 
+// ClientCallInterceptor acts as a middleware hook on the client side of the RPC call. The interceptor must
+// invoke next for the call to be made, and may invoke it more than once, for example to retry. The error it
+// returns is returned to the caller. Interceptors apply to Call and CallContext, but not to Go.
+type ClientCallInterceptor func(serviceMethod string, args, reply interface{}, next func() error) error
+
+func WithClientCallInterceptor(interceptor ClientCallInterceptor) func(*Client) {
+	return func(c *Client) {
+		c.callInterceptor = interceptor
+	}
+}
+
 type gobClientCodec struct {
 	rwc    io.ReadWriteCloser
 	dec    *gob.Decoder
@@ -237,7 +441,8 @@ func (c *gobClientCodec) Close() error {
 }
 
 // DialHTTP connects to an HTTP RPC server at the specified network address
-// listening on the default HTTP RPC path.
+// listening on the default HTTP RPC path, with an HTTP/1 CONNECT request.
+// DialHTTP2 connects over an HTTP/2 stream instead.
 func DialHTTP(network, address string) (*Client, error) {
 	return DialHTTPPath(network, address, DefaultRPCPath)
 }
@@ -245,37 +450,12 @@ func DialHTTP(network, address string) (*Client, error) {
 // DialHTTPPath connects to an HTTP RPC server
 // at the specified network address and path.
 func DialHTTPPath(network, address, path string) (*Client, error) {
-	conn, err := net.Dial(network, address)
-	if err != nil {
-		return nil, err
-	}
-	io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n")
-
-	// Require successful HTTP response
-	// before switching to RPC protocol.
-	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
-	if err == nil && resp.Status == connected {
-		return NewClient(conn), nil
-	}
-	if err == nil {
-		err = errors.New("unexpected HTTP response: " + resp.Status)
-	}
-	conn.Close()
-	return nil, &net.OpError{
-		Op:   "dial-http",
-		Net:  network + " " + address,
-		Addr: nil,
-		Err:  err,
-	}
+	return dialWith(HTTPConnectTransport(path, nil), network, address)
 }
 
 // Dial connects to an RPC server at the specified network address.
 func Dial(network, address string) (*Client, error) {
-	conn, err := net.Dial(network, address)
-	if err != nil {
-		return nil, err
-	}
-	return NewClient(conn), nil
+	return dialWith(TCPTransport(), network, address)
 }
 
 // Close calls the underlying codec's Close method. If the connection is already
@@ -300,6 +480,13 @@ func (client *Client) Go(serviceMethod string, args interface{}, reply interface
 	call.ServiceMethod = serviceMethod
 	call.Args = args
 	call.Reply = reply
+	call.Error = client.checkDeadline(nil, serviceMethod)
+	return client.goCall(call, done)
+}
+
+// goCall sends call, which will signal done when it is complete. A call
+// whose Error is already set is completed without being sent.
+func (client *Client) goCall(call *Call, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10) // buffered.
 	} else {
@@ -312,12 +499,169 @@ func (client *Client) Go(serviceMethod string, args interface{}, reply interface
 		}
 	}
 	call.Done = done
+	if call.Error == nil {
+		call.Error = client.authenticate(context.Background(), call)
+	}
+	if call.Error != nil {
+		call.done()
+		return call
+	}
 	client.send(call)
 	return call
 }
 
 // Call invokes the named function, waits for it to complete, and returns its error status.
 func (client *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
-	return call.Error
+	if err := client.checkDeadline(nil, serviceMethod); err != nil {
+		return err
+	}
+	next := client.withRetries(context.Background(), client.withBreaker(func(ctx context.Context) error {
+		call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply}
+		call = <-client.goCall(call, make(chan *Call, 1)).Done
+		client.recordWritten(ctx, call)
+		return call.Error
+	}))
+	if client.callInterceptor != nil {
+		return client.callInterceptor(serviceMethod, args, reply, next)
+	}
+	return next()
+}
+
+// CallContext is like Call but gives up waiting when ctx is done, returning
+// ctx.Err(). An abandoned call is removed from the pending set, so a response
+// that arrives later is discarded and never decoded into reply. If ctx has a
+// deadline, the remaining time is sent to the server, which applies it to the
+// context passed to handlers. Metadata carried by ctx is sent with the
+// request.
+func (client *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if err := client.checkDeadline(ctx, serviceMethod); err != nil {
+		return err
+	}
+	next := client.withRetries(ctx, client.withBreaker(func(ctx context.Context) error {
+		return client.callContext(ctx, serviceMethod, args, reply)
+	}))
+	if client.callInterceptor != nil {
+		return client.callInterceptor(serviceMethod, args, reply, next)
+	}
+	return next()
+}
+
+// CallTimeout is like Call but gives up waiting once timeout has passed,
+// returning ErrTimeout. The abandoned call is removed from the pending set,
+// as by CallContext, and the timeout is sent to the server, which applies it
+// to the context passed to handlers. Retries made under the client's retry
+// policy share the timeout.
+func (client *Client) CallTimeout(serviceMethod string, args interface{}, reply interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := client.CallContext(ctx, serviceMethod, args, reply)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
+
+// withRetries wraps call to retry it according to the client's retry policy.
+// call is given ctx, or the context of the attempt if it is retried.
+func (client *Client) withRetries(ctx context.Context, call func(context.Context) error) func() error {
+	if client.retryPolicy == nil {
+		return func() error {
+			return call(ctx)
+		}
+	}
+	return func() error {
+		return client.retryPolicy.do(ctx, call, client.isShutdown)
+	}
+}
+
+// withBreaker wraps call to go through the client's circuit breaker.
+func (client *Client) withBreaker(call func(context.Context) error) func(context.Context) error {
+	if client.breaker == nil {
+		return call
+	}
+	return func(ctx context.Context) error {
+		return client.breaker.do(func() error {
+			return call(ctx)
+		})
+	}
+}
+
+// recordWritten records in the attempt ctx carries, if the call is retried,
+// whether any of the request of call may have been written.
+func (client *Client) recordWritten(ctx context.Context, call *Call) {
+	a := attemptFromContext(ctx)
+	if a == nil {
+		return
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	switch {
+	case call.written || client.writing == call:
+		a.written = true
+	case !call.sent.IsZero():
+		// The write failed. Unless the codec counts what it wrote, part of
+		// the request may have been.
+		_, counted := client.codec.(WriteCounter)
+		a.written = !counted || call.writeEnd != call.writeStart
+	}
+}
+
+// isShutdown reports whether the client can no longer make calls.
+func (client *Client) isShutdown() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.shutdown || client.closing
+}
+
+func (client *Client) callContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+	}
+	if md := MetadataFromContext(ctx); md != nil {
+		call.metadata = client.metadata.Merge(md)
+	}
+	call.progress = progressFromContext(ctx)
+	call.session = sessionFromContext(ctx)
+	call.replyMD, _ = ctx.Value(responseMetadataSinkKey{}).(*Metadata)
+	if deadline, ok := ctx.Deadline(); ok {
+		if call.timeout = time.Until(deadline); call.timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+	if err := client.authenticate(ctx, call); err != nil {
+		return err
+	}
+	client.send(call)
+	select {
+	case call = <-call.Done:
+		client.recordWritten(ctx, call)
+		return call.Error
+	case <-ctx.Done():
+		if !client.abandon(call) {
+			// The response is already being processed; wait for it so the
+			// reply is not written after we return.
+			call = <-call.Done
+			client.recordWritten(ctx, call)
+			return call.Error
+		}
+		return ctx.Err()
+	}
+}
+
+// abandon removes call from the pending set. It reports false if the call
+// was no longer pending, which means it has completed or is completing.
+func (client *Client) abandon(call *Call) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.pending[call.seq] != call {
+		return false
+	}
+	delete(client.pending, call.seq)
+	return true
 }
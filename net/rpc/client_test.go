@@ -5,11 +5,15 @@
 package rpc
 
 import (
+	"bufio"
+	"context"
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"testing"
+	"time"
 )
 
 type shutdownCodec struct {
@@ -87,3 +91,145 @@ func TestGobError(t *testing.T) {
 
 	listen.Close()
 }
+
+func TestCallContext(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	client, err := Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	err = client.CallContext(context.Background(), "Arith.Add", &Args{7, 8}, reply)
+	if err != nil {
+		t.Fatalf("Add: expected no error but got %v", err)
+	}
+	if reply.C != 15 {
+		t.Errorf("Add: expected 15 got %d", reply.C)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = client.CallContext(ctx, "Arith.SleepMilli", &Args{A: 200}, new(Reply))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	client.mutex.Lock()
+	pending := len(client.pending)
+	client.mutex.Unlock()
+	if pending != 0 {
+		t.Errorf("expected no pending calls after cancellation, got %d", pending)
+	}
+
+	// The late response must be discarded without breaking the connection.
+	time.Sleep(250 * time.Millisecond)
+	err = client.CallContext(context.Background(), "Arith.Add", &Args{1, 2}, reply)
+	if err != nil || reply.C != 3 {
+		t.Errorf("Add after cancellation: got %d, %v", reply.C, err)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	client, err := Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.CallTimeout("Arith.Add", &Args{7, 8}, reply, time.Second); err != nil || reply.C != 15 {
+		t.Fatalf("Add: expected 15, got %d, %v", reply.C, err)
+	}
+	err = client.CallTimeout("Arith.SleepMilli", &Args{A: 200}, new(Reply), 10*time.Millisecond)
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if pending := client.PendingCalls(); len(pending) != 0 {
+		t.Errorf("expected no pending calls after the timeout, got %v", pending)
+	}
+}
+
+type DeadlineEcho struct{}
+
+func (DeadlineEcho) Remaining(ctx context.Context, args struct{}, reply *time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = time.Until(deadline)
+	}
+	return nil
+}
+
+func TestCallContextPropagatesDeadline(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(DeadlineEcho{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	var remaining time.Duration
+	if err := client.CallContext(context.Background(), "DeadlineEcho.Remaining", struct{}{}, &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected no deadline, got %v", remaining)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := client.CallContext(ctx, "DeadlineEcho.Remaining", struct{}{}, &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining <= 0 || remaining > time.Minute {
+		t.Errorf("expected a deadline within a minute, got %v", remaining)
+	}
+
+	// Plain calls on the same client must not inherit the previous timeout.
+	remaining = 0
+	if err := client.Call("DeadlineEcho.Remaining", struct{}{}, &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected no deadline, got %v", remaining)
+	}
+}
+
+func TestClientCallInterceptor(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	var attempts []string
+	retry := func(serviceMethod string, args, reply interface{}, next func() error) error {
+		attempts = append(attempts, serviceMethod)
+		if err := next(); err == nil {
+			return nil
+		}
+		attempts = append(attempts, serviceMethod)
+		return next()
+	}
+	encBuf := bufio.NewWriter(conn)
+	codec := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	client := NewClientWithOpts(codec, WithClientCallInterceptor(retry))
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add: got %d, %v", reply.C, err)
+	}
+	if err := client.CallContext(context.Background(), "Arith.Error", &Args{}, reply); err == nil || err.Error() != "ERROR" {
+		t.Errorf("Error: expected ERROR, got %v", err)
+	}
+	want := "Arith.Add Arith.Error Arith.Error"
+	if got := strings.Join(attempts, " "); got != want {
+		t.Errorf("expected attempts %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteCoalescer is implemented by ServerCodecs that can leave responses
+// buffered and flush several at once, as set by WithWriteCoalescing. The gob
+// codec used by this package and the msgpackrpc codec, when it buffers
+// writes, implement it.
+type WriteCoalescer interface {
+	// SetWriteCoalescing is called before the first request is read.
+	SetWriteCoalescing(maxResponses int, maxDelay time.Duration)
+}
+
+// WithWriteCoalescing makes the server flush responses to connections
+// whose codec implements WriteCoalescer once maxResponses are buffered, or
+// maxDelay after the first of them was, rather than after each response,
+// saving a write to the connection for every response beyond the first
+// under high throughput. Each response may be delayed by up to maxDelay,
+// so it should be a few hundred microseconds at most. A maxResponses of 1 or
+// less, or a maxDelay of 0, leaves every response flushed at once.
+func WithWriteCoalescing(maxResponses int, maxDelay time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.coalescing = nil
+		if maxResponses > 1 && maxDelay > 0 {
+			s.coalescing = &writeCoalescing{maxResponses: maxResponses, maxDelay: maxDelay}
+		}
+	}
+}
+
+type writeCoalescing struct {
+	maxResponses int
+	maxDelay     time.Duration
+}
+
+// DelayedFlusher decides when a codec coalescing its writes flushes them.
+// It is meant for implementations of WriteCoalescer.
+type DelayedFlusher struct {
+	mu    sync.Locker // held by the codec while it writes
+	max   int
+	delay time.Duration
+	flush func() error
+
+	buffered int         // writes since the last flush
+	timer    *time.Timer // flushes delay after the first of them
+	err      error       // the error of the last flush made by the timer
+}
+
+// NewDelayedFlusher returns a DelayedFlusher calling flush once max writes
+// were made since the last flush, or delay after the first of them. mu is
+// the lock the codec holds while writing: the delayed flushes are made with
+// it held. A max of 1 or less flushes after every write.
+func NewDelayedFlusher(mu sync.Locker, max int, delay time.Duration, flush func() error) *DelayedFlusher {
+	return &DelayedFlusher{mu: mu, max: max, delay: delay, flush: flush}
+}
+
+// Wrote records a write the codec buffered, flushing if enough are. It must
+// be called with the codec's lock held. It returns the error of the flush,
+// or of the last delayed flush if it failed.
+func (f *DelayedFlusher) Wrote() error {
+	if err := f.err; err != nil {
+		return err
+	}
+	f.buffered++
+	if f.buffered >= f.max || f.delay <= 0 {
+		return f.Flush()
+	}
+	if f.buffered == 1 {
+		if f.timer == nil {
+			f.timer = time.AfterFunc(f.delay, f.flushDelayed)
+		} else {
+			f.timer.Reset(f.delay)
+		}
+	}
+	return nil
+}
+
+// Flush flushes the buffered writes now. It must be called with the codec's
+// lock held.
+func (f *DelayedFlusher) Flush() error {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.buffered = 0
+	return f.flush()
+}
+
+func (f *DelayedFlusher) flushDelayed() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.buffered == 0 {
+		return
+	}
+	f.buffered = 0
+	if err := f.flush(); err != nil && f.err == nil {
+		f.err = err
+	}
+}
+
+// SetWriteCoalescing implements WriteCoalescer.
+func (c *gobServerCodec) SetWriteCoalescing(maxResponses int, maxDelay time.Duration) {
+	c.flusher = NewDelayedFlusher(&c.writeMu, maxResponses, maxDelay, c.encBuf.Flush)
+}
+
+// flushOnClose flushes the responses still buffered by a codec coalescing
+// its writes, unless a write holds the codec's lock, which may be waiting
+// for the connection Close is called to break.
+func (c *gobServerCodec) flushOnClose() {
+	if c.flusher == nil || !c.writeMu.TryLock() {
+		return
+	}
+	c.flusher.Flush()
+	c.writeMu.Unlock()
+}
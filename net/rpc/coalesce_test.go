@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelayedFlusher(t *testing.T) {
+	var mu sync.Mutex
+	flushes := 0
+	flush := func() error {
+		flushes++
+		return nil
+	}
+	f := NewDelayedFlusher(&mu, 3, 10*time.Millisecond, flush)
+
+	mu.Lock()
+	for i := 0; i < 3; i++ {
+		f.Wrote()
+	}
+	got := flushes
+	mu.Unlock()
+	if got != 1 {
+		t.Fatalf("expected a flush after 3 writes, got %d", got)
+	}
+
+	mu.Lock()
+	f.Wrote()
+	mu.Unlock()
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return flushes == 2
+	})
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	srv := NewServerWithOpts(WithWriteCoalescing(4, time.Second))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	served := make(chan *writeCountingNetConn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		counted := &writeCountingNetConn{Conn: conn}
+		served <- counted
+		codec := newGobServerCodec(counted)
+		defer codec.Close()
+		for srv.ServeRequestAsync(context.Background(), codec) == nil {
+		}
+	}()
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The responses are flushed four at a time, well before the delay.
+	batch := client.Batch()
+	for i := 0; i < 8; i++ {
+		batch.Call("Arith.Add", Args{i, 1}, new(Reply))
+	}
+	start := time.Now()
+	if err := batch.Do(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took >= time.Second {
+		t.Errorf("expected the responses to be flushed once 4 were buffered, took %v", took)
+	}
+	if n := (<-served).writes.Load(); n != 2 {
+		t.Errorf("expected 2 writes, got %d", n)
+	}
+}
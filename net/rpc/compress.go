@@ -0,0 +1,173 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"compress/flate"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// StreamCompressor compresses a whole connection, as opposed to compressing
+// each message. It suits codecs and workloads where per-message framing
+// overhead dominates. Each Write on a compressed connection is flushed, so
+// codecs should buffer a message and write it at once, as the gob and
+// msgpack codecs do.
+type StreamCompressor struct {
+	// Name identifies the compressor during negotiation.
+	Name string
+	// NewReader returns a reader decompressing r.
+	NewReader func(r io.Reader) io.Reader
+	// NewWriter returns a writer compressing to w. Flush must write all
+	// pending data to w.
+	NewWriter func(w io.Writer) FlushWriter
+}
+
+// FlushWriter is a writer that buffers data until it is flushed.
+type FlushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// FlateCompressor returns a StreamCompressor named "flate" using
+// compress/flate at the given level.
+func FlateCompressor(level int) StreamCompressor {
+	return StreamCompressor{
+		Name:      "flate",
+		NewReader: func(r io.Reader) io.Reader { return flate.NewReader(r) },
+		NewWriter: func(w io.Writer) FlushWriter {
+			fw, err := flate.NewWriter(w, level)
+			if err != nil {
+				// Only an invalid level fails; fall back to the default.
+				fw, _ = flate.NewWriter(w, flate.DefaultCompression)
+			}
+			return fw
+		},
+	}
+}
+
+// NegotiateCompression offers compressors to the server at the other end of
+// conn, in order of preference, and returns conn wrapped in the one the
+// server accepted. If the server accepted none, conn is returned unchanged.
+// It must be called before any other data is written to conn, and the server
+// must call AcceptCompression.
+func NegotiateCompression(conn net.Conn, preferred ...StreamCompressor) (net.Conn, error) {
+	if len(preferred) > 255 {
+		return nil, errors.New("rpc: too many compressors offered")
+	}
+	offer := []byte{byte(len(preferred))}
+	for _, c := range preferred {
+		if len(c.Name) == 0 || len(c.Name) > 255 {
+			return nil, errors.New("rpc: invalid compressor name " + c.Name)
+		}
+		offer = append(offer, byte(len(c.Name)))
+		offer = append(offer, c.Name...)
+	}
+	if _, err := conn.Write(offer); err != nil {
+		return nil, err
+	}
+	chosen, err := readName(conn)
+	if err != nil {
+		return nil, err
+	}
+	if chosen == "" {
+		return conn, nil
+	}
+	for _, c := range preferred {
+		if c.Name == chosen {
+			return newCompressedConn(conn, c), nil
+		}
+	}
+	return nil, errors.New("rpc: server chose compressor " + chosen + " which was not offered")
+}
+
+// AcceptCompression reads the compressors offered by a client calling
+// NegotiateCompression and returns conn wrapped in the first offered one that
+// is also in supported. If there is none, conn is returned unchanged.
+func AcceptCompression(conn net.Conn, supported ...StreamCompressor) (net.Conn, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(conn, n[:]); err != nil {
+		return nil, err
+	}
+	var chosen *StreamCompressor
+	for i := 0; i < int(n[0]); i++ {
+		name, err := readName(conn)
+		if err != nil {
+			return nil, err
+		}
+		if chosen != nil {
+			continue
+		}
+		for j := range supported {
+			if supported[j].Name == name {
+				chosen = &supported[j]
+				break
+			}
+		}
+	}
+
+	reply := []byte{0}
+	if chosen != nil {
+		reply = append([]byte{byte(len(chosen.Name))}, chosen.Name...)
+	}
+	if _, err := conn.Write(reply); err != nil {
+		return nil, err
+	}
+	if chosen == nil {
+		return conn, nil
+	}
+	return newCompressedConn(conn, *chosen), nil
+}
+
+// readName reads a name prefixed by its one-byte length, as sent during
+// connection handshakes.
+func readName(r io.Reader) (string, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	name := make([]byte, n[0])
+	if _, err := io.ReadFull(r, name); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(name), nil
+}
+
+// compressedConn is a net.Conn whose stream is compressed in both directions.
+type compressedConn struct {
+	net.Conn
+	c StreamCompressor
+
+	readOnce sync.Once // the reader is created lazily, as flate reads ahead
+	r        io.Reader
+
+	writeLock sync.Mutex // protects w
+	w         FlushWriter
+}
+
+func newCompressedConn(conn net.Conn, c StreamCompressor) *compressedConn {
+	return &compressedConn{Conn: conn, c: c, w: c.NewWriter(conn)}
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	c.readOnce.Do(func() {
+		c.r = c.c.NewReader(c.Conn)
+	})
+	return c.r.Read(p)
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"compress/flate"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConn counts the bytes written to a connection.
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func startCompressedServer(t *testing.T, supported ...StreamCompressor) string {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				cconn, err := AcceptCompression(conn, supported...)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				serveConn(srv, cconn)
+			}()
+		}
+	}()
+	return addr
+}
+
+// scanPadded calls Arith.Scan with a highly compressible argument and
+// returns the number of bytes written to the connection.
+func scanPadded(t *testing.T, addr string, offer ...StreamCompressor) int64 {
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted := &countingConn{Conn: raw}
+	conn, err := NegotiateCompression(counted, offer...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Scan", strings.Repeat(" ", 64<<10)+"42", reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 42 {
+		t.Errorf("expected 42, got %d", reply.C)
+	}
+	return counted.written.Load()
+}
+
+func TestStreamCompression(t *testing.T) {
+	flateC := FlateCompressor(flate.BestSpeed)
+	addr := startCompressedServer(t, flateC)
+
+	compressed := scanPadded(t, addr, StreamCompressor{Name: "unknown"}, flateC)
+	if compressed > 4<<10 {
+		t.Errorf("expected the compressed request to be small, wrote %d bytes", compressed)
+	}
+
+	// Without a common compressor, the connection is left uncompressed.
+	uncompressed := scanPadded(t, addr, StreamCompressor{Name: "unknown"})
+	if uncompressed < 64<<10 {
+		t.Errorf("expected an uncompressed request, wrote %d bytes", uncompressed)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// CodeTooManyRequests is the code of ErrTooManyRequests. Clients can test
+// for it with ErrorCode.
+const CodeTooManyRequests = "too_many_requests"
+
+// ErrTooManyRequests is sent to clients for requests refused because the
+// server's concurrency limit was reached and its queue was full. The request
+// was not served, so it is marked retryable.
+var ErrTooManyRequests error = &CodedError{
+	Code:      CodeTooManyRequests,
+	Message:   "rpc: too many concurrent requests",
+	Retryable: true,
+}
+
+// WithMaxConcurrentRequests bounds the number of handlers the server runs at
+// once across all connections, which matters when requests are served with
+// ServeRequestAsync. Up to queue requests beyond the limit wait for a
+// handler to finish; the rest are refused with ErrTooManyRequests. A negative
+// queue lets every excess request wait, and a queue of 0 refuses them all.
+// Waiting requests give up when the client's timeout elapses. An n of 0 or
+// less sets no limit.
+//
+// A handler stopped by WithMethodTimeout gives its slot up when its timeout
+// expires, although it keeps running until it returns: handlers that
+// ignore their context can outnumber the limit.
+func WithMaxConcurrentRequests(n, queue int) func(*Server) {
+	return func(s *Server) {
+		if n > 0 {
+			s.requestLimits().global = newConcurrencyLimiter(n, queue)
+		}
+	}
+}
+
+// WithMaxConcurrentRequestsPerConn is like WithMaxConcurrentRequests but
+// bounds the handlers running for each connection, so that one busy client
+// cannot take every slot of the global limit.
+func WithMaxConcurrentRequestsPerConn(n, queue int) func(*Server) {
+	return func(s *Server) {
+		if n > 0 {
+			l := s.requestLimits()
+			l.perConn, l.perConnQueue = n, queue
+		}
+	}
+}
+
+// ConcurrencyStats describes the requests held back by the server's
+// concurrency limits.
+type ConcurrencyStats struct {
+	Running  int    // handlers holding a slot of the global limit
+	Waiting  int    // requests waiting for a slot, globally or on their connection
+	Rejected uint64 // requests refused with ErrTooManyRequests
+	Yields   uint64 // global slots given up by handlers calling CheckCancel
+}
+
+// ConcurrencyStats returns the state of the server's concurrency limits.
+func (server *Server) ConcurrencyStats() ConcurrencyStats {
+	l := server.limits
+	if l == nil {
+		return ConcurrencyStats{}
+	}
+	stats := ConcurrencyStats{Rejected: l.rejected.Load(), Yields: l.yields.Load()}
+	if l.global != nil {
+		stats.Running = len(l.global.slots)
+		stats.Waiting = int(l.global.waiting.Load())
+	}
+	l.mu.Lock()
+	for _, conn := range l.conns {
+		stats.Waiting += int(conn.waiting.Load())
+	}
+	l.mu.Unlock()
+	return stats
+}
+
+func (server *Server) requestLimits() *requestLimits {
+	if server.limits == nil {
+		server.limits = new(requestLimits)
+	}
+	return server.limits
+}
+
+type requestLimits struct {
+	global       *concurrencyLimiter
+	perConn      int
+	perConnQueue int
+	rejected     atomic.Uint64
+	yields       atomic.Uint64
+
+	mu    sync.Mutex // protects conns
+	conns map[ServerCodec]*concurrencyLimiter
+}
+
+// Admit implements AdmissionController, waiting for a slot for the request,
+// first on its connection and then globally. The returned function releases
+// the slots.
+func (l *requestLimits) Admit(ctx context.Context, a Admission) (func(), error) {
+	if a.Stage != AdmitPostBody {
+		return nil, nil
+	}
+	codec := a.Codec
+	var conn *concurrencyLimiter
+	if l.perConn > 0 && codec != nil && comparableCodec(codec) {
+		l.mu.Lock()
+		if l.conns == nil {
+			l.conns = make(map[ServerCodec]*concurrencyLimiter)
+		}
+		conn = l.conns[codec]
+		if conn == nil {
+			conn = newConcurrencyLimiter(l.perConn, l.perConnQueue)
+			l.conns[codec] = conn
+		}
+		l.mu.Unlock()
+		if err := l.wait(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+	// A handler may give its global slot up in CheckCancel, so it holds
+	// the slot through ys.
+	var ys *yieldState
+	if rv := requestValuesFrom(ctx); rv != nil {
+		ys = rv.yield
+	}
+	if l.global != nil {
+		if err := l.wait(ctx, l.global); err != nil {
+			if conn != nil {
+				conn.release()
+			}
+			return nil, err
+		}
+		if ys != nil {
+			ys.hold(l.global)
+		}
+	}
+	return func() {
+		if l.global != nil && (ys == nil || ys.release()) {
+			l.global.release()
+		}
+		if conn != nil {
+			conn.release()
+		}
+	}, nil
+}
+
+func (l *requestLimits) wait(ctx context.Context, c *concurrencyLimiter) error {
+	err := c.acquire(ctx)
+	if err == ErrTooManyRequests {
+		l.rejected.Add(1)
+	}
+	return err
+}
+
+// ForgetConn implements ConnForgetter.
+func (l *requestLimits) ForgetConn(codec ServerCodec) {
+	l.mu.Lock()
+	delete(l.conns, codec)
+	l.mu.Unlock()
+}
+
+// concurrencyLimiter is a semaphore with a bounded number of waiters.
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   int // negative for no bound
+	waiting atomic.Int64
+}
+
+func newConcurrencyLimiter(n, queue int) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, n), queue: queue}
+}
+
+func (c *concurrencyLimiter) acquire(ctx context.Context) error {
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if n := c.waiting.Add(1); c.queue >= 0 && n > int64(c.queue) {
+		c.waiting.Add(-1)
+		return ErrTooManyRequests
+	}
+	defer c.waiting.Add(-1)
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *concurrencyLimiter) release() {
+	<-c.slots
+}
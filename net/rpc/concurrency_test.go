@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func startAsyncServer(t *testing.T, srv *Server) string {
+	l, addr := listenTCP(t)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				codec := newGobServerCodec(conn)
+				defer codec.Close()
+				for srv.ServeRequestAsync(context.Background(), codec) == nil {
+				}
+			}()
+		}
+	}()
+	return addr
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	srv := NewServerWithOpts(WithMaxConcurrentRequests(1, 1))
+	blocker := newBlocker()
+	if err := srv.RegisterAll(blocker, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	running := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+	reply := new(Reply)
+	queued := client.Go("Arith.Add", Args{1, 2}, reply, nil)
+	waitFor(t, func() bool { return srv.ConcurrencyStats().Waiting == 1 })
+
+	err = client.Call("Arith.Add", Args{1, 2}, new(Reply))
+	if ErrorCode(err) != CodeTooManyRequests || !IsRetryable(err) {
+		t.Errorf("expected ErrTooManyRequests, got %v", err)
+	}
+	select {
+	case <-queued.Done:
+		t.Fatal("expected the queued request to wait")
+	default:
+	}
+
+	close(blocker.release)
+	if call := <-running.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if call := <-queued.Done; call.Error != nil || reply.C != 3 {
+		t.Fatalf("expected the queued request to be served, got %d, %v", reply.C, call.Error)
+	}
+	if stats := srv.ConcurrencyStats(); stats != (ConcurrencyStats{Rejected: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMaxConcurrentRequestsPerConn(t *testing.T) {
+	srv := NewServerWithOpts(WithMaxConcurrentRequestsPerConn(1, 0))
+	blocker := newBlocker()
+	if err := srv.RegisterAll(blocker, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	addr := startAsyncServer(t, srv)
+	busy, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	other, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	running := busy.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+	if err := busy.Call("Arith.Add", Args{1, 2}, new(Reply)); ErrorCode(err) != CodeTooManyRequests {
+		t.Errorf("expected ErrTooManyRequests, got %v", err)
+	}
+	if err := other.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Errorf("expected another connection to be served, got %v", err)
+	}
+	close(blocker.release)
+	if call := <-running.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// ServerConfig is a view of the options a Server was created with, meant to
+// be included in debug bundles. Functions are reported by name and
+// configuration that may hold sensitive data, such as schema baselines, is
+// summarized rather than copied.
+type ServerConfig struct {
+	Services []string `json:"services"`
+
+	Interceptors InterceptorConfig `json:"interceptors"`
+
+	RequestRouter   string             `json:"request_router,omitempty"`
+	WireNamer       string             `json:"wire_namer"`
+	SchemaBaseline  []string           `json:"schema_baseline,omitempty"` // services checked against a baseline
+	ErrorBudget     *ErrorBudgetConfig `json:"error_budget,omitempty"`
+	MethodTimeouts  []MethodTimeout    `json:"method_timeouts,omitempty"`
+	Fairness        string             `json:"fairness,omitempty"` // the IdentityFunc, if fairness tracking is enabled
+	LazyMethods     bool               `json:"lazy_methods"`
+	ReplyVerify     bool               `json:"reply_verification"`
+	ReplyCanary     bool               `json:"reply_canary"`
+	WriteQueueLimit int                `json:"write_queue_limit"`
+	BulkMethods     []string           `json:"bulk_methods,omitempty"`
+	WriteRetry      *WriteRetryConfig  `json:"write_retry,omitempty"`
+	Metadata        Metadata           `json:"metadata,omitempty"`
+}
+
+// InterceptorConfig names the interceptors set on a Server. Unset
+// interceptors are empty.
+type InterceptorConfig struct {
+	ServiceCall        string `json:"service_call,omitempty"`
+	ServiceCallContext string `json:"service_call_context,omitempty"`
+	PreBody            string `json:"pre_body,omitempty"`
+	PreBodyContext     string `json:"pre_body_context,omitempty"`
+	Response           string `json:"response,omitempty"`
+	Panic              string `json:"panic,omitempty"`
+}
+
+// ErrorBudgetConfig is the ErrorBudget a Server was created with.
+type ErrorBudgetConfig struct {
+	Window    time.Duration `json:"window"`
+	Threshold float64       `json:"threshold"`
+	MinCalls  int           `json:"min_calls"`
+	OnAlarm   string        `json:"on_alarm,omitempty"`
+}
+
+// WriteRetryConfig is the WriteRetryPolicy a Server was created with.
+type WriteRetryConfig struct {
+	MaxAttempts int           `json:"max_attempts"`
+	Timeout     time.Duration `json:"timeout"`
+	IsTransient string        `json:"is_transient"`
+}
+
+// MethodTimeout is a timeout set with WithMethodTimeout.
+type MethodTimeout struct {
+	Pattern string        `json:"pattern"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// Config returns the server's current configuration.
+func (server *Server) Config() ServerConfig {
+	c := ServerConfig{
+		Interceptors: InterceptorConfig{
+			ServiceCall:        funcName(server.serverServiceCallInterceptor),
+			ServiceCallContext: funcName(server.serverServiceCallContextInterceptor),
+			PreBody:            funcName(server.preBodyInterceptor),
+			PreBodyContext:     funcName(server.preBodyContextInterceptor),
+			Response:           funcName(server.responseInterceptor),
+			Panic:              funcName(server.panicHandler),
+		},
+		RequestRouter:   funcName(server.requestRouter),
+		WireNamer:       funcName(server.wireNamer),
+		LazyMethods:     server.lazyMethods,
+		ReplyVerify:     server.replyVerifier != nil,
+		ReplyCanary:     server.replyCanary != nil,
+		WriteQueueLimit: server.writeQueueLimit,
+		BulkMethods:     append([]string(nil), server.bulkMethods...),
+		Metadata:        server.Metadata(),
+	}
+	server.serviceMap.Range(func(name, _ interface{}) bool {
+		c.Services = append(c.Services, name.(string))
+		return true
+	})
+	sort.Strings(c.Services)
+	if c.WireNamer == "" {
+		c.WireNamer = funcName(GobWireName)
+	}
+	for name := range server.schemaBaseline {
+		c.SchemaBaseline = append(c.SchemaBaseline, name)
+	}
+	sort.Strings(c.SchemaBaseline)
+	if eb := server.errorBudget; eb != nil {
+		c.ErrorBudget = &ErrorBudgetConfig{
+			Window:    eb.Window,
+			Threshold: eb.Threshold,
+			MinCalls:  eb.MinCalls,
+			OnAlarm:   funcName(eb.OnAlarm),
+		}
+	}
+	for _, mt := range server.methodTimeouts {
+		c.MethodTimeouts = append(c.MethodTimeouts, MethodTimeout{Pattern: mt.pattern, Timeout: mt.timeout})
+	}
+	if server.fairness != nil {
+		c.Fairness = funcName(server.fairness.identify)
+	}
+	if wr := server.writeRetry; wr != nil {
+		c.WriteRetry = &WriteRetryConfig{
+			MaxAttempts: wr.policy.MaxAttempts,
+			Timeout:     wr.policy.Timeout,
+			IsTransient: funcName(wr.policy.IsTransient),
+		}
+	}
+	if c.WriteQueueLimit <= 0 {
+		c.WriteQueueLimit = DefaultWriteQueueLimit
+	}
+	return c
+}
+
+// funcName returns the name of the function fn, or the empty string if fn is
+// nil. Closures are named after the function that created them.
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
@@ -0,0 +1,43 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func allowAll(string, net.Addr) error { return nil }
+
+func TestServerConfig(t *testing.T) {
+	srv := NewServerWithOpts(
+		WithPreBodyInterceptor(allowAll),
+		WithMethodTimeout("Arith.*", time.Second),
+		WithFairnessTracking(nil),
+		WithBulkMethod("Arith.Mul"),
+	)
+	srv.Register(new(Arith))
+
+	c := srv.Config()
+	if !strings.HasSuffix(c.Interceptors.PreBody, ".allowAll") {
+		t.Errorf("expected the pre-body interceptor to be named, got %q", c.Interceptors.PreBody)
+	}
+	if c.Interceptors.ServiceCall != "" || c.Interceptors.Response != "" {
+		t.Errorf("expected unset interceptors to be empty, got %+v", c.Interceptors)
+	}
+	if len(c.Services) != 1 || c.Services[0] != "Arith" {
+		t.Errorf("expected services [Arith], got %v", c.Services)
+	}
+	if len(c.MethodTimeouts) != 1 || c.MethodTimeouts[0] != (MethodTimeout{"Arith.*", time.Second}) {
+		t.Errorf("unexpected method timeouts %v", c.MethodTimeouts)
+	}
+	if !strings.HasSuffix(c.Fairness, ".SourceHostIdentity") || !strings.HasSuffix(c.WireNamer, ".GobWireName") {
+		t.Errorf("expected default functions to be named, got %q and %q", c.Fairness, c.WireNamer)
+	}
+	if c.WriteQueueLimit != DefaultWriteQueueLimit || c.ErrorBudget != nil {
+		t.Errorf("unexpected defaults %+v", c)
+	}
+}
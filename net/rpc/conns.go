@@ -0,0 +1,176 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"errors"
+	"net"
+	"reflect"
+)
+
+// errUnkeyedCodec is returned for the chunked and duplex calls read from
+// codecs that are not comparable.
+var errUnkeyedCodec = errors.New("rpc: chunked and duplex calls need a comparable ServerCodec")
+
+// ActiveConns returns the source addresses of the codecs the server is
+// currently serving. A codec served with ServeRequestAsync, as by
+// ServeCodecContext and ServeConn, is tracked from the first time it is
+// passed to it until reading from it fails. A codec served with ServeRequest
+// or ServeRequestContext is only tracked while one of them runs, which a loop
+// serving a connection with them spends waiting for the next request. Codecs
+// that are not comparable are not tracked.
+func (server *Server) ActiveConns() []net.Addr {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(server.codecs))
+	for codec := range server.codecs {
+		if addr := codec.SourceAddr(); addr != nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// CloseConn closes every codec being served whose source address matches
+// addr. It returns an error if there is no such codec.
+func (server *Server) CloseConn(addr net.Addr) error {
+	var matched []ServerCodec
+	server.mu.Lock()
+	for codec := range server.codecs {
+		if src := codec.SourceAddr(); src != nil && src.Network() == addr.Network() && src.String() == addr.String() {
+			matched = append(matched, codec)
+			delete(server.codecs, codec)
+			server.retireCodec(codec)
+		}
+	}
+	server.mu.Unlock()
+
+	if len(matched) == 0 {
+		return errors.New("rpc: no connection from " + addr.String())
+	}
+	for _, codec := range matched {
+		codec.Close()
+	}
+	return nil
+}
+
+// codecLogger is implemented by the codecs of this package that log, so that
+// they log through the logger of the server serving them.
+type codecLogger interface {
+	setLogger(Logger)
+}
+
+// trackCodec records codec as being served until untrackCodec is called, or
+// if it is lent, until releaseCodec is called. It returns the queue
+// serializing responses written to codec. Codecs that cannot be map keys are
+// not recorded: they share a queue if they are served asynchronously, and
+// are given one of their own for each request otherwise.
+func (server *Server) trackCodec(codec ServerCodec, lent bool) *writeQueue {
+	keyed := comparableCodec(codec)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if keyed {
+		if sending := server.codecs[codec]; sending != nil {
+			return sending
+		}
+	}
+	if limiter, ok := codec.(RequestSizeLimiter); ok && server.maxRequestBytes > 0 {
+		limiter.SetMaxRequestBytes(server.maxRequestBytes)
+	}
+	if l, ok := codec.(codecLogger); ok {
+		l.setLogger(server.logger())
+	}
+	if !keyed {
+		if lent {
+			return newWriteQueue(server.writeQueueLimit, &server.writeStats)
+		}
+		if server.unkeyedQueue == nil {
+			server.unkeyedQueue = newWriteQueue(server.writeQueueLimit, &server.writeStats)
+		}
+		return server.unkeyedQueue
+	}
+	if server.codecs == nil {
+		server.codecs = make(map[ServerCodec]*writeQueue)
+	}
+	sending := newWriteQueue(server.writeQueueLimit, &server.writeStats)
+	server.codecs[codec] = sending
+	if lent {
+		// A loop serving a connection with ServeRequest lends its codec
+		// once per request: the traffic it has already been counted for
+		// is remembered so that it is not counted again.
+		if server.lent == nil {
+			server.lent = make(map[ServerCodec]codecBytes)
+		}
+		server.lent[codec] = trafficOf(codec)
+	} else {
+		server.connsAccepted++
+	}
+	if coalescer, ok := codec.(WriteCoalescer); ok && server.coalescing != nil {
+		coalescer.SetWriteCoalescing(server.coalescing.maxResponses, server.coalescing.maxDelay)
+	}
+	return sending
+}
+
+// untrackCodec forgets codec and the state the server keeps for its
+// connection.
+func (server *Server) untrackCodec(codec ServerCodec) {
+	if !comparableCodec(codec) {
+		return
+	}
+	server.mu.Lock()
+	if _, ok := server.codecs[codec]; ok {
+		delete(server.codecs, codec)
+		server.retireCodec(codec)
+	}
+	server.mu.Unlock()
+	if server.replyVerifier != nil {
+		server.replyVerifier.forget(codec)
+	}
+	server.forgetConn(codec)
+	server.cancelDuplexes(codec)
+	server.forgetChunks(codec)
+	server.connSetup.forget(codec)
+}
+
+// releaseCodec stops tracking codec, lent to the server by ServeRequest for
+// a single request. The state kept for the connection of a codec that is
+// not lent again is limited to what its next request needs: the parts of
+// its chunked requests and the digests of the replies it is to verify.
+func (server *Server) releaseCodec(codec ServerCodec) {
+	if !comparableCodec(codec) {
+		return
+	}
+	server.mu.Lock()
+	_, lent := server.lent[codec]
+	if lent {
+		delete(server.codecs, codec)
+		server.retireCodec(codec)
+	}
+	server.mu.Unlock()
+	if lent {
+		server.forgetConn(codec)
+		server.connSetup.forget(codec)
+	}
+}
+
+// comparableCodec reports whether codec can be used as a map key. The state
+// the server keeps for each connection is keyed by its codec, so the
+// features needing it, chunked and duplex calls, are not available for
+// other codecs.
+func comparableCodec(codec ServerCodec) bool {
+	return reflect.TypeOf(codec).Comparable()
+}
+
+func (server *Server) closeCodecs() {
+	server.mu.Lock()
+	codecs := server.codecs
+	server.codecs = nil
+	for codec := range codecs {
+		server.retireCodec(codec)
+	}
+	server.mu.Unlock()
+	for codec := range codecs {
+		codec.Close()
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestActiveConns(t *testing.T) {
+	srv, addr, _ := startNewServer(t)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+	if err := client.Call("Arith.Add", &Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := srv.ActiveConns()
+	if len(conns) != 1 {
+		t.Fatalf("expected 1 active connection, got %v", conns)
+	}
+	// The client's local address is the server's view of the source address.
+	if err := srv.CloseConn(conns[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Arith.Add", &Args{1, 2}, new(Reply)); err == nil {
+		t.Error("expected error calling over a closed connection")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(srv.ActiveConns()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conns := srv.ActiveConns(); len(conns) != 0 {
+		t.Errorf("expected no active connections, got %v", conns)
+	}
+
+	unknown := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	if err := srv.CloseConn(unknown); err == nil {
+		t.Error("expected error closing an unknown connection")
+	}
+}
+
+func TestServeRequestReleasesCodec(t *testing.T) {
+	srv, _, _ := startNewServer(t)
+	for i := 0; i < 1000; i++ {
+		codec := &CodecEmulator{server: srv}
+		var reply Reply
+		if err := codec.Call("Arith.Add", &Args{7, 8}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns := srv.ActiveConns(); len(conns) != 0 {
+		t.Errorf("expected no active connections, got %d", len(conns))
+	}
+}
+
+// uncomparableCodec is a codec that cannot be a map key.
+type uncomparableCodec struct {
+	*CodecEmulator
+	_ []int
+}
+
+func TestServeRequestUncomparableCodec(t *testing.T) {
+	srv, _, _ := startNewServer(t)
+	codec := &CodecEmulator{server: srv, serviceMethod: "Arith.Add", args: &Args{7, 8}, reply: new(Reply)}
+	if err := srv.ServeRequest(uncomparableCodec{CodecEmulator: codec}); err != nil {
+		t.Fatal(err)
+	}
+	if codec.err != nil || codec.reply.C != 15 {
+		t.Errorf("got %v, %d", codec.err, codec.reply.C)
+	}
+	if conns := srv.ActiveConns(); len(conns) != 0 {
+		t.Errorf("expected no active connections, got %v", conns)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnPhase is a phase of establishing a connection, from the listener
+// returning it to its first request.
+type ConnPhase int
+
+const (
+	// ConnPhaseAccept lasts from Accept returning the connection to the
+	// start of the next phase: the time spent dispatching it to the
+	// goroutine serving it.
+	ConnPhaseAccept ConnPhase = iota
+	// ConnPhaseTLSHandshake is the TLS handshake.
+	ConnPhaseTLSHandshake
+	// ConnPhaseNegotiate is the negotiation of the codec, as with
+	// CodecRegistry.NewServerCodec.
+	ConnPhaseNegotiate
+	// ConnPhaseFirstRequest lasts from the connection being ready to the
+	// header of its first request being read.
+	ConnPhaseFirstRequest
+
+	numConnPhases
+)
+
+var connPhaseNames = [numConnPhases]string{"accept", "tls_handshake", "negotiate", "first_request"}
+
+// String returns the name of the phase in metrics.
+func (p ConnPhase) String() string {
+	if p < 0 || p >= numConnPhases {
+		return fmt.Sprintf("ConnPhase(%d)", int(p))
+	}
+	return connPhaseNames[p]
+}
+
+// ConnPhaseStats summarizes the times a phase took.
+type ConnPhaseStats struct {
+	Count    uint64        // connections that completed the phase
+	Failures uint64        // connections that failed in the phase
+	Total    time.Duration // total time the completed phases took
+	Max      time.Duration
+}
+
+// ConnSetupStats summarizes the phases of the connections set up with
+// TrackConnSetup.
+type ConnSetupStats struct {
+	Accept       ConnPhaseStats
+	TLSHandshake ConnPhaseStats
+	Negotiate    ConnPhaseStats
+	FirstRequest ConnPhaseStats
+}
+
+// Phase returns the stats of phase.
+func (s ConnSetupStats) Phase(phase ConnPhase) ConnPhaseStats {
+	switch phase {
+	case ConnPhaseAccept:
+		return s.Accept
+	case ConnPhaseTLSHandshake:
+		return s.TLSHandshake
+	case ConnPhaseNegotiate:
+		return s.Negotiate
+	case ConnPhaseFirstRequest:
+		return s.FirstRequest
+	}
+	return ConnPhaseStats{}
+}
+
+// SlowConnPhase describes a phase of establishing a connection that took
+// longer than the threshold set with WithSlowConnPhaseThreshold.
+type SlowConnPhase struct {
+	Phase      ConnPhase
+	Duration   time.Duration
+	RemoteAddr net.Addr
+	Err        error // the error the phase failed with, if any
+}
+
+// WithSlowConnPhaseThreshold makes the server call fn for each phase of
+// establishing a connection that takes threshold or longer, so that growing
+// handshakes, for example from longer certificate chains, are noticed. fn is
+// called on the goroutine setting up the connection, so it should not block.
+func WithSlowConnPhaseThreshold(threshold time.Duration, fn func(SlowConnPhase)) func(*Server) {
+	return func(s *Server) {
+		s.connSetup.slowThreshold = threshold
+		s.connSetup.onSlow = fn
+	}
+}
+
+// ConnPhaseSink is implemented by MetricsSinks that also record the phases
+// of establishing connections.
+type ConnPhaseSink interface {
+	// RecordConnPhase is called once a phase of establishing a connection
+	// took d and ended with err.
+	RecordConnPhase(phase ConnPhase, d time.Duration, err error)
+}
+
+// ConnSetup times the phases of establishing a connection. Servers
+// accepting their own connections start one with TrackConnSetup as soon as
+// Accept returns, end each phase with Done, and hand the codec serving the
+// connection to Ready. ServeTLS does so for the connections it accepts.
+type ConnSetup struct {
+	server     *Server
+	remoteAddr net.Addr
+	last       time.Time // when the last phase ended
+}
+
+// TrackConnSetup starts timing the setup of conn, which the server's
+// listener has just returned.
+func (server *Server) TrackConnSetup(conn net.Conn) *ConnSetup {
+	return &ConnSetup{server: server, remoteAddr: conn.RemoteAddr(), last: time.Now()}
+}
+
+// Done ends phase, which started when the previous phase ended, or when
+// the connection was accepted. If err is not nil, the phase is recorded as
+// failed.
+func (s *ConnSetup) Done(phase ConnPhase, err error) {
+	now := time.Now()
+	s.server.connSetup.record(s.server, phase, now.Sub(s.last), s.remoteAddr, err)
+	s.last = now
+}
+
+// Ready ends the setup of the connection, which codec serves from now on.
+// The time until its first request is read is recorded as
+// ConnPhaseFirstRequest.
+func (s *ConnSetup) Ready(codec ServerCodec) {
+	s.last = time.Now()
+	s.server.connSetup.waiting.Add(1)
+	s.server.connSetup.pending.Store(codec, s)
+}
+
+// ConnSetupStats returns the phases recorded by ConnSetups.
+func (server *Server) ConnSetupStats() ConnSetupStats {
+	cs := &server.connSetup
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return ConnSetupStats{
+		Accept:       cs.phases[ConnPhaseAccept],
+		TLSHandshake: cs.phases[ConnPhaseTLSHandshake],
+		Negotiate:    cs.phases[ConnPhaseNegotiate],
+		FirstRequest: cs.phases[ConnPhaseFirstRequest],
+	}
+}
+
+type connSetupTracker struct {
+	slowThreshold time.Duration
+	onSlow        func(SlowConnPhase)
+	pending       sync.Map     // ServerCodec to the *ConnSetup waiting for its first request
+	waiting       atomic.Int64 // entries in pending, so requests skip it when empty
+
+	mu     sync.Mutex // protects phases
+	phases [numConnPhases]ConnPhaseStats
+}
+
+func (t *connSetupTracker) record(server *Server, phase ConnPhase, d time.Duration, remoteAddr net.Addr, err error) {
+	if phase < 0 || phase >= numConnPhases {
+		return
+	}
+	t.mu.Lock()
+	stats := &t.phases[phase]
+	if err != nil {
+		stats.Failures++
+	} else {
+		stats.Count++
+		stats.Total += d
+		if d > stats.Max {
+			stats.Max = d
+		}
+	}
+	t.mu.Unlock()
+
+	for _, sink := range server.metricsSinks {
+		if s, ok := sink.(ConnPhaseSink); ok {
+			s.RecordConnPhase(phase, d, err)
+		}
+	}
+	if t.onSlow != nil && d >= t.slowThreshold {
+		t.onSlow(SlowConnPhase{Phase: phase, Duration: d, RemoteAddr: remoteAddr, Err: err})
+	}
+}
+
+// firstRequest records the first request of codec, if its setup was tracked.
+func (t *connSetupTracker) firstRequest(codec ServerCodec) {
+	if t.waiting.Load() == 0 || !comparableCodec(codec) {
+		return
+	}
+	if v, ok := t.pending.LoadAndDelete(codec); ok {
+		t.waiting.Add(-1)
+		v.(*ConnSetup).Done(ConnPhaseFirstRequest, nil)
+	}
+}
+
+// forget drops the setup of codec, whose connection closed before its
+// first request.
+func (t *connSetupTracker) forget(codec ServerCodec) {
+	if _, ok := t.pending.LoadAndDelete(codec); ok {
+		t.waiting.Add(-1)
+	}
+}
+
+// writePrometheus writes the phases recorded so far.
+func (t *connSetupTracker) writePrometheus(b *strings.Builder) {
+	t.mu.Lock()
+	phases := t.phases
+	t.mu.Unlock()
+	b.WriteString("# HELP rpc_server_conn_setup_seconds Time taken by each phase of establishing connections.\n# TYPE rpc_server_conn_setup_seconds summary\n")
+	for phase, stats := range phases {
+		name := ConnPhase(phase).String()
+		fmt.Fprintf(b, "rpc_server_conn_setup_seconds_sum{phase=%q} %s\n", name, formatSeconds(stats.Total))
+		fmt.Fprintf(b, "rpc_server_conn_setup_seconds_count{phase=%q} %d\n", name, stats.Count)
+	}
+	b.WriteString("# HELP rpc_server_conn_setup_failures_total Connections that failed in each phase of being established.\n# TYPE rpc_server_conn_setup_failures_total counter\n")
+	for phase, stats := range phases {
+		fmt.Fprintf(b, "rpc_server_conn_setup_failures_total{phase=%q} %d\n", ConnPhase(phase).String(), stats.Failures)
+	}
+}
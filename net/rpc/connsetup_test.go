@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type phaseSink struct {
+	mu     sync.Mutex
+	phases []string
+}
+
+func (s *phaseSink) RecordCall(string, time.Duration, error) {}
+
+func (s *phaseSink) RecordConnPhase(phase ConnPhase, d time.Duration, err error) {
+	s.mu.Lock()
+	s.phases = append(s.phases, phase.String())
+	s.mu.Unlock()
+}
+
+func (s *phaseSink) recorded() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(s.phases, ",")
+}
+
+func TestConnSetupTLS(t *testing.T) {
+	ca := newTestCA(t)
+	slow := make(chan SlowConnPhase, 10)
+	sink := new(phaseSink)
+	srv := NewServerWithOpts(
+		WithMetricsSink(sink),
+		WithSlowConnPhaseThreshold(100*time.Millisecond, func(p SlowConnPhase) { slow <- p }),
+	)
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	config := &tls.Config{Certificates: []tls.Certificate{ca.leaf("server", x509.ExtKeyUsageServerAuth)}}
+	go srv.ServeTLS(l, config)
+
+	client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool, ServerName: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// The client waits before its first request.
+	time.Sleep(200 * time.Millisecond)
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := srv.ConnSetupStats()
+	for _, phase := range []ConnPhase{ConnPhaseAccept, ConnPhaseTLSHandshake, ConnPhaseFirstRequest} {
+		if s := stats.Phase(phase); s.Count != 1 || s.Failures != 0 {
+			t.Errorf("expected one %s phase, got %+v", phase, s)
+		}
+	}
+	if stats.Negotiate.Count != 0 {
+		t.Errorf("expected no negotiation, got %+v", stats.Negotiate)
+	}
+	// The server may finish the handshake well after the client did.
+	if stats.FirstRequest.Total < 100*time.Millisecond {
+		t.Errorf("expected the first request to take at least 100ms, got %v", stats.FirstRequest.Total)
+	}
+	if got := sink.recorded(); got != "accept,tls_handshake,first_request" {
+		t.Errorf("unexpected phases recorded by the sink: %s", got)
+	}
+	reported := false
+	for len(slow) > 0 {
+		p := <-slow
+		reported = reported || p.Phase == ConnPhaseFirstRequest && p.RemoteAddr != nil
+	}
+	if !reported {
+		t.Error("expected the first request to be reported as slow")
+	}
+
+	var b strings.Builder
+	if err := srv.writePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `rpc_server_conn_setup_seconds_count{phase="tls_handshake"} 1`) {
+		t.Errorf("expected the handshake in the metrics, got:\n%s", b.String())
+	}
+}
+
+func TestConnSetupNegotiate(t *testing.T) {
+	srv := NewServer()
+	registry := NewCodecRegistry()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go NegotiateCodec(c1, "json")
+
+	setup := srv.TrackConnSetup(c2)
+	codec, err := registry.NewServerCodec(c2)
+	setup.Done(ConnPhaseNegotiate, err)
+	if err == nil {
+		codec.Close()
+		t.Fatal("expected an unregistered codec to be refused")
+	}
+	if s := srv.ConnSetupStats().Negotiate; s.Failures != 1 || s.Count != 0 {
+		t.Errorf("expected a failed negotiation, got %+v", s)
+	}
+}
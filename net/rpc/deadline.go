@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrNoDeadline is returned for calls made without a deadline by a client
+// checking deadlines strictly.
+var ErrNoDeadline = errors.New("rpc: call has no deadline")
+
+// MissingDeadline describes a call made without a deadline.
+type MissingDeadline struct {
+	ServiceMethod string
+	// Caller is the file and line of the code that made the call, outside
+	// this package.
+	Caller string
+}
+
+// WithDeadlineCheck makes the client check that calls have a deadline, to
+// find the call sites that can block forever on a server that never
+// answers. Calls made with Call or Go, and with CallContext when ctx has no
+// deadline, are passed to fn, or logged as warnings if fn is nil, and
+// counted by CallsWithoutDeadline. If strict is set, they also fail with
+// ErrNoDeadline without being sent.
+func WithDeadlineCheck(strict bool, fn func(MissingDeadline)) func(*Client) {
+	return func(c *Client) {
+		c.deadlineCheck = &deadlineCheck{strict: strict, fn: fn}
+	}
+}
+
+// CallsWithoutDeadline returns the number of calls made without a deadline
+// by a client created with WithDeadlineCheck.
+func (client *Client) CallsWithoutDeadline() uint64 {
+	if client.deadlineCheck == nil {
+		return 0
+	}
+	return client.deadlineCheck.missing.Load()
+}
+
+type deadlineCheck struct {
+	strict  bool
+	fn      func(MissingDeadline)
+	missing atomic.Uint64
+}
+
+// checkDeadline reports a call to serviceMethod if ctx has no deadline, and
+// returns ErrNoDeadline if the call must not be made. A nil ctx stands for
+// the calls that cannot have one.
+func (client *Client) checkDeadline(ctx context.Context, serviceMethod string) error {
+	dc := client.deadlineCheck
+	if dc == nil {
+		return nil
+	}
+	if ctx != nil {
+		if _, ok := ctx.Deadline(); ok {
+			return nil
+		}
+	}
+	dc.missing.Add(1)
+	md := MissingDeadline{ServiceMethod: serviceMethod, Caller: externalCaller()}
+	if dc.fn != nil {
+		dc.fn(md)
+	} else {
+		defaultLogger.Warn("rpc: call without a deadline", "serviceMethod", md.ServiceMethod, "caller", md.Caller)
+	}
+	if dc.strict {
+		return ErrNoDeadline
+	}
+	return nil
+}
+
+var pkgPrefix = reflect.TypeOf(Client{}).PkgPath() + "."
+
+// externalCaller returns the file and line of the innermost caller outside
+// this package, skipping wrappers such as Session.Call.
+func externalCaller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPrefix) || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadlineCheck(t *testing.T) {
+	_, addr, _ := startNewServer(t)
+	var missing []MissingDeadline
+	report := func(md MissingDeadline) { missing = append(missing, md) }
+	dial := func(strict bool) *Client {
+		d := NewDialer(WithDialClientOptions(WithSessionBits(4), WithDeadlineCheck(strict, report)))
+		client, err := d.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	client := dial(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := client.CallContext(ctx, "Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("expected a call with a deadline to pass, got %+v", missing)
+	}
+
+	// Without strict checking, calls are reported and still made.
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if call := <-client.Go("Arith.Add", Args{1, 2}, new(Reply), nil).Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if err := client.CallContext(context.Background(), "Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 3 || client.CallsWithoutDeadline() != 3 {
+		t.Fatalf("expected three calls reported, got %+v", missing)
+	}
+	for _, md := range missing {
+		if md.ServiceMethod != "Arith.Add" || !strings.Contains(md.Caller, "deadline_test.go:") {
+			t.Errorf("expected the call site in this file, got %+v", md)
+		}
+	}
+
+	// Strict checking fails them without sending them.
+	client = dial(true)
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); !errors.Is(err, ErrNoDeadline) {
+		t.Errorf("expected Call to fail, got %v", err)
+	}
+	if call := <-client.Go("Arith.Add", Args{1, 2}, new(Reply), nil).Done; !errors.Is(call.Error, ErrNoDeadline) {
+		t.Errorf("expected Go to fail, got %v", call.Error)
+	}
+	if err := client.Session(1).CallContext(context.Background(), "Arith.Add", Args{1, 2}, new(Reply)); !errors.Is(err, ErrNoDeadline) {
+		t.Errorf("expected a session's CallContext to fail, got %v", err)
+	}
+	if !strings.Contains(missing[len(missing)-1].Caller, "deadline_test.go:") {
+		t.Errorf("expected the session's caller to be this file, got %+v", missing[len(missing)-1])
+	}
+	if err := client.CallContext(ctx, "Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Error(err)
+	}
+}
@@ -10,10 +10,13 @@ package rpc
 */
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
 	"sort"
+	"strings"
 )
 
 const debugText = `<html>
@@ -24,11 +27,13 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Stability</th><th align=center>Description</th>
 		{{range .Method}}
 			<tr>
 			<td align=left font=fixed>{{.Name}}({{.Type.ArgType}}, {{.Type.ReplyType}}) error</td>
 			<td align=center>{{.Type.NumCalls}}</td>
+			<td align=center>{{.Doc.Stability}}</td>
+			<td align=left>{{.Doc.Description}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -44,6 +49,7 @@ var debugLog = false
 type debugMethod struct {
 	Type *methodType
 	Name string
+	Doc  MethodDoc
 }
 
 type methodArray []debugMethod
@@ -64,27 +70,98 @@ func (m methodArray) Len() int           { return len(m) }
 func (m methodArray) Less(i, j int) bool { return m[i].Name < m[j].Name }
 func (m methodArray) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
 
+// debugJSONService is the JSON form of a service on the debug page.
+type debugJSONService struct {
+	Name    string            `json:"name"`
+	Methods []debugJSONMethod `json:"methods"`
+}
+
+type debugJSONMethod struct {
+	Name        string    `json:"name"`
+	ArgType     string    `json:"argType"`
+	ReplyType   string    `json:"replyType"`
+	Calls       uint      `json:"calls"`
+	Stability   Stability `json:"stability,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+// HandleDebug registers on mux, at path, the server's debug page listing its
+// services, their methods and how often each was called. The page is HTML
+// unless JSON is asked for, with the query parameter format=json or an
+// Accept header listing application/json before text/html.
+func (server *Server) HandleDebug(mux *http.ServeMux, path string) {
+	mux.Handle(path, debugHTTP{server})
+}
+
 type debugHTTP struct {
 	*Server
 }
 
+// wantsJSON reports whether req asks for the debug page as JSON.
+func wantsJSON(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html", "*/*":
+			return false
+		}
+	}
+	return false
+}
+
 // Runs at /debug/rpc
 func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Build a sorted version of the data.
 	var services serviceArray
 	server.serviceMap.Range(func(snamei, svci interface{}) bool {
 		svc := svci.(*service)
-		ds := debugService{svc, snamei.(string), make(methodArray, 0, len(svc.method))}
-		for mname, method := range svc.method {
-			ds.Method = append(ds.Method, debugMethod{method, mname})
+		ds := debugService{svc, snamei.(string), make(methodArray, 0, len(svc.methods()))}
+		for mname, method := range svc.methods() {
+			ds.Method = append(ds.Method, debugMethod{method, mname, svc.docs[mname]})
 		}
 		sort.Sort(ds.Method)
 		services = append(services, ds)
 		return true
 	})
 	sort.Sort(services)
+	if wantsJSON(req) {
+		serveDebugJSON(w, services)
+		return
+	}
 	err := debug.Execute(w, services)
 	if err != nil {
 		fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
+
+func serveDebugJSON(w http.ResponseWriter, services serviceArray) {
+	out := make([]debugJSONService, 0, len(services))
+	for _, ds := range services {
+		js := debugJSONService{Name: ds.Name, Methods: make([]debugJSONMethod, 0, len(ds.Method))}
+		for _, dm := range ds.Method {
+			js.Methods = append(js.Methods, debugJSONMethod{
+				Name:        dm.Name,
+				ArgType:     dm.Type.ArgType.String(),
+				ReplyType:   dm.Type.ReplyType.String(),
+				Calls:       dm.Type.NumCalls(),
+				Stability:   dm.Doc.Stability,
+				Description: dm.Doc.Description,
+			})
+		}
+		out = append(out, js)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintln(w, "rpc: error encoding JSON:", err.Error())
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugJSON(t *testing.T) {
+	srv := NewServer()
+	err := srv.RegisterWithDocs(new(Arith), map[string]MethodDoc{
+		"Mul": {Description: "Multiplies two numbers.", Stability: StabilityBeta},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("Arith.Mul", &Args{2, 3}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv.HandleDebug(mux, DefaultDebugPath)
+
+	for _, tc := range []struct {
+		name     string
+		target   string
+		accept   string
+		wantJSON bool
+	}{
+		{"default", "/debug/rpc", "", false},
+		{"browser", "/debug/rpc", "text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"accept", "/debug/rpc", "application/json", true},
+		{"query", "/debug/rpc?format=json", "", true},
+		{"query overrides accept", "/debug/rpc?format=html", "application/json", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.target, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			isJSON := strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json")
+			if isJSON != tc.wantJSON {
+				t.Fatalf("got Content-Type %q", rec.Header().Get("Content-Type"))
+			}
+			if !isJSON {
+				return
+			}
+			var services []debugJSONService
+			if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+				t.Fatal(err)
+			}
+			if len(services) != 1 || services[0].Name != "Arith" {
+				t.Fatalf("got services %+v", services)
+			}
+			var found bool
+			for _, m := range services[0].Methods {
+				if m.Name != "Mul" {
+					continue
+				}
+				found = true
+				if m.ArgType != "*rpc.Args" || m.ReplyType != "*rpc.Reply" || m.Calls != 1 ||
+					m.Stability != StabilityBeta || m.Description != "Multiplies two numbers." {
+					t.Errorf("got %+v", m)
+				}
+			}
+			if !found {
+				t.Error("Mul not listed")
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+// WithResponseDedup makes the client remember the sequence numbers of the
+// last window calls it completed, for transports that may deliver a
+// response more than once, such as those that retry on reconnect. A
+// response for a remembered call is discarded as a duplicate and counted by
+// DuplicateResponses, and no new call is given a remembered sequence
+// number, so a late duplicate can never complete another call. Without
+// dedup, such responses are discarded as answers to unknown calls.
+func WithResponseDedup(window int) func(*Client) {
+	return func(c *Client) {
+		if window > 0 {
+			c.dedup = &seqWindow{ring: make([]uint64, 0, window), seen: make(map[uint64]struct{}, window)}
+		}
+	}
+}
+
+// DuplicateResponses returns the number of responses discarded as
+// duplicates by WithResponseDedup.
+func (client *Client) DuplicateResponses() uint64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.dedup == nil {
+		return 0
+	}
+	return client.dedup.duplicates
+}
+
+// seqWindow holds the sequence numbers of the calls completed most
+// recently. It is protected by the Client's mutex.
+type seqWindow struct {
+	ring       []uint64
+	next       int // index in ring of the oldest seq, once ring is full
+	seen       map[uint64]struct{}
+	duplicates uint64
+}
+
+func (w *seqWindow) contains(seq uint64) bool {
+	_, ok := w.seen[seq]
+	return ok
+}
+
+// add records the completion of the call with seq, forgetting the oldest
+// call if the window is full.
+func (w *seqWindow) add(seq uint64) {
+	if w.contains(seq) {
+		return
+	}
+	if len(w.ring) < cap(w.ring) {
+		w.ring = append(w.ring, seq)
+	} else {
+		delete(w.seen, w.ring[w.next])
+		w.ring[w.next] = seq
+		w.next = (w.next + 1) % len(w.ring)
+	}
+	w.seen[seq] = struct{}{}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"encoding/gob"
+	"net"
+	"testing"
+)
+
+func TestResponseDedup(t *testing.T) {
+	cli, conn := net.Pipe()
+	client := NewClientWithOpts(newGobClientCodec(cli), WithResponseDedup(4))
+	defer client.Close()
+
+	// A server that delivers the response to the first request twice.
+	served := make(chan error, 1)
+	go func() {
+		dec, enc := gob.NewDecoder(conn), gob.NewEncoder(conn)
+		var seqs []uint64
+		for i := 0; i < 2; i++ {
+			var req Request
+			var args Args
+			if err := dec.Decode(&req); err != nil {
+				served <- err
+				return
+			}
+			if err := dec.Decode(&args); err != nil {
+				served <- err
+				return
+			}
+			seqs = append(seqs, req.Seq)
+		}
+		for _, r := range []struct {
+			seq uint64
+			c   int
+		}{{seqs[0], 1}, {seqs[0], 99}, {seqs[1], 2}} {
+			if err := enc.Encode(&Response{ServiceMethod: "Arith.Add", Seq: r.seq}); err != nil {
+				served <- err
+				return
+			}
+			if err := enc.Encode(&Reply{C: r.c}); err != nil {
+				served <- err
+				return
+			}
+		}
+		served <- nil
+	}()
+
+	first := client.Go("Arith.Add", &Args{}, new(Reply), nil)
+	second := client.Go("Arith.Add", &Args{}, new(Reply), nil)
+	for i, call := range []*Call{first, second} {
+		call = <-call.Done
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+		if got := call.Reply.(*Reply).C; got != i+1 {
+			t.Errorf("call %d got reply %d", i, got)
+		}
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if n := client.DuplicateResponses(); n != 1 {
+		t.Errorf("got %d duplicates, want 1", n)
+	}
+}
+
+func TestSeqWindow(t *testing.T) {
+	w := &seqWindow{ring: make([]uint64, 0, 2), seen: make(map[uint64]struct{})}
+	w.add(1)
+	w.add(2)
+	w.add(3)
+	if w.contains(1) || !w.contains(2) || !w.contains(3) {
+		t.Errorf("window holds %v", w.ring)
+	}
+	w.add(4)
+	if w.contains(2) || !w.contains(4) {
+		t.Errorf("window holds %v", w.ring)
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// duplexWindow is the number of values either side of a duplex call may
+// send beyond those the other side has read. Each side starts with this much
+// credit and is granted more as the other reads.
+const duplexWindow = 16
+
+var typeOfDuplexer = reflect.TypeOf((*duplexer)(nil)).Elem()
+
+// errStreamOverflow is the error of a duplex call whose peer sent more
+// values than it had credit for.
+var errStreamOverflow = errors.New("rpc: stream peer sent values beyond its credit")
+
+// DuplexStream is the reply argument of duplex methods, which receive values
+// of type In from the caller while sending it values of type Out:
+//
+//	func (t *T) Sync(ctx context.Context, args *SyncArgs, stream *rpc.DuplexStream[Entry, Ack]) error
+//
+// Duplex methods are called with OpenDuplex, on connections served with
+// ServeRequestAsync so that the caller's values are read while the method
+// runs. Many duplex calls share a connection; each has its own flow
+// control, so a side that stops reading only stops the other side of the
+// same call from sending.
+type DuplexStream[In, Out any] struct {
+	Stream
+	in *inbox
+}
+
+// Send delivers v to the caller, waiting until the caller has read enough
+// of the values sent before. It fails once the call's context is done.
+func (s *DuplexStream[In, Out]) Send(v Out) error {
+	return s.Stream.Send(v)
+}
+
+// Recv returns the next value sent by the caller. It returns io.EOF once the
+// caller has called CloseSend and every value has been received, and the
+// context's error once the call is cancelled.
+func (s *DuplexStream[In, Out]) Recv() (In, error) {
+	var zero In
+	if s.in == nil {
+		return zero, errStreamUnsupported
+	}
+	v, err := s.in.wait(s.Context())
+	if err != nil {
+		return zero, err
+	}
+	return *v.(*In), nil
+}
+
+func (s *DuplexStream[In, Out]) newIn() interface{} {
+	return new(In)
+}
+
+func (s *DuplexStream[In, Out]) setInbox(in *inbox) {
+	s.in = in
+}
+
+// duplexer is implemented by the *DuplexStream types.
+type duplexer interface {
+	streamer
+	newIn() interface{}
+	setInbox(in *inbox)
+}
+
+func (m *methodType) isDuplex() bool {
+	return m.ReplyType.Implements(typeOfDuplexer)
+}
+
+// isStreamMessage reports whether r is a message of an open duplex call, or
+// a part of a chunked request other than the last, rather than a new
+// request.
+func (r *Request) isStreamMessage() bool {
+	return r.StreamItem || r.StreamEnd || r.StreamCredit > 0 || r.StreamCancel || r.Continued
+}
+
+// inbox holds the values received on one side of a duplex call until they
+// are read. The sender may only send as many values as it was granted
+// credit for, so the inbox never holds more than duplexWindow values and
+// the goroutine reading the connection never waits for it.
+type inbox struct {
+	grant func(n int) // grants the sender credit for n more values
+
+	mu    sync.Mutex // protects following
+	items []interface{}
+	err   error // returned once items are read; io.EOF for a normal end
+	read  int   // values read since the last grant
+
+	ready chan struct{} // has a token when items or err may have changed
+}
+
+func newInbox(grant func(n int)) *inbox {
+	return &inbox{grant: grant, ready: make(chan struct{}, 1)}
+}
+
+// put queues v. Once the values have ended, it drops v.
+func (in *inbox) put(v interface{}) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.err != nil {
+		return nil
+	}
+	if len(in.items) >= duplexWindow {
+		return errStreamOverflow
+	}
+	in.items = append(in.items, v)
+	in.signal()
+	return nil
+}
+
+// end makes err the error returned once the queued values are read, unless
+// the values have already ended.
+func (in *inbox) end(err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.err == nil {
+		in.err = err
+		in.signal()
+	}
+}
+
+func (in *inbox) signal() {
+	select {
+	case in.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns the next value, waiting for one until ctx is done, in which
+// case it ends the values with ctx.Err(). It grants credit once half the
+// window has been read.
+func (in *inbox) wait(ctx context.Context) (interface{}, error) {
+	for {
+		in.mu.Lock()
+		if len(in.items) > 0 {
+			v := in.items[0]
+			in.items[0] = nil
+			in.items = in.items[1:]
+			in.read++
+			n := 0
+			if in.read >= duplexWindow/2 && in.err == nil {
+				n, in.read = in.read, 0
+			}
+			in.mu.Unlock()
+			if n > 0 {
+				in.grant(n)
+			}
+			return v, nil
+		}
+		err := in.err
+		in.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-in.ready:
+		case <-ctx.Done():
+			in.end(ctx.Err())
+		}
+	}
+}
+
+// credit counts the values one side of a duplex call may still send.
+type credit struct {
+	mu   sync.Mutex
+	n    int
+	more chan struct{} // has a token when n may have grown
+}
+
+func newCredit() *credit {
+	return &credit{n: duplexWindow, more: make(chan struct{}, 1)}
+}
+
+func (c *credit) add(n int) {
+	c.mu.Lock()
+	c.n += n
+	c.mu.Unlock()
+	select {
+	case c.more <- struct{}{}:
+	default:
+	}
+}
+
+// acquire takes the credit to send one value, waiting for it until ctx or
+// stop is done.
+func (c *credit) acquire(ctx context.Context, stop <-chan struct{}) error {
+	for {
+		c.mu.Lock()
+		if c.n > 0 {
+			c.n--
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.more:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-stop:
+			return errStreamClosed
+		}
+	}
+}
+
+// duplexKey identifies a duplex call being served.
+type duplexKey struct {
+	codec ServerCodec
+	seq   uint64
+}
+
+// connDuplex is the server side of a duplex call read from a connection.
+type connDuplex struct {
+	newIn  func() interface{}
+	in     *inbox
+	out    *credit
+	cancel context.CancelFunc
+}
+
+// newConnDuplex returns the reply value of req, a call to the duplex method
+// m read from codec, and registers the call so that the messages the client
+// sends on it reach the method. Cancelling ctx with cancel ends the call.
+func (server *Server) newConnDuplex(ctx context.Context, cancel context.CancelFunc, sending *writeQueue, m *methodType, req *Request, codec ServerCodec) reflect.Value {
+	key := duplexKey{codec: codec, seq: req.Seq}
+	serviceMethod := req.ServiceMethod
+	priority := WritePrioritySmall
+	if server.isBulkMethod(serviceMethod) {
+		priority = WritePriorityBulk
+	}
+	write := func(resp *Response, body interface{}) error {
+		resp.ServiceMethod = serviceMethod
+		resp.Seq = key.seq
+		sending.Lock(priority)
+		err := codec.WriteResponse(resp, body)
+		sending.Unlock()
+		server.freeResponse(resp)
+		return err
+	}
+	d := &connDuplex{out: newCredit(), cancel: cancel}
+	d.in = newInbox(func(n int) {
+		resp := server.getResponse()
+		resp.StreamCredit = n
+		write(resp, emptyBody{})
+	})
+	replyv, stream := m.newStream(ctx, func(v interface{}) error {
+		if err := d.out.acquire(ctx, nil); err != nil {
+			return err
+		}
+		resp := server.getResponse()
+		resp.StreamItem = true
+		return write(resp, v)
+	})
+	stream.cancel = func() {
+		server.duplexes.Delete(key)
+		cancel()
+	}
+	dx := replyv.Interface().(duplexer)
+	dx.setInbox(d.in)
+	d.newIn = dx.newIn
+	server.duplexes.Store(key, d)
+	return replyv
+}
+
+// receiveStreamMessage reads the body of req, a message of a duplex call
+// read from codec, and hands it to the call. Messages for calls that are no
+// longer served are discarded.
+func (server *Server) receiveStreamMessage(codec ServerCodec, req *Request) error {
+	var d *connDuplex
+	if v, ok := server.duplexes.Load(duplexKey{codec: codec, seq: req.Seq}); ok {
+		d = v.(*connDuplex)
+	}
+	if d == nil || !req.StreamItem {
+		if err := codec.ReadRequestBody(nil); err != nil {
+			return err
+		}
+	}
+	if d == nil {
+		return nil
+	}
+	switch {
+	case req.StreamItem:
+		v := d.newIn()
+		if err := codec.ReadRequestBody(v); err != nil {
+			return err
+		}
+		if err := d.in.put(v); err != nil {
+			d.in.end(err)
+			d.cancel()
+		}
+	case req.StreamEnd:
+		d.in.end(io.EOF)
+	case req.StreamCredit > 0:
+		d.out.add(req.StreamCredit)
+	case req.StreamCancel:
+		d.cancel()
+	}
+	return nil
+}
+
+// cancelDuplexes cancels the duplex calls read from codec, whose client can
+// no longer send to them.
+func (server *Server) cancelDuplexes(codec ServerCodec) {
+	server.duplexes.Range(func(k, v interface{}) bool {
+		if k.(duplexKey).codec == codec {
+			v.(*connDuplex).cancel()
+		}
+		return true
+	})
+}
+
+// DuplexCall is the caller's side of a duplex call made with OpenDuplex. It
+// sends values of type In to the method and receives values of type Out.
+// Send and CloseSend may be called concurrently with Recv.
+type DuplexCall[In, Out any] struct {
+	client *Client
+	call   *Call
+	ctx    context.Context
+	in     *inbox
+	out    *credit
+
+	endOnce sync.Once
+	ended   chan struct{} // closed once the call is done
+
+	sendMu     sync.Mutex // protects sendClosed; held while sending
+	sendClosed bool
+
+	closeOnce sync.Once
+}
+
+// OpenDuplex calls the duplex method serviceMethod over client's connection,
+// whose DuplexStream is a DuplexStream[In, Out]. If ctx has a deadline, it
+// is sent to the server as CallContext does, and the call fails with
+// ctx.Err() once ctx is done. The codec must carry the Request's and
+// Response's stream fields, as those of this package, msgpackrpc and
+// protorpc do.
+//
+// Values the method sends are read from the connection as they arrive and
+// held until Recv is called. The method is stopped from sending more than a
+// small window of values ahead of Recv, and Send waits likewise for the
+// method to read, so a slow reader holds up only its own call.
+func OpenDuplex[In, Out any](ctx context.Context, client *Client, serviceMethod string, args interface{}) (*DuplexCall[In, Out], error) {
+	if err := client.checkDeadline(ctx, serviceMethod); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := &DuplexCall[In, Out]{
+		client: client,
+		ctx:    ctx,
+		out:    newCredit(),
+		ended:  make(chan struct{}),
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		stream:        s,
+		duplex:        true,
+	}
+	s.call = call
+	s.in = newInbox(func(n int) {
+		s.write(&Request{StreamCredit: n}, emptyBody{})
+	})
+	if md := MetadataFromContext(ctx); md != nil {
+		call.metadata = client.metadata.Merge(md)
+	}
+	call.session = sessionFromContext(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		if call.timeout = time.Until(deadline); call.timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	if err := client.authenticate(ctx, call); err != nil {
+		return nil, err
+	}
+	client.send(call)
+	return s, nil
+}
+
+// Send delivers v to the method, waiting until the method has read enough
+// of the values sent before. It fails once the call is done or closed, or
+// after CloseSend.
+func (s *DuplexCall[In, Out]) Send(v In) error {
+	if err := s.out.acquire(s.ctx, s.ended); err != nil {
+		return err
+	}
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	select {
+	case <-s.ended:
+		return errStreamClosed
+	default:
+	}
+	if s.sendClosed {
+		return errStreamClosed
+	}
+	return s.write(&Request{StreamItem: true}, v)
+}
+
+// CloseSend tells the method that no more values will be sent, so that its
+// Recv returns io.EOF once it has read those that were.
+func (s *DuplexCall[In, Out]) CloseSend() error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	return s.write(&Request{StreamEnd: true}, emptyBody{})
+}
+
+// Recv returns the next value sent by the method. Once the method has
+// returned and every value has been received, Recv returns io.EOF, or the
+// error the method returned.
+func (s *DuplexCall[In, Out]) Recv() (Out, error) {
+	var zero Out
+	v, err := s.in.wait(s.ctx)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			s.Close()
+		}
+		return zero, err
+	}
+	return *v.(*Out), nil
+}
+
+// Close abandons the call: the method's context is cancelled, and values it
+// sends afterwards are discarded.
+func (s *DuplexCall[In, Out]) Close() error {
+	s.closeOnce.Do(func() {
+		select {
+		case <-s.ended:
+			return
+		default:
+		}
+		if s.client.abandon(s.call) {
+			s.write(&Request{StreamCancel: true}, emptyBody{})
+		}
+		s.end(errStreamClosed)
+	})
+	return nil
+}
+
+// write writes a message of the call to the client's connection.
+func (s *DuplexCall[In, Out]) write(req *Request, body interface{}) error {
+	req.ServiceMethod = s.call.ServiceMethod
+	req.Seq = s.call.seq
+	client := s.client
+	client.reqMutex.Lock()
+	defer client.reqMutex.Unlock()
+	if client.isShutdown() {
+		return ErrShutdown
+	}
+	return client.codec.WriteRequest(req, body)
+}
+
+func (s *DuplexCall[In, Out]) receive(codec ClientCodec, resp *Response) error {
+	if !resp.StreamItem {
+		if err := codec.ReadResponseBody(nil); err != nil {
+			return err
+		}
+		s.out.add(resp.StreamCredit)
+		return nil
+	}
+	v := new(Out)
+	if err := codec.ReadResponseBody(v); err != nil {
+		return err
+	}
+	if err := s.in.put(v); err != nil {
+		s.in.end(err)
+	}
+	return nil
+}
+
+func (s *DuplexCall[In, Out]) end(err error) {
+	s.endOnce.Do(func() {
+		if err == nil {
+			err = io.EOF
+		}
+		s.in.end(err)
+		close(s.ended)
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Adder struct {
+	cancelled chan error
+}
+
+// Sum replies to each pair of numbers with their sum, and returns once the
+// caller stops sending.
+func (a *Adder) Sum(ctx context.Context, _ int, stream *DuplexStream[Args, Reply]) error {
+	for {
+		args, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if a.cancelled != nil {
+				a.cancelled <- err
+			}
+			return err
+		}
+		if err := stream.Send(Reply{C: args.A + args.B}); err != nil {
+			return err
+		}
+	}
+}
+
+func newDuplexClient(t *testing.T) (*Client, *Adder) {
+	adder := &Adder{cancelled: make(chan error, 1)}
+	srv := NewServer()
+	if err := srv.RegisterAll(adder, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, adder
+}
+
+func TestDuplex(t *testing.T) {
+	client, _ := newDuplexClient(t)
+	d, err := OpenDuplex[Args, Reply](context.Background(), client, "Adder.Sum", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10 * duplexWindow
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := d.Send(Args{i, i}); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- d.CloseSend()
+	}()
+	for i := 0; i < n; i++ {
+		reply, err := d.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.C != 2*i {
+			t.Fatalf("reply %d: expected %d, got %d", i, 2*i, reply.C)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Recv(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDuplexFlowControl(t *testing.T) {
+	client, _ := newDuplexClient(t)
+	d, err := OpenDuplex[Args, Reply](context.Background(), client, "Adder.Sum", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10 * duplexWindow
+	var sent atomic.Int32
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := d.Send(Args{i, 0}); err != nil {
+				done <- err
+				return
+			}
+			sent.Add(1)
+		}
+		done <- d.CloseSend()
+	}()
+
+	// Nothing reads the replies, so the method stops sending them, stops
+	// reading, and then the caller stops sending.
+	last := int32(-1)
+	for last != sent.Load() {
+		last = sent.Load()
+		time.Sleep(50 * time.Millisecond)
+	}
+	if last >= n || last < duplexWindow {
+		t.Fatalf("expected sending to stop after a few windows, sent %d", last)
+	}
+
+	// Other calls on the connection are not held up.
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		if reply, err := d.Recv(); err != nil || reply.C != i {
+			t.Fatalf("reply %d: got %d, %v", i, reply.C, err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDuplexClose(t *testing.T) {
+	client, adder := newDuplexClient(t)
+	d, err := OpenDuplex[Args, Reply](context.Background(), client, "Adder.Sum", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Send(Args{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := d.Recv(); err != nil || reply.C != 3 {
+		t.Fatalf("got %d, %v", reply.C, err)
+	}
+	d.Close()
+	if err := <-adder.cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the method's context to be cancelled, got %v", err)
+	}
+	if err := d.Send(Args{1, 2}); err == nil {
+		t.Error("expected Send to fail after Close")
+	}
+	if _, err := d.Recv(); err == nil {
+		t.Error("expected Recv to fail after Close")
+	}
+}
+
+func TestDuplexErrors(t *testing.T) {
+	client, _ := newDuplexClient(t)
+	if err := client.Call("Adder.Sum", 0, new(Reply)); err == nil || !strings.Contains(err.Error(), "streaming method") {
+		t.Errorf("expected Call to be rejected, got %v", err)
+	}
+	s, err := OpenStream[Reply](context.Background(), client, "Adder.Sum", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Recv(); err == nil || !strings.Contains(err.Error(), "without OpenDuplex") {
+		t.Errorf("expected OpenStream to be rejected, got %v", err)
+	}
+	d, err := OpenDuplex[Args, Reply](context.Background(), client, "Arith.Add", Args{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Recv(); err == nil || !strings.Contains(err.Error(), "not a streaming method") {
+		t.Errorf("expected OpenDuplex to reject Arith.Add, got %v", err)
+	}
+
+	// Connections served one request at a time cannot read the caller's
+	// values while the method runs.
+	srv := NewServer()
+	srv.Register(new(Adder))
+	cli, conn := net.Pipe()
+	go serveConn(srv, conn)
+	sync := NewClient(cli)
+	defer sync.Close()
+	d, err = OpenDuplex[Args, Reply](context.Background(), sync, "Adder.Sum", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Recv(); err == nil || !strings.Contains(err.Error(), "ServeRequestAsync") {
+		t.Errorf("expected a synchronously served connection to be rejected, got %v", err)
+	}
+}
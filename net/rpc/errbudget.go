@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// errorBudgetBuckets is the number of buckets a window is divided into. The
+// window slides one bucket at a time.
+const errorBudgetBuckets = 10
+
+// ErrorBudget configures per-method error rate tracking on a Server.
+type ErrorBudget struct {
+	// Window is the sliding window over which error rates are computed.
+	// It defaults to one minute, and windows shorter than 10ns, which cannot
+	// be divided into buckets, are lengthened to 10ns.
+	Window time.Duration
+	// Threshold is the error rate, between 0 and 1, above which a method's
+	// alarm fires.
+	Threshold float64
+	// MinCalls is the number of calls a method must receive within the
+	// window before its error rate is considered.
+	MinCalls int
+	// OnAlarm is called when a method's error rate rises above Threshold and
+	// again when it falls back to or below it. It is called synchronously
+	// from the goroutine serving the request and must not block.
+	OnAlarm func(ErrorBudgetAlarm)
+}
+
+// ErrorBudgetAlarm describes a change in a method's alarm state.
+type ErrorBudgetAlarm struct {
+	ServiceMethod string
+	Calls         int
+	Errors        int
+	ErrorRate     float64
+	Firing        bool // true when the rate rose above the threshold
+}
+
+// WithErrorBudget enables per-method error rate tracking.
+func WithErrorBudget(budget ErrorBudget) func(*Server) {
+	return func(s *Server) {
+		if budget.Window <= 0 {
+			budget.Window = time.Minute
+		} else if budget.Window < errorBudgetBuckets {
+			budget.Window = errorBudgetBuckets
+		}
+		s.errorBudget = &errorBudgetTracker{
+			ErrorBudget: budget,
+			methods:     make(map[string]*methodBudget),
+		}
+	}
+}
+
+type errorBudgetTracker struct {
+	ErrorBudget
+
+	mu      sync.Mutex // protects methods
+	methods map[string]*methodBudget
+}
+
+type methodBudget struct {
+	buckets [errorBudgetBuckets]budgetBucket
+	firing  bool
+}
+
+type budgetBucket struct {
+	start  time.Time
+	calls  int
+	errors int
+}
+
+// record counts a call to serviceMethod and fires the alarm callback if the
+// method's alarm state changes.
+func (t *errorBudgetTracker) record(serviceMethod string, callErr error) {
+	now := time.Now()
+	bucketSize := t.Window / errorBudgetBuckets
+	start := now.Truncate(bucketSize)
+
+	t.mu.Lock()
+	mb := t.methods[serviceMethod]
+	if mb == nil {
+		mb = new(methodBudget)
+		t.methods[serviceMethod] = mb
+	}
+	b := &mb.buckets[int(start.UnixNano()/int64(bucketSize))%errorBudgetBuckets]
+	if !b.start.Equal(start) {
+		*b = budgetBucket{start: start}
+	}
+	b.calls++
+	if callErr != nil {
+		b.errors++
+	}
+
+	var calls, errs int
+	for _, b := range mb.buckets {
+		if now.Sub(b.start) < t.Window {
+			calls += b.calls
+			errs += b.errors
+		}
+	}
+	var (
+		alarm   ErrorBudgetAlarm
+		changed bool
+	)
+	if calls >= t.MinCalls {
+		rate := float64(errs) / float64(calls)
+		if firing := rate > t.Threshold; firing != mb.firing {
+			mb.firing = firing
+			changed = true
+			alarm = ErrorBudgetAlarm{
+				ServiceMethod: serviceMethod,
+				Calls:         calls,
+				Errors:        errs,
+				ErrorRate:     rate,
+				Firing:        firing,
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if changed && t.OnAlarm != nil {
+		t.OnAlarm(alarm)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"testing"
+)
+
+func TestErrorBudget(t *testing.T) {
+	var alarms []ErrorBudgetAlarm
+	srv := NewServerWithOpts(WithErrorBudget(ErrorBudget{
+		Threshold: 0.5,
+		MinCalls:  4,
+		OnAlarm: func(alarm ErrorBudgetAlarm) {
+			alarms = append(alarms, alarm)
+		},
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client := CodecEmulator{server: srv}
+
+	// Below MinCalls nothing fires, even though every call fails.
+	for i := 0; i < 3; i++ {
+		client.Call("Arith.Div", &Args{1, 0}, new(Reply))
+	}
+	if len(alarms) != 0 {
+		t.Fatalf("expected no alarms below MinCalls, got %v", alarms)
+	}
+
+	client.Call("Arith.Div", &Args{1, 0}, new(Reply))
+	if len(alarms) != 1 || !alarms[0].Firing || alarms[0].ServiceMethod != "Arith.Div" || alarms[0].Errors != 4 {
+		t.Fatalf("expected Arith.Div alarm to fire, got %+v", alarms)
+	}
+
+	// Further errors do not fire again; successes bring the rate back down.
+	client.Call("Arith.Div", &Args{1, 0}, new(Reply))
+	for i := 0; i < 5; i++ {
+		client.Call("Arith.Div", &Args{4, 2}, new(Reply))
+	}
+	if len(alarms) != 2 || alarms[1].Firing {
+		t.Fatalf("expected Arith.Div alarm to resolve, got %+v", alarms)
+	}
+
+	// Other methods are tracked independently.
+	for i := 0; i < 10; i++ {
+		client.Call("Arith.Add", &Args{1, 2}, new(Reply))
+	}
+	if len(alarms) != 2 {
+		t.Fatalf("expected no alarms for Arith.Add, got %+v", alarms[2:])
+	}
+}
+
+func TestErrorBudgetTinyWindow(t *testing.T) {
+	srv := NewServerWithOpts(WithErrorBudget(ErrorBudget{Window: 1, Threshold: 0.5}))
+	if srv.errorBudget.Window != errorBudgetBuckets {
+		t.Errorf("expected the window to be lengthened to %d, got %v", errorBudgetBuckets, srv.errorBudget.Window)
+	}
+	// Recording a call must not divide by a zero bucket size.
+	srv.errorBudget.record("Arith.Add", nil)
+}
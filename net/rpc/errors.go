@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import "errors"
+
+// CodedError is an error that keeps its classification across the wire.
+// When a handler returns an error that is, or wraps, a *CodedError, its Code
+// and Details are sent in the Response alongside the message, and the
+// client returns a *CodedError instead of a ServerError. Callers can then
+// branch on the code with errors.As.
+//
+// Clients that predate CodedError see only the message.
+type CodedError struct {
+	Code    string // application defined, for example "not_found"
+	Message string
+	Details map[string]string // optional
+	// Retryable is set for errors marked with MarkRetryable.
+	Retryable bool
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns the code of the *CodedError in err's chain, or the empty
+// string if there is none.
+func ErrorCode(err error) string {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return ""
+}
+
+// setError records callErr in r, including its code and details if it is a
+// *CodedError and whether it was marked retryable.
+func (r *Response) setError(callErr error) {
+	r.Error = callErr.Error()
+	var coded *CodedError
+	if errors.As(callErr, &coded) {
+		r.ErrorCode = coded.Code
+		r.ErrorDetails = coded.Details
+	}
+	r.ErrorRetryable = IsRetryable(callErr)
+}
+
+// ResponseError returns the error reported by r: a *CodedError if the server
+// sent a code or marked the error retryable, a ServerError otherwise. It returns nil if r reports no error.
+// It is meant for code reading responses from a ClientCodec directly.
+func ResponseError(r *Response) error {
+	if r.Error == "" {
+		return nil
+	}
+	if r.ErrorCode != "" || r.ErrorRetryable {
+		return &CodedError{Code: r.ErrorCode, Message: r.Error, Details: r.ErrorDetails, Retryable: r.ErrorRetryable}
+	}
+	return ServerError(r.Error)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type Nodes struct{}
+
+func (Nodes) Get(name string, reply *string) error {
+	if name == "" {
+		return errors.New("missing name")
+	}
+	err := &CodedError{Code: "not_found", Message: "no node " + name, Details: map[string]string{"node": name}}
+	return fmt.Errorf("lookup: %w", err)
+}
+
+func TestCodedError(t *testing.T) {
+	srv := NewServer()
+	srv.Register(Nodes{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	err = client.Call("Nodes.Get", "a", &reply)
+	var coded *CodedError
+	if !errors.As(err, &coded) {
+		t.Fatalf("expected a CodedError, got %T: %v", err, err)
+	}
+	if coded.Code != "not_found" || coded.Details["node"] != "a" {
+		t.Errorf("unexpected error %+v", coded)
+	}
+	// The message is that of the error returned by the handler.
+	if coded.Message != "lookup: no node a" {
+		t.Errorf("unexpected message %q", coded.Message)
+	}
+
+	err = client.Call("Nodes.Get", "", &reply)
+	if _, ok := err.(ServerError); !ok || ErrorCode(err) != "" {
+		t.Errorf("expected an uncoded ServerError, got %T: %v", err, err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IdentityFunc returns the identity a request is accounted to.
+type IdentityFunc func(ctx context.Context, sourceAddr net.Addr) string
+
+// SourceHostIdentity is an IdentityFunc that accounts requests to the host
+// part of their source address.
+func SourceHostIdentity(_ context.Context, sourceAddr net.Addr) string {
+	if sourceAddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(sourceAddr.String())
+	if err != nil {
+		return sourceAddr.String()
+	}
+	return host
+}
+
+// WithFairnessTracking enables accounting of the time handlers spend serving
+// each identity, as returned by identify. A nil identify uses
+// SourceHostIdentity.
+func WithFairnessTracking(identify IdentityFunc) func(*Server) {
+	return func(s *Server) {
+		if identify == nil {
+			identify = SourceHostIdentity
+		}
+		s.fairness = &fairnessTracker{
+			identify: identify,
+			since:    time.Now(),
+			usage:    make(map[string]*IdentityShare),
+		}
+	}
+}
+
+// IdentityShare reports how much of the server's handler time one identity
+// consumed.
+type IdentityShare struct {
+	Identity string
+	Calls    uint64
+	// Busy is the total time handlers spent on the identity's requests. It is
+	// the integral of the identity's in-flight requests over the interval.
+	Busy time.Duration
+	// Share is Busy as a fraction of the Busy time of all identities.
+	Share float64
+}
+
+// FairnessReport is the per-identity usage over an interval.
+type FairnessReport struct {
+	Since  time.Time
+	Until  time.Time
+	Shares []IdentityShare // sorted by descending Busy time
+}
+
+// Fairness returns the per-identity usage recorded since fairness tracking
+// was enabled or last reset. If reset is true, a new interval is started.
+// It returns an empty report if fairness tracking is not enabled.
+func (server *Server) Fairness(reset bool) FairnessReport {
+	if server.fairness == nil {
+		return FairnessReport{}
+	}
+	return server.fairness.report(reset)
+}
+
+type fairnessTracker struct {
+	identify IdentityFunc
+
+	mu    sync.Mutex // protects following
+	since time.Time
+	usage map[string]*IdentityShare
+}
+
+func (f *fairnessTracker) record(identity string, busy time.Duration) {
+	f.mu.Lock()
+	u := f.usage[identity]
+	if u == nil {
+		u = &IdentityShare{Identity: identity}
+		f.usage[identity] = u
+	}
+	u.Calls++
+	u.Busy += busy
+	f.mu.Unlock()
+}
+
+func (f *fairnessTracker) report(reset bool) FairnessReport {
+	f.mu.Lock()
+	r := FairnessReport{Since: f.since, Until: time.Now()}
+	var total time.Duration
+	for _, u := range f.usage {
+		r.Shares = append(r.Shares, *u)
+		total += u.Busy
+	}
+	if reset {
+		f.since = r.Until
+		f.usage = make(map[string]*IdentityShare)
+	}
+	f.mu.Unlock()
+
+	for i := range r.Shares {
+		if total > 0 {
+			r.Shares[i].Share = float64(r.Shares[i].Busy) / float64(total)
+		}
+	}
+	sort.Slice(r.Shares, func(i, j int) bool {
+		if r.Shares[i].Busy != r.Shares[j].Busy {
+			return r.Shares[i].Busy > r.Shares[j].Busy
+		}
+		return r.Shares[i].Identity < r.Shares[j].Identity
+	})
+	return r
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestFairness(t *testing.T) {
+	srv := NewServerWithOpts(WithFairnessTracking(nil))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := CodecEmulator{server: srv}
+	for i := 0; i < 3; i++ {
+		if err := client.Call("Arith.Add", &Args{1, 2}, new(Reply)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	other := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("5.6.7.8:9000"))
+	decode := func(v any) error { return json.Unmarshal([]byte(`{"A": 20}`), v) }
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.SleepMilli", decode, other); err != nil {
+		t.Fatal(err)
+	}
+
+	report := srv.Fairness(true)
+	if len(report.Shares) != 2 {
+		t.Fatalf("expected 2 identities, got %+v", report.Shares)
+	}
+	top := report.Shares[0]
+	if top.Identity != "5.6.7.8" || top.Calls != 1 || top.Busy < 20*time.Millisecond {
+		t.Errorf("unexpected top share %+v", top)
+	}
+	if report.Shares[1].Identity != "1.2.3.4" || report.Shares[1].Calls != 3 {
+		t.Errorf("unexpected share %+v", report.Shares[1])
+	}
+	if sum := report.Shares[0].Share + report.Shares[1].Share; sum < 0.999 || sum > 1.001 {
+		t.Errorf("expected shares to sum to 1, got %v", sum)
+	}
+
+	if report := srv.Fairness(false); len(report.Shares) != 0 {
+		t.Errorf("expected reset report to be empty, got %+v", report.Shares)
+	}
+}
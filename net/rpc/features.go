@@ -0,0 +1,184 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"path"
+	"sync"
+)
+
+// Features of the server that FeatureFlags can roll out gradually. Until a
+// rule names one of them, it applies to every request as configured by its
+// option; once rules name it, it applies only to requests they enable it
+// for.
+const (
+	FeatureConcurrencyLimit = "concurrency-limit" // WithMaxConcurrentRequests and WithMaxConcurrentRequestsPerConn
+	FeatureReplyCanary      = "reply-canary"      // WithReplyCanary
+	FeatureCodecMigration   = "codec-migration"   // WithCodecMigration
+)
+
+// FeatureRule enables a feature for some of the requests to the methods
+// matching Methods, which uses the same syntax as WithMethodTimeout. The
+// feature is enabled for every request from the peers listed in Peers, and
+// for Percent percent of other requests, chosen at random.
+type FeatureRule struct {
+	Feature string
+	Methods string
+	Percent float64 // from 0 to 100
+	// Peers lists client identities: the common name of the certificate a
+	// client connected over TLS with, or the IP address of its connection.
+	Peers []string
+}
+
+// FeatureStats counts the evaluations of a feature.
+type FeatureStats struct {
+	Evaluated uint64 // requests matched by a rule for the feature
+	Enabled   uint64 // requests the feature was enabled for
+}
+
+// FeatureFlags decides, for each request, which features are enabled. Its
+// rules can be changed while the server runs with SetRules. Handlers check
+// features with FeatureEnabled.
+type FeatureFlags struct {
+	mu    sync.RWMutex // protects following
+	rules []FeatureRule
+	stats map[string]*FeatureStats
+}
+
+// NewFeatureFlags returns FeatureFlags holding rules.
+func NewFeatureFlags(rules ...FeatureRule) *FeatureFlags {
+	f := new(FeatureFlags)
+	f.SetRules(rules...)
+	return f
+}
+
+// SetRules replaces the rules. For each request and feature, the first rule
+// naming the feature whose Methods match applies.
+func (f *FeatureFlags) SetRules(rules ...FeatureRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append([]FeatureRule(nil), rules...)
+}
+
+// Stats returns the counts of each feature evaluated so far.
+func (f *FeatureFlags) Stats() map[string]FeatureStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	stats := make(map[string]FeatureStats, len(f.stats))
+	for feature, s := range f.stats {
+		stats[feature] = *s
+	}
+	return stats
+}
+
+// WithFeatureFlags makes the server evaluate f for each request.
+func WithFeatureFlags(f *FeatureFlags) func(*Server) {
+	return func(s *Server) {
+		s.features = f
+	}
+}
+
+// FeatureEnabled reports whether feature is enabled for the request ctx
+// belongs to.
+func FeatureEnabled(ctx context.Context, feature string) bool {
+	set, _ := ctx.Value(featureKey{}).(featureSet)
+	return set[feature]
+}
+
+type featureKey struct{}
+
+// featureSet maps the features rules were found for to whether they are
+// enabled.
+type featureSet map[string]bool
+
+// evaluate decides the features of a request to serviceMethod from peer.
+func (f *FeatureFlags) evaluate(serviceMethod string, peer *Peer) featureSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var set featureSet
+	for _, rule := range f.rules {
+		if _, decided := set[rule.Feature]; decided {
+			continue
+		}
+		if ok, _ := path.Match(rule.Methods, serviceMethod); !ok {
+			continue
+		}
+		if set == nil {
+			set = make(featureSet)
+		}
+		enabled := peerListed(peer, rule.Peers) || rand.Float64()*100 < rule.Percent
+		set[rule.Feature] = enabled
+
+		if f.stats == nil {
+			f.stats = make(map[string]*FeatureStats)
+		}
+		s := f.stats[rule.Feature]
+		if s == nil {
+			s = new(FeatureStats)
+			f.stats[rule.Feature] = s
+		}
+		s.Evaluated++
+		if enabled {
+			s.Enabled++
+		}
+	}
+	return set
+}
+
+// governs reports whether any rule names feature.
+func (f *FeatureFlags) governs(feature string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, rule := range f.rules {
+		if rule.Feature == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func peerListed(peer *Peer, peers []string) bool {
+	if peer == nil || len(peers) == 0 {
+		return false
+	}
+	var name string
+	if cert := peer.Certificate(); cert != nil {
+		name = cert.Subject.CommonName
+	}
+	host, _, err := net.SplitHostPort(peer.String())
+	if err != nil {
+		host = peer.String()
+	}
+	for _, p := range peers {
+		if p == host || (name != "" && p == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withFeatures returns ctx carrying the features of a request to
+// serviceMethod.
+func (server *Server) withFeatures(ctx context.Context, serviceMethod string) context.Context {
+	if server.features == nil {
+		return ctx
+	}
+	peer, _ := PeerFromContext(ctx)
+	if set := server.features.evaluate(serviceMethod, peer); set != nil {
+		ctx = context.WithValue(ctx, featureKey{}, set)
+	}
+	return ctx
+}
+
+// featureOn reports whether one of the server's own features applies to the
+// request ctx belongs to.
+func (server *Server) featureOn(ctx context.Context, feature string) bool {
+	if server.features == nil || !server.features.governs(feature) {
+		return true
+	}
+	return FeatureEnabled(ctx, feature)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Flagged struct{}
+
+func (Flagged) Check(ctx context.Context, feature string, reply *bool) error {
+	*reply = FeatureEnabled(ctx, feature)
+	return nil
+}
+
+func TestFeatureFlags(t *testing.T) {
+	flags := NewFeatureFlags(
+		FeatureRule{Feature: "strict", Methods: "Flagged.*", Peers: []string{"127.0.0.1"}},
+		FeatureRule{Feature: "strict", Methods: "*", Percent: 100},
+		FeatureRule{Feature: "fast", Methods: "Flagged.Check", Percent: 0},
+		FeatureRule{Feature: FeatureReplyCanary, Methods: "Arith.Add", Percent: 100},
+	)
+	var violations atomic.Int32
+	srv := NewServerWithOpts(
+		WithFeatureFlags(flags),
+		WithReplyCanary(0, func(*ReplyViolation) { violations.Add(1) }),
+		WithResponseInterceptor(func(resp *Response, reply interface{}, err error) error {
+			if resp.ServiceMethod == "Arith.Mul" {
+				reply.(*Reply).C++
+			}
+			return err
+		}),
+	)
+	if err := srv.RegisterAll(Flagged{}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	check := func(feature string) bool {
+		var enabled bool
+		if err := client.Call("Flagged.Check", feature, &enabled); err != nil {
+			t.Fatal(err)
+		}
+		return enabled
+	}
+	if !check("strict") {
+		t.Error("expected strict to be enabled for the listed peer")
+	}
+	if check("fast") || check("unknown") {
+		t.Error("expected features without an enabling rule to be disabled")
+	}
+	stats := flags.Stats()
+	if stats["strict"] != (FeatureStats{Evaluated: 3, Enabled: 3}) || stats["fast"] != (FeatureStats{Evaluated: 3}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// The reply canary is only enabled for Arith.Add.
+	if err := client.Call("Arith.Mul", &Args{2, 3}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if n := violations.Load(); n != 0 {
+		t.Errorf("expected the canary to be disabled, got %d violations", n)
+	}
+	flags.SetRules()
+	if err := client.Call("Arith.Mul", &Args{2, 3}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for violations.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := violations.Load(); n != 1 {
+		t.Errorf("expected the canary to apply without rules, got %d violations", n)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// RequestRouter is consulted after a request header has been read and before
+// its body is decoded. Returning a non-nil ForwardFunc hands the request to
+// it with the body still encoded; returning nil serves the request locally.
+type RequestRouter func(req *Request, sourceAddr net.Addr) ForwardFunc
+
+// ForwardFunc serves a request elsewhere, typically by writing it to another
+// server with a RawClientCodec. It receives the request body and returns the
+// reply body in their encoded form, so neither is decoded or re-encoded.
+type ForwardFunc func(ctx context.Context, req *Request, body RawValue) (RawValue, error)
+
+// RawServerCodec is implemented by ServerCodecs that can read request bodies
+// and write response bodies without decoding or encoding them. Requests can
+// only be forwarded by a RequestRouter on connections using such a codec.
+type RawServerCodec interface {
+	ServerCodec
+	ReadRequestBodyRaw() (RawValue, error)
+	WriteResponseRaw(*Response, RawValue) error
+}
+
+// RawClientCodec is the client side counterpart of RawServerCodec.
+type RawClientCodec interface {
+	ClientCodec
+	WriteRequestRaw(*Request, RawValue) error
+	ReadResponseBodyRaw() (RawValue, error)
+}
+
+var errNoRawCodec = errors.New("rpc: codec does not support forwarding requests")
+
+// WithRequestRouter sets the RequestRouter used to forward requests before
+// their body is decoded. The router is consulted once a request has passed
+// the pre-body interceptors and post-header admission controllers, so that
+// forwarded requests are checked as local ones are.
+func WithRequestRouter(router RequestRouter) func(*Server) {
+	return func(s *Server) {
+		s.requestRouter = router
+	}
+}
+
+// forwardRequest reads the still-encoded body of req, passes it to forward
+// and writes the encoded reply back on codec.
+func (server *Server) forwardRequest(ctx context.Context, sending *writeQueue, req *Request, codec ServerCodec, forward ForwardFunc, bodyRead func()) error {
+	defer server.freeRequest(req)
+	raw, ok := codec.(RawServerCodec)
+	if !ok {
+		codec.ReadRequestBody(nil)
+		if bodyRead != nil {
+			bodyRead()
+		}
+		server.sendResponse(sending, req, invalidRequest, codec, errNoRawCodec)
+		return errNoRawCodec
+	}
+	var body RawValue
+	var err error
+	if req.Chunked {
+		var data []byte
+		data, err = server.readChunkedBody(codec, req)
+		body = RawValueFromBytes(data)
+	} else {
+		body, err = raw.ReadRequestBodyRaw()
+	}
+	if err != nil {
+		if errors.Is(err, ErrRequestTooLarge) {
+			server.sendResponse(sending, req, invalidRequest, codec, err)
+			codec.Close()
+		}
+		return err
+	}
+	if bodyRead != nil {
+		bodyRead()
+	}
+
+	reply, err := forward(ctx, req, body)
+	if err != nil {
+		server.sendResponse(sending, req, invalidRequest, codec, err)
+		return nil
+	}
+
+	resp := server.getResponse()
+	resp.ServiceMethod = req.ServiceMethod
+	resp.Seq = req.Seq
+	priority := WritePrioritySmall
+	if len(reply.Bytes()) > bulkResponseSize || server.isBulkMethod(req.ServiceMethod) {
+		priority = WritePriorityBulk
+	}
+	sending.Lock(priority)
+	err = raw.WriteResponseRaw(resp, reply)
+	if err != nil {
+		server.logger().Debug("rpc: writing forwarded response", "serviceMethod", req.ServiceMethod, "sourceAddr", codec.SourceAddr(), "error", err)
+	}
+	sending.Unlock()
+	server.freeResponse(resp)
+	return nil
+}
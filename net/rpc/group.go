@@ -0,0 +1,161 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CallGroup issues asynchronous calls on a Client while bounding the number
+// of calls in flight, and collects the errors of every call it started.
+// It replaces the gate channels callers otherwise build around Go when they
+// fire a large number of calls at once.
+type CallGroup struct {
+	client *Client
+	sem    chan struct{} // nil when the group is unbounded
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex // protects errs
+	errs []error
+}
+
+// NewCallGroup returns a CallGroup that allows at most limit calls to be in
+// flight on the client at once. A limit of zero or less means no limit.
+func (client *Client) NewCallGroup(limit int) *CallGroup {
+	g := &CallGroup{client: client}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g
+}
+
+// Go starts an asynchronous call like Client.Go. If the group is at its
+// limit, Go blocks until one of the earlier calls completes. The reply is
+// filled in by the time Wait returns.
+func (g *CallGroup) Go(serviceMethod string, args interface{}, reply interface{}) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Add(1)
+	call := g.client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	go func() {
+		defer g.wg.Done()
+		<-call.Done
+		if call.Error != nil {
+			g.mu.Lock()
+			g.errs = append(g.errs, &CallError{ServiceMethod: serviceMethod, Err: call.Error})
+			g.mu.Unlock()
+		}
+		if g.sem != nil {
+			<-g.sem
+		}
+	}()
+}
+
+// Wait blocks until every call started by the group has completed. It returns
+// nil if all calls succeeded, or an error joining the CallError of every call
+// that failed.
+func (g *CallGroup) Wait() error {
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
+
+// CallError records the failure of a single call made through a CallGroup.
+type CallError struct {
+	ServiceMethod string
+	Err           error
+}
+
+func (e *CallError) Error() string {
+	return e.ServiceMethod + ": " + e.Err.Error()
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+// CallSpec describes one call made by Client.CallMany.
+type CallSpec struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+}
+
+// CallResult reports the outcome of one call made by Client.CallMany.
+type CallResult struct {
+	ServiceMethod string
+	Error         error
+	Duration      time.Duration // time from sending the request to its completion
+}
+
+// CallManyResult holds the results of Client.CallMany, in the same order as
+// the CallSpecs that were passed in.
+type CallManyResult struct {
+	Results []CallResult
+}
+
+// Err returns nil if every call succeeded, or an error joining a CallError
+// for every call that failed.
+func (r *CallManyResult) Err() error {
+	var errs []error
+	for _, res := range r.Results {
+		if res.Error != nil {
+			errs = append(errs, &CallError{ServiceMethod: res.ServiceMethod, Err: res.Error})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CallMany issues all of the calls concurrently and waits for them to
+// complete or for ctx to be done. Calls that are still pending when ctx is
+// done are abandoned as with CallContext and report ctx.Err().
+func (client *Client) CallMany(ctx context.Context, specs []CallSpec) *CallManyResult {
+	result := &CallManyResult{Results: make([]CallResult, len(specs))}
+	if len(specs) == 0 {
+		return result
+	}
+
+	done := make(chan *Call, len(specs))
+	index := make(map[*Call]int, len(specs))
+	start := make([]time.Time, len(specs))
+	for i, spec := range specs {
+		result.Results[i].ServiceMethod = spec.ServiceMethod
+		start[i] = time.Now()
+		index[client.Go(spec.ServiceMethod, spec.Args, spec.Reply, done)] = i
+	}
+
+	for remaining := len(specs); remaining > 0; remaining-- {
+		select {
+		case call := <-done:
+			i := index[call]
+			delete(index, call)
+			result.Results[i].Error = call.Error
+			result.Results[i].Duration = time.Since(start[i])
+		case <-ctx.Done():
+			for call, i := range index {
+				if !client.abandon(call) {
+					// The call is completing; collect it below.
+					continue
+				}
+				result.Results[i].Error = ctx.Err()
+				result.Results[i].Duration = time.Since(start[i])
+				delete(index, call)
+				remaining--
+			}
+			for ; remaining > 0; remaining-- {
+				call := <-done
+				i := index[call]
+				result.Results[i].Error = call.Error
+				result.Results[i].Duration = time.Since(start[i])
+			}
+			return result
+		}
+	}
+	return result
+}
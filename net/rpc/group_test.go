@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallGroup(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	client, err := Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	const numCalls = 50
+	g := client.NewCallGroup(4)
+	replies := make([]Reply, numCalls)
+	for i := 0; i < numCalls; i++ {
+		g.Go("Arith.Add", &Args{i, 1}, &replies[i])
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, reply := range replies {
+		if reply.C != i+1 {
+			t.Errorf("Add: expected %d got %d", i+1, reply.C)
+		}
+	}
+
+	g = client.NewCallGroup(2)
+	g.Go("Arith.Add", &Args{1, 2}, new(Reply))
+	g.Go("Arith.Error", &Args{}, new(Reply))
+	g.Go("Arith.Div", &Args{1, 0}, new(Reply))
+	err = g.Wait()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var callErr *CallError
+	if !errors.As(err, &callErr) {
+		t.Fatalf("expected a CallError, got %T", err)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("expected 2 errors, got %d: %v", n, err)
+	}
+}
+
+func TestCallMany(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	client, err := Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	var sum Reply
+	var str string
+	res := client.CallMany(context.Background(), []CallSpec{
+		{ServiceMethod: "Arith.Add", Args: &Args{7, 8}, Reply: &sum},
+		{ServiceMethod: "Arith.String", Args: &Args{1, 2}, Reply: &str},
+		{ServiceMethod: "Arith.Div", Args: &Args{1, 0}, Reply: new(Reply)},
+	})
+	if len(res.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(res.Results))
+	}
+	if res.Results[0].Error != nil || sum.C != 15 {
+		t.Errorf("Add: unexpected result %v %d", res.Results[0].Error, sum.C)
+	}
+	if res.Results[1].Error != nil || str != "1+2=3" {
+		t.Errorf("String: unexpected result %v %q", res.Results[1].Error, str)
+	}
+	if res.Results[2].Error == nil || res.Results[2].Error.Error() != "divide by zero" {
+		t.Errorf("Div: expected divide by zero, got %v", res.Results[2].Error)
+	}
+	if err := res.Err(); err == nil || !strings.Contains(err.Error(), "Arith.Div: divide by zero") {
+		t.Errorf("expected aggregate error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	res = client.CallMany(ctx, []CallSpec{
+		{ServiceMethod: "Arith.SleepMilli", Args: &Args{A: 500}, Reply: new(Reply)},
+	})
+	if !errors.Is(res.Results[0].Error, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", res.Results[0].Error)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build go1.24
+
+package rpc
+
+import "net/http"
+
+// newH2CRoundTripper returns a RoundTripper speaking unencrypted HTTP/2 with
+// prior knowledge.
+func newH2CRoundTripper() (http.RoundTripper, error) {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Transport{Protocols: &protocols}, nil
+}
@@ -0,0 +1,15 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !go1.24
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+)
+
+func newH2CRoundTripper() (http.RoundTripper, error) {
+	return nil, errors.New("rpc: unencrypted HTTP/2 requires Go 1.24 or later")
+}
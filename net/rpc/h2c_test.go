@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build go1.24
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTP2Unencrypted(t *testing.T) {
+	srv := newHTTPTestServer(t)
+	l, addr := listenTCP(t)
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	hs := &http.Server{Handler: srv.HTTPHandler(), Protocols: &protocols}
+	go hs.Serve(l)
+	defer hs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := DialHTTP2(ctx, addr, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply Reply
+	if err := client.Call("Arith.Add", Args{1, 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 3 {
+		t.Errorf("Add: got %d, want 3", reply.C)
+	}
+	var isTLS bool
+	if err := client.Call("StreamPeer.TLS", struct{}{}, &isTLS); err != nil {
+		t.Fatal(err)
+	}
+	if isTLS {
+		t.Error("handler saw a TLS state on an unencrypted stream")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+)
+
+// ConnHandshake is run on each connection the server accepts, before any
+// of its requests is read. It may read or write a preamble of its own, such
+// as protocol version bytes or a PROXY protocol header, or wrap the
+// connection, for example in TLS. It returns the connection the requests
+// are read from and the context they are served with, which may carry
+// values of the connection for handlers; ServeConnContext adds its values
+// to the context it is given. A nil conn or ctx leaves the
+// connection or context.Background() as they were. If it returns an error,
+// the connection is closed without being served.
+type ConnHandshake func(conn net.Conn) (net.Conn, context.Context, error)
+
+// WithConnHandshake sets the handshake run on each connection accepted by
+// ServeTLS, ahead of the TLS handshake, and by Handshake.
+func WithConnHandshake(handshake ConnHandshake) func(*Server) {
+	return func(s *Server) {
+		s.connHandshake = handshake
+	}
+}
+
+// Handshake reads the PROXY protocol header of conn, which its listener
+// has just returned, if WithProxyProtocol asks for it, and runs the
+// server's ConnHandshake on it, for servers accepting their own
+// connections. The credentials of the peers of Unix sockets are read too,
+// for PeerCredsOf. They then serve the returned connection with the returned
+// context, for example with ServeRequestAsync. Without either option, it
+// returns conn and context.Background(). If the handshake fails, conn is
+// closed.
+func (server *Server) Handshake(conn net.Conn) (net.Conn, context.Context, error) {
+	conn = withPeerCreds(conn)
+	pc, err := server.readProxyProtocol(conn)
+	if err != nil {
+		server.logger().Debug("rpc: connection handshake failed", "sourceAddr", conn.RemoteAddr(), "error", err)
+		conn.Close()
+		return nil, nil, err
+	}
+	conn = pc
+	if server.connHandshake == nil {
+		return conn, context.Background(), nil
+	}
+	hc, ctx, err := server.connHandshake(conn)
+	if err != nil {
+		server.logger().Debug("rpc: connection handshake failed", "sourceAddr", conn.RemoteAddr(), "error", err)
+		conn.Close()
+		return nil, nil, err
+	}
+	if hc == nil {
+		hc = conn
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return hc, ctx, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type preambleKey struct{}
+
+// readPreamble is a ConnHandshake expecting the version bytes "RPC1".
+func readPreamble(conn net.Conn) (net.Conn, context.Context, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, nil, err
+	}
+	if string(buf) != "RPC1" {
+		return nil, nil, errors.New("unsupported version " + string(buf))
+	}
+	return conn, context.WithValue(context.Background(), preambleKey{}, string(buf)), nil
+}
+
+type ConnValue struct{}
+
+func (ConnValue) Preamble(ctx context.Context, args struct{}, reply *string) error {
+	*reply, _ = ctx.Value(preambleKey{}).(string)
+	return nil
+}
+
+func TestHandshake(t *testing.T) {
+	srv := NewServerWithOpts(WithConnHandshake(readPreamble))
+	if err := srv.Register(ConnValue{}); err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	go func() {
+		conn, ctx, err := srv.Handshake(c2)
+		if err != nil {
+			return
+		}
+		codec := NewGobServerCodec(conn)
+		defer codec.Close()
+		for srv.ServeRequestAsync(ctx, codec) == nil {
+		}
+	}()
+	if _, err := c1.Write([]byte("RPC1")); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(c1)
+	defer client.Close()
+	var preamble string
+	if err := client.Call("ConnValue.Preamble", struct{}{}, &preamble); err != nil || preamble != "RPC1" {
+		t.Errorf("expected the handshake's context, got %q, %v", preamble, err)
+	}
+}
+
+func TestHandshakeTLS(t *testing.T) {
+	ca := newTestCA(t)
+	srv := NewServerWithOpts(WithConnHandshake(readPreamble))
+	if err := srv.Register(ConnValue{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	config := &tls.Config{Certificates: []tls.Certificate{ca.leaf("server", x509.ExtKeyUsageServerAuth)}}
+	go srv.ServeTLS(l, config)
+
+	dial := func(preamble string) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte(preamble)); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	client := NewClient(tls.Client(dial("RPC1"), &tls.Config{RootCAs: ca.pool, ServerName: "server"}))
+	defer client.Close()
+	var preamble string
+	if err := client.Call("ConnValue.Preamble", struct{}{}, &preamble); err != nil || preamble != "RPC1" {
+		t.Errorf("expected the handshake's context, got %q, %v", preamble, err)
+	}
+
+	conn := dial("RPC2")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+	// The failure is recorded once the connection is closed.
+	waitFor(t, func() bool { return srv.ConnSetupStats().TLSHandshake.Failures == 1 })
+	if s := srv.ConnSetupStats().TLSHandshake; s.Count != 1 {
+		t.Errorf("expected one handshake to succeed, got %+v", s)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var (
+	errStreamDeadline = errors.New("rpc: deadlines are not supported on HTTP/2 streams")
+	errNotHTTP2       = errors.New("rpc: server did not negotiate HTTP/2")
+)
+
+// HTTPHandler returns an http.Handler serving RPC connections, so that the
+// server can share a listener with other HTTP traffic and be fronted by
+// HTTP load balancers and proxies.
+//
+// Over HTTP/2, each POST request is a connection: its body carries the
+// client's requests and the response body the server's responses, as
+// DialHTTP2 and HTTP2Transport expect. The handler must then be served over
+// TLS, where net/http negotiates HTTP/2, or over unencrypted HTTP/2 (h2c),
+// which net/http accepts from Go 1.24 when UnencryptedHTTP2 is set in the
+// http.Server's Protocols.
+//
+// Over HTTP/1.x, CONNECT requests are switched to the RPC protocol, as
+// DialHTTP and DialHTTPPath expect.
+func (server *Server) HTTPHandler() http.Handler {
+	return &httpHandler{server: server}
+}
+
+type httpHandler struct {
+	server *Server
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.ProtoMajor == 2 && r.Method == http.MethodPost:
+		h.serveStream(w, r)
+	case r.ProtoMajor == 1 && r.Method == http.MethodConnect:
+		h.serveConnect(w, r)
+	default:
+		w.Header().Set("Allow", "CONNECT, POST")
+		http.Error(w, "rpc: expected CONNECT over HTTP/1 or POST over HTTP/2", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpHandler) serveConnect(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "rpc: connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		h.server.logger().Debug("rpc: hijacking HTTP connection", "sourceAddr", r.RemoteAddr, "error", err)
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
+	codec := newGobServerCodec(conn)
+	defer codec.Close()
+	for h.server.ServeRequestAsync(context.Background(), codec) == nil {
+	}
+}
+
+func (h *httpHandler) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "rpc: response cannot be streamed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	conn := &streamConn{
+		r:       r.Body,
+		w:       w,
+		flush:   flusher.Flush,
+		remote:  remoteAddrOf(r),
+		tls:     r.TLS,
+		onClose: r.Body.Close,
+	}
+	conn.local, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	codec := newGobServerCodec(conn)
+	for h.server.ServeRequestAsync(r.Context(), codec) == nil {
+	}
+	// Responses must not be written once the handler returns.
+	codec.Close()
+}
+
+// remoteAddrOf returns the address of the client of r.
+func remoteAddrOf(r *http.Request) net.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+// HTTP2Transport connects to the HTTPHandler at path over an HTTP/2 stream.
+// With a non-nil config, it connects over TLS and negotiates HTTP/2;
+// otherwise it uses unencrypted HTTP/2 (h2c), which requires Go 1.24.
+// Connections to the same address share TCP connections.
+func HTTP2Transport(path string, config *tls.Config) Transport {
+	rt, err := newHTTP2RoundTripper(config)
+	scheme := "https"
+	if config == nil {
+		scheme = "http"
+	}
+	return Transport{
+		Name: "http2",
+		Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
+			if err != nil {
+				return nil, err
+			}
+			return dialHTTP2(ctx, rt, scheme+"://"+address+path)
+		},
+	}
+}
+
+// DialHTTP2 connects to the HTTPHandler at the specified TCP address and
+// path over an HTTP/2 stream, using TLS if config is non-nil and h2c
+// otherwise, as HTTP2Transport does.
+func DialHTTP2(ctx context.Context, address, path string, config *tls.Config) (*Client, error) {
+	return NewDialer(WithTransports(HTTP2Transport(path, config))).DialContext(ctx, "tcp", address)
+}
+
+func newHTTP2RoundTripper(config *tls.Config) (http.RoundTripper, error) {
+	if config == nil {
+		return newH2CRoundTripper()
+	}
+	return &http.Transport{TLSClientConfig: config.Clone(), ForceAttemptHTTP2: true}, nil
+}
+
+// dialHTTP2 opens a stream to the HTTPHandler at url.
+func dialHTTP2(ctx context.Context, rt http.RoundTripper, url string) (net.Conn, error) {
+	pr, pw := io.Pipe()
+	// The stream outlives ctx, which only bounds opening it.
+	streamCtx, cancel := context.WithCancel(context.Background())
+	opened := make(chan struct{})
+	defer close(opened)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+			pw.CloseWithError(ctx.Err())
+		case <-opened:
+		}
+	}()
+
+	conn := &streamConn{}
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		conn.local, conn.remote = info.Conn.LocalAddr(), info.Conn.RemoteAddr()
+		// Over HTTP/1 the body would be sent before the response is read,
+		// which never ends.
+		if tc, ok := info.Conn.(*tls.Conn); ok && tc.ConnectionState().NegotiatedProtocol != "h2" {
+			pw.CloseWithError(errNotHTTP2)
+		}
+	}}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(streamCtx, trace), http.MethodPost, url, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		cancel()
+		pw.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if errors.Is(err, errNotHTTP2) {
+			err = errNotHTTP2
+		}
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, errors.New("unexpected HTTP response: " + resp.Proto + " " + resp.Status)
+	}
+	conn.r, conn.w = resp.Body, pw
+	conn.onClose = func() error {
+		pw.Close()
+		err := resp.Body.Close()
+		cancel()
+		return err
+	}
+	return conn, nil
+}
+
+// streamConn carries the RPC protocol in the bodies of an HTTP/2 request and
+// its response.
+type streamConn struct {
+	r             io.ReadCloser
+	w             io.Writer
+	flush         func() // flushes w, if set
+	local, remote net.Addr
+	tls           *tls.ConnectionState // of the request, on the server
+	onClose       func() error
+
+	mu        sync.Mutex // held while writing
+	closed    bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(p)
+	if err == nil && c.flush != nil {
+		c.flush()
+	}
+	return n, err
+}
+
+// Close ends both bodies, then waits for a write in progress to return.
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.onClose()
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+	})
+	return c.closeErr
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *streamConn) SetDeadline(time.Time) error      { return errStreamDeadline }
+func (c *streamConn) SetReadDeadline(time.Time) error  { return errStreamDeadline }
+func (c *streamConn) SetWriteDeadline(time.Time) error { return errStreamDeadline }
+
+// ConnectionState returns the TLS state of the request, so that
+// SourceAddrOf reports it.
+func (c *streamConn) ConnectionState() tls.ConnectionState {
+	if c.tls == nil {
+		return tls.ConnectionState{}
+	}
+	return *c.tls
+}
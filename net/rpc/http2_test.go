@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type StreamPeer struct{}
+
+func (StreamPeer) TLS(ctx context.Context, args struct{}, reply *bool) error {
+	p, ok := PeerFromContext(ctx)
+	*reply = ok && p.TLS != nil
+	return nil
+}
+
+func newHTTPTestServer(t *testing.T) *Server {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Register(StreamPeer{}); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestHTTP2(t *testing.T) {
+	srv := newHTTPTestServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", srv.HTTPHandler())
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	ts := httptest.NewUnstartedServer(mux)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	config := &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	address := strings.TrimPrefix(ts.URL, "https://")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		client, err := DialHTTP2(ctx, address, "/rpc", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	for i, client := range clients {
+		var reply Reply
+		if err := client.Call("Arith.Add", Args{i, 10}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.C != i+10 {
+			t.Errorf("Add: got %d, want %d", reply.C, i+10)
+		}
+		var isTLS bool
+		if err := client.Call("StreamPeer.TLS", struct{}{}, &isTLS); err != nil {
+			t.Fatal(err)
+		}
+		if !isTLS {
+			t.Error("handler did not see the TLS state of the request")
+		}
+	}
+
+	// Other HTTP traffic shares the listener.
+	resp, err := ts.Client().Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Errorf("health: got %s %s", resp.Proto, resp.Status)
+	}
+
+	// Calls fail once the client is closed, and the server ends the stream.
+	clients[0].Close()
+	if err := clients[0].Call("Arith.Add", Args{1, 2}, new(Reply)); err != ErrShutdown {
+		t.Errorf("call after Close: got %v, want %v", err, ErrShutdown)
+	}
+}
+
+func TestHTTPHandlerConnect(t *testing.T) {
+	srv := newHTTPTestServer(t)
+	ts := httptest.NewServer(srv.HTTPHandler())
+	defer ts.Close()
+
+	client, err := DialHTTPPath("tcp", strings.TrimPrefix(ts.URL, "http://"), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply Reply
+	if err := client.Call("Arith.Mul", Args{6, 7}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 42 {
+		t.Errorf("Mul: got %d, want 42", reply.C)
+	}
+
+	// HTTP/2 streams are not attempted over HTTP/1.
+	resp, err := http.Post(ts.URL, "application/octet-stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST over HTTP/1: got status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsTS := httptest.NewTLSServer(srv.HTTPHandler())
+	defer tlsTS.Close()
+	config := &tls.Config{RootCAs: tlsTS.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	if _, err := DialHTTP2(ctx, strings.TrimPrefix(tlsTS.URL, "https://"), "/", config); !errors.Is(err, errNotHTTP2) {
+		t.Errorf("DialHTTP2 to a server without HTTP/2: got %v, want %v", err, errNotHTTP2)
+	}
+}
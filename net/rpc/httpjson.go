@@ -0,0 +1,219 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// HTTPJSONHandler returns an http.Handler exposing the server's methods as
+// JSON over HTTP, for debugging and scripting against a running server. Only
+// methods whose "Service.Method" name matches one of patterns, using
+// path.Match syntax, are exposed, so that the facade can be limited to
+// methods that only read state.
+//
+// GET / lists the exposed methods as ServiceDescriptors. A method is called
+// with POST on /Service/Method, taking the argument as a JSON body, and the
+// reply is written as JSON. Calls are refused with other HTTP methods, so
+// that links and prefetches cannot make them.
+//
+// Calls are subject to the server's method filters, PreBodyInterceptor,
+// ServerServiceCallInterceptor and admission controllers, like calls made
+// over RPC. If the server has an Authenticator, it is given the token of the
+// request's Authorization header, with any "Bearer " prefix removed, and
+// calls it rejects are refused with 401 Unauthorized.
+//
+// POST bodies are limited to the size set with WithMaxRequestBytes, or to
+// 10 MiB without it, and bodies that cannot be decoded are answered with 400
+// Bad Request. Errors coded CodeUnavailable and CodeTooManyRequests are
+// answered with 503 Service Unavailable and 429 Too Many Requests, and calls
+// refused by interceptors and method filters with 403 Forbidden, as are calls
+// from clients whose address is unknown.
+func (server *Server) HTTPJSONHandler(patterns ...string) http.Handler {
+	return &httpJSONHandler{server: server, patterns: patterns}
+}
+
+type httpJSONHandler struct {
+	server   *Server
+	patterns []string
+}
+
+type httpJSONError struct {
+	Error string `json:"error"`
+}
+
+// errHTTPBadArgs wraps errors decoding the argument of a call.
+type errHTTPBadArgs struct{ err error }
+
+func (e errHTTPBadArgs) Error() string { return "rpc: invalid argument: " + e.err.Error() }
+
+func (e errHTTPBadArgs) Unwrap() error { return e.err }
+
+// defaultHTTPJSONMaxBytes limits the bodies of HTTPJSONHandler calls if the
+// server has no WithMaxRequestBytes limit.
+const defaultHTTPJSONMaxBytes = 10 << 20
+
+func (h *httpJSONHandler) exposed(serviceMethod string) bool {
+	for _, pattern := range h.patterns {
+		if ok, _ := path.Match(pattern, serviceMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *httpJSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(r.URL.Path, "/")
+	allowed := http.MethodPost
+	if name == "" {
+		allowed = http.MethodGet
+	}
+	if r.Method != allowed {
+		w.Header().Set("Allow", allowed)
+		writeHTTPJSON(w, http.StatusMethodNotAllowed, httpJSONError{Error: "method not allowed"})
+		return
+	}
+	if name == "" {
+		h.serveIndex(w)
+		return
+	}
+	serviceMethod := strings.Replace(name, "/", ".", 1)
+	if !h.exposed(serviceMethod) {
+		writeHTTPJSON(w, http.StatusNotFound, httpJSONError{Error: "rpc: method not exposed: " + serviceMethod})
+		return
+	}
+	if _, _, err := h.server.findMethod(serviceMethod); err != nil {
+		writeHTTPJSON(w, http.StatusNotFound, httpJSONError{Error: err.Error()})
+		return
+	}
+
+	// AccessControl and the method filters cannot judge a call whose
+	// source is unknown.
+	sourceAddr := httpSourceAddr(r)
+	if sourceAddr == nil {
+		writeHTTPJSON(w, http.StatusForbidden, httpJSONError{Error: "rpc: unknown client address " + r.RemoteAddr})
+		return
+	}
+
+	ctx := r.Context()
+	if h.server.authenticator != nil {
+		req := Request{ServiceMethod: serviceMethod, AuthToken: httpAuthToken(r)}
+		ctx, _ = withRequestValues(ctx, sourceAddr)
+		if err := h.server.authenticate(ctx, &req, sourceAddr); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeHTTPJSON(w, http.StatusUnauthorized, httpJSONError{Error: err.Error()})
+			return
+		}
+	}
+
+	decoded := false
+	var decodeErr error
+	decodeArg := func(arg any) error {
+		decoded = true
+		maxBytes := h.server.maxRequestBytes
+		if maxBytes <= 0 {
+			maxBytes = defaultHTTPJSONMaxBytes
+		}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(arg); err != nil {
+			decodeErr = errHTTPBadArgs{err: err}
+			return decodeErr
+		}
+		return nil
+	}
+	replyv, err := h.server.InvokeMethod(ctx, serviceMethod, decodeArg, sourceAddr)
+	if err != nil {
+		status := httpJSONStatus(err, decoded)
+		if decodeErr != nil {
+			status = httpJSONDecodeStatus(decodeErr)
+		}
+		writeHTTPJSON(w, status, httpJSONError{Error: err.Error()})
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, replyv.Interface())
+}
+
+// httpJSONDecodeStatus returns the status of the response to a call whose
+// argument could not be decoded from the request body.
+func httpJSONDecodeStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// httpJSONStatus returns the status of the response to a call that failed
+// with err, after its argument was decoded if decoded is set.
+func httpJSONStatus(err error, decoded bool) int {
+	switch code := ErrorCode(err); {
+	case code == CodeUnavailable || err == ErrServerClosed:
+		return http.StatusServiceUnavailable
+	case code == CodeTooManyRequests:
+		return http.StatusTooManyRequests
+	case code == CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case code == CodeNotPermitted:
+		return http.StatusForbidden
+	case !decoded:
+		// An interceptor refused the call before its argument was read.
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *httpJSONHandler) serveIndex(w http.ResponseWriter) {
+	services := []ServiceDescriptor{}
+	for _, svc := range h.server.Describe() {
+		methods := svc.Methods[:0]
+		for _, m := range svc.Methods {
+			if h.exposed(svc.Name + "." + m.Name) {
+				methods = append(methods, m)
+			}
+		}
+		if len(methods) > 0 {
+			svc.Methods = methods
+			services = append(services, svc)
+		}
+	}
+	writeHTTPJSON(w, http.StatusOK, services)
+}
+
+func writeHTTPJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// httpAuthToken returns the auth token of r's Authorization header.
+func httpAuthToken(r *http.Request) string {
+	token := r.Header.Get("Authorization")
+	if len(token) > len("Bearer ") && strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+		token = token[len("Bearer "):]
+	}
+	return token
+}
+
+// httpSourceAddr returns the address of the client that made r, as a *Peer
+// if r came over TLS, or nil if r.RemoteAddr is not an IP address and port.
+func httpSourceAddr(r *http.Request) net.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	addr := net.TCPAddrFromAddrPort(addrPort)
+	if r.TLS != nil {
+		return &Peer{Addr: addr, TLS: r.TLS}
+	}
+	return addr
+}
@@ -0,0 +1,184 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPJSONHandler(t *testing.T) {
+	srv := NewServerWithOpts(WithPreBodyInterceptor(func(serviceMethod string, _ net.Addr) error {
+		if serviceMethod == "Arith.Div" {
+			return errors.New("permission denied")
+		}
+		return nil
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.HTTPJSONHandler("Arith.Add", "Arith.Mul", "Arith.Div", "Arith.Error"))
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"POST", "/Arith/Add", `{"a": 7, "B": 8}`, http.StatusOK, `"C": 15`},
+		{"POST", "/Arith/Mul", `{"A": 7, "B": 8}`, http.StatusOK, `"C": 56`},
+		{"POST", "/Arith/Mul", `{"A": "x"}`, http.StatusBadRequest, "invalid argument"},
+		{"POST", "/Arith/Add", `{"Z": 1}`, http.StatusBadRequest, `unknown field \"Z\"`},
+		{"POST", "/Arith/Add", `{"A": 1`, http.StatusBadRequest, "invalid argument"},
+		{"POST", "/Arith/Div", `{"A": 1, "B": 1}`, http.StatusForbidden, "permission denied"},
+		{"POST", "/Arith/Error", `{}`, http.StatusInternalServerError, "ERROR"},
+		{"POST", "/Arith/SleepMilli", `{}`, http.StatusNotFound, "not exposed"},
+		{"GET", "/Arith/Add", "", http.StatusMethodNotAllowed, "not allowed"},
+		{"DELETE", "/Arith/Add", "", http.StatusMethodNotAllowed, "not allowed"},
+		{"POST", "/", "", http.StatusMethodNotAllowed, "not allowed"},
+	}
+	for _, tc := range tests {
+		req, err := http.NewRequest(tc.method, ts.URL+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.method, tc.path, err)
+		}
+		if resp.StatusCode != tc.status || !strings.Contains(string(body), tc.want) {
+			t.Errorf("%s %s: got %d %s, want %d containing %q", tc.method, tc.path, resp.StatusCode, body, tc.status, tc.want)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var services []ServiceDescriptor
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || len(services[0].Methods) != 4 {
+		t.Errorf("expected the 4 exposed Arith methods, got %+v", services)
+	}
+}
+
+func TestHTTPJSONHandlerAuthenticator(t *testing.T) {
+	srv := NewServerWithOpts(WithAuthenticator(func(ctx context.Context, token string, sourceAddr net.Addr) (Identity, error) {
+		if token != "secret" {
+			return Identity{}, errors.New("bad token")
+		}
+		return Identity{Name: "alice"}, nil
+	}))
+	if err := srv.Register(new(AuthEcho)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.HTTPJSONHandler("AuthEcho.*"))
+	defer ts.Close()
+
+	for authorization, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+		"secret":        http.StatusOK,
+	} {
+		req, err := http.NewRequest("POST", ts.URL+"/AuthEcho/Name", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var name string
+		json.NewDecoder(resp.Body).Decode(&name)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%q: got %d, want %d", authorization, resp.StatusCode, want)
+		}
+		if want == http.StatusOK && name != "alice" {
+			t.Errorf("%q: expected the call to be made as alice, got %q", authorization, name)
+		}
+	}
+}
+
+func TestHTTPJSONHandlerStatus(t *testing.T) {
+	srv := NewServerWithOpts(
+		WithMaxRequestBytes(64),
+		WithPreBodyInterceptor(func(serviceMethod string, _ net.Addr) error {
+			if serviceMethod == "Arith.Div" {
+				return ErrTooManyRequests
+			}
+			return nil
+		}),
+	)
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.HTTPJSONHandler("Arith.*"))
+	defer ts.Close()
+
+	status := func(method, path, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status("POST", "/Arith/Add", `{"A": 1, "B": 2}`); got != http.StatusOK {
+		t.Errorf("small body: got %d", got)
+	}
+	if got := status("POST", "/Arith/Add", `{"A": 1, "B": 2`+strings.Repeat(" ", 100)+`}`); got != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: got %d, want 413", got)
+	}
+	if got := status("POST", "/Arith/Div", `{"A": 1, "B": 1}`); got != http.StatusTooManyRequests {
+		t.Errorf("too many requests: got %d, want 429", got)
+	}
+	if err := srv.DrainService(context.Background(), "Arith"); err != nil {
+		t.Fatal(err)
+	}
+	if got := status("POST", "/Arith/Add", `{"A": 1, "B": 1}`); got != http.StatusServiceUnavailable {
+		t.Errorf("drained service: got %d, want 503", got)
+	}
+}
+
+func TestHTTPJSONHandlerUnknownSource(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/Arith/Add", strings.NewReader(`{"A": 1, "B": 2}`))
+	req.RemoteAddr = "@"
+	w := httptest.NewRecorder()
+	srv.HTTPJSONHandler("Arith.*").ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "unknown client address") {
+		t.Errorf("expected a call from an unknown address to be refused, got %d %s", w.Code, w.Body)
+	}
+}
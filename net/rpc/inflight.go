@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// CallInfo describes a call the server is serving, as returned by InFlight
+// and passed to the hooks set with WithCallHooks.
+type CallInfo struct {
+	ServiceMethod string
+	// Seq is the sequence number the client chose for the request, which
+	// the client's PendingCalls reports too. It is 0 for calls made with
+	// InvokeMethod.
+	Seq uint64
+	// Peer is the client the request came from, or nil if it is unknown, as
+	// for InvokeMethod without a sourceAddr.
+	Peer  net.Addr
+	Start time.Time // when the server began serving the call
+}
+
+// WithCallHooks makes the server call started when it begins serving a
+// call, once its request header has been read, and finished once it is done
+// with it, after its response was written or it failed. Either may be nil.
+// They are called on the goroutine serving the request, so they should not
+// block.
+func WithCallHooks(started, finished func(CallInfo)) func(*Server) {
+	return func(s *Server) {
+		s.callHooks = &callHooks{started: started, finished: finished}
+	}
+}
+
+type callHooks struct {
+	started, finished func(CallInfo)
+}
+
+// InFlight returns the calls the server is serving, oldest first, to tell
+// what it is working on.
+func (server *Server) InFlight() []CallInfo {
+	calls := []CallInfo{}
+	server.calls.Range(func(c, _ any) bool {
+		calls = append(calls, *c.(*CallInfo))
+		return true
+	})
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].Start.Before(calls[j].Start)
+	})
+	return calls
+}
+
+// trackCall records a call as being served until untrackCall is called with
+// the CallInfo it returns, running the server's started hook. The calls are
+// kept in a sync.Map rather than under server.mu, since every request
+// updates them.
+func (server *Server) trackCall(serviceMethod string, seq uint64, peer net.Addr) *CallInfo {
+	c := &CallInfo{ServiceMethod: serviceMethod, Seq: seq, Peer: peer, Start: time.Now()}
+	server.calls.Store(c, nil)
+	if h := server.callHooks; h != nil && h.started != nil {
+		h.started(*c)
+	}
+	return c
+}
+
+// untrackCall records the call c as served, running the server's finished
+// hook.
+func (server *Server) untrackCall(c *CallInfo) {
+	server.calls.Delete(c)
+	if h := server.callHooks; h != nil && h.finished != nil {
+		h.finished(*c)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestInFlight(t *testing.T) {
+	var mu sync.Mutex
+	var started, finished []CallInfo
+	srv := NewServerWithOpts(WithCallHooks(func(c CallInfo) {
+		mu.Lock()
+		started = append(started, c)
+		mu.Unlock()
+	}, func(c CallInfo) {
+		mu.Lock()
+		finished = append(finished, c)
+		mu.Unlock()
+	}))
+	blocker := newBlocker()
+	if err := srv.RegisterAll(blocker, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+	calls := srv.InFlight()
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls in flight, want 1: %+v", len(calls), calls)
+	}
+	c := calls[0]
+	pending := client.PendingCalls()
+	if c.ServiceMethod != "Blocker.Block" || len(pending) != 1 || c.Seq != pending[0].Seq || c.Peer == nil || c.Start.IsZero() {
+		t.Errorf("got %+v, want the pending call %+v", c, pending)
+	}
+
+	close(blocker.release)
+	if <-call.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.Add", func(arg any) error {
+		*arg.(*Args) = Args{1, 2}
+		return nil
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Calls are done with once their response was written.
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(finished) == 2 && len(srv.InFlight()) == 0
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(started) != 2 {
+		t.Fatalf("got %d started calls, want 2", len(started))
+	}
+	if started[0] != c || finished[0] != c {
+		t.Errorf("hooks got %+v and %+v, want %+v", started[0], finished[0], c)
+	}
+	if invoked := started[1]; invoked.ServiceMethod != "Arith.Add" || invoked.Seq != 0 || invoked.Peer != nil {
+		t.Errorf("InvokeMethod call: got %+v", invoked)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"reflect"
+)
+
+// The interfaces below are the parts of Server and Client that frameworks
+// layered on this package, such as request routers, proxies and test fakes,
+// should depend on instead of the concrete types, so that the types can be
+// redesigned under them. They are stable: methods are only added to them in
+// a new major version.
+
+// Registrar registers the services a server serves. *Server implements it.
+type Registrar interface {
+	Register(rcvr interface{}) error
+	RegisterName(name string, rcvr interface{}) error
+}
+
+// RequestServer serves the requests read from ServerCodecs. *Server
+// implements it.
+type RequestServer interface {
+	ServeRequest(codec ServerCodec) error
+	ServeRequestContext(ctx context.Context, codec ServerCodec) error
+	ServeRequestAsync(ctx context.Context, codec ServerCodec) error
+}
+
+// MethodInvoker calls registered methods in process. *Server implements it.
+type MethodInvoker interface {
+	InvokeMethod(ctx context.Context, serviceMethod string, decodeArgFn func(any) error, sourceAddr net.Addr) (reflect.Value, error)
+}
+
+// ServerInterface is the set of Server capabilities other packages may rely
+// on. *Server implements it.
+type ServerInterface interface {
+	Registrar
+	RequestServer
+	MethodInvoker
+	// Stats returns the calls, errors and latency of each method.
+	Stats() ServerStats
+	// Shutdown stops the server, waiting for requests being served.
+	Shutdown(ctx context.Context) error
+}
+
+// Caller makes calls and waits for their replies. *Client, *ClientPool and
+// *MultiClient implement it.
+type Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
+}
+
+// ClientInterface is the set of Client capabilities other packages may rely
+// on. *Client implements it, and so does rpctest.RecordingCaller, which unit
+// tests of code making calls can substitute for a Client.
+type ClientInterface interface {
+	Caller
+	Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call
+	// PendingCalls returns the calls waiting for a response.
+	PendingCalls() []PendingCall
+	Close() error
+}
@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"testing"
+)
+
+var (
+	_ ServerInterface = (*Server)(nil)
+	_ ClientInterface = (*Client)(nil)
+	_ Caller          = (*ClientPool)(nil)
+	_ Caller          = (*MultiClient)(nil)
+)
+
+// fakeCaller is the kind of test fake the interfaces let callers of this
+// package substitute for a Client.
+type fakeCaller struct{ calls []string }
+
+func (f *fakeCaller) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return f.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+func (f *fakeCaller) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	f.calls = append(f.calls, serviceMethod)
+	reply.(*Reply).C = args.(Args).A + args.(Args).B
+	return nil
+}
+
+func TestInterfaces(t *testing.T) {
+	add := func(c Caller) int {
+		reply := new(Reply)
+		if err := c.Call("Arith.Add", Args{1, 2}, reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply.C
+	}
+
+	var srv ServerInterface = NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv.(*Server)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ci ClientInterface = client
+	defer ci.Close()
+
+	fake := new(fakeCaller)
+	if add(ci) != 3 || add(fake) != 3 || len(fake.calls) != 1 {
+		t.Error("expected the client and the fake to add alike")
+	}
+	if stats := srv.Stats()["Arith.Add"]; stats.Calls != 1 {
+		t.Errorf("expected one call in the server's stats, got %+v", stats)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ServiceInfo describes a registered service, as returned by
+// Server.Services.
+type ServiceInfo struct {
+	Name    string
+	Methods []MethodInfo // sorted by name
+}
+
+// MethodInfo describes a method of a registered service and the calls made
+// to it so far.
+type MethodInfo struct {
+	Name       string
+	ArgType    reflect.Type
+	ReplyType  reflect.Type
+	HasContext bool // the method takes a context.Context first
+	Stream     bool // the method streams its reply, see Stream
+	Duplex     bool // the method is called with OpenDuplex
+	Doc        MethodDoc
+
+	// Calls counts the requests for the method read from connections, as
+	// shown on the debug page. Stats are those Server.Stats reports for it,
+	// which also cover calls made with InvokeMethod; they are zero until its
+	// handler first returns.
+	Calls uint64
+	Stats MethodStats
+}
+
+// Services returns the registered services, sorted by name, with their
+// methods. Unlike Describe, which documents the wire schema of the
+// services, it reports the Go types of the methods and their statistics.
+// The method tables of services registered with WithLazyMethodTables are
+// built if they were not yet.
+func (server *Server) Services() []ServiceInfo {
+	stats := server.Stats()
+	var out []ServiceInfo
+	server.serviceMap.Range(func(snamei, svci interface{}) bool {
+		svc := svci.(*service)
+		info := ServiceInfo{Name: snamei.(string)}
+		for mname, mtype := range svc.methods() {
+			info.Methods = append(info.Methods, MethodInfo{
+				Name:       mname,
+				ArgType:    mtype.ArgType,
+				ReplyType:  mtype.ReplyType,
+				HasContext: mtype.HasContext,
+				Stream:     mtype.isStream(),
+				Duplex:     mtype.isDuplex(),
+				Doc:        svc.docs[mname],
+				Calls:      uint64(mtype.NumCalls()),
+				Stats:      stats[info.Name+"."+mname],
+			})
+		}
+		sort.Slice(info.Methods, func(i, j int) bool { return info.Methods[i].Name < info.Methods[j].Name })
+		out = append(out, info)
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestServices(t *testing.T) {
+	srv := NewServer()
+	err := srv.RegisterWithDocs(new(Arith), map[string]MethodDoc{
+		"Add": {Description: "Adds A and B.", Stability: StabilityStable},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMethod(srv, "Typed.Add", func(ctx context.Context, args *Args, reply *Reply) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := srv.InvokeMethod(context.Background(), "Arith.Add", func(any) error { return nil }, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services := srv.Services()
+	if len(services) != 2 || services[0].Name != "Arith" || services[1].Name != "Typed" {
+		t.Fatalf("unexpected services: %+v", services)
+	}
+	var add *MethodInfo
+	for i, m := range services[0].Methods {
+		if i > 0 && services[0].Methods[i-1].Name >= m.Name {
+			t.Errorf("methods not sorted: %s before %s", services[0].Methods[i-1].Name, m.Name)
+		}
+		if m.Name == "Add" {
+			add = &services[0].Methods[i]
+		}
+	}
+	if add == nil {
+		t.Fatal("Arith.Add not found")
+	}
+	if add.ArgType != reflect.TypeOf(Args{}) || add.ReplyType != reflect.TypeOf(&Reply{}) || !add.HasContext {
+		t.Errorf("unexpected signature: %+v", add)
+	}
+	if add.Doc.Stability != StabilityStable || add.Calls != 1 || add.Stats.Calls != 3 {
+		t.Errorf("unexpected doc or stats: %+v", add)
+	}
+	if typed := services[1].Methods; len(typed) != 1 || typed[0].ArgType != reflect.TypeOf(&Args{}) || typed[0].Calls != 0 {
+		t.Errorf("unexpected methods of Typed: %+v", typed)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Reply struct {
+	C int
+}
+
+type Arith int
+
+func (t *Arith) Add(args *Args, reply *Reply) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (t *Arith) Mul(args *Args, reply *Reply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Div(args *Args, reply *Reply) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	reply.C = args.A / args.B
+	return nil
+}
+
+func newServer(t *testing.T) *rpc.Server {
+	srv := rpc.NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// serve serves requests read from conn until the connection is done.
+func serve(srv *rpc.Server, conn io.ReadWriteCloser) {
+	codec := NewServerCodec(conn)
+	defer codec.Close()
+	for {
+		if err := srv.ServeRequest(codec); err == io.EOF || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func TestServerV1(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	go serve(newServer(t), srv)
+	dec := json.NewDecoder(cli)
+
+	// Send hand-coded requests to server, parse responses.
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(cli, `{"method": "Arith.Add", "id": "\u%04d", "params": [{"A": %d, "B": %d}]}`, i, i, i+1)
+		var resp struct {
+			Id     string
+			Result Reply
+			Error  interface{}
+		}
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("Decode: %s", err)
+		}
+		if resp.Error != nil {
+			t.Fatalf("resp.Error: %s", resp.Error)
+		}
+		if resp.Id != string(rune(i)) {
+			t.Fatalf("resp: bad id %q want %q", resp.Id, string(rune(i)))
+		}
+		if resp.Result.C != 2*i+1 {
+			t.Fatalf("resp: bad result: %d+%d=%d", i, i+1, resp.Result.C)
+		}
+	}
+}
+
+func TestServerV2(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	go serve(newServer(t), srv)
+	r := bufio.NewReader(cli)
+
+	tests := []struct {
+		req  string
+		resp string
+	}{
+		{
+			req:  `{"jsonrpc": "2.0", "method": "Arith.Add", "params": [{"A": 1, "B": 2}], "id": 1}`,
+			resp: `{"jsonrpc":"2.0","id":1,"result":{"C":3}}`,
+		},
+		{
+			// Params by name.
+			req:  `{"jsonrpc": "2.0", "method": "Arith.Mul", "params": {"A": 3, "B": 4}, "id": "a"}`,
+			resp: `{"jsonrpc":"2.0","id":"a","result":{"C":12}}`,
+		},
+		{
+			// Notifications get no response, so the next response is for id 3.
+			req: `{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 1}}` +
+				`{"jsonrpc": "2.0", "method": "Arith.Div", "params": {"A": 1, "B": 0}, "id": 3}`,
+			resp: `{"jsonrpc":"2.0","id":3,"error":{"code":-32000,"message":"divide by zero"}}`,
+		},
+		{
+			req:  `{"jsonrpc": "2.0", "method": "Arith.Nope", "params": {}, "id": 4}`,
+			resp: `{"jsonrpc":"2.0","id":4,"error":{"code":-32601,"message":"rpc: can't find method Arith.Nope"}}`,
+		},
+		{
+			req:  `{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": "x"}, "id": 5}`,
+			resp: `{"jsonrpc":"2.0","id":5,"error":{"code":-32602,`,
+		},
+	}
+	for _, tc := range tests {
+		if _, err := io.WriteString(cli, tc.req); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, tc.resp) {
+			t.Errorf("request %s\ngot  %s\nwant %s", tc.req, line, tc.resp)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	for name, newCodec := range map[string]func(io.ReadWriteCloser) rpc.ClientCodec{
+		"v1": NewClientCodec,
+		"v2": NewClientCodecV2,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cli, srv := net.Pipe()
+			go serve(newServer(t), srv)
+
+			client := rpc.NewClientWithCodec(newCodec(cli))
+			defer client.Close()
+
+			reply := new(Reply)
+			if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.C != 15 {
+				t.Errorf("Add: expected 15, got %d", reply.C)
+			}
+
+			err := client.Call("Arith.Div", &Args{7, 0}, reply)
+			if err == nil || err.Error() != "divide by zero" {
+				t.Errorf("Div: expected divide by zero error, got %v", err)
+			}
+
+			call := client.Go("Arith.Mul", &Args{7, 8}, new(Reply), nil)
+			if call = <-call.Done; call.Error != nil {
+				t.Fatal(call.Error)
+			}
+			if c := call.Reply.(*Reply).C; c != 56 {
+				t.Errorf("Mul: expected 56, got %d", c)
+			}
+		})
+	}
+}
+
+func TestMalformedOutput(t *testing.T) {
+	cli, srv := net.Pipe()
+	go srv.Write([]byte(`{"id":0,"result":null,"error":null}`))
+	go io.ReadAll(srv)
+
+	client := NewClient(cli)
+	defer client.Close()
+
+	args := Args{7, 8}
+	reply := new(Reply)
+	err := client.Call("Arith.Add", args, reply)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+type Catalog struct{}
+
+func (Catalog) Lookup(name *string, reply *string) error {
+	return &rpc.CodedError{Code: "not_found", Message: "no node " + *name, Details: map[string]string{"node": *name}}
+}
+
+func TestCodedErrorV2(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.Register(Catalog{}); err != nil {
+		t.Fatal(err)
+	}
+	cli, conn := net.Pipe()
+	go serve(srv, conn)
+	client := rpc.NewClientWithCodec(NewClientCodecV2(cli))
+	defer client.Close()
+
+	var reply string
+	err := client.Call("Catalog.Lookup", "a", &reply)
+	var coded *rpc.CodedError
+	if !errors.As(err, &coded) {
+		t.Fatalf("expected a CodedError, got %T: %v", err, err)
+	}
+	if coded.Code != "not_found" || coded.Message != "no node a" || coded.Details["node"] != "a" {
+		t.Errorf("unexpected error %+v", coded)
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	srv := rpc.NewServerWithOpts(rpc.WithAuthenticator(func(ctx context.Context, token string, sourceAddr net.Addr) (rpc.Identity, error) {
+		if token != "secret" {
+			return rpc.Identity{}, errors.New("bad token")
+		}
+		return rpc.Identity{Name: "alice"}, nil
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	for name, newCodec := range map[string]func(io.ReadWriteCloser) rpc.ClientCodec{
+		"v1": NewClientCodec,
+		"v2": NewClientCodecV2,
+	} {
+		t.Run(name, func(t *testing.T) {
+			for token, ok := range map[string]bool{"secret": true, "wrong": false} {
+				cli, conn := net.Pipe()
+				go serve(srv, conn)
+				token := token
+				client := rpc.NewClientWithOpts(newCodec(cli), rpc.WithAuthToken(func(context.Context) (string, error) {
+					return token, nil
+				}))
+				err := client.Call("Arith.Add", &Args{7, 8}, new(Reply))
+				client.Close()
+				if ok && err != nil {
+					t.Errorf("%s: %v", token, err)
+				}
+				if !ok && (err == nil || !strings.Contains(err.Error(), "bad token")) {
+					t.Errorf("%s: expected the call to be unauthenticated, got %v", token, err)
+				}
+			}
+		})
+	}
+}
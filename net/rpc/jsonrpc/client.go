@@ -0,0 +1,179 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jsonrpc implements a JSON-RPC ClientCodec and ServerCodec
+// for the rpc package.
+// For JSON-RPC 1.0 it is compatible with the standard library's net/rpc/jsonrpc.
+// The server codec also understands JSON-RPC 2.0 requests, and the client
+// codec returned by NewClientCodecV2 sends them.
+//
+// The auth token of a request, for the server's rpc.Authenticator, is sent in
+// an "auth" member of the request object, in both versions.
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+type clientCodec struct {
+	dec *json.Decoder // for reading JSON values
+	enc *json.Encoder // for writing JSON values
+	c   io.Closer
+
+	// version2 selects JSON-RPC 2.0 requests.
+	version2 bool
+
+	// temporary work space
+	req  clientRequest
+	resp clientResponse
+
+	// JSON-RPC responses include the request id but not the request method.
+	// Package rpc expects both.
+	// We save the request method in pending when sending a request
+	// and then look it up by request ID when filling out the rpc Response.
+	mutex   sync.Mutex        // protects pending
+	pending map[uint64]string // map request id to method name
+}
+
+// NewClientCodec returns a new rpc.ClientCodec using JSON-RPC 1.0 on conn.
+func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]string),
+	}
+}
+
+// NewClientCodecV2 returns a new rpc.ClientCodec using JSON-RPC 2.0 on conn.
+func NewClientCodecV2(conn io.ReadWriteCloser) rpc.ClientCodec {
+	codec := NewClientCodec(conn).(*clientCodec)
+	codec.version2 = true
+	return codec
+}
+
+type clientRequest struct {
+	Version string         `json:"jsonrpc,omitempty"`
+	Method  string         `json:"method"`
+	Params  [1]interface{} `json:"params"`
+	Id      uint64         `json:"id"`
+	Auth    string         `json:"auth,omitempty"`
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	c.mutex.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mutex.Unlock()
+	if c.version2 {
+		c.req.Version = version2
+	}
+	c.req.Method = r.ServiceMethod
+	c.req.Params[0] = param
+	c.req.Id = r.Seq
+	c.req.Auth = r.AuthToken
+	return c.enc.Encode(&c.req)
+}
+
+type clientResponse struct {
+	Id     uint64           `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+func (r *clientResponse) reset() {
+	r.Id = 0
+	r.Result = nil
+	r.Error = nil
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.resp.reset()
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	r.ServiceMethod = c.pending[c.resp.Id]
+	delete(c.pending, c.resp.Id)
+	c.mutex.Unlock()
+
+	r.Error = ""
+	r.ErrorCode = ""
+	r.ErrorDetails = nil
+	r.ErrorRetryable = false
+	r.Seq = c.resp.Id
+	if c.resp.Error != nil || c.resp.Result == nil {
+		switch e := c.resp.Error.(type) {
+		case string:
+			r.Error = e
+		case map[string]interface{}:
+			// A JSON-RPC 2.0 error object.
+			msg, ok := e["message"].(string)
+			if !ok {
+				return fmt.Errorf("invalid error %v", c.resp.Error)
+			}
+			r.Error = msg
+			decodeErrorData(e["data"], r)
+		default:
+			return fmt.Errorf("invalid error %v", c.resp.Error)
+		}
+		if r.Error == "" {
+			r.Error = "unspecified error"
+		}
+	}
+	return nil
+}
+
+// decodeErrorData fills in the code, details and retryable mark of an
+// rpc.CodedError from the data member of a JSON-RPC 2.0 error object, if
+// present.
+func decodeErrorData(data interface{}, r *rpc.Response) {
+	d, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	r.ErrorCode, _ = d["code"].(string)
+	r.ErrorRetryable, _ = d["retryable"].(bool)
+	details, _ := d["details"].(map[string]interface{})
+	for k, v := range details {
+		if s, ok := v.(string); ok {
+			if r.ErrorDetails == nil {
+				r.ErrorDetails = make(map[string]string)
+			}
+			r.ErrorDetails[k] = s
+		}
+	}
+}
+
+func (c *clientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+func (c *clientCodec) Close() error {
+	return c.c.Close()
+}
+
+// NewClient returns a new rpc.Client to handle requests to the
+// set of services at the other end of the connection.
+func NewClient(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+// Dial connects to a JSON-RPC server at the specified network address.
+func Dial(network, address string) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), err
+}
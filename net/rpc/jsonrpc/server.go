@@ -0,0 +1,236 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// version2 is the value of the jsonrpc member of JSON-RPC 2.0 messages.
+const version2 = "2.0"
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeServerError is used for errors returned by service methods.
+	CodeServerError = -32000
+)
+
+// Error is a JSON-RPC 2.0 error object.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var errMissingParams = errors.New("jsonrpc: request body missing params")
+
+type serverCodec struct {
+	dec *json.Decoder // for reading JSON values
+	enc *json.Encoder // for writing JSON values
+	c   io.ReadWriteCloser
+
+	// temporary work space
+	req serverRequest
+
+	// JSON-RPC clients can use arbitrary json values as request IDs.
+	// Package rpc expects uint64 request IDs.
+	// We assign uint64 sequence numbers to incoming requests
+	// but save the original request ID in the pending map.
+	// When rpc responds, we use the sequence number in
+	// the response to find the original request ID.
+	mutex   sync.Mutex // protects seq, pending
+	seq     uint64
+	pending map[uint64]pendingRequest
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type pendingRequest struct {
+	id       *json.RawMessage
+	version2 bool
+}
+
+// NewServerCodec returns a new rpc.ServerCodec using JSON-RPC on conn.
+// Each request is answered in the version it was sent in: requests with a
+// jsonrpc member of "2.0" get JSON-RPC 2.0 responses, and JSON-RPC 2.0
+// notifications, which have no id, get none.
+func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]pendingRequest),
+	}
+}
+
+type serverRequest struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params"`
+	Id      *json.RawMessage `json:"id"`
+	Auth    string           `json:"auth"`
+}
+
+func (r *serverRequest) reset() {
+	r.Version = ""
+	r.Method = ""
+	r.Params = nil
+	r.Id = nil
+	r.Auth = ""
+}
+
+type serverResponse struct {
+	Id     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+type serverResponseV2 struct {
+	Version string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req.reset()
+	if err := c.dec.Decode(&c.req); err != nil {
+		return err
+	}
+	r.ServiceMethod = c.req.Method
+	r.AuthToken = c.req.Auth
+
+	// JSON request id can be any JSON value;
+	// RPC package expects uint64.  Translate to
+	// internal uint64 and save JSON on the side.
+	c.mutex.Lock()
+	c.seq++
+	c.pending[c.seq] = pendingRequest{id: c.req.Id, version2: c.req.Version == version2}
+	c.req.Id = nil
+	r.Seq = c.seq
+	c.mutex.Unlock()
+
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	if c.req.Params == nil {
+		if c.req.Version == version2 {
+			// Params may be omitted in JSON-RPC 2.0.
+			return nil
+		}
+		return errMissingParams
+	}
+	if c.req.Version == version2 && bytes.HasPrefix(bytes.TrimSpace(*c.req.Params), []byte("{")) {
+		// JSON-RPC 2.0 allows params by name, which we decode into x.
+		return json.Unmarshal(*c.req.Params, x)
+	}
+	// JSON params is array value.
+	// RPC params is struct.
+	// Unmarshal into array containing struct for now.
+	// Should think about making RPC more general.
+	var params [1]interface{}
+	params[0] = x
+	return json.Unmarshal(*c.req.Params, &params)
+}
+
+var null = json.RawMessage([]byte("null"))
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.mutex.Lock()
+	p, ok := c.pending[r.Seq]
+	if !ok {
+		c.mutex.Unlock()
+		return errors.New("invalid sequence number in response")
+	}
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+
+	if p.version2 {
+		if p.id == nil {
+			// Notifications are not answered.
+			return nil
+		}
+		resp := serverResponseV2{Version: version2, Id: p.id}
+		if r.Error == "" {
+			resp.Result = x
+		} else {
+			resp.Error = &Error{Code: errorCode(r.Error), Message: r.Error}
+			if r.ErrorCode != "" || r.ErrorRetryable {
+				resp.Error.Data = errorData{Code: r.ErrorCode, Details: r.ErrorDetails, Retryable: r.ErrorRetryable}
+			}
+		}
+		return c.enc.Encode(resp)
+	}
+
+	if p.id == nil {
+		// Invalid request so no id. Use JSON null.
+		p.id = &null
+	}
+	resp := serverResponse{Id: p.id}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		resp.Error = r.Error
+	}
+	return c.enc.Encode(resp)
+}
+
+// errorData is the data member of errors for rpc.CodedErrors, which carries
+// their code and details.
+type errorData struct {
+	Code      string            `json:"code"`
+	Details   map[string]string `json:"details,omitempty"`
+	Retryable bool              `json:"retryable,omitempty"`
+}
+
+// errorCode returns the JSON-RPC 2.0 error code for an error reported by the
+// rpc package.
+func errorCode(msg string) int {
+	switch {
+	case strings.HasPrefix(msg, "rpc: can't find"),
+		strings.HasPrefix(msg, "rpc: service/method request ill-formed"):
+		return CodeMethodNotFound
+	case strings.HasPrefix(msg, "jsonrpc: request body missing params"),
+		strings.HasPrefix(msg, "json: "):
+		return CodeInvalidParams
+	}
+	return CodeServerError
+}
+
+func (c *serverCodec) SourceAddr() net.Addr {
+	if conn, ok := c.c.(net.Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+func (c *serverCodec) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.c.Close()
+	})
+	return c.closeErr
+}
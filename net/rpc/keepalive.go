@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"errors"
+	"time"
+)
+
+// pingMethod is the ServiceMethod of the keepalive pings clients send.
+// Servers answer them with an empty reply, before looking up any method, and
+// without admission control or call interceptors.
+const pingMethod = "_rpc.Ping"
+
+// ErrKeepaliveTimeout is the error of the calls pending on a connection that
+// the client's keepalive pings declared dead.
+var ErrKeepaliveTimeout = errors.New("rpc: connection is dead: keepalive pings went unanswered")
+
+// WithKeepalive makes the client ping the server every interval, and close
+// the connection once maxMissed pings in a row went unanswered for an
+// interval each, failing the pending calls with ErrKeepaliveTimeout rather
+// than leaving them waiting on a half-open connection that TCP keepalives
+// take minutes to notice. A maxMissed of less than 1 is taken as 1.
+//
+// Servers answer pings before serving other requests. Servers of versions
+// that do not know them answer with an error, which keeps the connection
+// alive all the same.
+func WithKeepalive(interval time.Duration, maxMissed int) func(*Client) {
+	return func(c *Client) {
+		if interval <= 0 {
+			return
+		}
+		if maxMissed < 1 {
+			maxMissed = 1
+		}
+		c.keepalive = &keepalive{interval: interval, maxMissed: maxMissed}
+	}
+}
+
+type keepalive struct {
+	interval  time.Duration
+	maxMissed int
+}
+
+// ping sends keepalive pings until the client shuts down or declares the
+// connection dead.
+func (client *Client) ping(k *keepalive) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	var outstanding *Call
+	missed := 0
+	for range ticker.C {
+		if client.isShutdown() {
+			return
+		}
+		if outstanding != nil {
+			select {
+			case <-outstanding.Done:
+				missed = 0
+			default:
+				if missed++; missed >= k.maxMissed {
+					client.declareDead()
+					return
+				}
+				// Wait for the ping outstanding rather than send
+				// another behind it.
+				continue
+			}
+		}
+		outstanding = &Call{ServiceMethod: pingMethod, Args: struct{}{}, Done: make(chan *Call, 1)}
+		// A write to a dead connection may block, so the ping is sent
+		// in the background.
+		go client.send(outstanding)
+	}
+}
+
+// declareDead closes the connection, so that the pending calls fail with
+// ErrKeepaliveTimeout.
+func (client *Client) declareDead() {
+	client.mutex.Lock()
+	if client.shutdown || client.closing {
+		client.mutex.Unlock()
+		return
+	}
+	client.broken = ErrKeepaliveTimeout
+	client.mutex.Unlock()
+	client.codec.Close()
+}
+
+// pong is the reply to a keepalive ping.
+type pong struct{}
+
+// answerPing replies to a keepalive ping.
+func (server *Server) answerPing(sending *writeQueue, req *Request, codec ServerCodec) error {
+	server.sendResponse(sending, req, pong{}, codec, nil)
+	server.freeRequest(req)
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	// Pings are answered even while the concurrency limit is reached.
+	srv := NewServerWithOpts(WithMaxConcurrentRequests(1, 0))
+	blocker := newBlocker()
+	if err := srv.Register(blocker); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDialer(WithDialClientOptions(WithKeepalive(20*time.Millisecond, 5)))
+	client, err := d.DialContext(context.Background(), "tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+	time.Sleep(200 * time.Millisecond)
+	close(blocker.release)
+	if err := (<-call.Done).Error; err != nil {
+		t.Fatalf("expected the connection to stay alive, got %v", err)
+	}
+}
+
+func TestKeepaliveDeadConnection(t *testing.T) {
+	// The server reads requests but never answers.
+	l, addr := listenTCP(t)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+	d := NewDialer(WithDialClientOptions(WithKeepalive(10*time.Millisecond, 3)))
+	client, err := d.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Arith.Add", Args{1, 2}, new(Reply), nil)
+	select {
+	case call = <-call.Done:
+		if call.Error != ErrKeepaliveTimeout {
+			t.Errorf("expected ErrKeepaliveTimeout, got %v", call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pending call to fail")
+	}
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != ErrShutdown {
+		t.Errorf("expected later calls to fail with ErrShutdown, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Lifecycle owns the listeners, Servers and clients of a process and tears
+// them down in order. Shutdown first stops accepting connections and waits
+// for the accept loops to exit, then shuts down the servers, waits for the
+// goroutines serving their connections, and finally closes the clients, which
+// handlers may have been using to forward requests.
+type Lifecycle struct {
+	mu        sync.Mutex // protects following
+	closing   bool
+	listeners []net.Listener
+	servers   []*Server
+	clients   []io.Closer
+
+	loops sync.WaitGroup // accept loops
+	conns sync.WaitGroup // connection goroutines started by Serve
+}
+
+// NewLifecycle returns an empty Lifecycle.
+func NewLifecycle() *Lifecycle {
+	return new(Lifecycle)
+}
+
+// AddServer adds a server for Shutdown to shut down.
+func (lc *Lifecycle) AddServer(server *Server) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.servers = append(lc.servers, server)
+}
+
+// AddClient adds a client, such as a Client, ClientPool or MultiClient, for
+// Shutdown to close once the servers are shut down.
+func (lc *Lifecycle) AddClient(client io.Closer) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.clients = append(lc.clients, client)
+}
+
+// Serve accepts connections on l and calls serve for each in its own
+// goroutine. serve should serve requests on the connection with one of the
+// Lifecycle's servers until ServeRequest returns an error. Serve blocks until
+// l fails or the Lifecycle shuts down, in which case it returns
+// ErrServerClosed.
+func (lc *Lifecycle) Serve(l net.Listener, serve func(net.Conn)) error {
+	lc.mu.Lock()
+	if lc.closing {
+		lc.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	lc.listeners = append(lc.listeners, l)
+	lc.loops.Add(1)
+	lc.mu.Unlock()
+	defer lc.loops.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if lc.isClosing() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		lc.mu.Lock()
+		if lc.closing {
+			lc.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		lc.conns.Add(1)
+		lc.mu.Unlock()
+		go func() {
+			defer lc.conns.Done()
+			serve(conn)
+		}()
+	}
+}
+
+func (lc *Lifecycle) isClosing() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.closing
+}
+
+// Shutdown closes the listeners, shuts down the servers, waits for their
+// connections to be served and closes the clients. If ctx is done first, the
+// remaining steps are still taken without waiting, and ctx.Err() is included
+// in the returned error.
+func (lc *Lifecycle) Shutdown(ctx context.Context) error {
+	lc.mu.Lock()
+	if lc.closing {
+		lc.mu.Unlock()
+		return ErrServerClosed
+	}
+	lc.closing = true
+	listeners, servers, clients := lc.listeners, lc.servers, lc.clients
+	lc.mu.Unlock()
+
+	var errs []error
+	for _, l := range listeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	// Wait for the accept loops so no connection is handed to a server that
+	// is shutting down.
+	lc.loops.Wait()
+
+	var wg sync.WaitGroup
+	serverErrs := make([]error, len(servers))
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *Server) {
+			defer wg.Done()
+			serverErrs[i] = server.Shutdown(ctx)
+		}(i, server)
+	}
+	wg.Wait()
+	errs = append(errs, serverErrs...)
+
+	done := make(chan struct{})
+	go func() {
+		lc.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if !errors.Is(errors.Join(errs...), ctx.Err()) {
+			errs = append(errs, ctx.Err())
+		}
+	}
+
+	for _, client := range clients {
+		if err := client.Close(); err != nil && err != ErrShutdown {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	srv := NewServer()
+	srv.Register(new(Arith))
+	blocker := newBlocker()
+	srv.Register(blocker)
+	l, addr := listenTCP(t)
+
+	lc := NewLifecycle()
+	lc.AddServer(srv)
+	served := make(chan error, 1)
+	go func() {
+		served <- lc.Serve(l, func(conn net.Conn) { serveConn(srv, conn) })
+	}()
+
+	pool := DialPool("tcp", addr)
+	lc.AddClient(pool)
+	if err := pool.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A call in flight when Shutdown starts completes.
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	slow := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- lc.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v without waiting for the in-flight call", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(blocker.release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatal(err)
+	}
+	if call := <-slow.Done; call.Error != nil {
+		t.Errorf("expected the in-flight call to complete, got %v", call.Error)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected Serve to return ErrServerClosed, got %v", err)
+	}
+	if err := pool.Call("Arith.Add", Args{1, 2}, new(Reply)); err != ErrShutdown {
+		t.Errorf("expected the pool to be closed, got %v", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+	if err := lc.Shutdown(ctx); err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed from a second Shutdown, got %v", err)
+	}
+	if err := lc.Serve(l, nil); err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed from Serve after Shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+)
+
+// ErrRequestTooLarge is returned, and sent to the client, for requests whose
+// body is larger than the limit set with WithMaxRequestBytes. The codec
+// stops reading in the middle of the request, so the server closes the
+// connection after sending the error.
+var ErrRequestTooLarge = errors.New("rpc: request body too large")
+
+// RequestSizeLimiter is implemented by ServerCodecs that can refuse to decode
+// request messages larger than a limit, before allocating memory for them.
+// Codecs should return an error wrapping ErrRequestTooLarge. The gob codec
+// used by this package, and the msgpackrpc and protorpc codecs, implement it.
+type RequestSizeLimiter interface {
+	// SetMaxRequestBytes is called before the first request is read.
+	SetMaxRequestBytes(n int64)
+}
+
+// WithMaxRequestBytes makes the server refuse requests whose header or body
+// is larger than n bytes, on connections whose codec implements
+// RequestSizeLimiter. Requests on other connections are not limited.
+func WithMaxRequestBytes(n int64) func(*Server) {
+	return func(s *Server) {
+		s.maxRequestBytes = n
+	}
+}
+
+// closeIfTooLarge closes codec if err means a request was only partly read
+// because it was too large, since the next request cannot be found.
+func closeIfTooLarge(codec ServerCodec, err error) {
+	if errors.Is(err, ErrRequestTooLarge) {
+		codec.Close()
+	}
+}
+
+// SetMaxRequestBytes implements RequestSizeLimiter.
+func (c *gobServerCodec) SetMaxRequestBytes(n int64) {
+	if c.limit == nil {
+		var r io.Reader = c.conn
+		if c.counted != nil {
+			r = c.counted
+		}
+		c.limit = &gobLimitReader{r: bufio.NewReader(r)}
+		c.dec = gob.NewDecoder(c.limit)
+	}
+	c.limit.max = n
+}
+
+// gobLimitReader passes a stream of gob messages through, failing any message
+// that would take the bytes read since reset past max. Each message is
+// preceded by its length, so it is refused before gob allocates a buffer for
+// it.
+type gobLimitReader struct {
+	r   *bufio.Reader
+	max int64 // zero means no limit
+
+	read      int64  // bytes of messages read since reset
+	remaining int64  // bytes left in the current message
+	prefix    []byte // bytes of the current length prefix read so far
+}
+
+func (l *gobLimitReader) reset() {
+	l.read = 0
+}
+
+func (l *gobLimitReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if l.remaining > 0 {
+		if int64(len(p)) > l.remaining {
+			p = p[:l.remaining]
+		}
+		n, err := l.r.Read(p)
+		l.remaining -= int64(n)
+		return n, err
+	}
+	// Hand out the length prefix a byte at a time, so the length is checked
+	// before the decoder has all of it.
+	b, err := l.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	l.prefix = append(l.prefix, b)
+	if size, ok := gobMessageSize(l.prefix); ok {
+		l.read += int64(len(l.prefix)) + size
+		l.prefix = l.prefix[:0]
+		if l.max > 0 && l.read > l.max {
+			return 0, ErrRequestTooLarge
+		}
+		l.remaining = size
+	}
+	p[0] = b
+	return 1, nil
+}
+
+// ReadByte implements io.ByteReader, which stops gob from adding a buffer of
+// its own that would read ahead of the message being decoded.
+func (l *gobLimitReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(l, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// gobMessageSize decodes the unsigned integer gob writes before each
+// message. It reports false if more bytes of it are needed.
+func gobMessageSize(prefix []byte) (int64, bool) {
+	if prefix[0] <= 0x7f {
+		return int64(prefix[0]), true
+	}
+	n := -int(int8(prefix[0]))
+	if len(prefix) < 1+n {
+		return 0, false
+	}
+	var size uint64
+	for _, b := range prefix[1 : 1+n] {
+		size = size<<8 | uint64(b)
+	}
+	if size > 1<<62 {
+		// Let the decoder report the corrupt length.
+		return 0, true
+	}
+	return int64(size), true
+}
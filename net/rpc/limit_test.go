@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type Sizer struct{}
+
+func (Sizer) Len(args string, reply *int) error {
+	*reply = len(args)
+	return nil
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	srv := NewServerWithOpts(WithMaxRequestBytes(1024))
+	srv.Register(Sizer{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var n int
+	if err := client.Call("Sizer.Len", strings.Repeat("x", 512), &n); err != nil || n != 512 {
+		t.Fatalf("expected a request under the limit to succeed, got %d, %v", n, err)
+	}
+	err = client.Call("Sizer.Len", strings.Repeat("x", 4096), &n)
+	if err == nil || err.Error() != ErrRequestTooLarge.Error() {
+		t.Errorf("expected ErrRequestTooLarge, got %v", err)
+	}
+	// The connection is closed, since the rest of the request was not read.
+	if err := client.Call("Sizer.Len", "x", &n); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestGobLimitReader(t *testing.T) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	enc.Encode(Args{A: 1, B: 2})
+	enc.Encode(strings.Repeat("x", 100))
+
+	limit := &gobLimitReader{r: bufio.NewReader(&buf), max: 90}
+	dec := gob.NewDecoder(limit)
+	var args Args
+	if err := dec.Decode(&args); err != nil || args != (Args{1, 2}) {
+		t.Fatalf("expected %v, got %v, %v", Args{1, 2}, args, err)
+	}
+	limit.reset()
+	var s string
+	if err := dec.Decode(&s); !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("expected ErrRequestTooLarge, got %v", err)
+	}
+
+	// A message claiming to be huge is refused before gob allocates for it.
+	huge := []byte{0xfc, 0x7f, 0xff, 0xff, 0xff}
+	limit = &gobLimitReader{r: bufio.NewReader(bytes.NewReader(huge)), max: 1 << 20}
+	if err := gob.NewDecoder(limit).Decode(&s); !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("expected ErrRequestTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Logger receives the messages logged by a server, such as registration
+// failures, errors writing responses and failed TLS handshakes. Each
+// message is followed by alternating keys and values describing it, such as
+// "serviceMethod" and "sourceAddr". The method set is that of
+// github.com/hashicorp/go-hclog's Logger, so an hclog.Logger can be passed
+// to WithLogger as is.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// WithLogger sets the logger of the server. By default messages are written
+// with package log, debug messages being dropped.
+func WithLogger(l Logger) func(*Server) {
+	return func(s *Server) {
+		s.log = l
+	}
+}
+
+// NewStdLogger returns a Logger writing to l, or with package log if l is
+// nil, as the message followed by its fields in key=value form. Debug
+// messages are written only if debug is set.
+func NewStdLogger(l *log.Logger, debug bool) Logger {
+	return stdLogger{l: l, debug: debug}
+}
+
+// defaultLogger is the logger of servers created without WithLogger.
+var defaultLogger Logger = stdLogger{}
+
+type stdLogger struct {
+	l     *log.Logger // nil for package log
+	debug bool
+}
+
+func (s stdLogger) Debug(msg string, args ...interface{}) {
+	if s.debug || debugLog {
+		s.output("[DEBUG] ", msg, args)
+	}
+}
+
+func (s stdLogger) Info(msg string, args ...interface{})  { s.output("[INFO] ", msg, args) }
+func (s stdLogger) Warn(msg string, args ...interface{})  { s.output("[WARN] ", msg, args) }
+func (s stdLogger) Error(msg string, args ...interface{}) { s.output("[ERROR] ", msg, args) }
+
+func (s stdLogger) output(level, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	if s.l != nil {
+		s.l.Output(3, b.String())
+	} else {
+		log.Output(3, b.String())
+	}
+}
+
+// logger returns the server's logger.
+func (server *Server) logger() Logger {
+	if server.log == nil {
+		return defaultLogger
+	}
+	return server.log
+}
+
+// MetadataRequestID is the request metadata key naming the request in the
+// server's logs. Requests without it are given a random ID.
+const MetadataRequestID = "request-id"
+
+// requestLogger is the logger of a request, passing its fields ahead of
+// those of each message.
+type requestLogger struct {
+	l      Logger
+	id     string
+	fields []interface{}
+}
+
+func (r requestLogger) Debug(msg string, args ...interface{}) { r.l.Debug(msg, r.with(args)...) }
+func (r requestLogger) Info(msg string, args ...interface{})  { r.l.Info(msg, r.with(args)...) }
+func (r requestLogger) Warn(msg string, args ...interface{})  { r.l.Warn(msg, r.with(args)...) }
+func (r requestLogger) Error(msg string, args ...interface{}) { r.l.Error(msg, r.with(args)...) }
+
+func (r requestLogger) with(args []interface{}) []interface{} {
+	return append(r.fields[:len(r.fields):len(r.fields)], args...)
+}
+
+// requestLog is what the logger of a request is built from. Most handlers
+// never log, so the logger, and the request ID if the client sent none, are
+// only built the first time LoggerFromContext or RequestIDFromContext asks.
+type requestLog struct {
+	l             Logger
+	id            string // from the request's metadata, if set
+	serviceMethod string
+	sourceAddr    net.Addr
+
+	once   sync.Once
+	logger requestLogger
+}
+
+// build returns the logger of the request.
+func (r *requestLog) build() requestLogger {
+	r.once.Do(func() {
+		id := r.id
+		if id == "" {
+			id = fmt.Sprintf("%016x", rand.Uint64())
+		}
+		fields := []interface{}{"requestID", id, "serviceMethod", r.serviceMethod}
+		if r.sourceAddr != nil {
+			fields = append(fields, "sourceAddr", r.sourceAddr)
+		}
+		r.logger = requestLogger{l: r.l, id: id, fields: fields}
+	})
+	return r.logger
+}
+
+// setRequestLogger sets the logger of the request ctx belongs to, a request
+// to serviceMethod from sourceAddr. The request's metadata must already be
+// in ctx.
+func (server *Server) setRequestLogger(ctx context.Context, serviceMethod string, sourceAddr net.Addr) {
+	rv := requestValuesFrom(ctx)
+	if rv == nil {
+		return
+	}
+	rv.log.l = server.logger()
+	rv.log.id = MetadataFromContext(ctx)[MetadataRequestID]
+	rv.log.serviceMethod = serviceMethod
+	rv.log.sourceAddr = sourceAddr
+}
+
+// LoggerFromContext returns the server's logger, as set with WithLogger,
+// tagged with the ID, method and source address of the request ctx belongs
+// to, so that handlers log them without passing them around. Outside a
+// handler it returns the default logger, untagged.
+func LoggerFromContext(ctx context.Context) Logger {
+	if rv := requestValuesFrom(ctx); rv != nil && rv.log.l != nil {
+		return rv.log.build()
+	}
+	return defaultLogger
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, as
+// tagged on the logger LoggerFromContext returns, or "" outside a handler.
+func RequestIDFromContext(ctx context.Context) string {
+	if rv := requestValuesFrom(ctx); rv != nil && rv.log.l != nil {
+		return rv.log.build().id
+	}
+	return ""
+}
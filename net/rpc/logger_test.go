@@ -0,0 +1,145 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger records the messages logged at each level.
+type recordingLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (r *recordingLogger) record(level, msg string, args []interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (r *recordingLogger) Debug(msg string, args ...interface{}) { r.record("debug", msg, args) }
+func (r *recordingLogger) Info(msg string, args ...interface{})  { r.record("info", msg, args) }
+func (r *recordingLogger) Warn(msg string, args ...interface{})  { r.record("warn", msg, args) }
+func (r *recordingLogger) Error(msg string, args ...interface{}) { r.record("error", msg, args) }
+
+type BadMethods int
+
+func (BadMethods) Good(args *Args, reply *Reply) error { return nil }
+
+func (BadMethods) NoReply(args *Args) error { return nil }
+
+func TestWithLogger(t *testing.T) {
+	logger := new(recordingLogger)
+	srv := NewServerWithOpts(WithLogger(logger))
+	if err := srv.Register(NeedsPtrType(0)); err == nil {
+		t.Fatal("expected registration to fail")
+	}
+	if err := srv.Register(new(BadMethods)); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(logger.logs, "\n")
+	for _, want := range []string{
+		"error rpc.Register: type NeedsPtrType has no exported methods of suitable type",
+		"warn rpc.Register: method has wrong number of input parameters [method NoReply inputs 2 want 3]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+
+	NewStdLogger(l, false).Debug("dropped")
+	NewStdLogger(l, false).Warn("rpc: something", "serviceMethod", "Arith.Add", "odd")
+	NewStdLogger(l, true).Debug("kept", "seq", 7)
+	want := "[WARN] rpc: something serviceMethod=Arith.Add odd\n[DEBUG] kept seq=7\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+// Unencodable is a reply gob cannot encode.
+type Unencodable struct {
+	C chan int
+}
+
+type Encoding struct{}
+
+func (Encoding) Fail(args int, reply *Unencodable) error { return nil }
+
+func TestCodecErrorsLogged(t *testing.T) {
+	logger := new(recordingLogger)
+	srv := NewServerWithOpts(WithLogger(logger))
+	srv.Register(Encoding{})
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Call("Encoding.Fail", 0, new(Unencodable)); err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	waitFor(t, func() bool {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		return strings.Contains(strings.Join(logger.logs, "\n"), "error rpc: gob error encoding body")
+	})
+}
+
+type Logged struct{}
+
+// Log logs msg and replies with the request's ID.
+func (Logged) Log(ctx context.Context, msg string, reply *string) error {
+	LoggerFromContext(ctx).Info(msg, "extra", 1)
+	*reply = RequestIDFromContext(ctx)
+	return nil
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	logger := new(recordingLogger)
+	srv := NewServerWithOpts(WithLogger(logger))
+	srv.Register(Logged{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var id string
+	ctx := ContextWithMetadata(context.Background(), Metadata{MetadataRequestID: "req-1"})
+	if err := client.CallContext(ctx, "Logged.Log", "handled", &id); err != nil || id != "req-1" {
+		t.Fatalf("expected the client's request ID, got %q, %v", id, err)
+	}
+	want := "info handled [requestID req-1 serviceMethod Logged.Log sourceAddr 127.0.0.1:"
+	if got := strings.Join(logger.logs, "\n"); !strings.Contains(got, want) || !strings.HasSuffix(got, " extra 1]") {
+		t.Errorf("expected %q followed by the message's fields, got:\n%s", want, got)
+	}
+
+	var first, second string
+	client.Call("Logged.Log", "a", &first)
+	client.Call("Logged.Log", "b", &second)
+	if len(first) != 16 || first == second {
+		t.Errorf("expected distinct random request IDs, got %q and %q", first, second)
+	}
+	if got := strings.Join(logger.logs, "\n"); !strings.Contains(got, "info a [requestID "+first+" ") {
+		t.Errorf("expected the logged request ID to be %q, got:\n%s", first, got)
+	}
+
+	if LoggerFromContext(context.Background()) != defaultLogger || RequestIDFromContext(context.Background()) != "" {
+		t.Error("expected the default logger and no request ID outside a handler")
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Metadata is a set of key-value pairs sent with a request, alongside its
+// arguments.
+type Metadata map[string]string
+
+// Standard metadata keys. On requests they name the datacenter, node and
+// network segment the request is meant for; on clients and servers they
+// describe where the client or server runs.
+const (
+	MetadataDatacenter = "datacenter"
+	MetadataNode       = "node"
+	MetadataSegment    = "segment"
+)
+
+// Datacenter returns the value of the MetadataDatacenter key.
+func (md Metadata) Datacenter() string { return md[MetadataDatacenter] }
+
+// Node returns the value of the MetadataNode key.
+func (md Metadata) Node() string { return md[MetadataNode] }
+
+// Segment returns the value of the MetadataSegment key.
+func (md Metadata) Segment() string { return md[MetadataSegment] }
+
+// MetadataWarnings is the response metadata key holding the warnings added
+// by the handler with AddWarning, one per line.
+const MetadataWarnings = "warnings"
+
+// Warnings returns the warnings held by the MetadataWarnings key.
+func (md Metadata) Warnings() []string {
+	if md[MetadataWarnings] == "" {
+		return nil
+	}
+	return strings.Split(md[MetadataWarnings], "\n")
+}
+
+// Merge returns a copy of md with the pairs of other added, replacing those
+// with the same key. It returns nil if both are empty.
+func (md Metadata) Merge(other Metadata) Metadata {
+	if len(md)+len(other) == 0 {
+		return nil
+	}
+	merged := make(Metadata, len(md)+len(other))
+	for k, v := range md {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
+// matches reports whether md has the datacenter and segment that want asks
+// for. Keys want leaves empty match anything.
+func (md Metadata) matches(want Metadata) bool {
+	for _, key := range []string{MetadataDatacenter, MetadataSegment} {
+		if v := want[key]; v != "" && md[key] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type metadataKey struct{}
+
+// ContextWithMetadata returns a copy of ctx carrying md, merged over any
+// metadata ctx already carries. Client.CallContext sends the metadata with
+// the request.
+func ContextWithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, MetadataFromContext(ctx).Merge(md))
+}
+
+// MetadataFromContext returns the metadata carried by ctx. The context a
+// server passes to handlers carries the request's metadata, so calls the
+// handler makes with that context pass it on.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+type responseMetadataSinkKey struct{}
+
+// responseMetadata collects the metadata a handler sets for its response.
+type responseMetadata struct {
+	mu   sync.Mutex // protects following
+	md   Metadata
+	sent bool
+}
+
+func responseMetadataFromContext(ctx context.Context) *responseMetadata {
+	if rv := requestValuesFrom(ctx); rv != nil {
+		return rv.metadata
+	}
+	return nil
+}
+
+// take returns the metadata to send with the response. Later calls to
+// SetResponseMetadata are ignored.
+func (rm *responseMetadata) take() Metadata {
+	if rm == nil {
+		return nil
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.sent = true
+	return rm.md
+}
+
+// SetResponseMetadata merges md into the metadata sent with the response of
+// the request ctx belongs to, for callers using CallWithMetadata to read. It
+// does nothing outside a handler, or once the handler has returned.
+func SetResponseMetadata(ctx context.Context, md Metadata) {
+	rm := responseMetadataFromContext(ctx)
+	if rm == nil {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if !rm.sent {
+		rm.md = rm.md.Merge(md)
+	}
+}
+
+// AddWarning adds warning to the response of the request ctx belongs to, for
+// callers using CallWithMetadata to read with Metadata.Warnings. Warnings
+// report what a caller should know about a call that nonetheless succeeded,
+// such as results that are partial or a parameter that is deprecated, and
+// are also sent with errors. Line breaks in warning are replaced with
+// spaces. It does nothing outside a handler, or once the handler has
+// returned.
+func AddWarning(ctx context.Context, warning string) {
+	rm := responseMetadataFromContext(ctx)
+	if rm == nil || warning == "" {
+		return
+	}
+	warning = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(warning)
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.sent {
+		return
+	}
+	if prev := rm.md[MetadataWarnings]; prev != "" {
+		warning = prev + "\n" + warning
+	}
+	rm.md = rm.md.Merge(Metadata{MetadataWarnings: warning})
+}
+
+// CallWithMetadata is like CallContext, but sends md with the request, merged
+// over the metadata of the client and ctx, and returns the metadata the
+// handler set with SetResponseMetadata.
+func (client *Client) CallWithMetadata(ctx context.Context, serviceMethod string, md Metadata, args interface{}, reply interface{}) (Metadata, error) {
+	sink := new(Metadata)
+	ctx = context.WithValue(ContextWithMetadata(ctx, md), responseMetadataSinkKey{}, sink)
+	err := client.CallContext(ctx, serviceMethod, args, reply)
+	return *sink, err
+}
+
+// WithClientMetadata sets metadata sent with every request made by the
+// client. Metadata carried by the context of CallContext is merged over it.
+func WithClientMetadata(md Metadata) func(*Client) {
+	return func(c *Client) {
+		c.metadata = Metadata(nil).Merge(md)
+	}
+}
+
+// Metadata returns the metadata set with WithClientMetadata.
+func (client *Client) Metadata() Metadata {
+	return Metadata(nil).Merge(client.metadata)
+}
+
+// WithServerMetadata sets metadata describing the server, such as its
+// datacenter.
+func WithServerMetadata(md Metadata) func(*Server) {
+	return func(s *Server) {
+		s.metadata = Metadata(nil).Merge(md)
+	}
+}
+
+// Metadata returns the metadata set with WithServerMetadata.
+func (server *Server) Metadata() Metadata {
+	return Metadata(nil).Merge(server.metadata)
+}
+
+// RouteByDatacenter returns a RequestRouter that serves requests for the
+// local datacenter, or naming no datacenter, locally, and hands requests for
+// other datacenters to the ForwardFunc forward returns for them. If forward
+// returns nil, the request is served locally.
+func RouteByDatacenter(local string, forward func(datacenter string) ForwardFunc) RequestRouter {
+	return func(req *Request, sourceAddr net.Addr) ForwardFunc {
+		dc := req.Metadata.Datacenter()
+		if dc == "" || dc == local {
+			return nil
+		}
+		return forward(dc)
+	}
+}
+
+// WithBackendMetadata describes the server at address, which must be one of
+// the addresses given to DialMulti. Calls whose context carries a datacenter
+// or segment with ContextWithMetadata are balanced across the servers that
+// match it. If no server matches, the call goes to any server, which is
+// expected to forward it.
+func WithBackendMetadata(address string, md Metadata) func(*MultiClient) {
+	return func(m *MultiClient) {
+		if m.backendMetadata == nil {
+			m.backendMetadata = make(map[string]Metadata)
+		}
+		m.backendMetadata[address] = Metadata(nil).Merge(md)
+	}
+}
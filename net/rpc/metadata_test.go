@@ -0,0 +1,216 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type Echo struct{}
+
+// Metadata replies with the metadata the request was sent with.
+func (Echo) Metadata(ctx context.Context, args int, reply *Metadata) error {
+	*reply = MetadataFromContext(ctx)
+	return nil
+}
+
+// Tenant replies with the request's tenant and sets the response's.
+func (Echo) Tenant(ctx context.Context, fail bool, reply *string) error {
+	*reply = MetadataFromContext(ctx)["tenant"]
+	SetResponseMetadata(ctx, Metadata{"served-by": "server-1"})
+	SetResponseMetadata(ctx, Metadata{"tenant": *reply})
+	if fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestCallWithMetadata(t *testing.T) {
+	srv := NewServer()
+	srv.Register(Echo{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var tenant string
+	md, err := client.CallWithMetadata(context.Background(), "Echo.Tenant", Metadata{"tenant": "acme"}, false, &tenant)
+	if err != nil || tenant != "acme" {
+		t.Fatalf("Tenant: got %q, %v", tenant, err)
+	}
+	if want := (Metadata{"served-by": "server-1", "tenant": "acme"}); !reflect.DeepEqual(md, want) {
+		t.Errorf("expected %v, got %v", want, md)
+	}
+
+	// Metadata is returned with errors too.
+	md, err = client.CallWithMetadata(context.Background(), "Echo.Tenant", nil, true, &tenant)
+	if err == nil || md["served-by"] != "server-1" {
+		t.Errorf("expected an error and metadata, got %v, %v", md, err)
+	}
+
+	// Calls without CallWithMetadata ignore it.
+	if err := client.Call("Echo.Tenant", false, &tenant); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Partial returns the first n of three items, warning when it returns fewer.
+func (Echo) Partial(ctx context.Context, n int, reply *[]string) error {
+	*reply = []string{"a", "b", "c"}
+	if n < len(*reply) {
+		*reply = (*reply)[:n]
+		AddWarning(ctx, "partial results:\nlimit reached")
+		AddWarning(ctx, "limit is deprecated")
+	}
+	return nil
+}
+
+func TestWarnings(t *testing.T) {
+	srv := NewServer()
+	srv.Register(Echo{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var items []string
+	md, err := client.CallWithMetadata(context.Background(), "Echo.Partial", nil, 2, &items)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected 2 items, got %v, %v", items, err)
+	}
+	want := []string{"partial results: limit reached", "limit is deprecated"}
+	if got := md.Warnings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected warnings %q, got %q", want, got)
+	}
+
+	md, err = client.CallWithMetadata(context.Background(), "Echo.Partial", nil, 3, &items)
+	if err != nil || md.Warnings() != nil {
+		t.Errorf("expected no warnings, got %q, %v", md.Warnings(), err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	srv := NewServerWithOpts(WithServerMetadata(Metadata{MetadataDatacenter: "dc1", MetadataNode: "server-1"}))
+	srv.Register(Echo{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	if md := srv.Metadata(); md.Datacenter() != "dc1" || md.Node() != "server-1" || md.Segment() != "" {
+		t.Errorf("unexpected server metadata %v", md)
+	}
+	if md := srv.Config().Metadata; md.Datacenter() != "dc1" {
+		t.Errorf("expected the metadata in the server config, got %v", md)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encBuf := bufio.NewWriter(conn)
+	codec := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	client := NewClientWithOpts(codec, WithClientMetadata(Metadata{MetadataNode: "client-1"}))
+	defer client.Close()
+	if md := client.Metadata(); md.Node() != "client-1" {
+		t.Errorf("unexpected client metadata %v", md)
+	}
+
+	var got Metadata
+	if err := client.Call("Echo.Metadata", 0, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Metadata{MetadataNode: "client-1"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	ctx := ContextWithMetadata(context.Background(), Metadata{MetadataDatacenter: "dc2"})
+	ctx = ContextWithMetadata(ctx, Metadata{MetadataSegment: "alpha"})
+	got = nil
+	if err := client.CallContext(ctx, "Echo.Metadata", 0, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{MetadataNode: "client-1", MetadataDatacenter: "dc2", MetadataSegment: "alpha"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRouteByDatacenter(t *testing.T) {
+	remote := func(context.Context, *Request, RawValue) (RawValue, error) { return RawValue{}, nil }
+	var forwardedTo string
+	router := RouteByDatacenter("dc1", func(dc string) ForwardFunc {
+		forwardedTo = dc
+		if dc == "unknown" {
+			return nil
+		}
+		return remote
+	})
+
+	for _, tc := range []struct {
+		dc      string
+		forward bool
+	}{
+		{"", false},
+		{"dc1", false},
+		{"dc2", true},
+		{"unknown", false},
+	} {
+		forwardedTo = ""
+		req := &Request{ServiceMethod: "Echo.Metadata", Metadata: Metadata{MetadataDatacenter: tc.dc}}
+		if forward := router(req, nil); (forward != nil) != tc.forward {
+			t.Errorf("%q: expected forward %v", tc.dc, tc.forward)
+		}
+		if tc.forward && forwardedTo != tc.dc {
+			t.Errorf("%q: forwarded to %q", tc.dc, forwardedTo)
+		}
+	}
+}
+
+func TestMultiClientDatacenter(t *testing.T) {
+	_, _, addrA := startNamedServer(t, "a")
+	_, _, addrB := startNamedServer(t, "b")
+	_, _, addrC := startNamedServer(t, "c")
+
+	client, err := DialMulti("tcp", []string{addrA, addrB, addrC},
+		WithBackendMetadata(addrA, Metadata{MetadataDatacenter: "dc1"}),
+		WithBackendMetadata(addrB, Metadata{MetadataDatacenter: "dc2"}),
+		WithBackendMetadata(addrC, Metadata{MetadataDatacenter: "dc2", MetadataSegment: "alpha"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	names := func(md Metadata) map[string]int {
+		ctx := ContextWithMetadata(context.Background(), md)
+		seen := make(map[string]int)
+		for i := 0; i < 4; i++ {
+			var name string
+			if err := client.CallContext(ctx, "Whoami.Name", 0, &name); err != nil {
+				t.Fatal(err)
+			}
+			seen[name]++
+		}
+		return seen
+	}
+	if seen := names(Metadata{MetadataDatacenter: "dc2"}); seen["b"] != 2 || seen["c"] != 2 {
+		t.Errorf("expected calls for dc2 spread over b and c, got %v", seen)
+	}
+	if seen := names(Metadata{MetadataDatacenter: "dc2", MetadataSegment: "alpha"}); seen["c"] != 4 {
+		t.Errorf("expected calls for segment alpha on c, got %v", seen)
+	}
+	if seen := names(Metadata{MetadataDatacenter: "dc3"}); len(seen) != 3 {
+		t.Errorf("expected calls for an unknown datacenter to go to any server, got %v", seen)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"path"
+)
+
+// CodeNotPermitted is the code of the errors sent for calls refused by the
+// filters set with WithMethodFilter and WithPeerMethodFilter, and by
+// AccessControl.
+const CodeNotPermitted = "not_permitted"
+
+// WithMethodFilter makes the server refuse calls to the methods for which
+// allow returns false. The filter sees the "Service.Method" of each request
+// once its header is read: a refused request's body is discarded without
+// being decoded, and the call fails with an error coded CodeNotPermitted.
+// Filters run before pre-body interceptors and request routers, and apply
+// to InvokeMethod too. A call must pass every filter the server is given.
+func WithMethodFilter(allow func(serviceMethod string) bool) func(*Server) {
+	return WithPeerMethodFilter(func(serviceMethod string, _ net.Addr) bool {
+		return allow(serviceMethod)
+	})
+}
+
+// WithPeerMethodFilter is like WithMethodFilter but also passes allow the
+// address of the peer making the call, so that, for example, internal
+// methods can be kept to loopback peers:
+//
+//	internal := rpc.MatchMethods("Internal.*")
+//	rpc.WithPeerMethodFilter(func(serviceMethod string, peer net.Addr) bool {
+//		tcp, ok := peer.(*net.TCPAddr)
+//		return !internal(serviceMethod) || ok && tcp.IP.IsLoopback()
+//	})
+func WithPeerMethodFilter(allow func(serviceMethod string, peer net.Addr) bool) func(*Server) {
+	return func(s *Server) {
+		s.methodFilters = append(s.methodFilters, allow)
+	}
+}
+
+// MatchMethods returns a function reporting whether a service method
+// matches any of patterns, which use path.Match syntax against
+// "Service.Method", for use in method filters. Malformed patterns match
+// nothing.
+func MatchMethods(patterns ...string) func(serviceMethod string) bool {
+	return func(serviceMethod string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, serviceMethod); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// checkMethodFilters returns the error refusing a call to serviceMethod
+// from peer, if a method filter does.
+func (server *Server) checkMethodFilters(serviceMethod string, peer net.Addr) error {
+	for _, allow := range server.methodFilters {
+		if !allow(serviceMethod, peer) {
+			return errNotPermitted(serviceMethod)
+		}
+	}
+	return nil
+}
+
+func errNotPermitted(serviceMethod string) error {
+	return &CodedError{Code: CodeNotPermitted, Message: "rpc: method not permitted: " + serviceMethod}
+}
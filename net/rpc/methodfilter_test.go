@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestMethodFilter(t *testing.T) {
+	internal := MatchMethods("Internal.*", "Arith.Mul")
+	srv := NewServerWithOpts(WithMethodFilter(func(serviceMethod string) bool {
+		return !internal(serviceMethod)
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	for _, method := range []string{"Arith.Mul", "Internal.Anything"} {
+		if err := client.Call(method, &Args{7, 8}, reply); ErrorCode(err) != CodeNotPermitted {
+			t.Errorf("%s: expected the call not to be permitted, got %v", method, err)
+		}
+	}
+	// The refused bodies were discarded, leaving the connection usable.
+	if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d, %v", reply.C, err)
+	}
+
+	_, err = srv.InvokeMethod(context.Background(), "Arith.Mul", func(any) error { return nil }, nil)
+	if ErrorCode(err) != CodeNotPermitted {
+		t.Errorf("InvokeMethod: expected the call not to be permitted, got %v", err)
+	}
+}
+
+func TestPeerMethodFilter(t *testing.T) {
+	srv := NewServerWithOpts(WithPeerMethodFilter(func(serviceMethod string, peer net.Addr) bool {
+		tcp, ok := peer.(*net.TCPAddr)
+		return serviceMethod != "Arith.Add" || !ok || !tcp.IP.IsLoopback()
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Call("Arith.Add", Args{7, 8}, new(Reply)); ErrorCode(err) != CodeNotPermitted {
+		t.Errorf("expected calls from loopback not to be permitted, got %v", err)
+	}
+	if err := client.Call("Arith.Mul", &Args{7, 8}, new(Reply)); err != nil {
+		t.Error(err)
+	}
+}
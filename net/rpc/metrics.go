@@ -0,0 +1,185 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultMetricsPath is the path HandleMetrics is conventionally given.
+const DefaultMetricsPath = "/debug/rpc/metrics"
+
+// ReadCounter is implemented by ServerCodecs that count the bytes they have
+// read from their connection. Together with WriteCounter, it lets the
+// metrics served by HandleMetrics report the traffic of each codec. The gob
+// codec implements both.
+type ReadCounter interface {
+	BytesRead() uint64
+}
+
+// NamedCodec is implemented by ServerCodecs that name themselves in
+// metrics. Other codecs are named after their type.
+type NamedCodec interface {
+	CodecName() string
+}
+
+// HandleMetrics registers on mux, at path, a handler serving the server's
+// metrics in the Prometheus text format: the calls, errors and latency of
+// each method reported by Stats, the requests in flight, the connections
+// open and accepted, and the bytes read and written by each codec.
+func (server *Server) HandleMetrics(mux *http.ServeMux, path string) {
+	mux.Handle(path, PrometheusHandler(server))
+}
+
+// codecBytes counts the traffic of the codecs of one name.
+type codecBytes struct {
+	read, written uint64
+}
+
+func codecName(codec ServerCodec) string {
+	if named, ok := codec.(NamedCodec); ok {
+		return named.CodecName()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", codec), "*")
+}
+
+// retireCodec adds the traffic of codec, which is no longer served, to the
+// server's totals. server.mu must be held.
+func (server *Server) retireCodec(codec ServerCodec) {
+	base, lent := server.lent[codec]
+	if lent {
+		delete(server.lent, codec)
+	}
+	r, readCounted := codec.(ReadCounter)
+	w, writeCounted := codec.(WriteCounter)
+	if !readCounted && !writeCounted {
+		return
+	}
+	if server.retiredBytes == nil {
+		server.retiredBytes = make(map[string]*codecBytes)
+	}
+	name := codecName(codec)
+	total := server.retiredBytes[name]
+	if total == nil {
+		total = new(codecBytes)
+		server.retiredBytes[name] = total
+	}
+	if readCounted {
+		total.read += r.BytesRead() - base.read
+	}
+	if writeCounted {
+		total.written += w.BytesWritten() - base.written
+	}
+}
+
+// trafficOf returns the traffic codec has counted so far.
+func trafficOf(codec ServerCodec) codecBytes {
+	var traffic codecBytes
+	if r, ok := codec.(ReadCounter); ok {
+		traffic.read = r.BytesRead()
+	}
+	if w, ok := codec.(WriteCounter); ok {
+		traffic.written = w.BytesWritten()
+	}
+	return traffic
+}
+
+// writePrometheus writes the server's metrics in the Prometheus text format.
+func (server *Server) writePrometheus(w io.Writer) error {
+	server.mu.Lock()
+	inFlight, conns, accepted := server.inFlight, len(server.codecs), server.connsAccepted
+	bytes := make(map[string]codecBytes, len(server.retiredBytes))
+	for name, total := range server.retiredBytes {
+		bytes[name] = *total
+	}
+	for codec := range server.codecs {
+		r, readCounted := codec.(ReadCounter)
+		wc, writeCounted := codec.(WriteCounter)
+		if !readCounted && !writeCounted {
+			continue
+		}
+		name := codecName(codec)
+		total := bytes[name]
+		base := server.lent[codec]
+		if readCounted {
+			total.read += r.BytesRead() - base.read
+		}
+		if writeCounted {
+			total.written += wc.BytesWritten() - base.written
+		}
+		bytes[name] = total
+	}
+	server.mu.Unlock()
+
+	if err := server.Stats().WritePrometheus(w); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(bytes))
+	for name := range bytes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP rpc_server_requests_in_flight Requests being served.\n# TYPE rpc_server_requests_in_flight gauge\nrpc_server_requests_in_flight %d\n", inFlight)
+	fmt.Fprintf(&b, "# HELP rpc_server_connections Connections being served.\n# TYPE rpc_server_connections gauge\nrpc_server_connections %d\n", conns)
+	fmt.Fprintf(&b, "# HELP rpc_server_connections_total Connections served.\n# TYPE rpc_server_connections_total counter\nrpc_server_connections_total %d\n", accepted)
+	b.WriteString("# HELP rpc_server_read_bytes_total Bytes read by codecs.\n# TYPE rpc_server_read_bytes_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "rpc_server_read_bytes_total{codec=%q} %d\n", name, bytes[name].read)
+	}
+	b.WriteString("# HELP rpc_server_written_bytes_total Bytes written by codecs.\n# TYPE rpc_server_written_bytes_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "rpc_server_written_bytes_total{codec=%q} %d\n", name, bytes[name].written)
+	}
+	server.connSetup.writePrometheus(&b)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// byteCountingConn counts the bytes read from and written to the connection
+// of a gobServerCodec.
+type byteCountingConn struct {
+	net.Conn
+	read, written atomic.Uint64
+}
+
+func (c *byteCountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(uint64(n))
+	return n, err
+}
+
+func (c *byteCountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(uint64(n))
+	return n, err
+}
+
+// CodecName implements NamedCodec.
+func (c *gobServerCodec) CodecName() string {
+	return "gob"
+}
+
+// BytesRead implements ReadCounter.
+func (c *gobServerCodec) BytesRead() uint64 {
+	if c.counted == nil {
+		return 0
+	}
+	return c.counted.read.Load()
+}
+
+// BytesWritten implements WriteCounter.
+func (c *gobServerCodec) BytesWritten() uint64 {
+	if c.counted == nil {
+		return 0
+	}
+	return c.counted.written.Load()
+}
@@ -0,0 +1,94 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func scrapeMetrics(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// metricValue returns the value of the sample named name in body.
+func metricValue(t *testing.T, body, name string) int64 {
+	t.Helper()
+	m := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(name) + ` (\d+)$`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("no %s in:\n%s", name, body)
+	}
+	n, _ := strconv.ParseInt(m[1], 10, 64)
+	return n
+}
+
+func TestHandleMetrics(t *testing.T) {
+	srv := NewServer()
+	srv.Register(new(Arith))
+	mux := http.NewServeMux()
+	srv.HandleMetrics(mux, DefaultMetricsPath)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+	url := hs.URL + DefaultMetricsPath
+
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	for i := 0; i < 3; i++ {
+		if err := client.Call("Arith.Add", Args{1, 2}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Call("Arith.Div", Args{1, 0}, &reply); err == nil {
+		t.Fatal("expected error")
+	}
+
+	body := scrapeMetrics(t, url)
+	for name, want := range map[string]int64{
+		`rpc_server_calls_total{method="Arith.Add"}`:  3,
+		`rpc_server_errors_total{method="Arith.Div"}`: 1,
+		`rpc_server_requests_in_flight`:               0,
+		`rpc_server_connections`:                      1,
+		`rpc_server_connections_total`:                1,
+	} {
+		if got := metricValue(t, body, name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+	read := metricValue(t, body, `rpc_server_read_bytes_total{codec="gob"}`)
+	written := metricValue(t, body, `rpc_server_written_bytes_total{codec="gob"}`)
+	if read == 0 || written == 0 {
+		t.Errorf("read %d and wrote %d bytes", read, written)
+	}
+
+	// The traffic of closed connections is kept.
+	client.Close()
+	waitFor(t, func() bool {
+		return strings.Contains(scrapeMetrics(t, url), "rpc_server_connections 0\n")
+	})
+	body = scrapeMetrics(t, url)
+	if got := metricValue(t, body, `rpc_server_read_bytes_total{codec="gob"}`); got != read {
+		t.Errorf("read %d bytes after close, want %d", got, read)
+	}
+	if got := metricValue(t, body, `rpc_server_connections_total`); got != 1 {
+		t.Errorf("%d connections served", got)
+	}
+}
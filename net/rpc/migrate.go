@@ -0,0 +1,143 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+)
+
+// CodecMigration configures a server to check, while it keeps serving with
+// its current codec, that a codec it may migrate to would carry its traffic
+// unchanged. For a sampled fraction of requests, the decoded args and the
+// reply are round-tripped through Target and compared with the same values
+// round-tripped through Legacy; any difference is reported as a
+// CodecDivergence.
+type CodecMigration struct {
+	// Legacy is the encoding the server uses today. If nil, Target's round
+	// trip is compared with the values themselves, which also reports
+	// differences the legacy codec has, such as dropped unexported fields.
+	Legacy RawEncoding
+	// Target is the encoding being migrated to.
+	Target RawEncoding
+	// SampleRate is the fraction of requests checked, from 0 to 1. Checks
+	// run in the request's goroutine, adding to its latency.
+	SampleRate float64
+	// OnDivergence is called for each divergence found. It may be called
+	// from any goroutine.
+	OnDivergence func(*CodecDivergence)
+}
+
+// CodecDivergence describes a value that Target did not carry unchanged.
+type CodecDivergence struct {
+	ServiceMethod string
+	Reply         bool // the value is the reply rather than the args
+	// Legacy and Target are the value after each round trip. Legacy is the
+	// original value if CodecMigration.Legacy is nil.
+	Legacy, Target interface{}
+	// Err is set if a round trip failed, in which case Legacy or Target is
+	// nil.
+	Err error
+}
+
+func (d *CodecDivergence) Error() string {
+	what := "args"
+	if d.Reply {
+		what = "reply"
+	}
+	if d.Err != nil {
+		return fmt.Sprintf("rpc: %s %s: %v", d.ServiceMethod, what, d.Err)
+	}
+	return fmt.Sprintf("rpc: %s %s: %#v became %#v", d.ServiceMethod, what, d.Legacy, d.Target)
+}
+
+// CodecMigrationStats counts the requests checked by a CodecMigration.
+type CodecMigrationStats struct {
+	Sampled  uint64 // requests whose args were checked
+	Diverged uint64 // args or replies reported as divergent
+}
+
+// WithCodecMigration makes the server check its traffic against
+// m.Target. See CodecMigration.
+func WithCodecMigration(m CodecMigration) func(*Server) {
+	return func(s *Server) {
+		s.migration = &codecMigration{CodecMigration: m}
+	}
+}
+
+// CodecMigrationStats returns the counts of the server's CodecMigration.
+func (server *Server) CodecMigrationStats() CodecMigrationStats {
+	if server.migration == nil {
+		return CodecMigrationStats{}
+	}
+	return CodecMigrationStats{
+		Sampled:  server.migration.sampled.Load(),
+		Diverged: server.migration.diverged.Load(),
+	}
+}
+
+type codecMigration struct {
+	CodecMigration
+	sampled  atomic.Uint64
+	diverged atomic.Uint64
+}
+
+// sample reports whether the current request should be checked.
+func (m *codecMigration) sample() bool {
+	if m.SampleRate <= 0 || (m.SampleRate < 1 && rand.Float64() >= m.SampleRate) {
+		return false
+	}
+	m.sampled.Add(1)
+	return true
+}
+
+// check compares v's round trips through the legacy and target encodings.
+func (m *codecMigration) check(serviceMethod string, reply bool, v reflect.Value) {
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	d := &CodecDivergence{ServiceMethod: serviceMethod, Reply: reply}
+	legacy := v
+	if m.Legacy != nil {
+		var err error
+		if legacy, err = roundTrip(m.Legacy, v); err != nil {
+			d.Err = fmt.Errorf("legacy encoding: %w", err)
+			m.report(d)
+			return
+		}
+	}
+	d.Legacy = legacy.Interface()
+	target, err := roundTrip(m.Target, v)
+	if err != nil {
+		d.Err = fmt.Errorf("target encoding: %w", err)
+		m.report(d)
+		return
+	}
+	d.Target = target.Interface()
+	if !reflect.DeepEqual(d.Legacy, d.Target) {
+		m.report(d)
+	}
+}
+
+func (m *codecMigration) report(d *CodecDivergence) {
+	m.diverged.Add(1)
+	if m.OnDivergence != nil {
+		m.OnDivergence(d)
+	}
+}
+
+// roundTrip encodes v with enc and decodes the result into a new value.
+func roundTrip(enc RawEncoding, v reflect.Value) (reflect.Value, error) {
+	data, err := enc.Marshal(v.Interface())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	out := reflect.New(v.Type())
+	if err := enc.Unmarshal(data, out.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return out.Elem(), nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonEncoding) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type MigrateArgs struct {
+	Name   string
+	Secret string `json:"-"`
+}
+
+type Migrate struct{}
+
+func (Migrate) Echo(args MigrateArgs, reply *MigrateArgs) error {
+	*reply = args
+	return nil
+}
+
+func TestCodecMigration(t *testing.T) {
+	var mu sync.Mutex
+	var divergences []*CodecDivergence
+	srv := NewServerWithOpts(WithCodecMigration(CodecMigration{
+		Legacy:     GobEncoding,
+		Target:     jsonEncoding{},
+		SampleRate: 1,
+		OnDivergence: func(d *CodecDivergence) {
+			mu.Lock()
+			defer mu.Unlock()
+			divergences = append(divergences, d)
+		},
+	}))
+	if err := srv.RegisterAll(Migrate{}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Migrate.Echo", MigrateArgs{Name: "a"}, new(MigrateArgs)); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(divergences) != 0 {
+		t.Fatalf("unexpected divergences %v", divergences)
+	}
+	mu.Unlock()
+
+	reply := new(MigrateArgs)
+	if err := client.Call("Migrate.Echo", MigrateArgs{Name: "a", Secret: "s"}, reply); err != nil || reply.Secret != "s" {
+		t.Fatalf("expected the legacy codec to keep serving, got %+v, %v", reply, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(divergences) != 2 || divergences[0].Reply || !divergences[1].Reply {
+		t.Fatalf("expected the args and reply to diverge, got %v", divergences)
+	}
+	if got := divergences[0].Target.(MigrateArgs); got.Secret != "" || got.Name != "a" {
+		t.Errorf("unexpected target value %+v", got)
+	}
+	if stats := srv.CodecMigrationStats(); stats != (CodecMigrationStats{Sampled: 3, Diverged: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Balancer selects how a MultiClient spreads calls across its servers.
+type Balancer int
+
+const (
+	// RoundRobin sends each call to the next server in turn.
+	RoundRobin Balancer = iota
+	// LeastPending sends each call to the server with the fewest calls in
+	// flight, taking servers in turn when they tie.
+	LeastPending
+)
+
+// MultiClient spreads calls across several servers that offer the same
+// services, and fails over to another server when the one it picked cannot
+// be reached. Each server's connections are kept in a ClientPool, so a server
+// that goes down is re-dialed with backoff while calls go elsewhere.
+type MultiClient struct {
+	backends []*backend
+	balancer Balancer
+	poolOpts []func(*ClientPool)
+	dial     func(network, address string) (*Client, error)
+	next     atomic.Uint64
+
+	backendMetadata map[string]Metadata // set with WithBackendMetadata
+}
+
+type backend struct {
+	address   string
+	metadata  Metadata
+	pool      *ClientPool
+	pending   atomic.Int64
+	failovers atomic.Uint64
+}
+
+// BackendStats reports the state of one of a MultiClient's servers.
+type BackendStats struct {
+	Address   string
+	Pending   int       // calls currently in flight
+	Failovers uint64    // calls that failed over to another server
+	Pool      PoolStats // the server's connections
+}
+
+// WithBalancer sets how calls are spread across servers. It defaults to
+// RoundRobin.
+func WithBalancer(b Balancer) func(*MultiClient) {
+	return func(m *MultiClient) {
+		m.balancer = b
+	}
+}
+
+// WithBackendPoolOptions sets the options of the ClientPool kept for each
+// server.
+func WithBackendPoolOptions(options ...func(*ClientPool)) func(*MultiClient) {
+	return func(m *MultiClient) {
+		m.poolOpts = append(m.poolOpts, options...)
+	}
+}
+
+// WithMultiDialer sets the function used to connect to each server. It
+// defaults to Dial.
+func WithMultiDialer(dial func(network, address string) (*Client, error)) func(*MultiClient) {
+	return func(m *MultiClient) {
+		m.dial = dial
+	}
+}
+
+// DialMulti returns a MultiClient for the RPC servers at the specified
+// network addresses. Connections are dialed when calls need them.
+func DialMulti(network string, addrs []string, options ...func(*MultiClient)) (*MultiClient, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("rpc: DialMulti needs at least one address")
+	}
+	m := &MultiClient{dial: Dial}
+	for _, option := range options {
+		option(m)
+	}
+	for _, addr := range addrs {
+		addr := addr
+		m.backends = append(m.backends, &backend{
+			address:  addr,
+			metadata: m.backendMetadata[addr],
+			pool: NewClientPool(func() (*Client, error) {
+				return m.dial(network, addr)
+			}, m.poolOpts...),
+		})
+	}
+	return m, nil
+}
+
+// Call invokes the named function on one of the servers, waits for it to
+// complete, and returns its error status.
+func (m *MultiClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return m.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext is like Call but behaves like Client.CallContext. If the call
+// fails because the server could not be reached or the connection broke, it
+// is made again on the next server, until every server has been tried. A call
+// whose connection broke may already have run on the server it was sent to.
+func (m *MultiClient) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	var err error
+	for _, b := range m.order(MetadataFromContext(ctx)) {
+		b.pending.Add(1)
+		err = b.pool.CallContext(ctx, serviceMethod, args, reply)
+		b.pending.Add(-1)
+		if err == nil || !isConnError(err) || ctx.Err() != nil {
+			return err
+		}
+		b.failovers.Add(1)
+	}
+	return err
+}
+
+// Stats returns the state of each server, in the order they were given to
+// DialMulti.
+func (m *MultiClient) Stats() []BackendStats {
+	stats := make([]BackendStats, len(m.backends))
+	for i, b := range m.backends {
+		stats[i] = BackendStats{
+			Address:   b.address,
+			Pending:   int(b.pending.Load()),
+			Failovers: b.failovers.Load(),
+			Pool:      b.pool.Stats(),
+		}
+	}
+	return stats
+}
+
+// Close closes the connections to every server. Calls made after Close
+// return ErrShutdown.
+func (m *MultiClient) Close() error {
+	var err error
+	for _, b := range m.backends {
+		if cerr := b.pool.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// order returns the servers in the order a call with metadata md should try
+// them: the one the balancer picked, followed by the others in turn. Only the
+// servers matching md's datacenter and segment are returned, unless none do.
+func (m *MultiClient) order(md Metadata) []*backend {
+	backends := m.backends
+	if len(md) > 0 {
+		var matching []*backend
+		for _, b := range m.backends {
+			if b.metadata.matches(md) {
+				matching = append(matching, b)
+			}
+		}
+		if len(matching) > 0 {
+			backends = matching
+		}
+	}
+	n := len(backends)
+	start := int(m.next.Add(1)-1) % n
+	if m.balancer == LeastPending {
+		best := start
+		for i := 1; i < n; i++ {
+			j := (start + i) % n
+			if backends[j].pending.Load() < backends[best].pending.Load() {
+				best = j
+			}
+		}
+		start = best
+	}
+	order := make([]*backend, n)
+	for i := range order {
+		order[i] = backends[(start+i)%n]
+	}
+	return order
+}
+
+// isConnError reports whether err means the call never reached a server or
+// its connection broke, rather than that the server returned an error.
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrShutdown) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type Whoami struct {
+	name string
+}
+
+func (w *Whoami) Name(args int, reply *string) error {
+	*reply = w.name
+	return nil
+}
+
+func startNamedServer(t *testing.T, name string) (*Server, net.Listener, string) {
+	srv := NewServer()
+	if err := srv.RegisterAll(&Whoami{name: name}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	return srv, l, addr
+}
+
+func TestMultiClientRoundRobin(t *testing.T) {
+	_, _, addrA := startNamedServer(t, "a")
+	srvB, lB, addrB := startNamedServer(t, "b")
+	_, _, addrC := startNamedServer(t, "c")
+
+	client, err := DialMulti("tcp", []string{addrA, addrB, addrC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	names := func(n int) map[string]int {
+		seen := make(map[string]int)
+		for i := 0; i < n; i++ {
+			var name string
+			if err := client.Call("Whoami.Name", 0, &name); err != nil {
+				t.Fatal(err)
+			}
+			seen[name]++
+		}
+		return seen
+	}
+	if seen := names(6); seen["a"] != 2 || seen["b"] != 2 || seen["c"] != 2 {
+		t.Errorf("expected calls spread evenly, got %v", seen)
+	}
+
+	// Take b down; its calls fail over to the other servers.
+	lB.Close()
+	for _, addr := range srvB.ActiveConns() {
+		srvB.CloseConn(addr)
+	}
+	if seen := names(6); seen["b"] != 0 || seen["a"]+seen["c"] != 6 {
+		t.Errorf("expected calls to fail over from b, got %v", seen)
+	}
+	if stats := client.Stats(); stats[1].Address != addrB || stats[1].Failovers == 0 {
+		t.Errorf("expected failovers counted for b, got %+v", stats[1])
+	}
+
+	// Server errors are returned without failing over.
+	if err := client.Call("Arith.Error", new(Args), new(Reply)); err == nil || isConnError(err) {
+		t.Errorf("expected the server's error, got %v", err)
+	}
+}
+
+func TestMultiClientLeastPending(t *testing.T) {
+	_, _, addrA := startNamedServer(t, "a")
+	_, _, addrB := startNamedServer(t, "b")
+
+	client, err := DialMulti("tcp", []string{addrA, addrB},
+		WithBalancer(LeastPending),
+		WithBackendPoolOptions(WithPoolMaxConns(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Keep one server busy with a slow call.
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call("Arith.SleepMilli", &Args{A: 200}, new(Reply))
+	}()
+	var busy string
+	for deadline := time.Now().Add(time.Second); busy == ""; {
+		for _, s := range client.Stats() {
+			if s.Pending > 0 {
+				busy = s.Address
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow call never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	idle := "a"
+	if busy == addrA {
+		idle = "b"
+	}
+	for i := 0; i < 4; i++ {
+		var name string
+		if err := client.Call("Whoami.Name", 0, &name); err != nil {
+			t.Fatal(err)
+		}
+		if name != idle {
+			t.Errorf("call %d: expected the idle server %s, got %s", i, idle, name)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialMultiNoAddrs(t *testing.T) {
+	if _, err := DialMulti("tcp", nil); err == nil {
+		t.Error("expected an error without addresses")
+	}
+}
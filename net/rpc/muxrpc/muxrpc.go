@@ -0,0 +1,303 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package muxrpc serves and calls rpc servers over multiplexed connections.
+// Each connection carries any number of streams, and each stream an rpc
+// connection of its own: a logical client, or a group of calls that should
+// not wait behind the others. Streams have their own codec, negotiated as
+// with rpc.NegotiateCodec, and their own flow control, so a client that
+// stops reading the replies of one stream holds up only that stream.
+//
+// Either end may open streams, so a server can call back a client that
+// registered services of its own, without dialing it: see Conn.
+//
+// Connections are multiplexed with github.com/hashicorp/yamux, with its
+// default configuration, so either end may use yamux instead of this
+// package.
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+	"github.com/hashicorp/yamux"
+)
+
+// ErrSessionClosed is returned by Open once the connection is closed.
+var ErrSessionClosed = yamux.ErrSessionShutdown
+
+// Server serves an rpc.Server on the streams of multiplexed connections.
+type Server struct {
+	rpc    *rpc.Server
+	codecs *rpc.CodecRegistry
+
+	onConnect func(*Conn)
+
+	mu    sync.Mutex // protects conns
+	conns map[*yamux.Session]*Conn
+}
+
+// NewServer returns a Server serving srv with the given options.
+func NewServer(srv *rpc.Server, options ...func(*Server)) *Server {
+	s := &Server{rpc: srv, conns: make(map[*yamux.Session]*Conn)}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// WithCodecRegistry makes the server negotiate the codec of each stream
+// with registry. Clients must then be created with WithCodec. By default
+// every stream uses the gob codec without negotiation.
+func WithCodecRegistry(registry *rpc.CodecRegistry) func(*Server) {
+	return func(s *Server) {
+		s.codecs = registry
+	}
+}
+
+// WithConnectHook makes the server call fn with each connection it starts
+// serving, for example to keep the connections whose clients should be
+// notified of events.
+func WithConnectHook(fn func(*Conn)) func(*Server) {
+	return func(s *Server) {
+		s.onConnect = fn
+	}
+}
+
+// Serve accepts connections from l and serves each in a new goroutine, as
+// ServeConn does, until l fails. It returns the error of l.Accept.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves the streams of conn, which must be dialed by DialMux,
+// NewClient or another yamux client, until the session ends. Requests on
+// each stream are served concurrently, as with rpc.Server.ServeRequestAsync.
+func (s *Server) ServeConn(conn net.Conn) error {
+	session, err := yamux.Server(conn, nil)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c := &Conn{session: session}
+	s.mu.Lock()
+	s.conns[session] = c
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, session)
+		s.mu.Unlock()
+		session.Close()
+	}()
+	if s.onConnect != nil {
+		s.onConnect(c)
+	}
+	ctx := context.WithValue(context.Background(), connKey{}, c)
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			if errors.Is(err, ErrSessionClosed) || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		go s.serveStream(ctx, stream)
+	}
+}
+
+// Conns returns the connections being served.
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Close closes the sessions being served. It does not close the listeners
+// passed to Serve.
+func (s *Server) Close() error {
+	for _, c := range s.Conns() {
+		c.session.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveStream(ctx context.Context, stream net.Conn) {
+	var codec rpc.ServerCodec
+	if s.codecs != nil {
+		var err error
+		if codec, err = s.codecs.NewServerCodec(stream); err != nil {
+			stream.Close()
+			return
+		}
+	} else {
+		codec = rpc.NewGobServerCodec(stream)
+	}
+	defer codec.Close()
+	for s.rpc.ServeRequestAsync(ctx, codec) == nil {
+	}
+}
+
+// Conn is a connection a Server serves. Through it, the server calls back
+// the client, if the client was created with WithServer.
+type Conn struct {
+	session *yamux.Session
+
+	mu     sync.Mutex // protects client
+	client *rpc.Client
+}
+
+type connKey struct{}
+
+// ConnFromContext returns the connection the request ctx belongs to, in the
+// handlers of a Server.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+// Client returns an rpc client calling the services the client at the other
+// end registered with WithServer, over a stream of the connection, using the
+// gob codec. The stream is opened by the first call to Client and shared by
+// the later ones. If the client serves no services, calls fail once the
+// client closes the stream.
+func (c *Conn) Client() (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		stream, err := c.session.Open()
+		if err != nil {
+			return nil, err
+		}
+		c.client = rpc.NewClient(stream)
+	}
+	return c.client, nil
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
+
+// Done returns a channel closed once the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.session.CloseChan()
+}
+
+// Client opens rpc clients on the streams of a multiplexed connection.
+type Client struct {
+	session   *yamux.Session
+	codec     string
+	newClient func(conn net.Conn) *rpc.Client
+	server    *rpc.Server
+}
+
+// WithCodec makes the client negotiate the codec registered under name on
+// each stream, and create the stream's rpc client with newClient, for
+// example msgpackrpc.NewClient. The server must be created with
+// WithCodecRegistry.
+func WithCodec(name string, newClient func(conn net.Conn) *rpc.Client) func(*Client) {
+	return func(c *Client) {
+		c.codec = name
+		c.newClient = newClient
+	}
+}
+
+// WithServer makes the client serve srv on the streams the server opens
+// with Conn.Client, using the gob codec, so that the server can call the
+// client back. Streams opened by the server of a client without one are
+// closed.
+func WithServer(srv *rpc.Server) func(*Client) {
+	return func(c *Client) {
+		c.server = srv
+	}
+}
+
+// DialMux connects to the muxrpc server at address.
+func DialMux(ctx context.Context, network, address string, options ...func(*Client)) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, options...), nil
+}
+
+// NewClient returns a Client using conn, a connection to a muxrpc server.
+func NewClient(conn net.Conn, options ...func(*Client)) *Client {
+	// yamux only fails to start sessions with invalid configurations.
+	session, _ := yamux.Client(conn, nil)
+	c := &Client{
+		session: session,
+		newClient: func(conn net.Conn) *rpc.Client {
+			return rpc.NewClient(conn)
+		},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	go c.acceptStreams()
+	return c
+}
+
+// acceptStreams serves the streams opened by the server until the session
+// ends.
+func (c *Client) acceptStreams() {
+	for {
+		stream, err := c.session.Accept()
+		if err != nil {
+			return
+		}
+		if c.server == nil {
+			stream.Close()
+			continue
+		}
+		go func() {
+			codec := rpc.NewGobServerCodec(stream)
+			defer codec.Close()
+			for c.server.ServeRequestAsync(context.Background(), codec) == nil {
+			}
+		}()
+	}
+}
+
+// Open opens a new stream and returns an rpc client using it. Closing the
+// rpc client closes the stream, leaving the connection's other streams
+// open.
+func (c *Client) Open() (*rpc.Client, error) {
+	stream, err := c.session.Open()
+	if err != nil {
+		return nil, err
+	}
+	if c.codec != "" {
+		if err := rpc.NegotiateCodec(stream, c.codec); err != nil {
+			stream.Close()
+			return nil, err
+		}
+	}
+	return c.newClient(stream), nil
+}
+
+// Session returns the yamux session the client opens its streams on.
+func (c *Client) Session() *yamux.Session {
+	return c.session
+}
+
+// Close closes the connection and every stream.
+func (c *Client) Close() error {
+	return c.session.Close()
+}
@@ -0,0 +1,274 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	msgpackrpc "github.com/hashicorp/consul-net-rpc/net-rpc-msgpackrpc"
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+	"github.com/hashicorp/yamux"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith struct{}
+
+func (Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func startServer(t *testing.T, options ...func(*Server)) string {
+	srv := rpc.NewServer()
+	if err := srv.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(srv, options...)
+	t.Cleanup(func() {
+		l.Close()
+		s.Close()
+	})
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func TestMux(t *testing.T) {
+	c, err := DialMux(context.Background(), "tcp", startServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	first, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	for i, client := range []*rpc.Client{first, second} {
+		if err := client.Call("Arith.Add", &Args{i, 10}, &sum); err != nil || sum != i+10 {
+			t.Fatalf("expected %d, got %d, %v", i+10, sum, err)
+		}
+	}
+
+	// Closing a client leaves the connection's other streams open.
+	first.Close()
+	if err := second.Call("Arith.Add", &Args{1, 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("expected 3, got %d, %v", sum, err)
+	}
+	if n := c.Session().NumStreams(); n != 1 {
+		t.Errorf("expected 1 open stream, got %d", n)
+	}
+
+	c.Close()
+	if _, err := c.Open(); err != ErrSessionClosed {
+		t.Errorf("expected ErrSessionClosed, got %v", err)
+	}
+	if err := second.Call("Arith.Add", &Args{1, 2}, &sum); err == nil {
+		t.Error("expected calls to fail once the session is closed")
+	}
+}
+
+func TestMuxCodec(t *testing.T) {
+	registry := rpc.NewCodecRegistry()
+	registry.Register("msgpack", msgpackrpc.NewServerCodec)
+	addr := startServer(t, WithCodecRegistry(registry))
+
+	c, err := DialMux(context.Background(), "tcp", addr, WithCodec("msgpack", msgpackrpc.NewClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	if err := client.Call("Arith.Add", &Args{2, 3}, &sum); err != nil || sum != 5 {
+		t.Fatalf("expected 5, got %d, %v", sum, err)
+	}
+
+	c, err = DialMux(context.Background(), "tcp", addr, WithCodec("json", msgpackrpc.NewClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Open(); err == nil {
+		t.Error("expected an unregistered codec to be refused")
+	}
+}
+
+// Inbox collects the notifications a server sends its client.
+type Inbox struct {
+	notes chan string
+}
+
+func (i *Inbox) Notify(note string, reply *struct{}) error {
+	i.notes <- note
+	return nil
+}
+
+type Subscriptions struct{}
+
+// Subscribe calls the client back before replying.
+func (Subscriptions) Subscribe(ctx context.Context, topic string, reply *bool) error {
+	conn, ok := ConnFromContext(ctx)
+	if !ok {
+		return errors.New("no connection")
+	}
+	client, err := conn.Client()
+	if err != nil {
+		return err
+	}
+	*reply = true
+	return client.Call("Inbox.Notify", "subscribed to "+topic, new(struct{}))
+}
+
+func TestReverseCalls(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.Register(Subscriptions{}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conns := make(chan *Conn, 1)
+	s := NewServer(srv, WithConnectHook(func(c *Conn) { conns <- c }))
+	defer s.Close()
+	go s.Serve(l)
+
+	inbox := &Inbox{notes: make(chan string, 1)}
+	clientSrv := rpc.NewServer()
+	if err := clientSrv.Register(inbox); err != nil {
+		t.Fatal(err)
+	}
+	c, err := DialMux(context.Background(), "tcp", l.Addr().String(), WithServer(clientSrv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handler calls the client back while serving its call.
+	var ok bool
+	if err := client.Call("Subscriptions.Subscribe", "nodes", &ok); err != nil || !ok {
+		t.Fatalf("expected the subscription to succeed, got %v, %v", ok, err)
+	}
+	if note := <-inbox.notes; note != "subscribed to nodes" {
+		t.Errorf("unexpected notification %q", note)
+	}
+
+	// The server pushes to connected clients outside handlers.
+	conn := <-conns
+	if got := s.Conns(); len(got) != 1 || got[0] != conn {
+		t.Fatalf("expected the connection to be served, got %v", got)
+	}
+	reverse, err := conn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reverse.Call("Inbox.Notify", "pushed", new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if note := <-inbox.notes; note != "pushed" {
+		t.Errorf("unexpected notification %q", note)
+	}
+
+	// Clients serving nothing refuse calls back.
+	plain, err := DialMux(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, err := plain.Open(); err != nil {
+		t.Fatal(err)
+	}
+	reverse, err = (<-conns).Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reverse.Call("Inbox.Notify", "dropped", new(struct{})); err == nil {
+		t.Error("expected the call back to fail")
+	}
+}
+
+func TestSessionFlowControl(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, err := yamux.Client(c1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := yamux.Server(c2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+	window := int(yamux.DefaultConfig().MaxStreamWindowSize)
+
+	open := func() (net.Conn, net.Conn) {
+		out, err := client.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := server.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out, in
+	}
+	slowOut, slowIn := open()
+
+	// The writer may send a window's worth before the reader reads.
+	data := bytes.Repeat([]byte("x"), window)
+	if _, err := slowOut.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	slowOut.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := slowOut.Write([]byte("y")); err == nil {
+		t.Fatal("expected the write to wait for the reader")
+	}
+	slowOut.SetWriteDeadline(time.Time{})
+
+	// Other streams are not held up, in either direction, while the slow
+	// stream's writer waits.
+	go slowOut.Write([]byte("y"))
+	out, in := open()
+	for _, pair := range [][2]net.Conn{{out, in}, {in, out}} {
+		if _, err := pair[0].Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(pair[1], buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("expected hello, got %q, %v", buf, err)
+		}
+	}
+
+	// Reading grants the writer more room.
+	buf := make([]byte, window+1)
+	if _, err := io.ReadFull(slowIn, buf); err != nil || buf[window] != 'y' {
+		t.Fatalf("expected %d bytes ending in y, got %v", window+1, err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"reflect"
+)
+
+// CallObserver is called before a handler runs with the context it will be
+// called with, the method and its decoded args. It returns the context to
+// call the handler with instead, and a function that is called with the
+// reply and error once the handler has returned. reply is nil if the handler
+// failed. Observers suit tracing and metrics, which need to wrap each call
+// and pass state to the handler.
+type CallObserver func(ctx context.Context, serviceMethod string, args interface{}) (context.Context, func(reply interface{}, err error))
+
+// WithCallObserver adds an observer of the calls the server handles.
+// Observers are called in the order they were added, each with the context
+// returned by the previous one.
+func WithCallObserver(o CallObserver) func(*Server) {
+	return func(s *Server) {
+		s.observers = append(s.observers, o)
+	}
+}
+
+// observe calls the server's observers for a call to serviceMethod. The
+// returned function must be called once the handler has returned.
+func (server *Server) observe(ctx context.Context, serviceMethod string, args interface{}) (context.Context, func(reply interface{}, err error)) {
+	if len(server.observers) == 0 {
+		return ctx, func(interface{}, error) {}
+	}
+	done := make([]func(interface{}, error), 0, len(server.observers))
+	for _, o := range server.observers {
+		var fn func(interface{}, error)
+		ctx, fn = o(ctx, serviceMethod, args)
+		if fn != nil {
+			done = append(done, fn)
+		}
+	}
+	return ctx, func(reply interface{}, err error) {
+		for i := len(done) - 1; i >= 0; i-- {
+			done[i](reply, err)
+		}
+	}
+}
+
+// replyIfOK returns the reply to pass to observers of a call that returned
+// err.
+func replyIfOK(replyv reflect.Value, err error) interface{} {
+	if err != nil || !replyv.IsValid() {
+		return nil
+	}
+	return replyv.Interface()
+}
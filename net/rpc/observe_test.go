@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type observedKey struct{}
+
+func TestCallObserver(t *testing.T) {
+	var log []string
+	observer := func(name string) CallObserver {
+		return func(ctx context.Context, serviceMethod string, args interface{}) (context.Context, func(interface{}, error)) {
+			log = append(log, name+" start "+serviceMethod)
+			ctx = context.WithValue(ctx, observedKey{}, name)
+			return ctx, func(reply interface{}, err error) {
+				if err != nil {
+					log = append(log, name+" error "+err.Error())
+					return
+				}
+				log = append(log, fmt.Sprintf("%s done %d", name, reply.(*Reply).C))
+			}
+		}
+	}
+	srv := NewServerWithOpts(WithCallObserver(observer("a")), WithCallObserver(observer("b")))
+	srv.Register(new(Arith))
+
+	decode := func(v any) error {
+		*v.(*Args) = Args{A: 2, B: 3}
+		return nil
+	}
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.Add", decode, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.Div", func(v any) error { return nil }, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	want := []string{
+		"a start Arith.Add", "b start Arith.Add", "b done 5", "a done 5",
+		"a start Arith.Div", "b start Arith.Div", "b error divide by zero", "a error divide by zero",
+	}
+	if got := strings.Join(log, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
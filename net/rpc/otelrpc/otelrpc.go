@@ -0,0 +1,232 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package otelrpc traces rpc calls with OpenTelemetry. A server observer and
+// a client wrapper create a span for each call, propagate its trace context
+// from client to server through request metadata, in the W3C Trace Context
+// format by default, and record the method, peer address, payload sizes and
+// error status as attributes named after the OpenTelemetry RPC semantic
+// conventions.
+package otelrpc
+
+import (
+	"context"
+	"net"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// instrumentationName names the tracer spans are started with.
+const instrumentationName = "github.com/hashicorp/consul-net-rpc/net/rpc/otelrpc"
+
+// Attribute keys recorded on spans.
+const (
+	AttrSystem       = attribute.Key("rpc.system")
+	AttrService      = attribute.Key("rpc.service")
+	AttrMethod       = attribute.Key("rpc.method")
+	AttrPeerAddress  = attribute.Key("net.peer.name")
+	AttrRequestSize  = attribute.Key("rpc.request.size")
+	AttrResponseSize = attribute.Key("rpc.response.size")
+	AttrErrorCode    = attribute.Key("rpc.error_code")
+)
+
+// system is the value of AttrSystem.
+const system = "net_rpc"
+
+type config struct {
+	tracer      trace.Tracer
+	provider    trace.TracerProvider
+	propagators propagation.TextMapPropagator
+	encoding    rpc.RawEncoding
+	peer        string
+}
+
+// Option configures the tracing of NewClient and ServerObserver.
+type Option func(*config)
+
+// WithTracerProvider sets the provider of the tracer spans are started with.
+// It defaults to the global provider, otel.GetTracerProvider().
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithPropagators sets how the trace context of a call is written to and
+// read from its metadata. It defaults to propagation.TraceContext, which
+// sends the W3C traceparent and tracestate headers.
+func WithPropagators(propagators propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagators = propagators
+	}
+}
+
+// WithPayloadEncoding makes spans record the size of args and replies
+// encoded with enc, such as rpc.GobEncoding. Encoding them again adds to the
+// latency of calls, so sizes are not recorded by default.
+func WithPayloadEncoding(enc rpc.RawEncoding) Option {
+	return func(c *config) {
+		c.encoding = enc
+	}
+}
+
+// WithPeerAddress sets the address of the server recorded on client spans.
+func WithPeerAddress(address string) Option {
+	return func(c *config) {
+		c.peer = address
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{propagators: propagation.TraceContext{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.provider == nil {
+		c.provider = otel.GetTracerProvider()
+	}
+	c.tracer = c.provider.Tracer(instrumentationName)
+	return c
+}
+
+// payloadSize records the encoded size of v on span as key.
+func (c *config) payloadSize(span trace.Span, key attribute.Key, v interface{}) {
+	if c.encoding == nil || v == nil {
+		return
+	}
+	if data, err := c.encoding.Marshal(v); err == nil {
+		span.SetAttributes(key.Int(len(data)))
+	}
+}
+
+// metadataCarrier reads and writes the trace context of a call in its
+// metadata.
+type metadataCarrier rpc.Metadata
+
+func (m metadataCarrier) Get(key string) string { return m[key] }
+
+func (m metadataCarrier) Set(key, value string) { m[key] = value }
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ServerObserver returns a CallObserver, for use with rpc.WithCallObserver,
+// that starts a server span for each call, continuing the trace of the
+// client's span if it sent one. Handlers taking a context receive the
+// span's context.
+func ServerObserver(opts ...Option) rpc.CallObserver {
+	c := newConfig(opts)
+	return func(ctx context.Context, serviceMethod string, args interface{}) (context.Context, func(interface{}, error)) {
+		ctx = c.propagators.Extract(ctx, metadataCarrier(rpc.MetadataFromContext(ctx)))
+		attrs := methodAttributes(serviceMethod)
+		if peer, ok := rpc.PeerFromContext(ctx); ok {
+			attrs = append(attrs, AttrPeerAddress.String(peerHost(peer.String())))
+		}
+		ctx, span := c.tracer.Start(ctx, serviceMethod,
+			trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+		c.payloadSize(span, AttrRequestSize, args)
+		return ctx, func(reply interface{}, err error) {
+			if err == nil {
+				c.payloadSize(span, AttrResponseSize, reply)
+			}
+			finish(span, err)
+		}
+	}
+}
+
+// Client wraps an rpc.Client to trace its calls.
+type Client struct {
+	*rpc.Client
+	config *config
+}
+
+// NewClient returns a Client making calls with client and tracing them.
+func NewClient(client *rpc.Client, opts ...Option) *Client {
+	return &Client{Client: client, config: newConfig(opts)}
+}
+
+// Dial connects to the server at address like rpc.Dial and traces the calls
+// of the returned Client.
+func Dial(network, address string, opts ...Option) (*Client, error) {
+	client, err := rpc.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]Option{WithPeerAddress(address)}, opts...)
+	return NewClient(client, opts...), nil
+}
+
+// Call is like CallContext with a background context.
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext makes a call within a client span, a child of the span ctx
+// carries, and sends the span's context to the server.
+func (c *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	attrs := methodAttributes(serviceMethod)
+	if c.config.peer != "" {
+		attrs = append(attrs, AttrPeerAddress.String(peerHost(c.config.peer)))
+	}
+	ctx, span := c.config.tracer.Start(ctx, serviceMethod,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	md := make(rpc.Metadata)
+	c.config.propagators.Inject(ctx, metadataCarrier(md))
+	if len(md) > 0 {
+		ctx = rpc.ContextWithMetadata(ctx, md)
+	}
+	c.config.payloadSize(span, AttrRequestSize, args)
+	err := c.Client.CallContext(ctx, serviceMethod, args, reply)
+	if err == nil {
+		c.config.payloadSize(span, AttrResponseSize, reply)
+	}
+	finish(span, err)
+	return err
+}
+
+// methodAttributes returns the attributes naming serviceMethod.
+func methodAttributes(serviceMethod string) []attribute.KeyValue {
+	service, method := serviceMethod, ""
+	for i := len(serviceMethod) - 1; i >= 0; i-- {
+		if serviceMethod[i] == '.' {
+			service, method = serviceMethod[:i], serviceMethod[i+1:]
+			break
+		}
+	}
+	attrs := []attribute.KeyValue{AttrSystem.String(system), AttrService.String(service)}
+	if method != "" {
+		attrs = append(attrs, AttrMethod.String(method))
+	}
+	return attrs
+}
+
+// finish records err on span and ends it.
+func finish(span trace.Span, err error) {
+	if err != nil {
+		if code := rpc.ErrorCode(err); code != "" {
+			span.SetAttributes(AttrErrorCode.String(code))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// peerHost returns the host of address, or address if it has no port.
+func peerHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
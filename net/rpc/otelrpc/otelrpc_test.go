@@ -0,0 +1,163 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package otelrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+type Args struct{ A, B int }
+
+type Reply struct{ C int }
+
+type Arith struct{}
+
+func (Arith) Add(ctx context.Context, args Args, reply *Reply) error {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return errors.New("no span in handler context")
+	}
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (Arith) Fail(args Args, reply *Reply) error {
+	return errors.New("failed")
+}
+
+func startServer(t *testing.T, srv *rpc.Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	registry := rpc.NewCodecRegistry()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				codec, err := registry.NewServerCodec(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer codec.Close()
+				for srv.ServeRequest(codec) == nil {
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr string, provider trace.TracerProvider) *Client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.NegotiateCodec(conn, "gob"); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(rpc.NewClient(conn), WithTracerProvider(provider), WithPeerAddress(addr), WithPayloadEncoding(rpc.GobEncoding))
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	srv := rpc.NewServerWithOpts(rpc.WithCallObserver(ServerObserver(WithTracerProvider(provider), WithPayloadEncoding(rpc.GobEncoding))))
+	if err := srv.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	client := dial(t, startServer(t, srv), provider)
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "root")
+	var reply Reply
+	if err := client.CallContext(ctx, "Arith.Add", Args{7, 8}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 15 {
+		t.Fatalf("got %d", reply.C)
+	}
+	if err := client.Call("Arith.Fail", Args{}, &reply); err == nil {
+		t.Fatal("expected error")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	byKind := func(kind trace.SpanKind, i int) sdktrace.ReadOnlySpan {
+		var n int
+		for _, s := range spans {
+			if s.SpanKind() == kind {
+				if n == i {
+					return s
+				}
+				n++
+			}
+		}
+		t.Fatalf("no span %d of kind %v", i, kind)
+		return nil
+	}
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	clientAdd, serverAdd := byKind(trace.SpanKindClient, 0), byKind(trace.SpanKindServer, 0)
+	if clientAdd.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("client span is not a child of the context's span")
+	}
+	if !serverAdd.Parent().IsRemote() || serverAdd.Parent().SpanID() != clientAdd.SpanContext().SpanID() {
+		t.Error("server span does not continue the client span")
+	}
+	for _, s := range []sdktrace.ReadOnlySpan{clientAdd, serverAdd} {
+		if s.Name() != "Arith.Add" || s.Status().Code == codes.Error {
+			t.Errorf("span %q failed: %v", s.Name(), s.Status())
+		}
+		a := attrs(s)
+		if a[AttrService].AsString() != "Arith" || a[AttrMethod].AsString() != "Add" || a[AttrSystem].AsString() != system {
+			t.Errorf("attributes %v", s.Attributes())
+		}
+		if a[AttrPeerAddress].AsString() != "127.0.0.1" {
+			t.Errorf("peer %v", a[AttrPeerAddress].Emit())
+		}
+		if a[AttrRequestSize].AsInt64() == 0 {
+			t.Errorf("request size %v", a[AttrRequestSize].Emit())
+		}
+		if a[AttrResponseSize].AsInt64() == 0 {
+			t.Errorf("response size %v", a[AttrResponseSize].Emit())
+		}
+	}
+
+	clientFail, serverFail := byKind(trace.SpanKindClient, 1), byKind(trace.SpanKindServer, 1)
+	if clientFail.Status().Code != codes.Error || serverFail.Status().Code != codes.Error {
+		t.Error("failed call not recorded as an error")
+	}
+	if _, ok := attrs(serverFail)[AttrResponseSize]; ok {
+		t.Error("response size recorded for failed call")
+	}
+	if clientFail.Parent().IsValid() || serverFail.Parent().SpanID() != clientFail.SpanContext().SpanID() {
+		t.Error("call without a span in its context did not start a trace")
+	}
+}
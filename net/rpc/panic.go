@@ -0,0 +1,40 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+)
+
+// PanicHandler is called with the value recovered from a handler that
+// panicked. The error it returns is sent to the client in place of the
+// reply; if it returns nil, a generic error is sent instead.
+type PanicHandler func(serviceMethod string, recovered any) error
+
+// WithPanicHandler makes the server recover panics in handlers, so a
+// panicking method fails the request rather than crashing the process. The
+// connection stays open for the client's other requests. Without a
+// PanicHandler, panics are not recovered.
+func WithPanicHandler(handler PanicHandler) func(*Server) {
+	return func(s *Server) {
+		s.panicHandler = handler
+	}
+}
+
+// recoverHandler calls call, turning a panic into the error returned by the
+// server's PanicHandler.
+func (server *Server) recoverHandler(serviceMethod string, call func() error) (err error) {
+	if server.panicHandler == nil {
+		return call()
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = server.panicHandler(serviceMethod, recovered)
+			if err == nil {
+				err = fmt.Errorf("rpc: %s panicked", serviceMethod)
+			}
+		}
+	}()
+	return call()
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type Panicky struct{}
+
+func (Panicky) Boom(args string, reply *string) error {
+	panic(args)
+}
+
+func TestPanicHandler(t *testing.T) {
+	var recovered []any
+	srv := NewServerWithOpts(
+		WithPanicHandler(func(serviceMethod string, r any) error {
+			recovered = append(recovered, r)
+			if r == "quiet" {
+				return nil
+			}
+			return fmt.Errorf("%s failed: %v", serviceMethod, r)
+		}),
+		WithMethodTimeout("Panicky.*", time.Second),
+	)
+	if err := srv.RegisterAll(Panicky{}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	err = client.Call("Panicky.Boom", "oops", &reply)
+	if err == nil || err.Error() != "Panicky.Boom failed: oops" {
+		t.Errorf("expected the panic handler's error, got %v", err)
+	}
+	err = client.Call("Panicky.Boom", "quiet", &reply)
+	if err == nil || err.Error() != "rpc: Panicky.Boom panicked" {
+		t.Errorf("expected a generic error, got %v", err)
+	}
+	if len(recovered) != 2 {
+		t.Errorf("expected 2 recovered panics, got %v", recovered)
+	}
+
+	// The connection survives.
+	r := new(Reply)
+	if err := client.Call("Arith.Add", Args{1, 2}, r); err != nil || r.C != 3 {
+		t.Errorf("expected the connection to keep working, got %v, %d", err, r.C)
+	}
+	if name := srv.Config().Interceptors.Panic; name == "" {
+		t.Error("expected the panic handler in the server config")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ErrPeerCredsUnsupported is returned by ReadPeerCreds on platforms where
+// the credentials of Unix socket peers cannot be read.
+var ErrPeerCredsUnsupported = errors.New("rpc: peer credentials are not supported on this platform")
+
+// PeerCreds are the credentials of the process at the other end of a Unix
+// socket, as the kernel recorded them when it connected, so that local
+// agent APIs can authorize callers by OS user.
+type PeerCreds struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+// ReadPeerCreds returns the credentials of the peer of conn, a Unix socket.
+// It is only supported on Linux, where it uses SO_PEERCRED.
+func ReadPeerCreds(conn net.Conn) (*PeerCreds, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("rpc: peer credentials are only available for Unix sockets")
+	}
+	return readPeerCreds(uc)
+}
+
+// PeerCredsOf returns the credentials of the client at sourceAddr, as
+// passed to a PreBodyInterceptor, or nil if it is not known. They are known
+// for Unix socket connections set up with Handshake, as ServeUnix does.
+func PeerCredsOf(sourceAddr net.Addr) *PeerCreds {
+	if p, ok := sourceAddr.(*Peer); ok {
+		return p.Creds
+	}
+	return nil
+}
+
+// credsConn is a Unix socket whose peer's credentials were read once it
+// was accepted.
+type credsConn struct {
+	net.Conn
+	creds *PeerCreds
+}
+
+// withPeerCreds returns conn wrapped to report the credentials of its peer,
+// if it is a Unix socket whose peer's credentials can be read.
+func withPeerCreds(conn net.Conn) net.Conn {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn
+	}
+	creds, err := readPeerCreds(uc)
+	if err != nil {
+		return conn
+	}
+	return &credsConn{Conn: conn, creds: creds}
+}
+
+// ServeUnix accepts connections on l and serves them with ServeConn, which
+// runs Handshake on each, so that the credentials of their peers are
+// reported by PeerCredsOf and PeerFromContext. It blocks until l fails or
+// the server shuts down, in which case it returns ErrServerClosed.
+func (server *Server) ServeUnix(l *net.UnixListener) error {
+	return server.Accept(context.Background(), l)
+}
+
+// DialUnix connects to an RPC server listening on the Unix socket at path.
+func DialUnix(path string) (*Client, error) {
+	return Dial("unix", path)
+}
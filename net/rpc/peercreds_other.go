@@ -0,0 +1,12 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package rpc
+
+import "net"
+
+func readPeerCreds(conn *net.UnixConn) (*PeerCreds, error) {
+	return nil, ErrPeerCredsUnsupported
+}
@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+type CredsEcho struct{}
+
+func (CredsEcho) UID(ctx context.Context, args struct{}, reply *int) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.Creds == nil {
+		return errors.New("no credentials")
+	}
+	*reply = int(p.Creds.UID)
+	return nil
+}
+
+func TestServeUnix(t *testing.T) {
+	var preBody atomic.Pointer[PeerCreds]
+	srv := NewServerWithOpts(WithPreBodyInterceptor(func(serviceMethod string, sourceAddr net.Addr) error {
+		preBody.Store(PeerCredsOf(sourceAddr))
+		return nil
+	}))
+	if err := srv.Register(CredsEcho{}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "rpc.sock"), Net: "unix"})
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	defer l.Close()
+	go srv.ServeUnix(l)
+
+	client, err := DialUnix(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var uid int
+	err = client.Call("CredsEcho.UID", struct{}{}, &uid)
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Error("expected no credentials outside Linux")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != os.Getuid() {
+		t.Errorf("expected uid %d, got %d", os.Getuid(), uid)
+	}
+	if creds := preBody.Load(); creds == nil || int(creds.PID) != os.Getpid() {
+		t.Errorf("expected the pre-body interceptor to see pid %d, got %+v", os.Getpid(), creds)
+	}
+}
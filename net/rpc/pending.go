@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// WriteCounter is implemented by ClientCodecs that count the bytes they have
+// written to their connection. PendingCalls uses it to report how much of
+// each request was written. The codec NewClient uses implements it.
+type WriteCounter interface {
+	BytesWritten() uint64
+}
+
+// PendingCall describes a call waiting for its response.
+type PendingCall struct {
+	ServiceMethod string
+	Seq           uint64
+	Age           time.Duration // since the call was sent
+	// Written reports whether the request was completely written. A call
+	// that was not is stuck behind a connection that stopped draining.
+	Written bool
+	// BytesWritten is how much of the request has been written, or -1 if
+	// the codec is not a WriteCounter.
+	BytesWritten int64
+}
+
+// PendingCalls returns the calls waiting for a response, oldest first, so
+// that the cause of a connection going quiet can be logged.
+func (client *Client) PendingCalls() []PendingCall {
+	counter, counted := client.codec.(WriteCounter)
+	now := time.Now()
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	calls := make([]PendingCall, 0, len(client.pending))
+	for seq, call := range client.pending {
+		pc := PendingCall{
+			ServiceMethod: call.ServiceMethod,
+			Seq:           seq,
+			Age:           now.Sub(call.sent),
+			Written:       call.written,
+			BytesWritten:  -1,
+		}
+		if counted {
+			if call.written {
+				pc.BytesWritten = int64(call.writeEnd - call.writeStart)
+			} else if call == client.writing {
+				pc.BytesWritten = int64(counter.BytesWritten() - call.writeStart)
+			} else {
+				pc.BytesWritten = 0
+			}
+		}
+		calls = append(calls, pc)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Seq < calls[j].Seq })
+	return calls
+}
+
+// writeCountingConn counts the bytes written to the connection of a
+// gobClientCodec.
+type writeCountingConn struct {
+	io.ReadWriteCloser
+	written atomic.Uint64
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(b)
+	c.written.Add(uint64(n))
+	return n, err
+}
+
+func (c *gobClientCodec) BytesWritten() uint64 {
+	if cc, ok := c.rwc.(*writeCountingConn); ok {
+		return cc.written.Load()
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPendingCalls(t *testing.T) {
+	srv := NewServer()
+	blocker := newBlocker()
+	if err := srv.Register(blocker); err != nil {
+		t.Fatal(err)
+	}
+	cli, conn := net.Pipe()
+	client := NewClient(cli)
+	defer client.Close()
+
+	// Nothing reads the connection yet, so the request cannot be written.
+	calls := make(chan *Call, 1)
+	go func() { calls <- client.Go("Blocker.Block", &Args{}, new(Reply), nil) }()
+	waitFor(t, func() bool { return len(client.PendingCalls()) == 1 })
+	pending := client.PendingCalls()[0]
+	if pending.ServiceMethod != "Blocker.Block" || pending.Written || pending.BytesWritten != 0 {
+		t.Errorf("expected the call to be stuck writing, got %+v", pending)
+	}
+
+	go serveConn(srv, conn)
+	call := <-calls
+	<-blocker.started
+	waitFor(t, func() bool { return client.PendingCalls()[0].Written })
+	pending = client.PendingCalls()[0]
+	if pending.BytesWritten <= 0 || pending.Age <= 0 || pending.Seq != call.seq {
+		t.Errorf("expected the call to be written and waiting, got %+v", pending)
+	}
+
+	close(blocker.release)
+	if call := <-call.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if pending := client.PendingCalls(); len(pending) != 0 {
+		t.Errorf("expected no pending calls, got %+v", pending)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// PipeTransport returns a Transport connecting clients to servers in the
+// same process over in-memory pipes, for tests and for embedding a server
+// without opening a socket. Dial connects to the listener returned by Listen
+// for the same address; the network is ignored. Each call returns a new
+// namespace of addresses.
+func PipeTransport() Transport {
+	p := &pipeNetwork{listeners: make(map[string]*pipeListener)}
+	return Transport{Name: "pipe", Dial: p.dial, Listen: p.listen}
+}
+
+type pipeNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*pipeListener
+}
+
+func (p *pipeNetwork) listen(_ context.Context, _, address string) (net.Listener, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.listeners[address]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(address), Err: syscall.EADDRINUSE}
+	}
+	l := &pipeListener{
+		network: p,
+		addr:    pipeAddr(address),
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	p.listeners[address] = l
+	return l, nil
+}
+
+func (p *pipeNetwork) dial(ctx context.Context, _, address string) (net.Conn, error) {
+	p.mu.Lock()
+	l := p.listeners[address]
+	p.mu.Unlock()
+	if l == nil {
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(address), Err: syscall.ECONNREFUSED}
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- &pipeConn{Conn: server, local: l.addr, remote: pipeAddr("client")}:
+		return &pipeConn{Conn: client, local: pipeAddr("client"), remote: l.addr}, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: l.addr, Err: syscall.ECONNREFUSED}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeListener struct {
+	network   *pipeNetwork
+	addr      pipeAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.network.mu.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return l.addr }
+
+// pipeConn reports the addresses of the ends of a pipe, which net.Pipe
+// leaves unnamed.
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+type pipeAddr string
+
+func (pipeAddr) Network() string  { return "pipe" }
+func (a pipeAddr) String() string { return string(a) }
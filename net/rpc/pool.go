@@ -0,0 +1,447 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ClientPool maintains a set of Clients connected to the same server and
+// spreads calls across them. Connections are dialed on demand, up to the
+// configured maximum, and connections left idle for longer than the idle
+// timeout are closed by a background reaper. Connections that shut down are
+// dropped from the pool and replaced by the next dial.
+type ClientPool struct {
+	dial        func(context.Context) (*Client, error)
+	maxConns    int
+	idleTimeout time.Duration
+	minConns    int
+	dialBackoff func(retry int) time.Duration
+	healthEvery time.Duration
+	ping        func(*Client) error
+
+	mu           sync.Mutex    // protects following
+	dialed       chan struct{} // closed and replaced when a dial completes
+	conns        []*poolConn
+	dialing      int
+	closed       bool
+	stats        PoolStats
+	dialFailures int       // consecutive failed dials
+	nextDial     time.Time // no dials before this while dialFailures > 0
+	dialErr      error     // the error of the last failed dial
+
+	retryPolicy *RetryPolicy
+	stop        chan struct{} // closed by Close to stop background goroutines
+}
+
+type poolConn struct {
+	client   *Client
+	inUse    int       // calls currently using the client
+	lastUsed time.Time // when the last call finished
+}
+
+// PoolStats reports the state of a ClientPool.
+type PoolStats struct {
+	Open       int    // connections currently open
+	InUse      int    // calls currently in flight
+	Dialed     uint64 // connections dialed over the pool's lifetime
+	Reaped     uint64 // connections closed for being idle
+	Failed     uint64 // connections dropped after shutting down or failing a health check
+	DialErrors uint64 // dials that failed
+}
+
+// WithPoolMaxConns sets the maximum number of connections the pool opens. It
+// defaults to 1, and an n below 1 is taken as 1.
+func WithPoolMaxConns(n int) func(*ClientPool) {
+	return func(p *ClientPool) {
+		if n < 1 {
+			n = 1
+		}
+		p.maxConns = n
+	}
+}
+
+// WithPoolIdleTimeout makes the pool close connections that have had no calls in
+// flight for longer than d. Zero, the default, keeps idle connections open.
+func WithPoolIdleTimeout(d time.Duration) func(*ClientPool) {
+	return func(p *ClientPool) {
+		p.idleTimeout = d
+	}
+}
+
+// WithPoolMinConns sets the number of connections the idle reaper leaves open.
+// With WithPoolHealthCheck, it is also the number of connections the pool
+// dials ahead of calls and re-dials when they fail.
+func WithPoolMinConns(n int) func(*ClientPool) {
+	return func(p *ClientPool) {
+		p.minConns = n
+	}
+}
+
+// WithPoolDialBackoff sets the delay after a failed dial before the pool dials
+// again, given the number of consecutive failures. Until then, calls that need
+// a new connection fail with the last dial error, or use a busy connection if
+// there is one. It defaults to ExponentialBackoff(100*time.Millisecond,
+// 10*time.Second).
+func WithPoolDialBackoff(backoff func(retry int) time.Duration) func(*ClientPool) {
+	return func(p *ClientPool) {
+		p.dialBackoff = backoff
+	}
+}
+
+// WithPoolHealthCheck makes the pool check its connections every interval.
+// Connections that have shut down, or that are idle and for which ping
+// returns an error, are closed and dropped. The pool then dials connections
+// until it has the number set by WithPoolMinConns. ping may be nil.
+func WithPoolHealthCheck(interval time.Duration, ping func(*Client) error) func(*ClientPool) {
+	return func(p *ClientPool) {
+		p.healthEvery = interval
+		p.ping = ping
+	}
+}
+
+// NewClientPool returns a ClientPool that uses dial to open connections.
+func NewClientPool(dial func() (*Client, error), options ...func(*ClientPool)) *ClientPool {
+	return NewClientPoolContext(func(context.Context) (*Client, error) {
+		return dial()
+	}, options...)
+}
+
+// NewClientPoolContext is like NewClientPool but dials with the context of
+// the call that needs the connection, so the dial is abandoned when the call
+// is. Connections dialed in the background are dialed with
+// context.Background().
+func NewClientPoolContext(dial func(context.Context) (*Client, error), options ...func(*ClientPool)) *ClientPool {
+	p := &ClientPool{
+		dial:        dial,
+		maxConns:    1,
+		dialBackoff: ExponentialBackoff(100*time.Millisecond, 10*time.Second),
+		dialed:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(p)
+	}
+	if p.idleTimeout > 0 || p.healthEvery > 0 {
+		p.stop = make(chan struct{})
+	}
+	if p.idleTimeout > 0 {
+		go p.reap()
+	}
+	if p.healthEvery > 0 {
+		go p.checkHealth()
+	}
+	return p
+}
+
+// DialPool returns a ClientPool of connections to the RPC server at the
+// specified network address.
+func DialPool(network, address string, options ...func(*ClientPool)) *ClientPool {
+	return NewClientPoolContext(func(ctx context.Context) (*Client, error) {
+		return DialContext(ctx, network, address)
+	}, options...)
+}
+
+// Call invokes the named function on one of the pool's connections, waits
+// for it to complete, and returns its error status.
+func (p *ClientPool) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return p.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext is like Call but behaves like Client.CallContext. It also gives
+// up waiting for a connection to be dialed when ctx is done.
+func (p *ClientPool) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	call := func(ctx context.Context) error {
+		pc, err := p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer p.release(pc)
+		return pc.client.CallContext(ctx, serviceMethod, args, reply)
+	}
+	if p.retryPolicy == nil {
+		return call(ctx)
+	}
+	return p.retryPolicy.do(ctx, call, p.isClosed)
+}
+
+func (p *ClientPool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Stats returns the current PoolStats.
+func (p *ClientPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Open = len(p.conns)
+	for _, pc := range p.conns {
+		stats.InUse += pc.inUse
+	}
+	return stats
+}
+
+// Close closes every connection in the pool. Calls made after Close return
+// ErrShutdown.
+func (p *ClientPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrShutdown
+	}
+	p.closed = true
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+	}
+	for _, pc := range conns {
+		pc.client.Close()
+	}
+	return nil
+}
+
+// acquire returns the least loaded connection, dialing a new one with ctx if
+// every connection is busy and the pool is below its maximum size.
+func (p *ClientPool) acquire(ctx context.Context) (*poolConn, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrShutdown
+		}
+		dead := p.pruneLocked()
+		var best *poolConn
+		for _, pc := range p.conns {
+			if best == nil || pc.inUse < best.inUse {
+				best = pc
+			}
+		}
+		full := len(p.conns)+p.dialing >= p.maxConns
+		backingOff := p.dialFailures > 0 && time.Now().Before(p.nextDial)
+		if best != nil && (best.inUse == 0 || full || backingOff) {
+			best.inUse++
+			p.mu.Unlock()
+			closeClients(dead)
+			return best, nil
+		}
+		if !full && backingOff {
+			err := p.dialErr
+			p.mu.Unlock()
+			closeClients(dead)
+			return nil, err
+		}
+		if !full {
+			p.dialing++
+			p.mu.Unlock()
+			closeClients(dead)
+			return p.dialConn(ctx, 1)
+		}
+		if len(dead) > 0 {
+			p.mu.Unlock()
+			closeClients(dead)
+			p.mu.Lock()
+			continue
+		}
+		// Every connection is still being dialed.
+		dialed := p.dialed
+		p.mu.Unlock()
+		select {
+		case <-dialed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+}
+
+// dialConn dials a new connection with ctx and adds it to the pool with inUse
+// calls. The caller must have counted the dial in p.dialing, and must not
+// hold p.mu.
+func (p *ClientPool) dialConn(ctx context.Context, inUse int) (*poolConn, error) {
+	client, err := p.dial(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	close(p.dialed)
+	p.dialed = make(chan struct{})
+	if err != nil && ctx.Err() != nil {
+		// The caller gave up, which says nothing about the server.
+		return nil, err
+	}
+	if err != nil {
+		p.stats.DialErrors++
+		p.dialFailures++
+		p.nextDial = time.Now().Add(p.dialBackoff(p.dialFailures))
+		p.dialErr = err
+		return nil, err
+	}
+	p.dialFailures = 0
+	if p.closed {
+		client.Close()
+		return nil, ErrShutdown
+	}
+	pc := &poolConn{client: client, inUse: inUse, lastUsed: time.Now()}
+	p.conns = append(p.conns, pc)
+	p.stats.Dialed++
+	return pc, nil
+}
+
+func (p *ClientPool) release(pc *poolConn) {
+	p.mu.Lock()
+	pc.inUse--
+	pc.lastUsed = time.Now()
+	var broken bool
+	if pc.client.isShutdown() {
+		// Let the next call dial a new connection instead.
+		broken = p.remove(pc)
+	}
+	p.mu.Unlock()
+	if broken {
+		pc.client.Close()
+	}
+}
+
+// remove drops a failed connection from the pool so no new calls use it. It
+// reports false if pc was already removed.
+func (p *ClientPool) remove(pc *poolConn) bool {
+	for i, c := range p.conns {
+		if c == pc {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			p.stats.Failed++
+			return true
+		}
+	}
+	return false
+}
+
+// pruneLocked drops the connections that have shut down and returns their
+// clients, which the caller must close without p.mu held.
+func (p *ClientPool) pruneLocked() []*Client {
+	var dead []*Client
+	for i := 0; i < len(p.conns); i++ {
+		if pc := p.conns[i]; pc.client.isShutdown() && p.remove(pc) {
+			dead = append(dead, pc.client)
+			i--
+		}
+	}
+	return dead
+}
+
+func closeClients(clients []*Client) {
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// reap periodically closes connections that have been idle for longer than
+// the idle timeout, keeping at least minConns open.
+func (p *ClientPool) reap() {
+	// Checking more often than every millisecond gains nothing, and half of
+	// a 1ns timeout is not a valid ticker interval.
+	every := p.idleTimeout / 2
+	if every < time.Millisecond {
+		every = time.Millisecond
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			closeClients(p.reapIdle(now))
+		}
+	}
+}
+
+func (p *ClientPool) reapIdle(now time.Time) []*Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var reaped []*Client
+	kept := p.conns[:0]
+	for i, pc := range p.conns {
+		open := len(kept) + len(p.conns) - i
+		if pc.inUse == 0 && now.Sub(pc.lastUsed) > p.idleTimeout && open > p.minConns {
+			reaped = append(reaped, pc.client)
+			continue
+		}
+		kept = append(kept, pc)
+	}
+	for i := len(kept); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = kept
+	p.stats.Reaped += uint64(len(reaped))
+	return reaped
+}
+
+// checkHealth periodically drops failed connections and dials replacements.
+func (p *ClientPool) checkHealth() {
+	ticker := time.NewTicker(p.healthEvery)
+	defer ticker.Stop()
+	for {
+		p.checkConns()
+		p.fill()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkConns pings the idle connections and drops those that fail.
+func (p *ClientPool) checkConns() {
+	p.mu.Lock()
+	dead := p.pruneLocked()
+	var idle []*poolConn
+	if p.ping != nil {
+		for _, pc := range p.conns {
+			if pc.inUse == 0 {
+				// Count the ping as a call so the reaper leaves pc alone.
+				pc.inUse++
+				idle = append(idle, pc)
+			}
+		}
+	}
+	p.mu.Unlock()
+	closeClients(dead)
+
+	for _, pc := range idle {
+		err := p.ping(pc.client)
+		p.mu.Lock()
+		pc.inUse--
+		failed := err != nil && p.remove(pc)
+		p.mu.Unlock()
+		if failed {
+			pc.client.Close()
+		}
+	}
+}
+
+// fill dials connections until the pool has minConns, stopping at the first
+// failed dial.
+func (p *ClientPool) fill() {
+	for {
+		p.mu.Lock()
+		open := len(p.conns) + p.dialing
+		need := !p.closed && open < p.minConns && open < p.maxConns
+		if !need || p.dialFailures > 0 && time.Now().Before(p.nextDial) {
+			p.mu.Unlock()
+			return
+		}
+		p.dialing++
+		p.mu.Unlock()
+		if _, err := p.dialConn(context.Background(), 0); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	pool := NewClientPool(func() (*Client, error) {
+		return Dial("tcp", serverAddr)
+	}, WithPoolMaxConns(3))
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := new(Reply)
+			if err := pool.Call("Arith.Add", &Args{i, 1}, reply); err != nil {
+				t.Errorf("Add: %v", err)
+			} else if reply.C != i+1 {
+				t.Errorf("Add: expected %d got %d", i+1, reply.C)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := pool.Stats()
+	if stats.Open == 0 || stats.Open > 3 {
+		t.Errorf("expected between 1 and 3 open connections, got %d", stats.Open)
+	}
+	if stats.InUse != 0 {
+		t.Errorf("expected no calls in use, got %d", stats.InUse)
+	}
+
+	pool.Close()
+	if err := pool.Call("Arith.Add", &Args{1, 2}, new(Reply)); err != ErrShutdown {
+		t.Errorf("expected ErrShutdown after Close, got %v", err)
+	}
+}
+
+func TestClientPoolMaxConnsBelowOne(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	pool := NewClientPool(func() (*Client, error) {
+		return Dial("tcp", serverAddr)
+	}, WithPoolMaxConns(0))
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pool.CallContext(ctx, "Arith.Add", &Args{1, 2}, new(Reply)); err != nil {
+		t.Fatalf("expected the pool to open a connection, got %v", err)
+	}
+	if open := pool.Stats().Open; open != 1 {
+		t.Errorf("expected 1 open connection, got %d", open)
+	}
+}
+
+func TestClientPoolReapsIdleConns(t *testing.T) {
+	srv := NewServer()
+	blocker := newBlocker()
+	if err := srv.Register(blocker); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	pool := NewClientPool(func() (*Client, error) {
+		return Dial("tcp", addr)
+	}, WithPoolMaxConns(3), WithPoolMinConns(1), WithPoolIdleTimeout(20*time.Millisecond))
+	defer pool.Close()
+
+	// Three concurrent calls each get their own connection.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pool.Call("Blocker.Block", &Args{}, new(Reply)); err != nil {
+				t.Errorf("Block: %v", err)
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-blocker.started
+	}
+	if open := pool.Stats().Open; open != 3 {
+		t.Fatalf("expected 3 open connections, got %d", open)
+	}
+	close(blocker.release)
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.Stats().Open > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := pool.Stats()
+	if stats.Open != 1 {
+		t.Errorf("expected reaper to keep exactly 1 connection, got %d", stats.Open)
+	}
+	if stats.Reaped != 2 {
+		t.Errorf("expected 2 reaped connections, got %d", stats.Reaped)
+	}
+	if err := pool.Call("Blocker.Block", &Args{}, new(Reply)); err != nil {
+		t.Errorf("Block after reaping: %v", err)
+	}
+}
+
+func TestClientPoolTinyIdleTimeout(t *testing.T) {
+	serverAddr, _ := startSharedServer()
+	pool := DialPool("tcp", serverAddr, WithPoolIdleTimeout(time.Nanosecond))
+	defer pool.Close()
+
+	if err := pool.Call("Arith.Add", &Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	// Give the reaper a few ticks to run.
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestClientPoolReplacesDeadConns(t *testing.T) {
+	srv := NewServer()
+	srv.Register(new(Arith))
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	pool := DialPool("tcp", addr)
+	defer pool.Close()
+
+	reply := new(Reply)
+	if err := pool.Call("Arith.Add", Args{1, 2}, reply); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range srv.ActiveConns() {
+		srv.CloseConn(addr)
+	}
+	// Wait for the client to notice.
+	pool.mu.Lock()
+	client := pool.conns[0].client
+	pool.mu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for !client.isShutdown() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pool.Call("Arith.Add", Args{3, 4}, reply); err != nil {
+		t.Fatalf("expected a new connection to be dialed, got %v", err)
+	}
+	if stats := pool.Stats(); stats.Dialed != 2 || stats.Failed != 1 || stats.Open != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestClientPoolDialBackoff(t *testing.T) {
+	var dials int
+	errDial := errors.New("connection refused")
+	pool := NewClientPool(func() (*Client, error) {
+		dials++
+		return nil, errDial
+	}, WithPoolDialBackoff(func(int) time.Duration { return time.Hour }))
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := pool.Call("Arith.Add", Args{1, 2}, new(Reply)); err != errDial {
+			t.Errorf("expected the dial error, got %v", err)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("expected calls to fail without dialing while backing off, got %d dials", dials)
+	}
+	if stats := pool.Stats(); stats.DialErrors != 1 {
+		t.Errorf("expected 1 dial error, got %d", stats.DialErrors)
+	}
+}
+
+func TestClientPoolCallContext(t *testing.T) {
+	dialing := make(chan struct{}, 1)
+	pool := NewClientPoolContext(func(ctx context.Context) (*Client, error) {
+		dialing <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	defer pool.Close()
+
+	// One call dials while the other waits for the dial; both give up when
+	// their context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		errs <- pool.CallContext(ctx, "Arith.Add", Args{1, 2}, new(Reply))
+	}()
+	<-dialing
+	go func() {
+		errs <- pool.CallContext(ctx, "Arith.Add", Args{1, 2}, new(Reply))
+	}()
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected the call to be cancelled, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("call ignored its context")
+		}
+	}
+	if stats := pool.Stats(); stats.DialErrors != 0 {
+		t.Errorf("expected the abandoned dial not to count as failed, got %d", stats.DialErrors)
+	}
+}
+
+func TestClientPoolHealthCheck(t *testing.T) {
+	srv := NewServer()
+	srv.Register(new(Arith))
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	var (
+		mu      sync.Mutex
+		healthy = true
+	)
+	ping := func(c *Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !healthy {
+			healthy = true
+			return errors.New("unhealthy")
+		}
+		return c.Call("Arith.Add", Args{}, new(Reply))
+	}
+	pool := DialPool("tcp", addr, WithPoolMaxConns(2), WithPoolMinConns(2), WithPoolHealthCheck(5*time.Millisecond, ping))
+	defer pool.Close()
+
+	waitFor := func(cond func(PoolStats) bool) PoolStats {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stats := pool.Stats()
+			if cond(stats) || time.Now().After(deadline) {
+				return stats
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// Connections are dialed ahead of calls.
+	if stats := waitFor(func(s PoolStats) bool { return s.Open == 2 }); stats.Open != 2 {
+		t.Fatalf("expected 2 open connections, got %+v", stats)
+	}
+
+	mu.Lock()
+	healthy = false
+	mu.Unlock()
+	stats := waitFor(func(s PoolStats) bool { return s.Failed == 1 && s.Open == 2 })
+	if stats.Failed != 1 || stats.Open != 2 || stats.Dialed != 3 {
+		t.Errorf("expected the unhealthy connection to be replaced, got %+v", stats)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync"
+)
+
+// Progress is a progress notification sent by a handler before its reply.
+type Progress struct {
+	Percent float64 // from 0 to 100
+	Message string
+}
+
+type progressKey struct{}
+
+type progressReporterKey struct{}
+
+// ContextWithProgress returns a context that makes CallContext ask the
+// server for progress notifications and pass them to fn. fn is called from
+// the goroutine reading responses, so it must not block.
+func ContextWithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ReportProgress sends a progress notification to the caller of the request
+// ctx belongs to, if the caller asked for them with ContextWithProgress and
+// its codec carries them. Otherwise, and once the handler has returned, it
+// does nothing. It returns an error if the notification could not be
+// written.
+func ReportProgress(ctx context.Context, percent float64, message string) error {
+	r := progressReporterFromContext(ctx)
+	if r == nil {
+		return nil
+	}
+	return r.report(Progress{Percent: percent, Message: message})
+}
+
+// progressReporter writes the progress notifications of one request.
+type progressReporter struct {
+	server        *Server
+	sending       *writeQueue
+	codec         ServerCodec
+	serviceMethod string
+	seq           uint64
+
+	mu     sync.Mutex // protects closed; held while writing
+	closed bool
+}
+
+// withProgress returns ctx carrying a progressReporter for req if the client
+// asked for progress. close must be called before the reply is written.
+func (server *Server) withProgress(ctx context.Context, sending *writeQueue, req *Request, codec ServerCodec) (context.Context, *progressReporter) {
+	if !req.Progress {
+		return ctx, nil
+	}
+	r := &progressReporter{
+		server:        server,
+		sending:       sending,
+		codec:         codec,
+		serviceMethod: req.ServiceMethod,
+		seq:           req.Seq,
+	}
+	return context.WithValue(ctx, progressReporterKey{}, r), r
+}
+
+func progressReporterFromContext(ctx context.Context) *progressReporter {
+	r, _ := ctx.Value(progressReporterKey{}).(*progressReporter)
+	return r
+}
+
+func progressFromContext(ctx context.Context) func(Progress) {
+	fn, _ := ctx.Value(progressKey{}).(func(Progress))
+	return fn
+}
+
+func (r *progressReporter) report(p Progress) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	resp := r.server.getResponse()
+	resp.ServiceMethod = r.serviceMethod
+	resp.Seq = r.seq
+	resp.Progress = &p
+	r.sending.Lock(WritePrioritySmall)
+	err := r.codec.WriteResponse(resp, invalidRequest)
+	r.sending.Unlock()
+	r.server.freeResponse(resp)
+	return err
+}
+
+// close stops the reporter, waiting for a notification being written.
+func (r *progressReporter) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type Restore struct{}
+
+func (Restore) Run(ctx context.Context, steps int, reply *int) error {
+	for i := 1; i <= steps; i++ {
+		if err := ReportProgress(ctx, float64(i*100/steps), fmt.Sprintf("step %d", i)); err != nil {
+			return err
+		}
+	}
+	*reply = steps
+	return nil
+}
+
+func TestProgress(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(Restore{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var got []Progress
+	ctx := ContextWithProgress(context.Background(), func(p Progress) {
+		got = append(got, p)
+	})
+	var reply int
+	if err := client.CallContext(ctx, "Restore.Run", 4, &reply); err != nil || reply != 4 {
+		t.Fatalf("Run: got %d, %v", reply, err)
+	}
+	if len(got) != 4 || got[0] != (Progress{25, "step 1"}) || got[3] != (Progress{100, "step 4"}) {
+		t.Errorf("unexpected progress %v", got)
+	}
+
+	// Callers that did not ask for progress only get the reply.
+	if err := client.Call("Restore.Run", 3, &reply); err != nil || reply != 3 {
+		t.Fatalf("Run: got %d, %v", reply, err)
+	}
+	if len(got) != 4 {
+		t.Errorf("unexpected progress %v", got)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package protorpc implements an rpc ClientCodec and ServerCodec for services
+// whose argument and reply types are generated protobuf messages.
+//
+// Every header and body is written as a size-delimited protobuf message: a
+// varint length followed by the encoded message. Bodies are encoded by their
+// own MarshalBinary and UnmarshalBinary methods, such as those generated by
+// protoc-gen-go-binary, so payloads are encoded exactly once. The values of
+// streaming and duplex methods are encoded the same way, and the headers
+// carry every field of rpc.Request and rpc.Response, so that streaming,
+// duplex, chunked calls and progress notifications work over protorpc.
+package protorpc
+
+import (
+	"bufio"
+	"encoding"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// bodyBytes returns the encoding of body. The parts of chunked bodies are
+// sent as they are.
+func bodyBytes(body interface{}) ([]byte, error) {
+	switch b := body.(type) {
+	case encoding.BinaryMarshaler:
+		return b.MarshalBinary()
+	case []byte:
+		return b, nil
+	}
+	return nil, fmt.Errorf("protorpc: %T does not implement encoding.BinaryMarshaler", body)
+}
+
+// readBody reads the next frame into body, or discards it if body is nil.
+func readBody(r *bufio.Reader, body interface{}) error {
+	if body == nil {
+		return skipFrame(r)
+	}
+	b, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalBody(b, body)
+}
+
+func unmarshalBody(b []byte, body interface{}) error {
+	switch u := body.(type) {
+	case encoding.BinaryUnmarshaler:
+		return u.UnmarshalBinary(b)
+	case *[]byte:
+		// A part of a chunked body.
+		*u = b
+		return nil
+	}
+	return fmt.Errorf("protorpc: %T does not implement encoding.BinaryUnmarshaler", body)
+}
+
+// bodyEncoding is the rpc.RawEncoding of protorpc bodies, with which
+// chunked bodies are encoded before being split and decoded once joined.
+type bodyEncoding struct{}
+
+func (bodyEncoding) Marshal(v interface{}) ([]byte, error) {
+	return bodyBytes(v)
+}
+
+func (bodyEncoding) Unmarshal(data []byte, v interface{}) error {
+	return unmarshalBody(data, v)
+}
+
+type clientCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	buf  []byte // reused by WriteRequest, which the client serializes
+}
+
+// NewClientCodec returns a new rpc.ClientCodec using protorpc on conn.
+func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	// Encode the body first, so that a body that cannot be encoded fails the
+	// call without corrupting the stream.
+	var payload []byte
+	if !r.StreamEnd && !r.StreamCancel && r.StreamCredit == 0 {
+		// The control messages of duplex calls have empty bodies.
+		var err error
+		if payload, err = bodyBytes(body); err != nil {
+			return err
+		}
+	}
+	c.buf = appendFrame(c.buf[:0], appendRequest(nil, r))
+	c.buf = appendFrame(c.buf, payload)
+	_, err := c.conn.Write(c.buf)
+	return err
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	b, err := readFrame(c.r)
+	if err != nil {
+		return err
+	}
+	*r = rpc.Response{}
+	return decodeResponse(b, r)
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return readBody(c.r, body)
+}
+
+// BodyEncoding returns the encoding of protorpc bodies.
+func (c *clientCodec) BodyEncoding() rpc.RawEncoding {
+	return bodyEncoding{}
+}
+
+func (c *clientCodec) Close() error {
+	return c.conn.Close()
+}
+
+type serverCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+
+	writeLock  sync.Mutex
+	maxRequest int64 // set by SetMaxRequestBytes
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewServerCodec returns a new rpc.ServerCodec using protorpc on conn.
+func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	b, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	return decodeRequest(b, r)
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	if body == nil {
+		// Discarding a frame does not allocate, so it need not be limited.
+		return skipFrame(c.r)
+	}
+	b, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	return unmarshalBody(b, body)
+}
+
+// SetMaxRequestBytes implements rpc.RequestSizeLimiter.
+func (c *serverCodec) SetMaxRequestBytes(n int64) {
+	c.maxRequest = n
+}
+
+func (c *serverCodec) readFrame() ([]byte, error) {
+	if c.maxRequest > 0 && c.maxRequest < MaxMessageSize {
+		return readFrameLimit(c.r, uint64(c.maxRequest), fmt.Errorf("protorpc: %w", rpc.ErrRequestTooLarge))
+	}
+	return readFrame(c.r)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	var payload []byte
+	// Empty structs are the bodies of the responses carrying no value, such
+	// as progress notifications and the responses ending streams.
+	if t := reflect.TypeOf(body); r.Error == "" && (t == nil || t.Kind() != reflect.Struct || t.NumField() > 0) {
+		var err error
+		if payload, err = bodyBytes(body); err != nil {
+			// Report the failure to the client rather than breaking the
+			// stream.
+			resp := *r
+			resp.Error = err.Error()
+			r, payload = &resp, nil
+		}
+	}
+	buf := appendFrame(nil, appendResponse(nil, r))
+	buf = appendFrame(buf, payload)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// BodyEncoding returns the encoding of protorpc bodies.
+func (c *serverCodec) BodyEncoding() rpc.RawEncoding {
+	return bodyEncoding{}
+}
+
+func (c *serverCodec) SourceAddr() net.Addr {
+	if conn, ok := c.conn.(net.Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+func (c *serverCodec) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+// NewClient returns a new rpc.Client to handle requests to the
+// set of services at the other end of the connection.
+func NewClient(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+// Dial connects to a protorpc server at the specified network address.
+func Dial(network, address string) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
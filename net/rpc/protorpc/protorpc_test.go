@@ -0,0 +1,338 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package protorpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// Pair and Sum stand in for generated messages
+//
+//	message Pair { int64 a = 1; int64 b = 2; }
+//	message Sum { int64 c = 1; }
+type Pair struct {
+	A, B int64
+}
+
+func (p *Pair) MarshalBinary() ([]byte, error) {
+	return appendVarint(appendVarint(nil, 1, uint64(p.A)), 2, uint64(p.B)), nil
+}
+
+func (p *Pair) UnmarshalBinary(b []byte) error {
+	return decodeFields(b, func(num int, v uint64, _ []byte) {
+		switch num {
+		case 1:
+			p.A = int64(v)
+		case 2:
+			p.B = int64(v)
+		}
+	})
+}
+
+type Sum struct {
+	C int64
+}
+
+func (s *Sum) MarshalBinary() ([]byte, error) {
+	return appendVarint(nil, 1, uint64(s.C)), nil
+}
+
+func (s *Sum) UnmarshalBinary(b []byte) error {
+	return decodeFields(b, func(num int, v uint64, _ []byte) {
+		if num == 1 {
+			s.C = int64(v)
+		}
+	})
+}
+
+type Plain struct {
+	C int64
+}
+
+type Arith struct{}
+
+func (Arith) Add(args *Pair, reply *Sum) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (Arith) Div(args *Pair, reply *Sum) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	reply.C = args.A / args.B
+	return nil
+}
+
+func (Arith) Plain(args *Pair, reply *Plain) error {
+	reply.C = args.A
+	return nil
+}
+
+func startClient(t *testing.T) *rpc.Client {
+	srv := rpc.NewServer()
+	if err := srv.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	cli, conn := net.Pipe()
+	go func() {
+		codec := NewServerCodec(conn)
+		defer codec.Close()
+		for {
+			if err := srv.ServeRequest(codec); err == io.EOF || errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+		}
+	}()
+	client := NewClient(cli)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCall(t *testing.T) {
+	client := startClient(t)
+
+	reply := new(Sum)
+	if err := client.Call("Arith.Add", &Pair{A: 7, B: 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d", reply.C)
+	}
+
+	if err := client.Call("Arith.Div", &Pair{A: 7}, reply); err == nil || err.Error() != "divide by zero" {
+		t.Errorf("Div: expected divide by zero, got %v", err)
+	}
+
+	// A reply that cannot be encoded is reported as an error.
+	if err := client.Call("Arith.Plain", &Pair{A: 1}, new(Plain)); err == nil {
+		t.Error("Plain: expected an error for a reply without MarshalBinary")
+	}
+	// An argument that cannot be encoded fails the call alone.
+	if err := client.Call("Arith.Add", struct{}{}, reply); err == nil {
+		t.Error("expected an error for an argument without MarshalBinary")
+	}
+
+	if err := client.Call("Arith.Add", &Pair{A: -1, B: -2}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != -3 {
+		t.Errorf("Add: expected -3, got %d", reply.C)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	req := rpc.Request{
+		ServiceMethod: "Arith.Add",
+		Seq:           300,
+		Timeout:       time.Second,
+		VerifyReply:   true,
+		Metadata:      rpc.Metadata{rpc.MetadataDatacenter: "dc2", rpc.MetadataNode: ""},
+		AuthToken:     "secret",
+		Progress:      true,
+		Stream:        true,
+		Duplex:        true,
+		StreamItem:    true,
+		StreamEnd:     true,
+		StreamCredit:  16,
+		StreamCancel:  true,
+		Chunked:       true,
+		Continued:     true,
+	}
+	b := appendRequest(nil, &req)
+	// Unknown fields of every wire type are skipped.
+	b = append(binary.AppendUvarint(b, 96<<3|wireFixed64), 1, 2, 3, 4, 5, 6, 7, 8)
+	b = append(binary.AppendUvarint(b, 97<<3|wireFixed32), 1, 2, 3, 4)
+	b = appendString(b, 98, "future")
+	b = appendVarint(b, 99, 42)
+	var got rpc.Request
+	if err := decodeRequest(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("got %+v, want %+v", got, req)
+	}
+
+	resp := rpc.Response{
+		ServiceMethod:  "Arith.Add",
+		Seq:            1,
+		Error:          "boom",
+		ErrorCode:      "not_found",
+		ErrorDetails:   map[string]string{"node": "a", "dc": "dc1"},
+		ErrorRetryable: true,
+		Metadata:       rpc.Metadata{"trace": "abc"},
+		Progress:       &rpc.Progress{Percent: 12.5, Message: "reading"},
+		StreamItem:     true,
+		StreamCredit:   8,
+		Chunked:        true,
+		Continued:      true,
+	}
+	var gotResp rpc.Response
+	if err := decodeResponse(appendResponse(nil, &resp), &gotResp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotResp, resp) {
+		t.Errorf("got %+v, want %+v", gotResp, resp)
+	}
+
+	if err := decodeRequest([]byte{1<<3 | wireBytes, 10, 'a'}, &got); err != errMalformedHeader {
+		t.Errorf("expected errMalformedHeader, got %v", err)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	frame := binary.AppendUvarint(nil, MaxMessageSize+1)
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(frame))); err != errMessageTooLarge {
+		t.Errorf("expected errMessageTooLarge, got %v", err)
+	}
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	srv := rpc.NewServerWithOpts(rpc.WithMaxRequestBytes(16))
+	if err := srv.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	cli, conn := net.Pipe()
+	go func() {
+		codec := NewServerCodec(conn)
+		defer codec.Close()
+		for {
+			if err := srv.ServeRequest(codec); err == io.EOF || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, rpc.ErrRequestTooLarge) {
+				return
+			}
+		}
+	}()
+	client := NewClient(cli)
+	defer client.Close()
+
+	reply := new(Sum)
+	if err := client.Call("Arith.Add", &Pair{A: 1, B: 2}, reply); err != nil || reply.C != 3 {
+		t.Fatalf("expected a small request to succeed, got %d, %v", reply.C, err)
+	}
+	// The header of this request is longer than the limit.
+	err := client.Call("Arith.Add"+strings.Repeat("x", 16), &Pair{A: 1, B: 2}, reply)
+	if err == nil {
+		t.Error("expected the request to be refused")
+	}
+}
+
+// Streams sends and receives values over streams.
+type Streams struct{}
+
+// Count sends the sums a+b, a+2b, ... a+b*n for n = args.B.
+func (Streams) Count(ctx context.Context, args *Pair, stream *rpc.SendStream[*Sum]) error {
+	for i := int64(1); i <= args.B; i++ {
+		if err := stream.Send(&Sum{C: args.A + i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add replies to each pair it receives with their sum.
+func (Streams) Add(ctx context.Context, _ *Pair, stream *rpc.DuplexStream[Pair, *Sum]) error {
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&Sum{C: p.A + p.B}); err != nil {
+			return err
+		}
+	}
+}
+
+// Slow reports its progress before replying.
+func (Streams) Slow(ctx context.Context, args *Pair, reply *Sum) error {
+	if err := rpc.ReportProgress(ctx, 50, "halfway"); err != nil {
+		return err
+	}
+	reply.C = args.A + args.B
+	return nil
+}
+
+func TestStreamingCalls(t *testing.T) {
+	srv := rpc.NewServerWithOpts(rpc.WithChunking(2))
+	if err := srv.Register(Streams{}); err != nil {
+		t.Fatal(err)
+	}
+	cli, conn := net.Pipe()
+	go srv.ServeCodecContext(context.Background(), NewServerCodec(conn))
+	client := rpc.NewClientWithOpts(NewClientCodec(cli), rpc.WithClientChunking(2))
+	defer client.Close()
+	ctx := context.Background()
+
+	s, err := rpc.OpenStream[Sum](ctx, client, "Streams.Count", &Pair{A: 10, B: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for {
+		v, err := s.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v.C)
+	}
+	if !reflect.DeepEqual(got, []int64{11, 12, 13}) {
+		t.Errorf("Count: got %v", got)
+	}
+
+	d, err := rpc.OpenDuplex[*Pair, Sum](ctx, client, "Streams.Add", &Pair{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3; i++ {
+		if err := d.Send(&Pair{A: i, B: 100}); err != nil {
+			t.Fatal(err)
+		}
+		v, err := d.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.C != i+100 {
+			t.Errorf("Add: got %d, want %d", v.C, i+100)
+		}
+	}
+	if err := d.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Recv(); err != io.EOF {
+		t.Errorf("expected io.EOF once the call ended, got %v", err)
+	}
+
+	// The args and reply are large enough to be chunked.
+	var progress []rpc.Progress
+	reply := new(Sum)
+	err = client.CallContext(rpc.ContextWithProgress(ctx, func(p rpc.Progress) {
+		progress = append(progress, p)
+	}), "Streams.Slow", &Pair{A: 1000, B: 2000}, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 3000 {
+		t.Errorf("Slow: got %d", reply.C)
+	}
+	if want := []rpc.Progress{{Percent: 50, Message: "halfway"}}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("expected %v, got %v", want, progress)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package protorpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// MaxMessageSize is the largest frame the codecs accept, to stop a corrupt or
+// hostile length prefix from causing an unbounded allocation.
+const MaxMessageSize = 64 << 20
+
+var errMessageTooLarge = fmt.Errorf("protorpc: message exceeds %d bytes", MaxMessageSize)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// The headers are the protobuf messages
+//
+//	message Request {
+//	  string service_method = 1;
+//	  uint64 seq = 2;
+//	  int64 timeout_nanos = 3;
+//	  bool verify_reply = 4;
+//	  map<string, string> metadata = 5;
+//	  string auth_token = 6;
+//	  bool progress = 7;
+//	  bool stream = 8;
+//	  bool duplex = 9;
+//	  bool stream_item = 10;
+//	  bool stream_end = 11;
+//	  int64 stream_credit = 12;
+//	  bool stream_cancel = 13;
+//	  bool chunked = 14;
+//	  bool continued = 15;
+//	}
+//
+//	message Response {
+//	  string service_method = 1;
+//	  uint64 seq = 2;
+//	  string error = 3;
+//	  bool verify_reply = 4;
+//	  string error_code = 5;
+//	  map<string, string> error_details = 6;
+//	  bool error_retryable = 7;
+//	  map<string, string> metadata = 8;
+//	  Progress progress = 9;
+//	  bool stream_item = 10;
+//	  int64 stream_credit = 11;
+//	  bool chunked = 12;
+//	  bool continued = 13;
+//	}
+//
+//	message Progress {
+//	  double percent = 1;
+//	  string message = 2;
+//	}
+//
+// encoded by hand so this package does not depend on a protobuf runtime.
+
+func appendRequest(b []byte, r *rpc.Request) []byte {
+	b = appendString(b, 1, r.ServiceMethod)
+	b = appendVarint(b, 2, r.Seq)
+	b = appendVarint(b, 3, uint64(r.Timeout))
+	b = appendBool(b, 4, r.VerifyReply)
+	b = appendMap(b, 5, r.Metadata)
+	b = appendString(b, 6, r.AuthToken)
+	b = appendBool(b, 7, r.Progress)
+	b = appendBool(b, 8, r.Stream)
+	b = appendBool(b, 9, r.Duplex)
+	b = appendBool(b, 10, r.StreamItem)
+	b = appendBool(b, 11, r.StreamEnd)
+	b = appendVarint(b, 12, uint64(r.StreamCredit))
+	b = appendBool(b, 13, r.StreamCancel)
+	b = appendBool(b, 14, r.Chunked)
+	return appendBool(b, 15, r.Continued)
+}
+
+func appendResponse(b []byte, r *rpc.Response) []byte {
+	b = appendString(b, 1, r.ServiceMethod)
+	b = appendVarint(b, 2, r.Seq)
+	b = appendString(b, 3, r.Error)
+	b = appendBool(b, 4, r.VerifyReply)
+	b = appendString(b, 5, r.ErrorCode)
+	b = appendMap(b, 6, r.ErrorDetails)
+	b = appendBool(b, 7, r.ErrorRetryable)
+	b = appendMap(b, 8, r.Metadata)
+	if r.Progress != nil {
+		// The field is written even if the message is empty, since its
+		// presence marks the response as a notification.
+		var progress []byte
+		if r.Progress.Percent != 0 {
+			progress = binary.AppendUvarint(progress, 1<<3|wireFixed64)
+			progress = binary.LittleEndian.AppendUint64(progress, math.Float64bits(r.Progress.Percent))
+		}
+		progress = appendString(progress, 2, r.Progress.Message)
+		b = binary.AppendUvarint(b, 9<<3|wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(progress)))
+		b = append(b, progress...)
+	}
+	b = appendBool(b, 10, r.StreamItem)
+	b = appendVarint(b, 11, uint64(r.StreamCredit))
+	b = appendBool(b, 12, r.Chunked)
+	return appendBool(b, 13, r.Continued)
+}
+
+func decodeRequest(b []byte, r *rpc.Request) error {
+	var entryErr error
+	err := decodeFields(b, func(num int, v uint64, s []byte) {
+		switch num {
+		case 1:
+			r.ServiceMethod = string(s)
+		case 2:
+			r.Seq = v
+		case 3:
+			r.Timeout = time.Duration(v)
+		case 4:
+			r.VerifyReply = v != 0
+		case 5:
+			if r.Metadata == nil {
+				r.Metadata = make(rpc.Metadata)
+			}
+			if err := decodeMapEntry(s, r.Metadata); err != nil {
+				entryErr = err
+			}
+		case 6:
+			r.AuthToken = string(s)
+		case 7:
+			r.Progress = v != 0
+		case 8:
+			r.Stream = v != 0
+		case 9:
+			r.Duplex = v != 0
+		case 10:
+			r.StreamItem = v != 0
+		case 11:
+			r.StreamEnd = v != 0
+		case 12:
+			r.StreamCredit = int(v)
+		case 13:
+			r.StreamCancel = v != 0
+		case 14:
+			r.Chunked = v != 0
+		case 15:
+			r.Continued = v != 0
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return entryErr
+}
+
+func decodeResponse(b []byte, r *rpc.Response) error {
+	var entryErr error
+	err := decodeFields(b, func(num int, v uint64, s []byte) {
+		switch num {
+		case 1:
+			r.ServiceMethod = string(s)
+		case 2:
+			r.Seq = v
+		case 3:
+			r.Error = string(s)
+		case 4:
+			r.VerifyReply = v != 0
+		case 5:
+			r.ErrorCode = string(s)
+		case 6:
+			if r.ErrorDetails == nil {
+				r.ErrorDetails = make(map[string]string)
+			}
+			if err := decodeMapEntry(s, r.ErrorDetails); err != nil {
+				entryErr = err
+			}
+		case 7:
+			r.ErrorRetryable = v != 0
+		case 8:
+			if r.Metadata == nil {
+				r.Metadata = make(rpc.Metadata)
+			}
+			if err := decodeMapEntry(s, r.Metadata); err != nil {
+				entryErr = err
+			}
+		case 9:
+			r.Progress = new(rpc.Progress)
+			if err := decodeProgress(s, r.Progress); err != nil {
+				entryErr = err
+			}
+		case 10:
+			r.StreamItem = v != 0
+		case 11:
+			r.StreamCredit = int(v)
+		case 12:
+			r.Chunked = v != 0
+		case 13:
+			r.Continued = v != 0
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return entryErr
+}
+
+// appendMap appends the entries of m as a map field, in key order so the
+// encoding is deterministic. Map entries are messages with the key in field 1
+// and the value in field 2.
+func appendMap(b []byte, num int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := appendString(appendString(nil, 1, k), 2, m[k])
+		b = binary.AppendUvarint(b, uint64(num)<<3|wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+	return b
+}
+
+// decodeMapEntry decodes one map entry written by appendMap into m.
+func decodeMapEntry(b []byte, m map[string]string) error {
+	var key, value string
+	err := decodeFields(b, func(num int, _ uint64, s []byte) {
+		switch num {
+		case 1:
+			key = string(s)
+		case 2:
+			value = string(s)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	m[key] = value
+	return nil
+}
+
+// decodeProgress decodes the Progress message of a response.
+func decodeProgress(b []byte, p *rpc.Progress) error {
+	return decodeFields(b, func(num int, v uint64, s []byte) {
+		switch num {
+		case 1:
+			p.Percent = math.Float64frombits(v)
+		case 2:
+			p.Message = string(s)
+		}
+	})
+}
+
+// appendBool appends a bool field, omitting it if it is false.
+func appendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+// appendVarint appends a varint field, omitting it if it has the default
+// value as proto3 does.
+func appendVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+var errMalformedHeader = errors.New("protorpc: malformed header")
+
+// decodeFields calls field for every varint, 64-bit and length-delimited
+// field in b. 32-bit fields are skipped.
+func decodeFields(b []byte, field func(num int, v uint64, s []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformedHeader
+		}
+		b = b[n:]
+		num := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errMalformedHeader
+			}
+			b = b[n:]
+			field(num, v, nil)
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errMalformedHeader
+			}
+			field(num, 0, b[n:n+int(l)])
+			b = b[n+int(l):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errMalformedHeader
+			}
+			field(num, binary.LittleEndian.Uint64(b), nil)
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errMalformedHeader
+			}
+			b = b[4:]
+		default:
+			return errMalformedHeader
+		}
+	}
+	return nil
+}
+
+// readFrame reads one size-delimited message: a varint length followed by
+// that many bytes.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	return readFrameLimit(r, MaxMessageSize, errMessageTooLarge)
+}
+
+// readFrameLimit is like readFrame but fails with errTooLarge for messages
+// longer than max.
+func readFrameLimit(r *bufio.Reader, max uint64, errTooLarge error) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > max {
+		return nil, errTooLarge
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// skipFrame discards one size-delimited message.
+func skipFrame(r *bufio.Reader) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if size > MaxMessageSize {
+		return errMessageTooLarge
+	}
+	if _, err := r.Discard(int(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func appendFrame(b, msg []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
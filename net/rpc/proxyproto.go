@@ -0,0 +1,200 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout bounds the time taken to read a PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts the headers of version 2 of the PROXY protocol.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// WithProxyProtocol makes the server read a PROXY protocol header, of
+// version 1 or 2, from the connections it accepts from trusted peers, such
+// as load balancers, so that SourceAddr reports the client the header
+// names rather than the load balancer. Connections from other peers are
+// served as they are, so with no trusted networks no header is read. A
+// connection from a trusted peer that does not start with a valid header is
+// closed. Headers are read by ServeTLS and Handshake, before the server's
+// ConnHandshake runs.
+func WithProxyProtocol(trusted ...netip.Prefix) func(*Server) {
+	return func(s *Server) {
+		s.proxyTrusted = append(s.proxyTrusted, trusted...)
+	}
+}
+
+// WithProxyProtocolFromAnyPeer is like WithProxyProtocol but trusts every
+// peer to send a PROXY protocol header. Any client reaching the server can
+// then claim any source address, defeating AccessControl and the method
+// filters, so it is only safe for listeners that only load balancers can
+// reach.
+func WithProxyProtocolFromAnyPeer() func(*Server) {
+	return func(s *Server) {
+		s.proxyAnyPeer = true
+	}
+}
+
+// ReadProxyHeader reads the PROXY protocol header, of version 1 or 2, that
+// conn starts with, and returns conn wrapped to report the source address
+// it names as its RemoteAddr and to read what follows the header. Headers
+// naming no address, such as health checks of the load balancer, leave
+// RemoteAddr as it was.
+func ReadProxyHeader(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	remote, err := readProxyHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("rpc: reading PROXY protocol header: %w", err)
+	}
+	return &proxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// readProxyHeader returns the source address of the header r starts with,
+// or nil if it names none.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Read no more than tells the versions apart before reading the header
+	// itself, so that a short header, such as the "PROXY UNKNOWN\r\n" of a
+	// health check, is never waited on for bytes that may not follow it.
+	start, err := r.Peek(len("PROXY "))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	if !bytes.HasPrefix(proxyV2Signature, start) {
+		return nil, errors.New("no header")
+	}
+	if start, err = r.Peek(len(proxyV2Signature)); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(start, proxyV2Signature) {
+		return nil, errors.New("no header")
+	}
+	return readProxyV2(r)
+}
+
+// readProxyV1 reads a version 1 header, a line such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// Lines are at most 107 bytes long.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("version 1 header too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("malformed version 1 header %q", line)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 reads a version 2 header: the signature, the version and
+// command, the address family and protocol, the length of the rest, the
+// addresses, and type-length-value fields, which are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+	rest := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	switch hdr[12] & 0xf {
+	case 0: // LOCAL: a connection of the proxy itself
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported command %d", hdr[12]&0xf)
+	}
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		ipLen = 4
+	case 2: // AF_INET6
+		ipLen = 16
+	default: // unspecified or AF_UNIX
+		return nil, nil
+	}
+	if len(rest) < 2*ipLen+4 {
+		return nil, errors.New("version 2 addresses truncated")
+	}
+	ip, _ := netip.AddrFromSlice(rest[:ipLen])
+	port := binary.BigEndian.Uint16(rest[2*ipLen:])
+	if hdr[13]&0xf == 2 { // DGRAM
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
+
+// proxyConn is a connection that started with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader // holds what was read past the header
+	remote net.Addr      // nil if the header named no address
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the source address named by the header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// readProxyProtocol reads the PROXY protocol header of conn if it comes
+// from a peer WithProxyProtocol trusts.
+func (server *Server) readProxyProtocol(conn net.Conn) (net.Conn, error) {
+	if !server.proxyAnyPeer {
+		ip, ok := peerIP(conn.RemoteAddr())
+		if !ok || !containsAddr(server.proxyTrusted, ip) {
+			return conn, nil
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	pc, err := ReadProxyHeader(conn)
+	conn.SetReadDeadline(time.Time{})
+	return pc, err
+}
@@ -0,0 +1,174 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd, fam byte, addrs []byte) []byte {
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	copy(v6, netip.MustParseAddr("2001:db8::1").AsSlice())
+	binary.BigEndian.PutUint16(v6[32:], 56324)
+	tlv := []byte{0x04, 0x00, 0x01, 0x00} // a no-op field, skipped
+	for _, tt := range []struct {
+		name   string
+		header []byte
+		remote string // empty if the header names no address
+		err    bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), "192.0.2.1:56324", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 malformed", []byte("PROXY TCP4 192.0.2.1\r\n"), "", true},
+		{"v2 IPv4", proxyV2Header(1, 0x11, v4), "192.0.2.1:56324", false},
+		{"v2 IPv4 with TLV", proxyV2Header(1, 0x11, append(v4, tlv...)), "192.0.2.1:56324", false},
+		{"v2 IPv6", proxyV2Header(1, 0x21, v6), "[2001:db8::1]:56324", false},
+		{"v2 LOCAL", proxyV2Header(0, 0x00, nil), "", false},
+		{"v2 truncated", proxyV2Header(1, 0x11, v4[:8]), "", true},
+		{"none", []byte("GET / HTTP/1.1\r\n"), "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+			go c1.Write(append(tt.header, "body"...))
+
+			conn, err := ReadProxyHeader(c2)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.remote
+			if want == "" {
+				want = c2.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("expected remote address %s, got %s", want, got)
+			}
+			body := make([]byte, 4)
+			if _, err := io.ReadFull(conn, body); err != nil || string(body) != "body" {
+				t.Errorf("expected the body to follow the header, got %q, %v", body, err)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderShortest(t *testing.T) {
+	// A health check may send the shortest header and wait for a reply.
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go c1.Write([]byte("PROXY UNKNOWN\r\n"))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ReadProxyHeader(c2)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the header to be read without waiting for more")
+	}
+}
+
+func TestWithProxyProtocol(t *testing.T) {
+	var source atomic.Value
+	newServer := func(proxyProtocol func(*Server)) *Server {
+		srv := NewServerWithOpts(
+			proxyProtocol,
+			WithPreBodyInterceptor(func(serviceMethod string, sourceAddr net.Addr) error {
+				source.Store(sourceAddr.String())
+				return nil
+			}),
+		)
+		if err := srv.Register(new(Arith)); err != nil {
+			t.Fatal(err)
+		}
+		return srv
+	}
+	serve := func(srv *Server, conn net.Conn) {
+		conn, ctx, err := srv.Handshake(conn)
+		if err != nil {
+			return
+		}
+		codec := NewGobServerCodec(conn)
+		defer codec.Close()
+		for srv.ServeRequestAsync(ctx, codec) == nil {
+		}
+	}
+
+	c1, c2 := net.Pipe()
+	go serve(newServer(WithProxyProtocolFromAnyPeer()), c2)
+	if _, err := c1.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(c1)
+	defer client.Close()
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if got := source.Load(); got != "192.0.2.1:56324" {
+		t.Errorf("expected the source address of the header, got %v", got)
+	}
+
+	// With no trusted networks, no peer is trusted.
+	c1, c2 = net.Pipe()
+	go serve(newServer(WithProxyProtocol()), c2)
+	// The server reads the header as a request, and fails to decode it.
+	c1.Write([]byte("PROXY TCP4 203.0.113.1 198.51.100.1 56324 443\r\n"))
+	untrusted := NewClient(c1)
+	defer untrusted.Close()
+	if err := untrusted.Call("Arith.Add", Args{1, 2}, new(Reply)); err == nil {
+		t.Error("expected the header of an untrusted peer to be served as a request")
+	}
+	if got := source.Load(); got == "203.0.113.1:56324" {
+		t.Errorf("expected the header of an untrusted peer to be ignored, got %v", got)
+	}
+
+	// Headers are only read from trusted peers.
+	l, addr := listenTCP(t)
+	srv := newServer(WithProxyProtocol(netip.MustParsePrefix("10.0.0.0/8")))
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			serve(srv, conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewClient(conn)
+	defer client.Close()
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if got := source.Load(); got != conn.LocalAddr().String() {
+		t.Errorf("expected the address of the untrusted peer, got %v", got)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// RawEncoding encodes and decodes the contents of a RawValue.
+type RawEncoding interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// GobEncoding is the RawEncoding for encoding/gob.
+var GobEncoding RawEncoding = gobEncoding{}
+
+type gobEncoding struct{}
+
+func (gobEncoding) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobEncoding) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// RawValue holds a value in its encoded form. Used as a field of an args or
+// reply type, it lets a server decode the small fields it needs for dispatch
+// (such as a datacenter or token) while leaving a large payload encoded until
+// Decode is called, or forwarding it elsewhere without decoding it at all.
+//
+// A RawValue is transmitted as a byte string by any codec that supports
+// encoding.BinaryMarshaler, which includes gob and msgpack. Both peers must
+// agree on the RawEncoding used for its contents.
+type RawValue struct {
+	data []byte
+}
+
+// NewRawValue encodes v with enc and returns it as a RawValue.
+func NewRawValue(enc RawEncoding, v interface{}) (RawValue, error) {
+	data, err := enc.Marshal(v)
+	if err != nil {
+		return RawValue{}, err
+	}
+	return RawValue{data: data}, nil
+}
+
+// RawValueFromBytes returns a RawValue holding data, which must already be
+// encoded. The RawValue takes ownership of data.
+func RawValueFromBytes(data []byte) RawValue {
+	return RawValue{data: data}
+}
+
+// Bytes returns the encoded contents of r. The caller must not modify them.
+func (r RawValue) Bytes() []byte {
+	return r.data
+}
+
+// Len returns the size of the encoded contents of r.
+func (r RawValue) Len() int {
+	return len(r.data)
+}
+
+// Decode decodes the contents of r into v using enc.
+func (r RawValue) Decode(enc RawEncoding, v interface{}) error {
+	return enc.Unmarshal(r.data, v)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (r RawValue) MarshalBinary() ([]byte, error) {
+	return r.data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (r *RawValue) UnmarshalBinary(data []byte) error {
+	r.data = append([]byte(nil), data...)
+	return nil
+}
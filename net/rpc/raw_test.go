@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"testing"
+)
+
+type ForwardArgs struct {
+	Datacenter string
+	Payload    RawValue
+}
+
+type ForwardReply struct {
+	Datacenter  string
+	PayloadSize int
+	Payload     RawValue
+}
+
+type RawEcho struct{}
+
+// Echo reads only the dispatch fields and returns the payload untouched.
+func (RawEcho) Echo(args *ForwardArgs, reply *ForwardReply) error {
+	reply.Datacenter = args.Datacenter
+	reply.PayloadSize = args.Payload.Len()
+	reply.Payload = args.Payload
+	return nil
+}
+
+func TestRawValue(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(RawEcho{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	payload, err := NewRawValue(GobEncoding, &Args{7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply ForwardReply
+	err = client.Call("RawEcho.Echo", &ForwardArgs{Datacenter: "dc2", Payload: payload}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Datacenter != "dc2" || reply.PayloadSize != payload.Len() {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+	var args Args
+	if err := reply.Payload.Decode(GobEncoding, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args != (Args{7, 8}) {
+		t.Errorf("expected payload {7 8}, got %+v", args)
+	}
+}
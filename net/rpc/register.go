@@ -0,0 +1,176 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// WithLazyMethodTables defers building a service's method table, which
+// reflects over every method of the receiver, from registration to the
+// first request for the service or the first call to Describe. Register then
+// no longer reports receivers without suitable methods; requests for them
+// fail with "can't find method" instead. Services present in the schema
+// baseline are still built eagerly so they can be checked.
+func WithLazyMethodTables() func(*Server) {
+	return func(s *Server) {
+		s.lazyMethods = true
+	}
+}
+
+// RegisterAll registers every receiver like Register, in parallel. It
+// returns the errors of the registrations that failed, joined; the other
+// receivers are registered regardless.
+func (server *Server) RegisterAll(rcvrs ...interface{}) error {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(rcvrs) {
+		workers = len(rcvrs)
+	}
+	errs := make([]error, len(rcvrs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = server.Register(rcvrs[i])
+			}
+		}()
+	}
+	for i := range rcvrs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Stability is how much clients may rely on a method staying as it is.
+type Stability string
+
+const (
+	StabilityStable   Stability = "stable"
+	StabilityBeta     Stability = "beta"     // may change in incompatible ways
+	StabilityInternal Stability = "internal" // only for use by the server's own peers
+)
+
+// MethodDoc documents a method. It is returned by Describe and shown by the
+// debug HTTP handler.
+type MethodDoc struct {
+	Description string
+	Stability   Stability // may be empty if unspecified
+}
+
+// RegisterWithDocs is like Register but also attaches documentation to the
+// receiver's methods. docs is keyed by method name; methods without an entry
+// are left undocumented.
+func (server *Server) RegisterWithDocs(rcvr interface{}, docs map[string]MethodDoc) error {
+	return server.register(rcvr, "", false, docs)
+}
+
+// RegisterNameWithDocs is like RegisterName but also attaches documentation
+// to the receiver's methods, as RegisterWithDocs does.
+func (server *Server) RegisterNameWithDocs(name string, rcvr interface{}, docs map[string]MethodDoc) error {
+	return server.register(rcvr, name, true, docs)
+}
+
+// RegisterFunc registers fn as serviceMethod, of the form "Service.Method",
+// so that a few endpoints can be served without a receiver type exporting
+// them. fn has the signature of a method without its receiver:
+//
+//	func(ctx context.Context, args T1, reply *T2) error
+//
+// where ctx is optional. Like with RegisterMethod, several methods of a
+// service may be registered this way, but not methods of a service
+// registered with Register.
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	fail := func(msg string) error {
+		s := "rpc.RegisterFunc: " + serviceMethod + ": " + msg
+		server.logger().Error(s)
+		return errors.New(s)
+	}
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return fail("not a function")
+	}
+	ftype := fv.Type()
+	hasContext := ftype.NumIn() == 3
+	argOffset := 0
+	if hasContext {
+		if ftype.In(0) != typeOfContext {
+			return fail("first of 3 arguments must be a context.Context")
+		}
+		argOffset = 1
+	} else if ftype.NumIn() != 2 {
+		return fail(fmt.Sprintf("function has %d input parameters, want 2 or 3", ftype.NumIn()))
+	}
+	argType := ftype.In(argOffset)
+	if !isExportedOrBuiltinType(argType) {
+		return fail("argument type " + argType.String() + " is not exported")
+	}
+	replyType := ftype.In(argOffset + 1)
+	if replyType.Kind() != reflect.Ptr {
+		return fail("reply type " + replyType.String() + " is not a pointer")
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return fail("reply type " + replyType.String() + " is not exported")
+	}
+	if ftype.NumOut() != 1 || ftype.Out(0) != typeOfError {
+		return fail("function must return a single error")
+	}
+
+	argElem := argType
+	if argType.Kind() == reflect.Ptr {
+		argElem = argType.Elem()
+	}
+	return server.registerMethod("rpc.RegisterFunc", serviceMethod, &methodType{
+		ArgType:    argType,
+		ReplyType:  replyType,
+		HasContext: hasContext,
+		invoke: func(ctx context.Context, argv, replyv reflect.Value) error {
+			in := []reflect.Value{argv, replyv}
+			if hasContext {
+				in = []reflect.Value{reflect.ValueOf(ctx), argv, replyv}
+			}
+			err, _ := fv.Call(in)[0].Interface().(error)
+			return err
+		},
+		argPool:   newValuePool(argElem),
+		replyPool: newValuePool(replyType.Elem()),
+	})
+}
+
+func checkStability(sname string, docs map[string]MethodDoc) error {
+	for mname, doc := range docs {
+		switch doc.Stability {
+		case "", StabilityStable, StabilityBeta, StabilityInternal:
+		default:
+			return fmt.Errorf("rpc.Register: method %s.%s has unknown stability %q", sname, mname, doc.Stability)
+		}
+	}
+	return nil
+}
+
+// checkMethodDocs returns an error if svc has documentation for methods it
+// does not have. Services registered lazily are not checked.
+func checkMethodDocs(svc *service) error {
+	var unknown []string
+	for mname := range svc.docs {
+		if _, ok := svc.methods()[mname]; !ok {
+			unknown = append(unknown, mname)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("rpc.Register: documentation for unknown methods of %s: %v", svc.name, unknown)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type NoMethods struct{}
+
+func TestLazyMethodTables(t *testing.T) {
+	srv := NewServerWithOpts(WithLazyMethodTables())
+	if err := srv.RegisterAll(new(Arith), new(Embed), new(NoMethods)); err != nil {
+		t.Fatal(err)
+	}
+	svci, _ := srv.serviceMap.Load("Arith")
+	if svci.(*service).method != nil {
+		t.Error("expected the method table to be built on first use")
+	}
+
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add: got %d, %v", reply.C, err)
+	}
+	if err := client.Call("NoMethods.Nope", &Args{}, reply); err == nil || !strings.Contains(err.Error(), "can't find method") {
+		t.Errorf("expected can't find method, got %v", err)
+	}
+
+	// Describe builds the remaining tables.
+	if services := srv.Describe(); len(services) != 3 || len(services[1].Methods) != 1 {
+		t.Errorf("unexpected descriptors %+v", services)
+	}
+}
+
+func TestRegisterAll(t *testing.T) {
+	srv := NewServer()
+	err := srv.RegisterAll(new(Arith), new(NoMethods), new(Embed), new(Arith))
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "NoMethods has no exported methods") || !strings.Contains(msg, "service already defined: Arith") {
+		t.Errorf("unexpected error %q", msg)
+	}
+	if _, ok := srv.serviceMap.Load("Embed"); !ok {
+		t.Error("expected Embed to be registered despite the other failures")
+	}
+}
+
+func TestRegisterWithDocs(t *testing.T) {
+	srv := NewServer()
+	err := srv.RegisterWithDocs(new(Arith), map[string]MethodDoc{
+		"Add": {Description: "Adds two numbers.", Stability: StabilityStable},
+		"Mul": {Description: "Multiplies two numbers.", Stability: StabilityBeta},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	methods := srv.Describe()[0].Methods
+	docs := make(map[string]MethodDescriptor)
+	for _, m := range methods {
+		docs[m.Name] = m
+	}
+	if m := docs["Add"]; m.Description != "Adds two numbers." || m.Stability != StabilityStable {
+		t.Errorf("unexpected descriptor for Add: %+v", m)
+	}
+	if m := docs["Mul"]; m.Stability != StabilityBeta {
+		t.Errorf("expected Mul to be beta, got %q", m.Stability)
+	}
+	if m := docs["Div"]; m.Description != "" || m.Stability != "" {
+		t.Errorf("expected Div to be undocumented, got %+v", m)
+	}
+
+	rec := httptest.NewRecorder()
+	debugHTTP{srv}.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/rpc", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "Multiplies two numbers.") || !strings.Contains(body, "beta") {
+		t.Errorf("expected debug page to show method docs, got %s", body)
+	}
+}
+
+func TestRegisterWithDocsErrors(t *testing.T) {
+	srv := NewServer()
+	err := srv.RegisterWithDocs(new(Arith), map[string]MethodDoc{"Pow": {Description: "missing"}})
+	if err == nil || !strings.Contains(err.Error(), "[Pow]") {
+		t.Errorf("expected error for unknown method, got %v", err)
+	}
+	err = srv.RegisterNameWithDocs("Arith2", new(Arith), map[string]MethodDoc{"Add": {Stability: "experimental"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown stability") {
+		t.Errorf("expected error for unknown stability, got %v", err)
+	}
+	if len(srv.Describe()) != 0 {
+		t.Error("expected failed registrations to register nothing")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	srv := NewServer()
+	err := srv.RegisterFunc("Calc.Add", func(ctx context.Context, args Args, reply *Reply) error {
+		reply.C = args.A + args.B
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.RegisterFunc("Calc.Div", func(args *Args, reply *Reply) error {
+		if args.B == 0 {
+			return errors.New("divide by zero")
+		}
+		reply.C = args.A / args.B
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Calc.Add", Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d, %v", reply.C, err)
+	}
+	if err := client.Call("Calc.Div", Args{16, 8}, reply); err != nil || reply.C != 2 {
+		t.Errorf("Div: expected 2, got %d, %v", reply.C, err)
+	}
+	if err := client.Call("Calc.Div", Args{16, 0}, reply); err == nil || err.Error() != "divide by zero" {
+		t.Errorf("Div: expected divide by zero, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		fn  interface{}
+		err string
+	}{
+		{nil, "not a function"},
+		{(func(Args, *Reply) error)(nil), "not a function"},
+		{func(Args) error { return nil }, "input parameters"},
+		{func(int, Args, *Reply) error { return nil }, "context.Context"},
+		{func(Args, Reply) error { return nil }, "not a pointer"},
+		{func(local, *Reply) error { return nil }, "not exported"},
+		{func(Args, *Reply) {}, "single error"},
+	} {
+		if err := srv.RegisterFunc("Calc.Bad", tt.fn); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%T: expected %q, got %v", tt.fn, tt.err, err)
+		}
+	}
+	if err := srv.RegisterFunc("Calc.Add", func(Args, *Reply) error { return nil }); err == nil {
+		t.Error("expected registering Calc.Add twice to fail")
+	}
+}
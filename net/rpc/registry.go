@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+)
+
+// CodecRegistry maps codec names to ServerCodec constructors so that a single
+// listener can serve clients using different codecs, for example during a
+// migration from one codec to another. A client announces its codec with
+// NegotiateCodec and the server constructs the matching ServerCodec with
+// NewServerCodec.
+type CodecRegistry struct {
+	mu     sync.RWMutex // protects codecs
+	codecs map[string]func(conn net.Conn) ServerCodec
+}
+
+// NewCodecRegistry returns a CodecRegistry holding the "gob" codec used by
+// NewClient.
+func NewCodecRegistry() *CodecRegistry {
+	r := &CodecRegistry{codecs: make(map[string]func(net.Conn) ServerCodec)}
+	r.Register("gob", newGobServerCodec)
+	return r
+}
+
+// Register adds a codec under name, replacing any codec of the same name.
+// Names are at most 255 bytes long.
+func (r *CodecRegistry) Register(name string, newCodec func(conn net.Conn) ServerCodec) {
+	if len(name) == 0 || len(name) > 255 {
+		panic("rpc: invalid codec name " + name)
+	}
+	r.mu.Lock()
+	r.codecs[name] = newCodec
+	r.mu.Unlock()
+}
+
+// Names returns the names of the registered codecs, sorted.
+func (r *CodecRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.codecs))
+	for name := range r.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewServerCodec reads the codec name sent by a client calling
+// NegotiateCodec and returns the matching ServerCodec. If the codec is not
+// registered, the client is told so and an error is returned; the caller
+// should close conn.
+func (r *CodecRegistry) NewServerCodec(conn net.Conn) (ServerCodec, error) {
+	name, err := readName(conn)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	newCodec := r.codecs[name]
+	r.mu.RUnlock()
+
+	ack := []byte{0}
+	if newCodec != nil {
+		ack = append([]byte{byte(len(name))}, name...)
+	}
+	if _, err := conn.Write(ack); err != nil {
+		return nil, err
+	}
+	if newCodec == nil {
+		return nil, errors.New("rpc: unknown codec " + name)
+	}
+	return newCodec(conn), nil
+}
+
+// NegotiateCodec tells the server at the other end of conn that the client
+// will use the codec registered under name. It returns an error if the
+// server does not support that codec. It must be called before any other
+// data is written to conn.
+func NegotiateCodec(conn net.Conn, name string) error {
+	if len(name) == 0 || len(name) > 255 {
+		return errors.New("rpc: invalid codec name " + name)
+	}
+	if _, err := conn.Write(append([]byte{byte(len(name))}, name...)); err != nil {
+		return err
+	}
+	ack, err := readName(conn)
+	if err != nil {
+		return err
+	}
+	if ack != name {
+		return errors.New("rpc: server does not support codec " + name)
+	}
+	return nil
+}
+
+// NewGobServerCodec returns a ServerCodec for the gob encoding NewClient
+// uses, for serving connections without negotiating a codec.
+func NewGobServerCodec(conn net.Conn) ServerCodec {
+	return newGobServerCodec(conn)
+}
+
+func newGobServerCodec(conn net.Conn) ServerCodec {
+	counted := &byteCountingConn{Conn: conn}
+	buf := bufio.NewWriter(counted)
+	return &gobServerCodec{
+		conn:    conn,
+		counted: counted,
+		dec:     gob.NewDecoder(counted),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+	}
+}
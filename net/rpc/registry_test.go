@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCodecRegistry(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	registry := NewCodecRegistry()
+	registry.Register("gob-alias", newGobServerCodec)
+	if names := registry.Names(); len(names) != 2 || names[0] != "gob" || names[1] != "gob-alias" {
+		t.Errorf("unexpected codec names %v", names)
+	}
+
+	l, addr := listenTCP(t)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				codec, err := registry.NewServerCodec(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer codec.Close()
+				for srv.ServeRequest(codec) == nil {
+				}
+			}()
+		}
+	}()
+
+	for _, name := range []string{"gob", "gob-alias"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NegotiateCodec(conn, name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		client := NewClient(conn)
+		reply := new(Reply)
+		if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if reply.C != 15 {
+			t.Errorf("%s: expected 15, got %d", name, reply.C)
+		}
+		client.Close()
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := NegotiateCodec(conn, "xml"); err == nil {
+		t.Error("expected an error negotiating an unknown codec")
+	}
+}
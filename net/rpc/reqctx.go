@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+)
+
+// requestValues holds the values a server gives the context of a request:
+// its peer and identity, its logger and timing, the metadata of its response
+// and the concurrency slot it holds. Serving a request adds them to its
+// context as a single value rather than one value each.
+type requestValues struct {
+	peer     *Peer
+	ownPeer  Peer // what peer points to, unless the source address is a *Peer
+	identity *Identity
+	log      requestLog
+	timing   RequestTiming
+	timed    bool // timing is set
+	metadata *responseMetadata
+	yield    *yieldState
+
+	ownMetadata responseMetadata // what metadata points to, unless inherited
+}
+
+type requestValuesKey struct{}
+
+// withRequestValues returns a copy of ctx carrying new requestValues for a
+// request from sourceAddr. The calls a handler makes in process with its
+// context keep the identity, response metadata and concurrency slot of the
+// handler's request, and its peer if they have no source address.
+func withRequestValues(ctx context.Context, sourceAddr net.Addr) (context.Context, *requestValues) {
+	rv := new(requestValues)
+	if outer := requestValuesFrom(ctx); outer != nil {
+		rv.peer = outer.peer
+		rv.identity = outer.identity
+		rv.metadata = outer.metadata
+		rv.yield = outer.yield
+	}
+	if p, ok := sourceAddr.(*Peer); ok {
+		rv.peer = p
+	} else if sourceAddr != nil {
+		rv.ownPeer.Addr = sourceAddr
+		rv.peer = &rv.ownPeer
+	}
+	return context.WithValue(ctx, requestValuesKey{}, rv), rv
+}
+
+// requestValuesFrom returns the requestValues of the request ctx belongs
+// to, or nil outside a request.
+func requestValuesFrom(ctx context.Context) *requestValues {
+	rv, _ := ctx.Value(requestValuesKey{}).(*requestValues)
+	return rv
+}
+
+// collectResponseMetadata makes the request collect the metadata its
+// handler sets for the response.
+func (rv *requestValues) collectResponseMetadata() {
+	rv.metadata = &rv.ownMetadata
+}
@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestRequestValues(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8300}
+	ctx, rv := withRequestValues(context.Background(), addr)
+	rv.identity = &Identity{Name: "alice"}
+	rv.collectResponseMetadata()
+	if p, ok := PeerFromContext(ctx); !ok || p.Addr != addr {
+		t.Errorf("expected the peer at %v, got %v", addr, p)
+	}
+
+	// Calls made in process by a handler keep its identity and response
+	// metadata, and take the peer they are given.
+	peer := &Peer{Addr: addr}
+	inner, _ := withRequestValues(ctx, peer)
+	if id, ok := IdentityFromContext(inner); !ok || id.Name != "alice" {
+		t.Errorf("expected the outer identity, got %+v, %v", id, ok)
+	}
+	if p, _ := PeerFromContext(inner); p != peer {
+		t.Errorf("expected the inner peer to be reused, got %v", p)
+	}
+	SetResponseMetadata(inner, Metadata{"k": "v"})
+	if md := rv.metadata.take(); md["k"] != "v" {
+		t.Errorf("expected the metadata set in process, got %v", md)
+	}
+
+	if _, ok := PeerFromContext(context.Background()); ok {
+		t.Error("expected no peer outside a request")
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// RetryPolicy configures automatic retries of failed calls.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a call is made, including the
+	// first. Values below 2 disable retries.
+	MaxAttempts int
+	// Backoff returns the delay before the given retry, counting from 1. It
+	// defaults to ExponentialBackoff(50*time.Millisecond, 2*time.Second).
+	Backoff func(retry int) time.Duration
+	// RetryOn reports whether a call that failed with err should be retried.
+	// By default, the calls retried are those the server marked retryable
+	// and those that failed on a broken connection before any of their
+	// request was written. Calls that may have reached the server can run
+	// twice if they are retried, so RetryOn should accept them only for
+	// idempotent methods.
+	RetryOn func(err error) bool
+}
+
+// ExponentialBackoff returns a RetryPolicy.Backoff that waits base before the
+// first retry and doubles the delay for every retry after it, up to max.
+func ExponentialBackoff(base, max time.Duration) func(retry int) time.Duration {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// WithRetryPolicy makes Call and CallContext retry calls that fail with an
+// error policy.RetryOn accepts. Calls are not retried once the client is shut
+// down, since they would fail the same way; use a ClientPool with
+// WithPoolRetryPolicy to retry on a new connection. Calls made with Go are
+// never retried.
+func WithRetryPolicy(policy RetryPolicy) func(*Client) {
+	return func(c *Client) {
+		c.retryPolicy = &policy
+	}
+}
+
+// WithPoolRetryPolicy makes the pool retry calls that fail with an error
+// policy.RetryOn accepts. Connections that are shut down are dropped from the
+// pool, so calls retried after failing on a broken connection are made on
+// another.
+func WithPoolRetryPolicy(policy RetryPolicy) func(*ClientPool) {
+	return func(p *ClientPool) {
+		p.retryPolicy = &policy
+	}
+}
+
+// MarkRetryable returns err marked as safe to retry. The mark is sent to
+// clients, which report it through IsRetryable, and the default RetryOn
+// retries such calls even though the server received them. The returned
+// error wraps err, so errors.Is and errors.As still find it and its code and
+// details, if it is or wraps a *CodedError, are sent as well.
+func MarkRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err}
+}
+
+// retryableError is an error marked with MarkRetryable.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// IsRetryable reports whether err is an error the server marked retryable
+// with MarkRetryable.
+func IsRetryable(err error) bool {
+	var marked *retryableError
+	if errors.As(err, &marked) {
+		return true
+	}
+	var coded *CodedError
+	return errors.As(err, &coded) && coded.Retryable
+}
+
+// isBrokenConn reports whether err is an error from a connection that is
+// broken or could not be made.
+func isBrokenConn(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrShutdown) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// attempt records whether any of the request of one try of a call may have
+// been written, in which case the server may have run it.
+type attempt struct {
+	written bool
+}
+
+type attemptKey struct{}
+
+// attemptFromContext returns the attempt a call made with ctx belongs to, or
+// nil if the call is not retried.
+func attemptFromContext(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// do calls call until it succeeds, fails with an error that should not be
+// retried, or has been made MaxAttempts times. It stops early when ctx is
+// done or stop reports true, returning the last error. call is given a
+// context carrying the attempt, in which it records whether its request was
+// written.
+func (p *RetryPolicy) do(ctx context.Context, call func(context.Context) error, stop func() bool) error {
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(50*time.Millisecond, 2*time.Second)
+	}
+	var err error
+	for n := 1; ; n++ {
+		a := new(attempt)
+		err = call(context.WithValue(ctx, attemptKey{}, a))
+		if outer := attemptFromContext(ctx); outer != nil && a.written {
+			// The call was retried inside a retried call.
+			outer.written = true
+		}
+		if err == nil || n >= p.MaxAttempts || !p.retryOn(err, a) || ctx.Err() != nil || stop() {
+			return err
+		}
+		timer := time.NewTimer(backoff(n))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// retryOn reports whether a call that failed with err in attempt a should be
+// retried.
+func (p *RetryPolicy) retryOn(err error, a *attempt) bool {
+	if p.RetryOn != nil {
+		return p.RetryOn(err)
+	}
+	return IsRetryable(err) || !a.written && isBrokenConn(err)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Flaky struct {
+	calls    atomic.Int32
+	failures int32
+}
+
+// Get fails with a retryable error until it has been called more than
+// failures times.
+func (f *Flaky) Get(args int, reply *int) error {
+	n := f.calls.Add(1)
+	if n <= f.failures {
+		return MarkRetryable(&CodedError{Code: "busy", Message: "try again"})
+	}
+	*reply = int(n)
+	return nil
+}
+
+func (f *Flaky) Fail(args int, reply *int) error {
+	f.calls.Add(1)
+	return errors.New("permanent")
+}
+
+func TestClientRetryPolicy(t *testing.T) {
+	flaky := &Flaky{failures: 2}
+	srv := NewServer()
+	srv.Register(flaky)
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encBuf := bufio.NewWriter(conn)
+	codec := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	client := NewClientWithOpts(codec, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+	}))
+	defer client.Close()
+
+	var reply int
+	if err := client.Call("Flaky.Get", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != 3 {
+		t.Errorf("expected success on the third attempt, got %d", reply)
+	}
+
+	flaky.calls.Store(0)
+	err = client.Call("Flaky.Fail", 0, &reply)
+	if err == nil || IsRetryable(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if n := flaky.calls.Load(); n != 1 {
+		t.Errorf("expected permanent errors not to be retried, got %d calls", n)
+	}
+
+	flaky.calls.Store(0)
+	flaky.failures = 5
+	err = client.Call("Flaky.Get", 0, &reply)
+	if !IsRetryable(err) || ErrorCode(err) != "busy" {
+		t.Errorf("expected the retryable error after the last attempt, got %v", err)
+	}
+	if n := flaky.calls.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestPoolRetryPolicy(t *testing.T) {
+	srv := NewServer()
+	srv.Register(new(Arith))
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	pool := NewClientPool(func() (*Client, error) {
+		return Dial("tcp", addr)
+	}, WithPoolRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return 10 * time.Millisecond },
+		// Arith.Add can safely run twice.
+		RetryOn: func(error) bool { return true },
+	}))
+	defer pool.Close()
+
+	reply := new(Reply)
+	if err := pool.Call("Arith.Add", Args{1, 2}, reply); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range srv.ActiveConns() {
+		srv.CloseConn(addr)
+	}
+
+	if err := pool.Call("Arith.Add", Args{3, 4}, reply); err != nil {
+		t.Fatalf("expected the call to be retried on a new connection, got %v", err)
+	}
+	if reply.C != 7 {
+		t.Errorf("expected 7, got %d", reply.C)
+	}
+	if stats := pool.Stats(); stats.Dialed != 2 || stats.Open != 1 {
+		t.Errorf("expected the broken connection to be replaced, got %+v", stats)
+	}
+}
+
+func TestRetryWrittenCalls(t *testing.T) {
+	srv := NewServer()
+	blocker := newBlocker()
+	srv.Register(blocker)
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	dials := 0
+	pool := NewClientPool(func() (*Client, error) {
+		if dials++; dials == 1 {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		}
+		return Dial("tcp", addr)
+	}, WithPoolDialBackoff(func(int) time.Duration { return 0 }), WithPoolRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+	}))
+	defer pool.Close()
+
+	// The failed dial wrote nothing, so the call is retried. Once the
+	// request was written, a broken connection fails the call.
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.Call("Blocker.Block", &Args{}, new(Reply))
+	}()
+	<-blocker.started
+	for _, addr := range srv.ActiveConns() {
+		srv.CloseConn(addr)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	close(blocker.release)
+	if n := len(blocker.started); n != 0 {
+		t.Errorf("expected the written call not to be retried, got %d more attempts", n)
+	}
+	if dials != 2 {
+		t.Errorf("expected the call to be retried after the failed dial, got %d dials", dials)
+	}
+}
+
+func TestMarkRetryable(t *testing.T) {
+	coded := &CodedError{Code: "busy", Message: "try again"}
+	err := MarkRetryable(fmt.Errorf("get: %w", coded))
+	var found *CodedError
+	if !IsRetryable(err) || !errors.Is(err, coded) || !errors.As(err, &found) || found != coded {
+		t.Errorf("expected %v to be retryable and wrap %v", err, coded)
+	}
+
+	var resp Response
+	resp.setError(err)
+	err = ResponseError(&resp)
+	if !IsRetryable(err) || ErrorCode(err) != "busy" || err.Error() != "get: try again" {
+		t.Errorf("expected the mark and code to be sent, got %v", err)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for retry, expected := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := backoff(retry + 1); d != expected*time.Millisecond {
+			t.Errorf("retry %d: expected %v, got %v", retry+1, expected*time.Millisecond, d)
+		}
+	}
+}
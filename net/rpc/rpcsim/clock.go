@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpcsim
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a manual clock. Time stands still until Advance moves it, firing
+// the timers that come due in order, so a test decides exactly when delayed
+// traffic is delivered.
+type Clock struct {
+	mu     sync.Mutex // protects following
+	now    time.Time
+	timers []*Timer
+	seq    uint64 // orders timers due at the same time
+}
+
+// NewClock returns a Clock reading start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Timer calls a function when its Clock reaches a time.
+type Timer struct {
+	clock *Clock
+	at    time.Time
+	seq   uint64
+	f     func()
+}
+
+// AfterFunc calls f once the clock has advanced by d, from the goroutine
+// calling Advance. f is called at once if d is not positive.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *Timer {
+	if d <= 0 {
+		f()
+		return &Timer{clock: c}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &Timer{clock: c, at: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Stop prevents the timer from firing. It reports whether it was pending.
+func (t *Timer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns the number of timers that have not fired.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Advance moves the clock forward by d, firing the timers due by then in
+// the order they are due, each with the clock reading its due time.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		sort.Slice(c.timers, func(i, j int) bool {
+			a, b := c.timers[i], c.timers[j]
+			return a.at.Before(b.at) || (a.at.Equal(b.at) && a.seq < b.seq)
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+		c.mu.Unlock()
+		t.f()
+	}
+}
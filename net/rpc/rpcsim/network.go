@@ -0,0 +1,354 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rpcsim simulates a network of RPC servers and clients in one
+// process. Nodes of a Network listen and dial by name over in-memory
+// connections, and the test controlling the Network scripts faults:
+// partitions between groups of nodes, severed connections and latency
+// between pairs of nodes. Delayed traffic is delivered as the Network's
+// Clock is advanced, so a test decides when each message arrives and can
+// exercise pools, balancers, retries and forwarding deterministically.
+//
+// Only the network runs on the simulated clock. Timers inside the rpc
+// package, such as retry backoff and call timeouts, use real time.
+package rpcsim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// Errors returned by dials and connections of a Network.
+var (
+	// ErrUnreachable is returned when a partition separates two nodes, and
+	// by connections broken by a partition or Sever.
+	ErrUnreachable = errors.New("rpcsim: host unreachable")
+	// ErrConnRefused is returned when dialing a node that is not listening.
+	ErrConnRefused = errors.New("rpcsim: connection refused")
+)
+
+// codecName is the codec nodes negotiate when they connect.
+const codecName = "gob"
+
+// Network connects simulated nodes.
+type Network struct {
+	clock *Clock
+
+	mu             sync.Mutex // protects following
+	listeners      map[string]*listener
+	groups         map[string]int // partition group of each listed node
+	partitioned    bool
+	latency        map[link]time.Duration
+	defaultLatency time.Duration
+	pipes          map[*pipe]struct{}
+}
+
+// link is an unordered pair of nodes.
+type link struct{ a, b string }
+
+func linkOf(a, b string) link {
+	if b < a {
+		a, b = b, a
+	}
+	return link{a, b}
+}
+
+// NewNetwork returns a Network delivering traffic on clock. If clock is nil,
+// the Network uses a new Clock and has no latency unless SetLatency or
+// SetDefaultLatency adds some.
+func NewNetwork(clock *Clock) *Network {
+	if clock == nil {
+		clock = NewClock(time.Unix(0, 0))
+	}
+	return &Network{
+		clock:     clock,
+		listeners: make(map[string]*listener),
+		latency:   make(map[link]time.Duration),
+		pipes:     make(map[*pipe]struct{}),
+	}
+}
+
+// Clock returns the clock the network delivers traffic on.
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// SetLatency sets the time traffic between nodes a and b takes, in either
+// direction.
+func (n *Network) SetLatency(a, b string, d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency[linkOf(a, b)] = d
+}
+
+// SetDefaultLatency sets the time traffic takes between nodes without a
+// latency of their own.
+func (n *Network) SetDefaultLatency(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.defaultLatency = d
+}
+
+func (n *Network) latencyOf(a, b string) time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if d, ok := n.latency[linkOf(a, b)]; ok {
+		return d
+	}
+	return n.defaultLatency
+}
+
+// Partition splits the network into groups: nodes can only reach nodes of
+// their own group, and nodes not listed form one more group. Connections
+// crossing groups are broken. It replaces any earlier partition. To script
+// a partition, call it from a function passed to Clock.AfterFunc.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	n.partitioned = true
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, name := range group {
+			n.groups[name] = i + 1
+		}
+	}
+	var broken []*pipe
+	for p := range n.pipes {
+		if !n.reachableLocked(p.client, p.server) {
+			broken = append(broken, p)
+		}
+	}
+	n.mu.Unlock()
+	for _, p := range broken {
+		p.fail(ErrUnreachable)
+	}
+}
+
+// Isolate cuts node off from every other node.
+func (n *Network) Isolate(node string) {
+	n.Partition([]string{node})
+}
+
+// Heal removes the partition. Connections it broke stay broken.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partitioned = false
+	n.groups = nil
+}
+
+// Sever breaks the connections between nodes a and b, as a connection
+// reset would, without preventing new ones.
+func (n *Network) Sever(a, b string) {
+	want := linkOf(a, b)
+	n.mu.Lock()
+	var broken []*pipe
+	for p := range n.pipes {
+		if linkOf(p.client, p.server) == want {
+			broken = append(broken, p)
+		}
+	}
+	n.mu.Unlock()
+	for _, p := range broken {
+		p.fail(ErrUnreachable)
+	}
+}
+
+func (n *Network) reachableLocked(a, b string) bool {
+	return !n.partitioned || n.groups[a] == n.groups[b]
+}
+
+// InFlight returns the number of writes not yet delivered because of
+// latency. A test can wait for it to reach the number of messages it
+// expects before advancing the clock.
+func (n *Network) InFlight() int {
+	n.mu.Lock()
+	pipes := make([]*pipe, 0, len(n.pipes))
+	for p := range n.pipes {
+		pipes = append(pipes, p)
+	}
+	n.mu.Unlock()
+	now := n.clock.Now()
+	var count int
+	for _, p := range pipes {
+		count += p.toServer.undelivered(now) + p.toClient.undelivered(now)
+	}
+	return count
+}
+
+// Close closes every listener and connection of the network.
+func (n *Network) Close() {
+	n.mu.Lock()
+	listeners := n.listeners
+	n.listeners = make(map[string]*listener)
+	pipes := n.pipes
+	n.pipes = make(map[*pipe]struct{})
+	n.mu.Unlock()
+	for _, l := range listeners {
+		l.close()
+	}
+	for p := range pipes {
+		p.fail(net.ErrClosed)
+	}
+}
+
+// Node returns the node named name. Nodes exist as soon as they are named.
+func (n *Network) Node(name string) *Node {
+	return &Node{network: n, name: name}
+}
+
+// Node is a host on a Network. Its name is its address.
+type Node struct {
+	network *Network
+	name    string
+}
+
+// Name returns the node's name.
+func (node *Node) Name() string {
+	return node.name
+}
+
+// Listen returns a listener accepting connections to the node.
+func (node *Node) Listen() (net.Listener, error) {
+	n := node.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.listeners[node.name]; ok {
+		return nil, errors.New("rpcsim: " + node.name + " is already listening")
+	}
+	l := &listener{network: n, addr: Addr(node.name), conns: make(chan net.Conn), done: make(chan struct{})}
+	n.listeners[node.name] = l
+	return l, nil
+}
+
+// Serve serves srv's requests on the node until the network is closed.
+// Requests on each connection are served concurrently, as with
+// rpc.Server.ServeRequestAsync.
+func (node *Node) Serve(srv *rpc.Server) error {
+	l, err := node.Listen()
+	if err != nil {
+		return err
+	}
+	registry := rpc.NewCodecRegistry()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				codec, err := registry.NewServerCodec(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer codec.Close()
+				for srv.ServeRequestAsync(context.Background(), codec) == nil {
+				}
+			}()
+		}
+	}()
+	return nil
+}
+
+// DialConn opens a connection from the node to the node named address.
+func (node *Node) DialConn(ctx context.Context, address string) (net.Conn, error) {
+	n := node.network
+	n.mu.Lock()
+	l := n.listeners[address]
+	reachable := n.reachableLocked(node.name, address)
+	n.mu.Unlock()
+	if !reachable {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: Addr(address), Err: ErrUnreachable}
+	}
+	if l == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: Addr(address), Err: ErrConnRefused}
+	}
+	p := newPipe(n, node.name, address)
+	select {
+	case l.conns <- p.serverConn():
+	case <-l.done:
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: Addr(address), Err: ErrConnRefused}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	n.mu.Lock()
+	n.pipes[p] = struct{}{}
+	n.mu.Unlock()
+	return p.clientConn(), nil
+}
+
+// Transport returns an rpc.Transport connecting from the node to servers
+// started with Serve.
+func (node *Node) Transport() rpc.Transport {
+	return rpc.Transport{
+		Name: "rpcsim",
+		Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
+			conn, err := node.DialConn(ctx, address)
+			if err != nil {
+				return nil, err
+			}
+			if err := rpc.NegotiateCodec(conn, codecName); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+}
+
+// Dial connects a client on the node to the server at address, ignoring
+// network. Its signature suits rpc.WithMultiDialer and rpc.NewClientPool.
+func (node *Node) Dial(network, address string) (*rpc.Client, error) {
+	return rpc.NewDialer(rpc.WithTransports(node.Transport())).DialContext(context.Background(), network, address)
+}
+
+// network is the name of the Network's address family.
+const network = "rpcsim"
+
+// Addr is the address of a node.
+type Addr string
+
+func (a Addr) Network() string { return network }
+
+func (a Addr) String() string { return string(a) }
+
+type listener struct {
+	network *Network
+	addr    Addr
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: network, Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+func (l *listener) Close() error {
+	n := l.network
+	n.mu.Lock()
+	if n.listeners[string(l.addr)] == l {
+		delete(n.listeners, string(l.addr))
+	}
+	n.mu.Unlock()
+	l.close()
+	return nil
+}
+
+func (l *listener) close() {
+	l.once.Do(func() { close(l.done) })
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,184 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpcsim
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// pipe is a connection between two nodes.
+type pipe struct {
+	network  *Network
+	client   string // the dialing node
+	server   string
+	toServer *stream
+	toClient *stream
+}
+
+func newPipe(n *Network, client, server string) *pipe {
+	return &pipe{
+		network:  n,
+		client:   client,
+		server:   server,
+		toServer: newStream(n.clock),
+		toClient: newStream(n.clock),
+	}
+}
+
+func (p *pipe) clientConn() net.Conn {
+	return &conn{pipe: p, local: p.client, remote: p.server, in: p.toClient, out: p.toServer}
+}
+
+func (p *pipe) serverConn() net.Conn {
+	return &conn{pipe: p, local: p.server, remote: p.client, in: p.toServer, out: p.toClient}
+}
+
+// fail breaks both directions of the pipe with err.
+func (p *pipe) fail(err error) {
+	p.toServer.fail(err)
+	p.toClient.fail(err)
+	p.forget()
+}
+
+func (p *pipe) forget() {
+	p.network.mu.Lock()
+	delete(p.network.pipes, p)
+	p.network.mu.Unlock()
+}
+
+// chunk is a write, delivered once the clock reaches at.
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+// stream carries the writes of one end of a pipe to the other.
+type stream struct {
+	clock *Clock
+
+	mu     sync.Mutex // protects following
+	wake   *sync.Cond // signaled when a chunk may be due or the stream fails
+	chunks []chunk
+	eof    bool  // the writer closed its end
+	err    error // set if the stream is broken
+}
+
+func newStream(clock *Clock) *stream {
+	s := &stream{clock: clock}
+	s.wake = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *stream) write(b []byte, latency time.Duration) (int, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return 0, s.err
+	}
+	if s.eof {
+		s.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	s.chunks = append(s.chunks, chunk{data: append([]byte(nil), b...), at: s.clock.Now().Add(latency)})
+	s.mu.Unlock()
+	s.clock.AfterFunc(latency, s.signal)
+	return len(b), nil
+}
+
+func (s *stream) signal() {
+	s.mu.Lock()
+	s.wake.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *stream) read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if len(s.chunks) > 0 && !s.chunks[0].at.After(s.clock.Now()) {
+			n := copy(b, s.chunks[0].data)
+			if s.chunks[0].data = s.chunks[0].data[n:]; len(s.chunks[0].data) == 0 {
+				s.chunks = s.chunks[1:]
+			}
+			return n, nil
+		}
+		if len(s.chunks) == 0 && s.eof {
+			return 0, io.EOF
+		}
+		s.wake.Wait()
+	}
+}
+
+// closeWrite lets the reader drain the stream and then read io.EOF.
+func (s *stream) closeWrite() {
+	s.mu.Lock()
+	s.eof = true
+	s.wake.Broadcast()
+	s.mu.Unlock()
+}
+
+// fail breaks the stream, discarding undelivered writes.
+func (s *stream) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+		s.chunks = nil
+	}
+	s.wake.Broadcast()
+	s.mu.Unlock()
+}
+
+// undelivered returns the number of writes not yet due at now.
+func (s *stream) undelivered(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, c := range s.chunks {
+		if c.at.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// conn is one end of a pipe. Deadlines are not supported.
+type conn struct {
+	pipe          *pipe
+	local, remote string
+	in, out       *stream
+	closeOnce     sync.Once
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return c.in.read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	return c.out.write(b, c.pipe.network.latencyOf(c.local, c.remote))
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.out.closeWrite()
+		c.in.fail(net.ErrClosed)
+		c.pipe.forget()
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return Addr(c.local) }
+func (c *conn) RemoteAddr() net.Addr { return Addr(c.remote) }
+
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpcsim
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+type Args struct{ A, B int }
+
+type Reply struct{ C int }
+
+type Arith struct{}
+
+func (Arith) Add(args Args, reply *Reply) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newServer(t *testing.T, node *Node) {
+	t.Helper()
+	srv := rpc.NewServer()
+	if err := srv.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Serve(srv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	var fired []int
+	clock.AfterFunc(20*time.Millisecond, func() { fired = append(fired, 2) })
+	clock.AfterFunc(10*time.Millisecond, func() { fired = append(fired, 1) })
+	stopped := clock.AfterFunc(15*time.Millisecond, func() { fired = append(fired, 0) })
+	if !stopped.Stop() {
+		t.Error("timer was not pending")
+	}
+	clock.Advance(10 * time.Millisecond)
+	if len(fired) != 1 || clock.Pending() != 1 {
+		t.Fatalf("fired %v with %d pending", fired, clock.Pending())
+	}
+	clock.Advance(time.Hour)
+	if len(fired) != 2 || fired[1] != 2 || !clock.Now().Equal(time.Unix(3600, 10e6)) {
+		t.Errorf("fired %v at %v", fired, clock.Now())
+	}
+}
+
+func TestLatency(t *testing.T) {
+	network := NewNetwork(nil)
+	defer network.Close()
+	newServer(t, network.Node("server"))
+	client, err := network.Node("client").Dial("rpcsim", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	network.SetLatency("client", "server", 10*time.Millisecond)
+	call := client.Go("Arith.Add", Args{1, 2}, new(Reply), nil)
+	waitFor(t, func() bool { return network.InFlight() == 1 })
+
+	// The request arrives, and the response is sent, only once the clock
+	// has advanced by the latency.
+	network.Clock().Advance(9 * time.Millisecond)
+	if network.InFlight() != 1 {
+		t.Fatal("request delivered early")
+	}
+	network.Clock().Advance(time.Millisecond)
+	waitFor(t, func() bool { return network.InFlight() == 1 })
+	select {
+	case <-call.Done:
+		t.Fatal("response delivered early")
+	case <-time.After(10 * time.Millisecond):
+	}
+	network.Clock().Advance(10 * time.Millisecond)
+	if call := <-call.Done; call.Error != nil || call.Reply.(*Reply).C != 3 {
+		t.Fatalf("got %v, %v", call.Reply, call.Error)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	network := NewNetwork(nil)
+	defer network.Close()
+	newServer(t, network.Node("server"))
+	node := network.Node("client")
+	client, err := node.Dial("rpcsim", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	network.Partition([]string{"client"}, []string{"server"})
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err == nil {
+		t.Fatal("call across a partition succeeded")
+	}
+	if _, err := node.Dial("rpcsim", "server"); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected dial across a partition to fail, got %v", err)
+	}
+
+	network.Heal()
+	client, err = node.Dial("rpcsim", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.Dial("rpcsim", "nowhere"); !errors.Is(err, ErrConnRefused) {
+		t.Fatalf("expected dial to a node not listening to be refused, got %v", err)
+	}
+}
+
+func TestSeverWithPool(t *testing.T) {
+	network := NewNetwork(nil)
+	defer network.Close()
+	newServer(t, network.Node("server"))
+	node := network.Node("client")
+	pool := rpc.NewClientPool(func() (*rpc.Client, error) { return node.Dial("rpcsim", "server") })
+	defer pool.Close()
+
+	if err := pool.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	network.Sever("client", "server")
+	// The pool drops the broken connection and dials a new one.
+	waitFor(t, func() bool {
+		return pool.Call("Arith.Add", Args{1, 2}, new(Reply)) == nil
+	})
+	if dialed := pool.Stats().Dialed; dialed != 2 {
+		t.Errorf("dialed %d connections, want 2", dialed)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpctest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// RecordingCaller is an rpc.ClientInterface that answers calls with canned
+// replies and records them, so that code making calls through an
+// rpc.Caller or rpc.ClientInterface can be unit tested without a server.
+// Calls complete before Call, CallContext and Go return. Calls to methods
+// without a reply fail.
+type RecordingCaller struct {
+	mu       sync.Mutex // protects following
+	handlers map[string]func(args, reply interface{}) error
+	calls    []Call
+	closed   bool
+}
+
+var _ rpc.ClientInterface = (*RecordingCaller)(nil)
+
+// NewRecordingCaller returns a RecordingCaller without replies.
+func NewRecordingCaller() *RecordingCaller {
+	return &RecordingCaller{handlers: make(map[string]func(args, reply interface{}) error)}
+}
+
+// Reply makes calls to serviceMethod succeed with reply, which is copied to
+// the call's reply. It is a value of the type the call's reply points to, or
+// a pointer to one.
+func (c *RecordingCaller) Reply(serviceMethod string, reply interface{}) {
+	c.Handle(serviceMethod, func(_, dst interface{}) error {
+		return copyReply(dst, reply)
+	})
+}
+
+// Fail makes calls to serviceMethod fail with err. Errors the server
+// returns reach clients as rpc.ServerError.
+func (c *RecordingCaller) Fail(serviceMethod string, err error) {
+	c.Handle(serviceMethod, func(_, _ interface{}) error {
+		return err
+	})
+}
+
+// Handle makes calls to serviceMethod answered by fn, which fills in reply
+// from args.
+func (c *RecordingCaller) Handle(serviceMethod string, fn func(args, reply interface{}) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[serviceMethod] = fn
+}
+
+// Call implements rpc.Caller.
+func (c *RecordingCaller) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext implements rpc.Caller. It fails with ctx.Err() without
+// recording the call if ctx is done.
+func (c *RecordingCaller) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	handler, closed := c.handlers[serviceMethod], c.closed
+	c.mu.Unlock()
+	if closed {
+		return rpc.ErrShutdown
+	}
+
+	var err error
+	if handler == nil {
+		err = fmt.Errorf("rpctest: no reply for %s", serviceMethod)
+	} else {
+		err = handler(args, reply)
+	}
+	call := Call{ServiceMethod: serviceMethod, Args: args, Err: err}
+	if err == nil {
+		call.Reply = reply
+	}
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	c.mu.Unlock()
+	return err
+}
+
+// Go implements rpc.ClientInterface. The call is done when Go returns.
+func (c *RecordingCaller) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 1)
+	} else if cap(done) == 0 {
+		panic("rpctest: done channel is unbuffered")
+	}
+	call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	call.Error = c.Call(serviceMethod, args, reply)
+	done <- call
+	return call
+}
+
+// PendingCalls implements rpc.ClientInterface. Calls never wait for a
+// response, so it returns nil.
+func (c *RecordingCaller) PendingCalls() []rpc.PendingCall {
+	return nil
+}
+
+// Close makes later calls fail with rpc.ErrShutdown, as those of a closed
+// Client do.
+func (c *RecordingCaller) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return rpc.ErrShutdown
+	}
+	c.closed = true
+	return nil
+}
+
+// Calls returns the calls made so far, in order.
+func (c *RecordingCaller) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// AssertCalled returns the latest call to serviceMethod, failing the test
+// if there is none.
+func (c *RecordingCaller) AssertCalled(t testing.TB, serviceMethod string) Call {
+	t.Helper()
+	calls := c.callsTo(serviceMethod)
+	if len(calls) == 0 {
+		t.Fatalf("rpctest: %s was not called", serviceMethod)
+		return Call{}
+	}
+	return calls[len(calls)-1]
+}
+
+// AssertNotCalled fails the test if serviceMethod was called.
+func (c *RecordingCaller) AssertNotCalled(t testing.TB, serviceMethod string) {
+	t.Helper()
+	if n := len(c.callsTo(serviceMethod)); n > 0 {
+		t.Errorf("rpctest: %s was called %d times, want none", serviceMethod, n)
+	}
+}
+
+func (c *RecordingCaller) callsTo(serviceMethod string) []Call {
+	var calls []Call
+	for _, call := range c.Calls() {
+		if call.ServiceMethod == serviceMethod {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// copyReply sets what dst points to to src, or to what src points to.
+func copyReply(dst, src interface{}) error {
+	d := reflect.ValueOf(dst)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return fmt.Errorf("rpctest: reply %T is not a non-nil pointer", dst)
+	}
+	s := reflect.ValueOf(src)
+	if !s.IsValid() {
+		d.Elem().Set(reflect.Zero(d.Elem().Type()))
+		return nil
+	}
+	if s.Type() != d.Elem().Type() && s.Kind() == reflect.Ptr {
+		s = s.Elem()
+	}
+	if !s.Type().AssignableTo(d.Elem().Type()) {
+		return fmt.Errorf("rpctest: canned reply %T does not fit reply %T", src, dst)
+	}
+	d.Elem().Set(s)
+	return nil
+}
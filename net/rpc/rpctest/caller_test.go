@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpctest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// divide is the kind of call site RecordingCaller lets tests cover.
+func divide(c rpc.Caller, a, b int) (Quotient, error) {
+	var quo Quotient
+	err := c.Call("Arith.Divide", &Args{a, b}, &quo)
+	return quo, err
+}
+
+func TestRecordingCaller(t *testing.T) {
+	c := NewRecordingCaller()
+	c.Reply("Arith.Divide", Quotient{Quo: 2, Rem: 1})
+	quo, err := divide(c, 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quo != (Quotient{2, 1}) {
+		t.Errorf("got %+v, want {2 1}", quo)
+	}
+	if args := c.AssertCalled(t, "Arith.Divide").Args.(*Args); *args != (Args{7, 3}) {
+		t.Errorf("recorded args %+v, want {7 3}", *args)
+	}
+
+	var sum int
+	c.Reply("Arith.Add", new(int))
+	c.Handle("Arith.Add", func(args, reply interface{}) error {
+		a := args.(*Args)
+		*reply.(*int) = a.A + a.B
+		return nil
+	})
+	call := c.Go("Arith.Add", &Args{1, 2}, &sum, nil)
+	if <-call.Done; call.Error != nil || sum != 3 {
+		t.Errorf("Go: got %d, %v, want 3, nil", sum, call.Error)
+	}
+
+	failure := errors.New("divide by zero")
+	c.Fail("Arith.Divide", failure)
+	if _, err := divide(c, 1, 0); err != failure {
+		t.Errorf("got %v, want %v", err, failure)
+	}
+	if err := c.Call("Arith.Negate", 1, new(int)); err == nil {
+		t.Error("expected a call without a reply to fail")
+	}
+	c.Reply("Arith.Mul", 1)
+	if err := c.Call("Arith.Mul", &Args{}, new(string)); err == nil {
+		t.Error("expected a canned reply of the wrong type to fail the call")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.CallContext(ctx, "Arith.Divide", &Args{1, 1}, new(Quotient)); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if n := len(c.Calls()); n != 5 {
+		t.Errorf("got %d calls, want 5", n)
+	}
+	c.AssertNotCalled(t, "Arith.Sub")
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := divide(c, 1, 1); err != rpc.ErrShutdown {
+		t.Errorf("call after Close: got %v, want %v", err, rpc.ErrShutdown)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rpctest helps projects built on package rpc test their services.
+//
+// Matrix runs a project's calls between every pairing of peers and codecs
+// the wire protocol must keep working for: clients and servers of this
+// package against the legacy peers of the standard library's net/rpc, which
+// the protocol extends, each codec, and codecs negotiated or not. Running it
+// in CI against the project's own service definitions catches changes to
+// argument and reply types, or to this package, that break older peers.
+//
+// NewInProcPair connects a client to a server in memory, replacing the
+// listener and accept loop every test of a service otherwise sets up, and
+// records the calls the server handles for the test to assert on.
+package rpctest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	netrpc "net/rpc"
+	"testing"
+
+	msgpackrpc "github.com/hashicorp/consul-net-rpc/net-rpc-msgpackrpc"
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// Codec is a wire encoding the matrix makes calls with.
+type Codec struct {
+	// Name is the name the codec is negotiated under.
+	Name string
+	// NewClient returns a client using the codec on conn.
+	NewClient func(conn net.Conn) *rpc.Client
+	// NewServerCodec returns a server codec using the codec on conn.
+	NewServerCodec func(conn net.Conn) rpc.ServerCodec
+	// Legacy is set if the standard library's net/rpc client and server
+	// speak the codec. Cells with a legacy peer are only run for such
+	// codecs.
+	Legacy bool
+}
+
+// Gob returns the gob codec, the default of both this package and net/rpc.
+func Gob() Codec {
+	return Codec{
+		Name: "gob",
+		NewClient: func(conn net.Conn) *rpc.Client {
+			return rpc.NewClient(conn)
+		},
+		NewServerCodec: rpc.NewGobServerCodec,
+		Legacy:         true,
+	}
+}
+
+// Msgpack returns the MessagePack codec of package msgpackrpc.
+func Msgpack() Codec {
+	return Codec{
+		Name:           "msgpack",
+		NewClient:      msgpackrpc.NewClient,
+		NewServerCodec: msgpackrpc.NewServerCodec,
+	}
+}
+
+// Peer is the implementation at one end of a cell's connection.
+type Peer string
+
+const (
+	Current Peer = "current" // this package
+	Legacy  Peer = "legacy"  // the standard library's net/rpc
+)
+
+// Cell is one pairing of peers and codec the matrix runs every case in.
+type Cell struct {
+	Client Peer
+	Server Peer
+	Codec  Codec
+	// Negotiate is set if the client announces the codec with
+	// rpc.NegotiateCodec, and the server picks it from an rpc.CodecRegistry
+	// holding every codec of the matrix.
+	Negotiate bool
+}
+
+// String returns the name of the cell's subtest, such as
+// "gob/current-client/legacy-server".
+func (c Cell) String() string {
+	s := fmt.Sprintf("%s/%s-client/%s-server", c.Codec.Name, c.Client, c.Server)
+	if c.Negotiate {
+		s += "/negotiated"
+	}
+	return s
+}
+
+func (c Cell) legacy() bool {
+	return c.Client == Legacy || c.Server == Legacy
+}
+
+// Case is a call the matrix makes in every cell.
+type Case struct {
+	Name          string
+	ServiceMethod string
+	Args          interface{}
+	// Reply returns a new pointer the reply is decoded into.
+	Reply func() interface{}
+	// Check, if set, verifies the reply and the error of the call, which
+	// must otherwise succeed. Legacy clients only carry the message of an
+	// error returned by the method, so Check should compare err.Error().
+	Check func(reply interface{}, err error) error
+	// CurrentOnly is set for calls that legacy peers cannot make, such as
+	// calls to methods taking a context.Context, which net/rpc does not
+	// register.
+	CurrentOnly bool
+}
+
+// Matrix is a compatibility test of a set of services.
+type Matrix struct {
+	// Services are registered on every server under their names.
+	Services map[string]interface{}
+	Cases    []Case
+	// Codecs are the codecs the matrix runs, Gob and Msgpack by default.
+	Codecs []Codec
+	// ServerOptions are the options of the servers of this package.
+	ServerOptions []func(*rpc.Server)
+}
+
+func (m *Matrix) codecs() []Codec {
+	if len(m.Codecs) == 0 {
+		return []Codec{Gob(), Msgpack()}
+	}
+	return m.Codecs
+}
+
+// Cells returns the cells the matrix runs: for each codec, a current client
+// against a current server with and without negotiation, and, for legacy
+// codecs, each current peer against a legacy one. Legacy peers predate
+// negotiation, so it is only run between current peers.
+func (m *Matrix) Cells() []Cell {
+	var cells []Cell
+	for _, codec := range m.codecs() {
+		cells = append(cells,
+			Cell{Client: Current, Server: Current, Codec: codec},
+			Cell{Client: Current, Server: Current, Codec: codec, Negotiate: true},
+		)
+		if codec.Legacy {
+			cells = append(cells,
+				Cell{Client: Legacy, Server: Current, Codec: codec},
+				Cell{Client: Current, Server: Legacy, Codec: codec},
+			)
+		}
+	}
+	return cells
+}
+
+// Run runs every case in every cell, each cell as a subtest named after it
+// and each case as a subtest of its cell.
+func (m *Matrix) Run(t *testing.T) {
+	for _, cell := range m.Cells() {
+		cell := cell
+		t.Run(cell.String(), func(t *testing.T) {
+			m.runCell(t, cell)
+		})
+	}
+}
+
+// caller is implemented by the clients of this package and of net/rpc.
+type caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	Close() error
+}
+
+func (m *Matrix) runCell(t *testing.T, cell Cell) {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	switch cell.Server {
+	case Current:
+		srv := rpc.NewServerWithOpts(m.ServerOptions...)
+		for name, rcvr := range m.Services {
+			if err := srv.RegisterName(name, rcvr); err != nil {
+				t.Fatalf("registering %s: %v", name, err)
+			}
+		}
+		var registry *rpc.CodecRegistry
+		if cell.Negotiate {
+			registry = rpc.NewCodecRegistry()
+			for _, codec := range m.codecs() {
+				registry.Register(codec.Name, codec.NewServerCodec)
+			}
+		}
+		go serve(srv, registry, cell.Codec, serverConn)
+	case Legacy:
+		srv := netrpc.NewServer()
+		for name, rcvr := range m.Services {
+			// Services whose methods all take a context cannot be
+			// registered, and are only called by CurrentOnly cases.
+			if err := srv.RegisterName(name, rcvr); err != nil {
+				t.Logf("registering %s on the legacy server: %v", name, err)
+			}
+		}
+		go srv.ServeConn(serverConn)
+	}
+
+	var client caller
+	switch cell.Client {
+	case Current:
+		if cell.Negotiate {
+			if err := rpc.NegotiateCodec(clientConn, cell.Codec.Name); err != nil {
+				t.Fatalf("negotiating %s: %v", cell.Codec.Name, err)
+			}
+		}
+		client = cell.Codec.NewClient(clientConn)
+	case Legacy:
+		client = netrpc.NewClient(clientConn)
+	}
+	defer client.Close()
+
+	for _, c := range m.Cases {
+		if c.CurrentOnly && cell.legacy() {
+			continue
+		}
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			reply := c.Reply()
+			err := client.Call(c.ServiceMethod, c.Args, reply)
+			if c.Check != nil {
+				err = c.Check(reply, err)
+			}
+			if err != nil {
+				t.Errorf("%s: %v", c.ServiceMethod, err)
+			}
+		})
+	}
+}
+
+// serve serves the requests on conn with srv, negotiating the codec with
+// registry if it is non-nil.
+func serve(srv *rpc.Server, registry *rpc.CodecRegistry, codec Codec, conn net.Conn) {
+	var sc rpc.ServerCodec
+	if registry != nil {
+		var err error
+		if sc, err = registry.NewServerCodec(conn); err != nil {
+			conn.Close()
+			return
+		}
+	} else {
+		sc = codec.NewServerCodec(conn)
+	}
+	defer sc.Close()
+	for srv.ServeRequestAsync(context.Background(), sc) == nil {
+	}
+}
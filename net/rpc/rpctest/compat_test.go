@@ -0,0 +1,102 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpctest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+}
+
+type Arith int
+
+func (Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (Arith) Divide(args *Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	quo.Quo = args.A / args.B
+	quo.Rem = args.A % args.B
+	return nil
+}
+
+func (Arith) Negate(ctx context.Context, n int, reply *int) error {
+	*reply = -n
+	return nil
+}
+
+func TestMatrix(t *testing.T) {
+	m := &Matrix{
+		Services: map[string]interface{}{"Arith": new(Arith)},
+		Cases: []Case{{
+			Name:          "add",
+			ServiceMethod: "Arith.Add",
+			Args:          &Args{7, 8},
+			Reply:         func() interface{} { return new(int) },
+			Check: func(reply interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				if got := *reply.(*int); got != 15 {
+					return fmt.Errorf("expected 15, got %d", got)
+				}
+				return nil
+			},
+		}, {
+			Name:          "divide",
+			ServiceMethod: "Arith.Divide",
+			Args:          &Args{7, 2},
+			Reply:         func() interface{} { return new(Quotient) },
+			Check: func(reply interface{}, err error) error {
+				if q := reply.(*Quotient); err != nil || *q != (Quotient{3, 1}) {
+					return fmt.Errorf("expected {3 1}, got %v, %v", q, err)
+				}
+				return nil
+			},
+		}, {
+			Name:          "error",
+			ServiceMethod: "Arith.Divide",
+			Args:          &Args{7, 0},
+			Reply:         func() interface{} { return new(Quotient) },
+			Check: func(reply interface{}, err error) error {
+				if err == nil || err.Error() != "divide by zero" {
+					return fmt.Errorf("expected the method's error, got %v", err)
+				}
+				return nil
+			},
+		}, {
+			Name:          "context",
+			ServiceMethod: "Arith.Negate",
+			Args:          3,
+			Reply:         func() interface{} { return new(int) },
+			CurrentOnly:   true,
+		}},
+	}
+
+	var names []string
+	for _, cell := range m.Cells() {
+		names = append(names, cell.String())
+	}
+	want := "[gob/current-client/current-server gob/current-client/current-server/negotiated " +
+		"gob/legacy-client/current-server gob/current-client/legacy-server " +
+		"msgpack/current-client/current-server msgpack/current-client/current-server/negotiated]"
+	if got := fmt.Sprint(names); got != want {
+		t.Errorf("expected cells %s, got %s", want, got)
+	}
+
+	m.Run(t)
+}
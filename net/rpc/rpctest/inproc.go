@@ -0,0 +1,206 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpctest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+// waitTimeout bounds how long the Recorder's assertions wait for calls.
+const waitTimeout = 5 * time.Second
+
+// Pair is a server and a client connected to it in memory, so that tests
+// can call services without listening on a socket.
+type Pair struct {
+	Server *rpc.Server
+	Client *rpc.Client
+	// Calls records the calls the server handles.
+	Calls *Recorder
+
+	t         testing.TB
+	transport rpc.Transport
+}
+
+// pairAddress is the address the server of a Pair listens at, in the
+// namespace of its own rpc.PipeTransport.
+const pairAddress = "rpctest"
+
+// NewInProcPair returns a Pair whose server has options and serves the
+// connections of its clients with ServeConn. Services are registered on
+// Server, before or after the first call. The server stops accepting
+// connections, and the clients are closed, when the test ends.
+func NewInProcPair(t testing.TB, options ...func(*rpc.Server)) *Pair {
+	t.Helper()
+	calls := NewRecorder()
+	p := &Pair{
+		Server:    rpc.NewServerWithOpts(append(options, rpc.WithCallObserver(calls.Observer()))...),
+		Calls:     calls,
+		t:         t,
+		transport: rpc.PipeTransport(),
+	}
+	l, err := p.transport.Listen(context.Background(), "pipe", pairAddress)
+	if err != nil {
+		t.Fatalf("rpctest: listening: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		p.Server.Accept(ctx, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+	p.Client = p.Dial()
+	return p
+}
+
+// Dial connects another client to the pair's server, with options. It is
+// closed when the test ends.
+func (p *Pair) Dial(options ...func(*rpc.Client)) *rpc.Client {
+	p.t.Helper()
+	d := rpc.NewDialer(rpc.WithTransports(p.transport), rpc.WithDialClientOptions(options...))
+	client, err := d.DialContext(context.Background(), "pipe", pairAddress)
+	if err != nil {
+		p.t.Fatalf("rpctest: dialing: %v", err)
+	}
+	p.t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Call is a call a Recorder recorded.
+type Call struct {
+	ServiceMethod string
+	Args          interface{}
+	// Reply is nil if the call failed.
+	Reply interface{}
+	Err   error
+}
+
+// Recorder records the calls a server handles, as a rpc.CallObserver, for
+// tests to assert on. A call is recorded once its handler returns, which
+// may be just after its client received the response, so the assertions
+// wait for the calls they expect. With rpc.WithValuePooling, the recorded
+// arguments and replies are reused by later calls.
+type Recorder struct {
+	mu      sync.Mutex // protects following
+	changed *sync.Cond // signaled when a call completes
+	started map[string]int
+	calls   []Call
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	r := &Recorder{started: make(map[string]int)}
+	r.changed = sync.NewCond(&r.mu)
+	return r
+}
+
+// Observer returns the observer recording calls, to pass to
+// rpc.WithCallObserver.
+func (r *Recorder) Observer() rpc.CallObserver {
+	return func(ctx context.Context, serviceMethod string, args interface{}) (context.Context, func(interface{}, error)) {
+		r.mu.Lock()
+		r.started[serviceMethod]++
+		r.mu.Unlock()
+		return ctx, func(reply interface{}, err error) {
+			r.mu.Lock()
+			r.calls = append(r.calls, Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Err: err})
+			r.changed.Broadcast()
+			r.mu.Unlock()
+		}
+	}
+}
+
+// Calls returns the calls recorded so far, in the order their handlers
+// returned.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Reset forgets the calls recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = make(map[string]int)
+	r.calls = nil
+}
+
+// WaitForCalls waits until n calls to serviceMethod have been recorded and
+// returns them, failing the test if they are not within a few seconds.
+func (r *Recorder) WaitForCalls(t testing.TB, serviceMethod string, n int) []Call {
+	t.Helper()
+	var timedOut bool
+	timer := time.AfterFunc(waitTimeout, func() {
+		r.mu.Lock()
+		timedOut = true
+		r.changed.Broadcast()
+		r.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		calls := r.callsTo(serviceMethod)
+		if len(calls) >= n {
+			return calls
+		}
+		if timedOut {
+			t.Fatalf("rpctest: got %d calls to %s, want %d", len(calls), serviceMethod, n)
+			return nil
+		}
+		r.changed.Wait()
+	}
+}
+
+// AssertCalled waits until serviceMethod has been called and returns its
+// latest call, failing the test if it is not within a few seconds.
+func (r *Recorder) AssertCalled(t testing.TB, serviceMethod string) Call {
+	t.Helper()
+	calls := r.WaitForCalls(t, serviceMethod, 1)
+	return calls[len(calls)-1]
+}
+
+// AssertNotCalled fails the test if a call to serviceMethod has started.
+func (r *Recorder) AssertNotCalled(t testing.TB, serviceMethod string) {
+	t.Helper()
+	r.mu.Lock()
+	n := r.started[serviceMethod]
+	r.mu.Unlock()
+	if n > 0 {
+		t.Errorf("rpctest: %s was called %d times, want none", serviceMethod, n)
+	}
+}
+
+// callsTo returns the recorded calls to serviceMethod. r.mu must be held.
+func (r *Recorder) callsTo(serviceMethod string) []Call {
+	var calls []Call
+	for _, c := range r.calls {
+		if c.ServiceMethod == serviceMethod {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// String lists the recorded calls, for failure messages.
+func (r *Recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := fmt.Sprintf("%d calls", len(r.calls))
+	for _, c := range r.calls {
+		s += fmt.Sprintf("\n\t%s(%+v) = %+v, %v", c.ServiceMethod, c.Args, c.Reply, c.Err)
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpctest
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-net-rpc/net/rpc"
+)
+
+func TestInProcPair(t *testing.T) {
+	p := NewInProcPair(t, rpc.WithMethodFilter(rpc.MatchMethods("Arith.Add", "Arith.Divide")))
+	if err := p.Server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int
+	if err := p.Client.Call("Arith.Add", &Args{7, 8}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 15 {
+		t.Errorf("expected 15, got %d", sum)
+	}
+	call := p.Calls.AssertCalled(t, "Arith.Add")
+	if args := call.Args.(*Args); *args != (Args{7, 8}) {
+		t.Errorf("recorded args %+v, want {7 8}", *args)
+	}
+	if call.Err != nil || *call.Reply.(*int) != 15 {
+		t.Errorf("recorded reply %v, %v, want 15, nil", call.Reply, call.Err)
+	}
+
+	other := p.Dial()
+	var quo Quotient
+	if err := other.Call("Arith.Divide", &Args{1, 0}, &quo); err == nil {
+		t.Error("expected dividing by zero to fail")
+	}
+	if call := p.Calls.AssertCalled(t, "Arith.Divide"); call.Err == nil || call.Reply != nil {
+		t.Errorf("recorded reply %v, %v, want nil and an error", call.Reply, call.Err)
+	}
+
+	// Calls rejected before their handler runs are not recorded.
+	var neg int
+	if err := p.Client.Call("Arith.Negate", 1, &neg); err == nil {
+		t.Error("expected the filtered call to fail")
+	}
+	p.Calls.AssertNotCalled(t, "Arith.Negate")
+
+	if n := len(p.Calls.Calls()); n != 2 {
+		t.Errorf("got %d calls, want 2:\n%s", n, p.Calls)
+	}
+	p.Calls.Reset()
+	if n := len(p.Calls.Calls()); n != 0 {
+		t.Errorf("got %d calls after Reset, want none", n)
+	}
+}
+
+func TestRecorderWaitForCalls(t *testing.T) {
+	p := NewInProcPair(t)
+	if err := p.Server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *rpc.Call, 3)
+	for i := 0; i < 3; i++ {
+		p.Client.Go("Arith.Add", &Args{i, i}, new(int), done)
+	}
+	calls := p.Calls.WaitForCalls(t, "Arith.Add", 3)
+	if len(calls) != 3 {
+		t.Errorf("got %d calls, want 3", len(calls))
+	}
+	for i := 0; i < 3; i++ {
+		if call := <-done; call.Error != nil {
+			t.Error(call.Error)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ServiceDescriptor describes the wire shape of a registered service. A
+// slice of descriptors can be stored (for example as JSON) and later
+// compared against a newer binary with CompareSchemas.
+type ServiceDescriptor struct {
+	Name    string             `json:"name"`
+	Methods []MethodDescriptor `json:"methods"`
+}
+
+// MethodDescriptor describes the argument and reply types of one method,
+// and the documentation it was registered with, if any.
+type MethodDescriptor struct {
+	Name        string         `json:"name"`
+	Args        TypeDescriptor `json:"args"`
+	Reply       TypeDescriptor `json:"reply"`
+	Description string         `json:"description,omitempty"`
+	Stability   Stability      `json:"stability,omitempty"`
+}
+
+// TypeDescriptor describes a type as a codec sees it. Pointers are elided
+// because neither gob nor msgpack distinguish them on the wire.
+type TypeDescriptor struct {
+	Kind   string            `json:"kind"`
+	Type   string            `json:"type,omitempty"`
+	Key    *TypeDescriptor   `json:"key,omitempty"`
+	Elem   *TypeDescriptor   `json:"elem,omitempty"`
+	Fields []FieldDescriptor `json:"fields,omitempty"`
+	// Ref is set instead of Fields when a struct type refers to itself.
+	Ref string `json:"ref,omitempty"`
+}
+
+// FieldDescriptor describes a struct field by the name it has on the wire.
+type FieldDescriptor struct {
+	Name string         `json:"name"`
+	Type TypeDescriptor `json:"type"`
+}
+
+// WireNamer returns the name a codec uses for a struct field on the wire, or
+// the empty string if the codec does not transmit the field.
+type WireNamer func(f reflect.StructField) string
+
+// GobWireName is the WireNamer for encoding/gob, which transmits exported
+// fields under their Go name.
+func GobWireName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	return f.Name
+}
+
+// WithWireNamer sets the WireNamer used by Describe and by schema baseline
+// checks. It defaults to GobWireName.
+func WithWireNamer(namer WireNamer) func(*Server) {
+	return func(s *Server) {
+		s.wireNamer = namer
+	}
+}
+
+// WithSchemaBaseline makes Register and RegisterName fail when the service
+// being registered has a breaking change compared to its descriptor in
+// baseline. Services that are not present in baseline are not checked.
+func WithSchemaBaseline(baseline []ServiceDescriptor) func(*Server) {
+	return func(s *Server) {
+		s.schemaBaseline = make(map[string]ServiceDescriptor, len(baseline))
+		for _, svc := range baseline {
+			s.schemaBaseline[svc.Name] = svc
+		}
+	}
+}
+
+// Describe returns descriptors for every registered service, sorted by
+// service and method name.
+func (server *Server) Describe() []ServiceDescriptor {
+	var out []ServiceDescriptor
+	server.serviceMap.Range(func(_, svci interface{}) bool {
+		out = append(out, server.describeService(svci.(*service)))
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (server *Server) describeService(svc *service) ServiceDescriptor {
+	namer := server.wireNamer
+	if namer == nil {
+		namer = GobWireName
+	}
+	sd := ServiceDescriptor{Name: svc.name}
+	for mname, mtype := range svc.methods() {
+		doc := svc.docs[mname]
+		sd.Methods = append(sd.Methods, MethodDescriptor{
+			Name:        mname,
+			Args:        describeType(mtype.ArgType, namer, map[reflect.Type]bool{}),
+			Reply:       describeType(mtype.ReplyType, namer, map[reflect.Type]bool{}),
+			Description: doc.Description,
+			Stability:   doc.Stability,
+		})
+	}
+	sort.Slice(sd.Methods, func(i, j int) bool { return sd.Methods[i].Name < sd.Methods[j].Name })
+	return sd
+}
+
+func describeType(t reflect.Type, namer WireNamer, seen map[reflect.Type]bool) TypeDescriptor {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	td := TypeDescriptor{Kind: t.Kind().String(), Type: t.String()}
+	switch t.Kind() {
+	case reflect.Map:
+		key := describeType(t.Key(), namer, seen)
+		td.Key = &key
+		fallthrough
+	case reflect.Slice, reflect.Array:
+		elem := describeType(t.Elem(), namer, seen)
+		td.Elem = &elem
+	case reflect.Struct:
+		if seen[t] {
+			td.Ref = t.String()
+			return td
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := namer(f)
+			if name == "" {
+				continue
+			}
+			td.Fields = append(td.Fields, FieldDescriptor{Name: name, Type: describeType(f.Type, namer, seen)})
+		}
+	}
+	return td
+}
+
+// SchemaChange is a difference between two service descriptors.
+type SchemaChange struct {
+	Path        string // for example "Catalog.Register.args.Node"
+	Description string
+	// Breaking is set when peers built against the old descriptor can no
+	// longer exchange the affected values with peers built against the new one.
+	Breaking bool
+}
+
+func (c SchemaChange) String() string {
+	return c.Path + ": " + c.Description
+}
+
+// CompareSchemas reports the differences between an old and a new set of
+// service descriptors. Removed services, methods and fields, and fields whose
+// kind changed, are breaking; additions are not.
+func CompareSchemas(old, new []ServiceDescriptor) []SchemaChange {
+	var changes []SchemaChange
+	newByName := make(map[string]ServiceDescriptor, len(new))
+	for _, svc := range new {
+		newByName[svc.Name] = svc
+	}
+	oldByName := make(map[string]bool, len(old))
+	for _, oldSvc := range old {
+		oldByName[oldSvc.Name] = true
+		newSvc, ok := newByName[oldSvc.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Path: oldSvc.Name, Description: "service removed", Breaking: true})
+			continue
+		}
+		changes = append(changes, compareService(oldSvc, newSvc)...)
+	}
+	for _, svc := range new {
+		if !oldByName[svc.Name] {
+			changes = append(changes, SchemaChange{Path: svc.Name, Description: "service added"})
+		}
+	}
+	return changes
+}
+
+func compareService(old, new ServiceDescriptor) []SchemaChange {
+	var changes []SchemaChange
+	newMethods := make(map[string]MethodDescriptor, len(new.Methods))
+	for _, m := range new.Methods {
+		newMethods[m.Name] = m
+	}
+	oldMethods := make(map[string]bool, len(old.Methods))
+	for _, oldM := range old.Methods {
+		oldMethods[oldM.Name] = true
+		path := old.Name + "." + oldM.Name
+		newM, ok := newMethods[oldM.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Path: path, Description: "method removed", Breaking: true})
+			continue
+		}
+		changes = compareType(changes, path+".args", oldM.Args, newM.Args)
+		changes = compareType(changes, path+".reply", oldM.Reply, newM.Reply)
+	}
+	for _, m := range new.Methods {
+		if !oldMethods[m.Name] {
+			changes = append(changes, SchemaChange{Path: new.Name + "." + m.Name, Description: "method added"})
+		}
+	}
+	return changes
+}
+
+func compareType(changes []SchemaChange, path string, old, new TypeDescriptor) []SchemaChange {
+	if kindClass(old.Kind) != kindClass(new.Kind) {
+		return append(changes, SchemaChange{
+			Path:        path,
+			Description: fmt.Sprintf("type changed from %s to %s", old.Type, new.Type),
+			Breaking:    true,
+		})
+	}
+	if old.Key != nil && new.Key != nil {
+		changes = compareType(changes, path+"[key]", *old.Key, *new.Key)
+	}
+	if old.Elem != nil && new.Elem != nil {
+		changes = compareType(changes, path+"[]", *old.Elem, *new.Elem)
+	}
+	if old.Ref != "" || new.Ref != "" {
+		return changes
+	}
+	newFields := make(map[string]TypeDescriptor, len(new.Fields))
+	for _, f := range new.Fields {
+		newFields[f.Name] = f.Type
+	}
+	oldFields := make(map[string]bool, len(old.Fields))
+	for _, f := range old.Fields {
+		oldFields[f.Name] = true
+		nt, ok := newFields[f.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Path: path + "." + f.Name, Description: "field removed or renamed", Breaking: true})
+			continue
+		}
+		changes = compareType(changes, path+"."+f.Name, f.Type, nt)
+	}
+	for _, f := range new.Fields {
+		if !oldFields[f.Name] {
+			changes = append(changes, SchemaChange{Path: path + "." + f.Name, Description: "field added"})
+		}
+	}
+	return changes
+}
+
+// kindClass groups kinds that gob and msgpack encode interchangeably.
+func kindClass(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"):
+		return "int"
+	case strings.HasPrefix(kind, "uint"):
+		return "uint"
+	case strings.HasPrefix(kind, "float"):
+		return "float"
+	case strings.HasPrefix(kind, "complex"):
+		return "complex"
+	case kind == "slice" || kind == "array":
+		return "list"
+	}
+	return kind
+}
+
+// checkSchemaBaseline returns an error describing the breaking changes of svc
+// against the server's schema baseline, if any.
+func (server *Server) checkSchemaBaseline(svc *service) error {
+	baseline, ok := server.schemaBaseline[svc.name]
+	if !ok {
+		return nil
+	}
+	var breaking []string
+	for _, change := range compareService(baseline, server.describeService(svc)) {
+		if change.Breaking {
+			breaking = append(breaking, change.String())
+		}
+	}
+	if len(breaking) == 0 {
+		return nil
+	}
+	return errors.New("rpc.Register: service " + svc.name + " breaks schema baseline: " + strings.Join(breaking, "; "))
+}
@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type SchemaArgs struct {
+	Name  string
+	Count int
+}
+
+type SchemaArith int
+
+func (t *SchemaArith) Add(ctx context.Context, args Args, reply *Reply) error {
+	return nil
+}
+
+func (t *SchemaArith) Mul(args *Args, reply *Reply) error {
+	return nil
+}
+
+type SchemaArithV2 int
+
+func (t *SchemaArithV2) Add(ctx context.Context, args SchemaArgs, reply *Reply) error {
+	return nil
+}
+
+func TestCompareSchemas(t *testing.T) {
+	oldSrv := NewServer()
+	if err := oldSrv.RegisterName("Arith", new(SchemaArith)); err != nil {
+		t.Fatal(err)
+	}
+	newSrv := NewServer()
+	if err := newSrv.RegisterName("Arith", new(SchemaArithV2)); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := CompareSchemas(oldSrv.Describe(), newSrv.Describe())
+	var breaking []string
+	for _, c := range changes {
+		if c.Breaking {
+			breaking = append(breaking, c.String())
+		}
+	}
+	expected := []string{
+		"Arith.Add.args.A: field removed or renamed",
+		"Arith.Add.args.B: field removed or renamed",
+		"Arith.Mul: method removed",
+	}
+	if strings.Join(breaking, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected breaking changes:\n%s", strings.Join(breaking, "\n"))
+	}
+
+	if changes := CompareSchemas(oldSrv.Describe(), oldSrv.Describe()); len(changes) != 0 {
+		t.Errorf("expected no changes comparing a schema to itself, got %v", changes)
+	}
+}
+
+func TestSchemaBaseline(t *testing.T) {
+	oldSrv := NewServer()
+	if err := oldSrv.RegisterName("Arith", new(SchemaArith)); err != nil {
+		t.Fatal(err)
+	}
+	baseline := oldSrv.Describe()
+
+	srv := NewServerWithOpts(WithSchemaBaseline(baseline))
+	err := srv.RegisterName("Arith", new(SchemaArithV2))
+	if err == nil || !strings.Contains(err.Error(), "breaks schema baseline") {
+		t.Fatalf("expected schema baseline error, got %v", err)
+	}
+	if err := srv.RegisterName("Arith", new(Arith)); err != nil {
+		t.Fatalf("expected compatible service to register, got %v", err)
+	}
+}
@@ -131,13 +131,16 @@ import (
 	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"go/token"
 	"io"
-	"log"
 	"net"
+	"net/netip"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -159,32 +162,121 @@ type methodType struct {
 	ReplyType  reflect.Type
 	HasContext bool
 	numCalls   uint
+
+	argPool   *sync.Pool // of *ArgType, or ArgType if it is a pointer; nil unless Resettable
+	replyPool *sync.Pool // of ReplyType; nil unless Resettable
+
+	// invoke calls the function registered with RegisterMethod or
+	// RegisterFunc, in place of method.
+	invoke func(ctx context.Context, argv, replyv reflect.Value) error
 }
 
 type service struct {
 	name   string                 // name of service
 	rcvr   reflect.Value          // receiver of methods for the service
 	typ    reflect.Type           // type of the receiver
-	method map[string]*methodType // registered methods
+	method map[string]*methodType // registered methods; use methods()
+	docs   map[string]MethodDoc   // by method name
+	logger Logger                 // reports unsuitable methods
+
+	methodOnce sync.Once // builds method
+
+	draining atomic.Bool  // set by DrainService
+	inFlight atomic.Int64 // requests being served
+
+	typed atomic.Pointer[map[string]*methodType] // methods added by RegisterMethod or RegisterFunc
+}
+
+// methods returns the service's method table, building it on first use for
+// services registered lazily.
+func (s *service) methods() map[string]*methodType {
+	if typed := s.typed.Load(); typed != nil {
+		return *typed
+	}
+	s.methodOnce.Do(func() {
+		s.method = suitableMethods(s.typ, s.logger)
+	})
+	return s.method
 }
 
 // Request is a header written before every RPC call. It is used internally
 // but documented here as an aid to debugging, such as when analyzing
 // network traffic.
 type Request struct {
-	ServiceMethod string   // format: "Service.Method"
-	Seq           uint64   // sequence number chosen by client
-	next          *Request // for free list in Server
+	ServiceMethod string // format: "Service.Method"
+	Seq           uint64 // sequence number chosen by client
+	// Timeout is the time the client is still willing to wait for the
+	// response, taken from the deadline of its context. Zero means no deadline.
+	Timeout time.Duration `codec:",omitempty"`
+	// VerifyReply asks the server to remember a digest of the reply so the
+	// client can verify it decoded the same value. See Client.VerifyReplies.
+	VerifyReply bool `codec:",omitempty"`
+	// Metadata is sent by the client with the request. See Metadata.
+	Metadata Metadata `codec:",omitempty"`
+	// Progress asks the server to send the handler's progress notifications
+	// ahead of the response. See ContextWithProgress.
+	Progress bool `codec:",omitempty"`
+	// Stream is set on calls to streaming methods made with OpenStream or
+	// OpenDuplex, and Duplex on the latter.
+	Stream bool `codec:",omitempty"`
+	Duplex bool `codec:",omitempty"`
+	// StreamItem, StreamEnd, StreamCredit and StreamCancel are set on the
+	// messages a client sends on an open duplex call, which carry its Seq: a
+	// value for the method, the end of those values, a grant of
+	// StreamCredit more values the method may send, and the abandonment of
+	// the call. Only a StreamItem message has a non-empty body.
+	StreamItem   bool `codec:",omitempty"`
+	StreamEnd    bool `codec:",omitempty"`
+	StreamCredit int  `codec:",omitempty"`
+	StreamCancel bool `codec:",omitempty"`
+	// AuthToken is the token the client was given by its WithAuthToken
+	// provider, for the server's Authenticator.
+	AuthToken string `codec:",omitempty"`
+	// Chunked is set on the messages carrying the parts of args split by
+	// WithClientChunking, each the body of its message as a []byte, and
+	// Continued on every part but the last, whose header is the call's.
+	Chunked   bool      `codec:",omitempty"`
+	Continued bool      `codec:",omitempty"`
+	next      *Request  // for free list in Server
+	arrived   time.Time // when the header was read
+
+	replyMetadata Metadata // set by the handler, sent with the response
+	admitted      func()   // releases the request's admission after its header
+
+	identity *Identity // set by the server's Authenticator
 }
 
 // Response is a header written before every RPC return. It is used internally
 // but documented here as an aid to debugging, such as when analyzing
 // network traffic.
 type Response struct {
-	ServiceMethod string    // echoes that of the Request
-	Seq           uint64    // echoes that of the request
-	Error         string    // error, if any.
-	next          *Response // for free list in Server
+	ServiceMethod string // echoes that of the Request
+	Seq           uint64 // echoes that of the request
+	Error         string // error, if any.
+	// ErrorCode, ErrorDetails and ErrorRetryable carry the classification
+	// of a CodedError.
+	ErrorCode      string            `codec:",omitempty"`
+	ErrorDetails   map[string]string `codec:",omitempty"`
+	ErrorRetryable bool              `codec:",omitempty"`
+	// VerifyReply is set when the server remembered a digest of the reply and
+	// expects the client to report the digest of the reply it decoded.
+	VerifyReply bool `codec:",omitempty"`
+	// Progress is set on notifications sent before the response of a
+	// request that asked for them. They are followed by an empty body.
+	Progress *Progress `codec:",omitempty"`
+	// StreamItem is set on the values a streaming method sends, each the
+	// body of its own response, ahead of the response ending the stream.
+	StreamItem bool `codec:",omitempty"`
+	// StreamCredit is set on responses granting the client of a duplex
+	// call StreamCredit more values to send. They have an empty body.
+	StreamCredit int `codec:",omitempty"`
+	// Chunked and Continued are set on the parts of a reply split by
+	// WithChunking, as on those of a Request.
+	Chunked   bool `codec:",omitempty"`
+	Continued bool `codec:",omitempty"`
+	// Metadata is set by the handler with SetResponseMetadata.
+	Metadata Metadata  `codec:",omitempty"`
+	next     *Response // for free list in Server
 }
 
 // Server represents an RPC Server.
@@ -195,8 +287,62 @@ type Server struct {
 	respLock   sync.Mutex // protects freeResp
 	freeResp   *Response
 
-	serverServiceCallInterceptor ServerServiceCallInterceptor
-	preBodyInterceptor           PreBodyInterceptor
+	serverServiceCallInterceptor        ServerServiceCallInterceptor
+	preBodyInterceptor                  PreBodyInterceptor
+	serverServiceCallContextInterceptor ServerServiceCallContextInterceptor
+	preBodyContextInterceptor           PreBodyContextInterceptor
+	responseInterceptor                 ResponseInterceptor
+
+	wireNamer      WireNamer
+	schemaBaseline map[string]ServiceDescriptor
+	requestRouter  RequestRouter
+	errorBudget    *errorBudgetTracker
+	methodTimeouts []methodTimeout
+	fairness       *fairnessTracker
+	lazyMethods    bool
+	replyVerifier  *replyVerifier
+	replyCanary    *replyCanary
+	migration      *codecMigration
+	features       *FeatureFlags
+	observers      []CallObserver
+	methodFilters  []func(serviceMethod string, peer net.Addr) bool
+	authenticator  Authenticator
+	connHandshake  ConnHandshake
+	proxyTrusted   []netip.Prefix // set by WithProxyProtocol
+	proxyAnyPeer   bool           // set by WithProxyProtocolFromAnyPeer
+
+	writeQueueLimit int
+	bulkMethods     []string
+	writeStats      writeQueueStats
+	writeRetry      *writeRetrier
+	metadata        Metadata
+	panicHandler    PanicHandler
+	log             Logger
+	timingStats     requestTimingStats
+	methodStats     methodStatsTracker
+	metricsSinks    []MetricsSink
+	maxRequestBytes int64
+	limits          *requestLimits
+	yieldQuantum    time.Duration // set by WithYieldQuantum
+	valuePooling    bool          // set by WithValuePooling
+	coalescing      *writeCoalescing
+	connSetup       connSetupTracker
+	admission       []AdmissionController
+	duplexes        sync.Map // duplexKey to *connDuplex, for the duplex calls being served
+	slowCalls       *slowCallHook
+	callHooks       *callHooks
+	chunkSize       int      // set by WithChunking
+	chunks          sync.Map // duplexKey to the parts of a chunked request received so far
+	calls           sync.Map // *CallInfo of the calls being served, for InFlight
+
+	mu            sync.Mutex                  // protects following
+	codecs        map[ServerCodec]*writeQueue // response write queues
+	connsAccepted uint64                      // codecs ever tracked
+	retiredBytes  map[string]*codecBytes      // traffic of codecs no longer served, by name
+	inFlight      int
+	inShutdown    bool
+	lent          map[ServerCodec]codecBytes // codecs in ServeRequest, with their traffic until then
+	unkeyedQueue  *writeQueue                // shared by the codecs that are not comparable
 }
 
 // NewServer returns a new Server.
@@ -232,8 +378,43 @@ type ServerServiceCallInterceptor func(reqServiceMethod string, argv, replyv ref
 // PreBodyInterceptor acts a middleware hook on the server side of the RPC call that executes as early as possible
 // in the flow of execution. Specifically, after the request header is parsed but before the request body is parsed.
 // Returning an error will cease further processing of the request and return a response containing the error.
+// For clients connected over TLS, sourceAddr is a *Peer carrying the client's certificate; see PeerCertificate.
 type PreBodyInterceptor func(reqServiceMethod string, sourceAddr net.Addr) error
 
+func WithServerServiceCallContextInterceptor(interceptor ServerServiceCallContextInterceptor) func(*Server) {
+	return func(s *Server) {
+		s.serverServiceCallContextInterceptor = interceptor
+	}
+}
+
+func WithPreBodyContextInterceptor(interceptor PreBodyContextInterceptor) func(*Server) {
+	return func(s *Server) {
+		s.preBodyContextInterceptor = interceptor
+	}
+}
+
+// ServerServiceCallContextInterceptor is like ServerServiceCallInterceptor but also receives the context the
+// handler is called with, which carries the request's deadline and any values set by the transport. If both
+// interceptors are set, ServerServiceCallInterceptor runs first.
+type ServerServiceCallContextInterceptor func(ctx context.Context, reqServiceMethod string, argv, replyv reflect.Value, handler func() error)
+
+// PreBodyContextInterceptor is like PreBodyInterceptor but also receives the request's context. If both
+// interceptors are set, PreBodyInterceptor runs first.
+type PreBodyContextInterceptor func(ctx context.Context, reqServiceMethod string, sourceAddr net.Addr) error
+
+func WithResponseInterceptor(interceptor ResponseInterceptor) func(*Server) {
+	return func(s *Server) {
+		s.responseInterceptor = interceptor
+	}
+}
+
+// ResponseInterceptor is called after the handler has run and before the response is written. resp has its
+// ServiceMethod and Seq set, reply is the handler's reply value (nil if the request could not be decoded), and
+// handlerErr is the error the request failed with. The interceptor may modify reply, for example to redact
+// sensitive fields; the error it returns is sent to the client in place of handlerErr. Forwarded responses are
+// written without calling the interceptor.
+type ResponseInterceptor func(resp *Response, reply interface{}, handlerErr error) error
+
 // DefaultServer is the default instance of *Server.
 var DefaultServer = NewServer()
 
@@ -260,17 +441,19 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 // The client accesses each method using a string of the form "Type.Method",
 // where Type is the receiver's concrete type.
 func (server *Server) Register(rcvr interface{}) error {
-	return server.register(rcvr, "", false)
+	return server.register(rcvr, "", false, nil)
 }
 
 // RegisterName is like Register but uses the provided name for the type
 // instead of the receiver's concrete type.
 func (server *Server) RegisterName(name string, rcvr interface{}) error {
-	return server.register(rcvr, name, true)
+	return server.register(rcvr, name, true, nil)
 }
 
-func (server *Server) register(rcvr interface{}, name string, useName bool) error {
+func (server *Server) register(rcvr interface{}, name string, useName bool, docs map[string]MethodDoc) error {
 	s := new(service)
+	s.docs = docs
+	s.logger = server.logger()
 	s.typ = reflect.TypeOf(rcvr)
 	s.rcvr = reflect.ValueOf(rcvr)
 	sname := reflect.Indirect(s.rcvr).Type().Name()
@@ -279,33 +462,55 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) erro
 	}
 	if sname == "" {
 		s := "rpc.Register: no service name for type " + s.typ.String()
-		log.Print(s)
+		server.logger().Error(s)
 		return errors.New(s)
 	}
 	if !token.IsExported(sname) && !useName {
 		s := "rpc.Register: type " + sname + " is not exported"
-		log.Print(s)
+		server.logger().Error(s)
 		return errors.New(s)
 	}
 	s.name = sname
 
-	// Install the methods
-	s.method = suitableMethods(s.typ, true)
+	if err := checkStability(sname, docs); err != nil {
+		server.logger().Error(err.Error())
+		return err
+	}
 
-	if len(s.method) == 0 {
+	if server.lazyMethods {
+		if _, checked := server.schemaBaseline[sname]; !checked {
+			if _, dup := server.serviceMap.LoadOrStore(sname, s); dup {
+				return errors.New("rpc: service already defined: " + sname)
+			}
+			return nil
+		}
+	}
+
+	// Install the methods
+	if len(s.methods()) == 0 {
 		str := ""
 
 		// To help the user, see if a pointer receiver would work.
-		method := suitableMethods(reflect.PtrTo(s.typ), false)
+		method := suitableMethods(reflect.PtrTo(s.typ), nil)
 		if len(method) != 0 {
 			str = "rpc.Register: type " + sname + " has no exported methods of suitable type (hint: pass a pointer to value of that type)"
 		} else {
 			str = "rpc.Register: type " + sname + " has no exported methods of suitable type"
 		}
-		log.Print(str)
+		server.logger().Error(str)
 		return errors.New(str)
 	}
 
+	if err := checkMethodDocs(s); err != nil {
+		server.logger().Error(err.Error())
+		return err
+	}
+
+	if err := server.checkSchemaBaseline(s); err != nil {
+		server.logger().Error(err.Error())
+		return err
+	}
+
 	if _, dup := server.serviceMap.LoadOrStore(sname, s); dup {
 		return errors.New("rpc: service already defined: " + sname)
 	}
@@ -313,8 +518,8 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) erro
 }
 
 // suitableMethods returns suitable Rpc methods of typ, it will report
-// error using log if reportErr is true.
-func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
+// error using logger if it is not nil.
+func suitableMethods(typ reflect.Type, logger Logger) map[string]*methodType {
 	methods := make(map[string]*methodType)
 	for m := 0; m < typ.NumMethod(); m++ {
 		method := typ.Method(m)
@@ -334,8 +539,8 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 			// First type if there are 4 args must be a ctx.
 			ctxType = mtype.In(1)
 			if ctxType != typeOfContext {
-				if reportErr {
-					log.Printf("rpc.Register: argument type of method %q must be context.Context when 4 arguments are provided: %q\n", mname, ctxType)
+				if logger != nil {
+					logger.Warn("rpc.Register: argument type of method must be context.Context when 4 arguments are provided", "method", mname, "type", ctxType)
 					continue
 				}
 			}
@@ -344,8 +549,8 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 
 		// Method needs 3-4 ins: ctx (optional), receiver, *args, *reply.
 		if mtype.NumIn() != 3+argOffset {
-			if reportErr {
-				log.Printf("rpc.Register: method %q has %d input parameters; needs exactly %d\n", mname, mtype.NumIn(), 3+argOffset)
+			if logger != nil {
+				logger.Warn("rpc.Register: method has wrong number of input parameters", "method", mname, "inputs", mtype.NumIn(), "want", 3+argOffset)
 			}
 			continue
 		}
@@ -353,45 +558,51 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 		// First arg need not be a pointer.
 		argType := mtype.In(1 + argOffset)
 		if !isExportedOrBuiltinType(argType) {
-			if reportErr {
-				log.Printf("rpc.Register: argument type of method %q is not exported: %q\n", mname, argType)
+			if logger != nil {
+				logger.Warn("rpc.Register: argument type of method is not exported", "method", mname, "type", argType)
 			}
 			continue
 		}
 		// Second arg must be a pointer.
 		replyType := mtype.In(2 + argOffset)
 		if replyType.Kind() != reflect.Ptr {
-			if reportErr {
-				log.Printf("rpc.Register: reply type of method %q is not a pointer: %q\n", mname, replyType)
+			if logger != nil {
+				logger.Warn("rpc.Register: reply type of method is not a pointer", "method", mname, "type", replyType)
 			}
 			continue
 		}
 		// Reply type must be exported.
 		if !isExportedOrBuiltinType(replyType) {
-			if reportErr {
-				log.Printf("rpc.Register: reply type of method %q is not exported: %q\n", mname, replyType)
+			if logger != nil {
+				logger.Warn("rpc.Register: reply type of method is not exported", "method", mname, "type", replyType)
 			}
 			continue
 		}
 		// Method needs one out.
 		if mtype.NumOut() != 1 {
-			if reportErr {
-				log.Printf("rpc.Register: method %q has %d output parameters; needs exactly one\n", mname, mtype.NumOut())
+			if logger != nil {
+				logger.Warn("rpc.Register: method has wrong number of output parameters", "method", mname, "outputs", mtype.NumOut(), "want", 1)
 			}
 			continue
 		}
 		// The return type of the method must be error.
 		if returnType := mtype.Out(0); returnType != typeOfError {
-			if reportErr {
-				log.Printf("rpc.Register: return type of method %q is %q, must be error\n", mname, returnType)
+			if logger != nil {
+				logger.Warn("rpc.Register: return type of method must be error", "method", mname, "type", returnType)
 			}
 			continue
 		}
+		argElem := argType
+		if argType.Kind() == reflect.Ptr {
+			argElem = argType.Elem()
+		}
 		methods[mname] = &methodType{
 			method:     method,
 			ArgType:    argType,
 			ReplyType:  replyType,
 			HasContext: (ctxType != nil),
+			argPool:    newValuePool(argElem),
+			replyPool:  newValuePool(replyType.Elem()),
 		}
 	}
 	return methods
@@ -402,19 +613,53 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 // contains an error when it is used.
 var invalidRequest = struct{}{}
 
-func (server *Server) sendResponse(sending *sync.Mutex, req *Request, reply interface{}, codec ServerCodec, callErr error) {
+func (server *Server) sendResponse(sending *writeQueue, req *Request, reply interface{}, codec ServerCodec, callErr error) {
 	resp := server.getResponse()
 	// Encode the response header
 	resp.ServiceMethod = req.ServiceMethod
+	resp.Seq = req.Seq
+	resp.Metadata = req.replyMetadata
+	if server.responseInterceptor != nil {
+		interceptReply := reply
+		if reply == invalidRequest {
+			interceptReply = nil
+		}
+		callErr = server.responseInterceptor(resp, interceptReply, callErr)
+		if callErr == nil && reply == invalidRequest {
+			// There is no reply to send without an error.
+			callErr = errors.New("rpc: invalid request")
+		}
+	}
 	if callErr != nil {
-		resp.Error = callErr.Error()
+		resp.setError(callErr)
 		reply = invalidRequest
+	} else if req.VerifyReply && server.replyVerifier != nil && comparableCodec(codec) {
+		server.replyVerifier.expect(codec, req, reply)
+		resp.VerifyReply = true
 	}
-	resp.Seq = req.Seq
-	sending.Lock()
+	priority := WritePrioritySmall
+	if callErr != nil {
+		priority = WritePriorityControl
+	} else if server.isBulkMethod(req.ServiceMethod) {
+		priority = WritePriorityBulk
+	}
+	if callErr == nil && server.chunkSize > 0 {
+		// A reply that cannot be encoded is sent whole, for WriteResponse
+		// to report the error.
+		if parts, _ := splitBody(codec, server.chunkSize, reply); len(parts) > 0 {
+			if err := server.writeChunks(sending, resp, parts, codec); err != nil {
+				server.logger().Debug("rpc: writing response", "serviceMethod", req.ServiceMethod, "sourceAddr", codec.SourceAddr(), "error", err)
+				server.freeResponse(resp)
+				return
+			}
+			resp.Chunked = true
+			reply = parts[len(parts)-1]
+		}
+	}
+	sending.Lock(priority)
 	err := codec.WriteResponse(resp, reply)
-	if debugLog && err != nil {
-		log.Println("rpc: writing response:", err)
+	if err != nil {
+		server.logger().Debug("rpc: writing response", "serviceMethod", req.ServiceMethod, "sourceAddr", codec.SourceAddr(), "error", err)
 	}
 	sending.Unlock()
 	server.freeResponse(resp)
@@ -427,18 +672,38 @@ func (m *methodType) NumCalls() (n uint) {
 	return n
 }
 
-func (s *service) call(ctx context.Context, server *Server, sending *sync.Mutex, wg *sync.WaitGroup, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec) error {
+func (s *service) call(ctx context.Context, server *Server, sending *writeQueue, wg *sync.WaitGroup, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec) error {
 	if wg != nil {
 		defer wg.Done()
 	}
 	mtype.Lock()
 	mtype.numCalls++
 	mtype.Unlock()
-	function := mtype.method.Func
 
-	callErr := callServiceMethod(ctx, mtype.HasContext, function, s.rcvr, argv, replyv)
+	sampled := server.migration != nil && server.featureOn(ctx, FeatureCodecMigration) && server.migration.sample()
+	if sampled {
+		server.migration.check(req.ServiceMethod, false, argv)
+	}
+	callErr := server.invokeHandler(ctx, req.arrived, req.ServiceMethod, mtype, s.rcvr, argv, replyv)
+	reply := replyv.Interface()
+	if st, ok := reply.(streamer); ok {
+		// The values were sent; the response only ends the stream.
+		st.stream().close()
+		reply = emptyBody{}
+	} else if sampled && callErr == nil {
+		server.migration.check(req.ServiceMethod, true, replyv)
+	}
 
-	server.sendResponse(sending, req, replyv.Interface(), codec, callErr)
+	progressReporterFromContext(ctx).close()
+	req.replyMetadata = responseMetadataFromContext(ctx).take()
+	var checkReply func()
+	if server.replyCanary != nil && callErr == nil && !mtype.isStream() && server.featureOn(ctx, FeatureReplyCanary) {
+		checkReply = server.replyCanary.watch(server.logger(), req.ServiceMethod, replyv)
+	}
+	server.sendResponse(sending, req, reply, codec, callErr)
+	if checkReply != nil {
+		checkReply()
+	}
 	server.freeRequest(req)
 	return callErr
 }
@@ -448,23 +713,43 @@ type gobServerCodec struct {
 	dec    *gob.Decoder
 	enc    *gob.Encoder
 	encBuf *bufio.Writer
-	closed bool
+
+	closeLock sync.Mutex // protects closed
+	closed    bool
+
+	limit   *gobLimitReader   // set by SetMaxRequestBytes
+	counted *byteCountingConn // conn, counting its traffic; nil if not counted
+
+	writeMu sync.Mutex      // held while writing, if flusher is set
+	flusher *DelayedFlusher // set by SetWriteCoalescing
+
+	log Logger // the logger of the server serving the codec
 }
 
 func (c *gobServerCodec) ReadRequestHeader(r *Request) error {
+	if c.limit != nil {
+		c.limit.reset()
+	}
 	return c.dec.Decode(r)
 }
 
 func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	if c.limit != nil {
+		c.limit.reset()
+	}
 	return c.dec.Decode(body)
 }
 
 func (c *gobServerCodec) WriteResponse(r *Response, body interface{}) (err error) {
+	if c.flusher != nil {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+	}
 	if err = c.enc.Encode(r); err != nil {
 		if c.encBuf.Flush() == nil {
 			// Gob couldn't encode the header. Should not happen, so if it does,
 			// shut down the connection to signal that the connection is broken.
-			log.Println("rpc: gob error encoding response:", err)
+			c.logger().Error("rpc: gob error encoding response", "error", err, "sourceAddr", c.SourceAddr())
 			c.Close()
 		}
 		return
@@ -473,36 +758,151 @@ func (c *gobServerCodec) WriteResponse(r *Response, body interface{}) (err error
 		if c.encBuf.Flush() == nil {
 			// Was a gob problem encoding the body but the header has been written.
 			// Shut down the connection to signal that the connection is broken.
-			log.Println("rpc: gob error encoding body:", err)
+			c.logger().Error("rpc: gob error encoding body", "error", err, "sourceAddr", c.SourceAddr())
 			c.Close()
 		}
 		return
 	}
+	if c.flusher != nil {
+		return c.flusher.Wrote()
+	}
 	return c.encBuf.Flush()
 }
 
+// setLogger implements codecLogger.
+func (c *gobServerCodec) setLogger(l Logger) {
+	c.log = l
+}
+
+func (c *gobServerCodec) logger() Logger {
+	if c.log == nil {
+		return defaultLogger
+	}
+	return c.log
+}
+
 func (c *gobServerCodec) SourceAddr() net.Addr {
-	return c.conn.RemoteAddr()
+	return SourceAddrOf(c.conn)
 }
 
 func (c *gobServerCodec) Close() error {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
 	if c.closed {
 		// Only call c.rwc.Close once; otherwise the semantics are undefined.
 		return nil
 	}
 	c.closed = true
+	c.flushOnClose()
 	return c.conn.Close()
 }
 
-// ServeRequest is like ServeCodec but synchronously serves a single request.
-// It does not close the codec upon completion.
+// ServeRequest is like ServeCodecContext but synchronously serves a single
+// request. It does not close the codec upon completion, unless the server
+// has been shut down. Once the server has been shut down or has closed the
+// connection, with CloseConn for instance, it returns io.EOF as if the
+// client had closed it, so that loops serving a connection until io.EOF
+// stop then too.
 func (server *Server) ServeRequest(codec ServerCodec) error {
 	return server.ServeRequestContext(context.Background(), codec)
 }
 
 func (server *Server) ServeRequestContext(ctx context.Context, codec ServerCodec) error {
-	sending := new(sync.Mutex)
-	service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
+	return server.serveRequest(ctx, codec, nil)
+}
+
+// serveRequest serves a single request. If bodyRead is not nil, it is called,
+// possibly more than once, when the request has been read from codec, so that
+// the next request can be read while this one is served.
+func (server *Server) serveRequest(ctx context.Context, codec ServerCodec, bodyRead func()) error {
+	if server.shuttingDown() {
+		if bodyRead == nil {
+			// The codec may not have been served before, so Shutdown
+			// may not close it: its client is not left waiting.
+			codec.Close()
+		}
+		return closedError(bodyRead)
+	}
+	sending := server.trackCodec(codec, bodyRead == nil)
+	if bodyRead == nil {
+		defer server.releaseCodec(codec)
+	}
+	ctx, rv := withRequestValues(ctx, codec.SourceAddr())
+
+	if len(server.admission) > 0 {
+		release, err := server.admit(ctx, AdmitPreHeader, "", codec.SourceAddr(), codec, nil)
+		if err != nil {
+			server.untrackCodec(codec)
+			return err
+		}
+		defer release()
+	}
+
+	service, mtype, req, argv, replyv, forward, keepReading, err := server.readRequest(ctx, codec, bodyRead)
+	if req != nil && req.admitted != nil {
+		defer req.admitted()
+	}
+	if req == nil {
+		// The connection is done, or no header could be read from it.
+		server.untrackCodec(codec)
+		if err != nil && (server.shuttingDown() || bodyRead == nil && errors.Is(err, net.ErrClosed)) {
+			// The server closed the codec while it was being read, with
+			// Shutdown or CloseConn.
+			return closedError(bodyRead)
+		}
+		return err
+	}
+	server.connSetup.firstRequest(codec)
+	if req.isStreamMessage() {
+		server.freeRequest(req)
+		closeIfTooLarge(codec, err)
+		return err
+	}
+	if req.ServiceMethod == pingMethod && keepReading && err == nil {
+		return server.answerPing(sending, req, codec)
+	}
+	if !server.beginRequest() {
+		if keepReading {
+			server.sendResponse(sending, req, invalidRequest, codec, ErrServerClosed)
+		}
+		server.freeRequest(req)
+		return closedError(bodyRead)
+	}
+	defer server.endRequest()
+	defer server.untrackCall(server.trackCall(req.ServiceMethod, req.Seq, codec.SourceAddr()))
+
+	ctx, cancel := requestContext(ctx, req)
+	defer cancel()
+	ctx = server.withFeatures(ctx, req.ServiceMethod)
+	server.setRequestLogger(ctx, req.ServiceMethod, codec.SourceAddr())
+	ctx, progress := server.withProgress(ctx, sending, req, codec)
+	rv.collectResponseMetadata()
+	defer progress.close()
+	if forward != nil {
+		return server.forwardRequest(ctx, sending, req, codec, forward, bodyRead)
+	}
+	if server.isReplyDigest(req) {
+		return server.verifyReply(codec, req, bodyRead)
+	}
+	if err == nil {
+		if err = service.begin(); err == nil {
+			defer service.end()
+		}
+	}
+	if err == nil && mtype.isDuplex() {
+		if !comparableCodec(codec) {
+			err = errUnkeyedCodec
+		} else if bodyRead == nil {
+			err = errors.New("rpc: duplex method " + req.ServiceMethod + " needs a connection served with ServeRequestAsync")
+		} else {
+			var cancelDuplex context.CancelFunc
+			ctx, cancelDuplex = context.WithCancel(ctx)
+			defer cancelDuplex()
+			replyv = server.newConnDuplex(ctx, cancelDuplex, sending, mtype, req, codec)
+		}
+	} else if err == nil && mtype.isStream() {
+		replyv = server.newConnStream(ctx, sending, mtype, req, codec)
+	}
 	if err != nil {
 		if !keepReading {
 			return err
@@ -512,20 +912,109 @@ func (server *Server) ServeRequestContext(ctx context.Context, codec ServerCodec
 			server.sendResponse(sending, req, invalidRequest, codec, err)
 			server.freeRequest(req)
 		}
+		closeIfTooLarge(codec, err)
 		return err
 	}
 
+	server.setYield(rv)
+	release, err := server.admit(ctx, AdmitPostBody, req.ServiceMethod, codec.SourceAddr(), codec, argv.Interface())
+	if err != nil {
+		server.sendResponse(sending, req, invalidRequest, codec, err)
+		server.freeRequest(req)
+		return err
+	}
+	defer release()
+
+	if server.fairness != nil {
+		identity := server.fairness.identify(ctx, codec.SourceAddr())
+		start := time.Now()
+		defer func() { server.fairness.record(identity, time.Since(start)) }()
+	}
+
+	serviceMethod := req.ServiceMethod // req is freed by service.call
+	ctx, observed := server.observe(ctx, serviceMethod, argv.Interface())
+	var callErr error
 	handler := func() error {
-		return service.call(ctx, server, sending, nil, mtype, req, argv, replyv, codec)
+		callErr = service.call(ctx, server, sending, nil, mtype, req, argv, replyv, codec)
+		return callErr
+	}
+
+	// service.call errors are sent to the client, not returned to the caller
+	server.interceptCall(ctx, serviceMethod, argv, replyv, handler)
+	observed(replyIfOK(replyv, callErr), callErr)
+	if server.valuePooling && server.methodTimeout(serviceMethod) == 0 {
+		mtype.releaseValues(argv, replyv)
+	}
+
+	return nil
+}
+
+// requestContext derives the context req is served with. It carries the
+// request's metadata, and work the client has already given up on is
+// stopped once the request's timeout elapses.
+func requestContext(ctx context.Context, req *Request) (context.Context, context.CancelFunc) {
+	if len(req.Metadata) > 0 {
+		ctx = ContextWithMetadata(ctx, req.Metadata)
+	}
+	if req.Timeout > 0 {
+		return context.WithTimeout(ctx, req.Timeout)
 	}
+	return ctx, func() {}
+}
 
+// interceptCall runs handler through the server's call interceptors.
+func (server *Server) interceptCall(ctx context.Context, serviceMethod string, argv, replyv reflect.Value, handler func() error) {
+	if server.serverServiceCallContextInterceptor != nil {
+		inner := handler
+		handler = func() error {
+			var err error
+			server.serverServiceCallContextInterceptor(ctx, serviceMethod, argv, replyv, func() error {
+				err = inner()
+				return err
+			})
+			return err
+		}
+	}
 	if server.serverServiceCallInterceptor != nil {
-		server.serverServiceCallInterceptor(req.ServiceMethod, argv, replyv, handler)
+		server.serverServiceCallInterceptor(serviceMethod, argv, replyv, handler)
 	} else {
-		// service.call errors are sent to the client, not returned to the caller
 		_ = handler()
 	}
+}
 
+// checkHeader runs the checks made of a request once its header is read:
+// it lets interceptors halt servicing of the request, and then admits it
+// with the post-header admission controllers, setting req.admitted. The
+// body of a request that is not admitted is discarded, and bodyRead reports
+// whether it was: that of a request halted by an interceptor is left to the
+// caller.
+func (server *Server) checkHeader(ctx context.Context, codec ServerCodec, req *Request) (bodyRead bool, err error) {
+	ctx, cancel := requestContext(ctx, req)
+	defer cancel()
+	if err := server.checkPreBody(ctx, req.ServiceMethod, codec.SourceAddr()); err != nil {
+		return false, err
+	}
+	req.admitted, err = server.admit(ctx, AdmitPostHeader, req.ServiceMethod, codec.SourceAddr(), codec, nil)
+	if err != nil {
+		// discard body
+		if derr := codec.ReadRequestBody(nil); errors.Is(derr, ErrRequestTooLarge) {
+			err = derr
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// checkPreBody runs the server's pre-body interceptors.
+func (server *Server) checkPreBody(ctx context.Context, serviceMethod string, sourceAddr net.Addr) error {
+	if server.preBodyInterceptor != nil {
+		if err := server.preBodyInterceptor(serviceMethod, sourceAddr); err != nil {
+			return err
+		}
+	}
+	if server.preBodyContextInterceptor != nil {
+		return server.preBodyContextInterceptor(ctx, serviceMethod, sourceAddr)
+	}
 	return nil
 }
 
@@ -569,36 +1058,135 @@ func (server *Server) freeResponse(resp *Response) {
 	server.respLock.Unlock()
 }
 
-func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *methodType, req *Request, argv, replyv reflect.Value, keepReading bool, err error) {
+func (server *Server) readRequest(ctx context.Context, codec ServerCodec, bodyRead func()) (service *service, mtype *methodType, req *Request, argv, replyv reflect.Value, forward ForwardFunc, keepReading bool, err error) {
 	service, mtype, req, keepReading, err = server.readRequestHeader(codec)
+	if keepReading && bodyRead != nil {
+		defer func() {
+			if forward == nil && !server.isReplyDigest(req) {
+				bodyRead()
+			}
+		}()
+	}
+	if keepReading && req.isStreamMessage() && !comparableCodec(codec) {
+		// Without a key for the connection, the parts and messages cannot
+		// be matched with their calls: the calls fail with errUnkeyedCodec.
+		err = codec.ReadRequestBody(nil)
+		return
+	}
+	if keepReading && req.Continued {
+		err = server.receiveChunk(ctx, codec, req)
+		return
+	}
+	if keepReading && req.isStreamMessage() {
+		err = server.receiveStreamMessage(codec, req)
+		return
+	}
+	if keepReading && req.ServiceMethod == pingMethod {
+		err = codec.ReadRequestBody(nil)
+		return
+	}
+	if keepReading && req.Chunked && !comparableCodec(codec) {
+		err = errUnkeyedCodec
+		keepReading = codec.ReadRequestBody(nil) == nil
+		return
+	}
+	if keepReading && req.Chunked && server.chunkSize <= 0 {
+		err = discardBody(codec, errChunkingOff)
+		return
+	}
+	if keepReading && req.Chunked {
+		// Drop the earlier parts if the last one is discarded.
+		defer func() {
+			if forward == nil {
+				server.chunks.Delete(duplexKey{codec: codec, seq: req.Seq})
+			}
+		}()
+	}
+	denied := false
+	if keepReading && !server.isReplyDigest(req) {
+		ferr := server.checkMethodFilters(req.ServiceMethod, codec.SourceAddr())
+		if ferr == nil {
+			ferr = server.authenticate(ctx, req, codec.SourceAddr())
+		}
+		if ferr != nil {
+			err, denied = ferr, true
+		}
+	}
+	checked := false
+	if keepReading && !denied && server.requestRouter != nil {
+		// Forwarded requests need not be served by a local method, so the
+		// router sees them before any method lookup error, but only once
+		// they have passed the checks every request passes before its body
+		// is read.
+		if bodyRead, cerr := server.checkHeader(ctx, codec, req); cerr != nil {
+			err = cerr
+			if !bodyRead {
+				// discard body
+				if derr := codec.ReadRequestBody(nil); errors.Is(derr, ErrRequestTooLarge) {
+					err = derr
+				}
+			}
+			return
+		}
+		checked = true
+		if forward = server.requestRouter(req, codec.SourceAddr()); forward != nil {
+			err = nil
+			return
+		}
+	}
+	if keepReading && server.isReplyDigest(req) {
+		// The control frame's body is read by verifyReply.
+		err = nil
+		return
+	}
 	if err != nil {
 		if !keepReading {
 			return
 		}
 		// discard body
-		codec.ReadRequestBody(nil)
+		if derr := codec.ReadRequestBody(nil); errors.Is(derr, ErrRequestTooLarge) {
+			err = derr
+		}
 		return
 	}
 
-	if server.preBodyInterceptor != nil {
-		// Allow interceptor to halt servicing of the request
-		err = server.preBodyInterceptor(req.ServiceMethod, codec.SourceAddr())
-		if err != nil {
+	if !checked {
+		if _, err = server.checkHeader(ctx, codec, req); err != nil {
 			return
 		}
 	}
 
 	// Decode the argument value.
-	argv, argIsValue := interpretArgumentValue(mtype.ArgType)
+	argv, argIsValue := mtype.newArg(server.valuePooling)
 	// argv guaranteed to be a pointer now.
-	if err = codec.ReadRequestBody(argv.Interface()); err != nil {
+	if req.Chunked {
+		var body []byte
+		if body, err = server.readChunkedBody(codec, req); err != nil {
+			return
+		}
+		if bodyRead != nil {
+			bodyRead()
+		}
+		err = joinBody(codec, nil, body, argv.Interface())
+	} else if dc, ok := codec.(DeferredDecodeCodec); ok && bodyRead != nil {
+		// Let the next request be read while this body is decoded.
+		var body RawValue
+		if body, err = dc.ReadRequestBodyRaw(); err != nil {
+			return
+		}
+		bodyRead()
+		err = body.Decode(dc.BodyEncoding(), argv.Interface())
+	} else {
+		err = codec.ReadRequestBody(argv.Interface())
+	}
+	if err != nil {
 		return
 	}
 	if argIsValue {
 		argv = argv.Elem()
 	}
 
-	replyv = interpretReplyValue(mtype.ReplyType)
+	replyv = mtype.newReply(server.valuePooling)
 	return
 }
 
@@ -611,15 +1199,37 @@ func (server *Server) readRequestHeader(codec ServerCodec) (svc *service, mtype
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			return
 		}
-		err = errors.New("rpc: server cannot decode request: " + err.Error())
+		err = fmt.Errorf("rpc: server cannot decode request: %w", err)
 		return
 	}
 
 	// We read the header successfully. If we see an error now,
 	// we can still recover and move on to the next request.
 	keepReading = true
+	req.arrived = time.Now()
 
+	if req.isStreamMessage() {
+		// The message belongs to a call already being served.
+		return
+	}
+	if req.ServiceMethod == pingMethod {
+		return
+	}
 	svc, mtype, err = server.findMethod(req.ServiceMethod)
+	if err == nil && mtype.isStream() != req.Stream {
+		if req.Stream {
+			err = errors.New("rpc: method " + req.ServiceMethod + " is not a streaming method")
+		} else {
+			err = errors.New("rpc: can't call streaming method " + req.ServiceMethod + " without OpenStream")
+		}
+	}
+	if err == nil && mtype.isDuplex() != req.Duplex {
+		if req.Duplex {
+			err = errors.New("rpc: method " + req.ServiceMethod + " is not a duplex method")
+		} else {
+			err = errors.New("rpc: can't call duplex method " + req.ServiceMethod + " without OpenDuplex")
+		}
+	}
 
 	return
 }
@@ -641,31 +1251,78 @@ func (server *Server) findMethod(serviceMethod string) (svc *service, mtype *met
 	}
 	svc = svci.(*service)
 
-	mtype = svc.method[methodName]
+	mtype = svc.methods()[methodName]
 	if mtype == nil {
 		err = errors.New("rpc: can't find method " + serviceMethod)
 	}
 	return
 }
 
+// InvokeMethod calls serviceMethod in process, with the same interceptors,
+// timeouts and accounting as requests read from a connection. decodeArgFn
+// decodes the argument into the pointer it is passed. InvokeMethod returns
+// the reply value once the method returns, or ctx.Err() once ctx is done;
+// a method that ignores its context keeps running in the background.
 func (server *Server) InvokeMethod(
 	ctx context.Context,
 	serviceMethod string,
 	decodeArgFn func(any) error,
 	sourceAddr net.Addr,
 ) (reflect.Value, error) {
+	return server.invoke(ctx, serviceMethod, decodeArgFn, sourceAddr, nil)
+}
+
+// invoke implements InvokeMethod, and InvokeMethodStream if newStream is
+// not nil, in which case it returns the reply value of the streaming method.
+func (server *Server) invoke(
+	ctx context.Context,
+	serviceMethod string,
+	decodeArgFn func(any) error,
+	sourceAddr net.Addr,
+	newStream func(*methodType) reflect.Value,
+) (reflect.Value, error) {
+	arrived := time.Now()
+	ctx, _ = withRequestValues(ctx, sourceAddr)
 	svc, mtype, err := server.findMethod(serviceMethod)
 	if err != nil {
 		return reflect.Value{}, err
 	}
-
-	if server.preBodyInterceptor != nil {
-		// Allow interceptor to halt servicing of the request
-		err = server.preBodyInterceptor(serviceMethod, sourceAddr)
-		if err != nil {
-			return reflect.Value{}, err
+	if err := svc.begin(); err != nil {
+		return reflect.Value{}, err
+	}
+	defer svc.end()
+	ctx = server.withFeatures(ctx, serviceMethod)
+	server.setRequestLogger(ctx, serviceMethod, sourceAddr)
+	if mtype.isStream() != (newStream != nil) {
+		if newStream != nil {
+			return reflect.Value{}, errors.New("rpc: method " + serviceMethod + " is not a streaming method")
 		}
+		return reflect.Value{}, errors.New("rpc: can't call streaming method " + serviceMethod + " with InvokeMethod")
+	}
+	if mtype.isDuplex() {
+		return reflect.Value{}, errors.New("rpc: can't call duplex method " + serviceMethod + " in process")
+	}
+	if !server.beginRequest() {
+		return reflect.Value{}, ErrServerClosed
+	}
+	defer server.endRequest()
+	defer server.untrackCall(server.trackCall(serviceMethod, 0, sourceAddr))
+	if err := ctx.Err(); err != nil {
+		return reflect.Value{}, err
+	}
+
+	if err := server.checkMethodFilters(serviceMethod, sourceAddr); err != nil {
+		return reflect.Value{}, err
+	}
+	// Allow interceptors to halt servicing of the request
+	if err := server.checkPreBody(ctx, serviceMethod, sourceAddr); err != nil {
+		return reflect.Value{}, err
+	}
+	release, err := server.admit(ctx, AdmitPostHeader, serviceMethod, sourceAddr, nil, nil)
+	if err != nil {
+		return reflect.Value{}, err
 	}
+	defer release()
 
 	argv, argIsValue := interpretArgumentValue(mtype.ArgType)
 	argvPtr := argv.Interface()
@@ -673,27 +1330,51 @@ func (server *Server) InvokeMethod(
 	if err := decodeArgFn(argvPtr); err != nil {
 		return reflect.Value{}, err
 	}
+	release, err = server.admit(ctx, AdmitPostBody, serviceMethod, sourceAddr, nil, argvPtr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	defer release()
 
 	if argIsValue {
 		argv = argv.Elem()
 	}
 
-	replyv := interpretReplyValue(mtype.ReplyType)
+	var replyv reflect.Value
+	if newStream != nil {
+		replyv = newStream(mtype)
+	} else {
+		replyv = interpretReplyValue(mtype.ReplyType)
+	}
 
-	function := mtype.method.Func
+	if server.fairness != nil {
+		identity := server.fairness.identify(ctx, sourceAddr)
+		start := time.Now()
+		defer func() { server.fairness.record(identity, time.Since(start)) }()
+	}
+
+	ctx, observed := server.observe(ctx, serviceMethod, argv.Interface())
 
 	// Capture the error so we can directly return it.
 	var callErr error
 	handler := func() error {
-		callErr = callServiceMethod(ctx, mtype.HasContext, function, svc.rcvr, argv, replyv)
+		callErr = callWithContext(ctx, func() error {
+			return server.invokeHandler(ctx, arrived, serviceMethod, mtype, svc.rcvr, argv, replyv)
+		})
 		return callErr
 	}
 
-	if server.serverServiceCallInterceptor != nil {
-		server.serverServiceCallInterceptor(serviceMethod, argv, replyv, handler)
-	} else {
-		_ = handler()
+	server.interceptCall(ctx, serviceMethod, argv, replyv, handler)
+
+	if server.responseInterceptor != nil {
+		var reply interface{}
+		if callErr == nil {
+			// A reply is only safe to use if the handler returned.
+			reply = replyv.Interface()
+		}
+		callErr = server.responseInterceptor(&Response{ServiceMethod: serviceMethod}, reply, callErr)
 	}
+	observed(replyIfOK(replyv, callErr), callErr)
 
 	if callErr != nil {
 		return reflect.Value{}, callErr
@@ -730,6 +1411,36 @@ func interpretReplyValue(replyType reflect.Type) reflect.Value {
 	return replyv
 }
 
+// invokeHandler calls the method, for a request that arrived at arrived,
+// with the server's per-method timeout and records the outcome.
+func (server *Server) invokeHandler(ctx context.Context, arrived time.Time, serviceMethod string, mtype *methodType, rcvr, argv, replyv reflect.Value) error {
+	function := mtype.method.Func
+	timing := startTiming(ctx, arrived)
+	defer func() {
+		server.timingStats.record(timing, time.Since(timing.Started))
+	}()
+	call := func(ctx context.Context) error {
+		return server.recoverHandler(serviceMethod, func() error {
+			if mtype.invoke != nil {
+				return mtype.invoke(ctx, argv, replyv)
+			}
+			return callServiceMethod(ctx, mtype.HasContext, function, rcvr, argv, replyv)
+		})
+	}
+	var callErr error
+	if timeout := server.methodTimeout(serviceMethod); timeout > 0 {
+		callErr = callWithTimeout(ctx, serviceMethod, timeout, call)
+	} else {
+		callErr = call(ctx)
+	}
+	if server.errorBudget != nil {
+		server.errorBudget.record(serviceMethod, callErr)
+	}
+	server.recordCall(serviceMethod, time.Since(timing.Started), callErr)
+	server.slowCalls.check(ctx, serviceMethod, time.Since(timing.Started), argv, callErr)
+	return callErr
+}
+
 func callServiceMethod(ctx context.Context, useCtx bool, function, rcvr, argv, replyv reflect.Value) error {
 	// Invoke the method, providing a new value for the reply.
 	var args []reflect.Value
@@ -681,6 +681,101 @@ func TestPreBodyInterceptor_Failure(t *testing.T) {
 	}
 }
 
+type interceptorCtxKey struct{}
+
+func TestContextInterceptors(t *testing.T) {
+	var calls []string
+	checkCtx := func(name string, ctx context.Context) {
+		calls = append(calls, name)
+		if ctx.Value(interceptorCtxKey{}) != "conn" {
+			t.Errorf("%s: expected context value to be passed through", name)
+		}
+	}
+	newServer := NewServerWithOpts(
+		WithPreBodyInterceptor(func(string, net.Addr) error {
+			calls = append(calls, "preBody")
+			return nil
+		}),
+		WithPreBodyContextInterceptor(func(ctx context.Context, reqServiceMethod string, _ net.Addr) error {
+			checkCtx("preBodyContext", ctx)
+			if reqServiceMethod == "Arith.Div" {
+				return errors.New("request denied")
+			}
+			return nil
+		}),
+		WithServerServiceCallInterceptor(func(_ string, _, _ reflect.Value, handler func() error) {
+			calls = append(calls, "call")
+			handler()
+		}),
+		WithServerServiceCallContextInterceptor(func(ctx context.Context, _ string, _, _ reflect.Value, handler func() error) {
+			checkCtx("callContext", ctx)
+			handler()
+		}),
+	)
+	newServer.Register(new(Arith))
+
+	ctx := context.WithValue(context.Background(), interceptorCtxKey{}, "conn")
+	client := CodecEmulator{server: newServer}
+	reply := new(Reply)
+	client.serviceMethod, client.args, client.reply = "Arith.Add", &Args{7, 8}, reply
+	if err := newServer.ServeRequestContext(ctx, &client); err != nil || client.err != nil {
+		t.Fatalf("Add: %v, %v", err, client.err)
+	}
+	if reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d", reply.C)
+	}
+	expected := []string{"preBody", "preBodyContext", "call", "callContext"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected interceptor calls %v, got %v", expected, calls)
+	}
+
+	calls = nil
+	client.serviceMethod = "Arith.Div"
+	newServer.ServeRequestContext(ctx, &client)
+	if client.err == nil || client.err.Error() != "request denied" {
+		t.Errorf("expected request denied, got %v", client.err)
+	}
+	if len(calls) != 2 {
+		t.Errorf("expected only pre-body interceptors to run, got %v", calls)
+	}
+}
+
+func TestResponseInterceptor(t *testing.T) {
+	var seen []string
+	newServer := NewServerWithOpts(WithResponseInterceptor(func(resp *Response, reply interface{}, handlerErr error) error {
+		seen = append(seen, resp.ServiceMethod)
+		if handlerErr != nil {
+			return errors.New("request failed")
+		}
+		// Redact the result.
+		reply.(*Reply).C = -1
+		return nil
+	}))
+	newServer.Register(new(Arith))
+
+	client := CodecEmulator{server: newServer}
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != -1 {
+		t.Errorf("expected redacted reply, got %d", reply.C)
+	}
+	client.Call("Arith.Div", &Args{7, 0}, reply)
+	if client.err == nil || client.err.Error() != "request failed" {
+		t.Errorf("expected rewritten error, got %v", client.err)
+	}
+	// Requests that fail to decode still report an error.
+	client.Call("Arith.Add", nil, reply)
+	if client.err == nil || client.err.Error() != "request failed" {
+		t.Errorf("expected rewritten error, got %v", client.err)
+	}
+	expected := []string{"Arith.Add", "Arith.Div", "Arith.Add"}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("expected interceptor calls %v, got %v", expected, seen)
+	}
+}
+
 func testServeRequest(t *testing.T, server *Server) {
 	client := CodecEmulator{server: server}
 	defer client.Close()
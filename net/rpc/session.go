@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// maxSessionBits bounds WithSessionBits so each session keeps enough
+// sequence numbers that they never wrap in practice.
+const maxSessionBits = 32
+
+// WithSessionBits reserves the top bits of every sequence number the client
+// assigns for the ID of the logical session that made the call, so that
+// subsystems sharing a connection through Client.Session can be told apart
+// in wire captures and server logs by their sequence numbers alone. Each
+// session numbers its calls from 0 in the remaining bits. Calls made on the
+// Client directly belong to session 0. bits must be at most 32.
+func WithSessionBits(bits uint) func(*Client) {
+	if bits > maxSessionBits {
+		panic(fmt.Sprintf("rpc: %d session bits is more than %d", bits, maxSessionBits))
+	}
+	return func(c *Client) {
+		c.sessionBits = bits
+	}
+}
+
+// SeqSession returns the session ID held in the top bits of seq, as
+// assigned by a client created with WithSessionBits(bits).
+func SeqSession(seq uint64, bits uint) uint64 {
+	if bits == 0 {
+		return 0
+	}
+	return seq >> (64 - bits)
+}
+
+// Session is a logical client sharing the connection of a Client, whose
+// calls carry its ID in their sequence numbers. See WithSessionBits.
+type Session struct {
+	client *Client
+	id     uint64
+}
+
+// Session returns the logical session with the given ID. The client must
+// have been created with WithSessionBits, and id must fit in its session
+// bits.
+func (client *Client) Session(id uint64) *Session {
+	if client.sessionBits == 0 || id > 1<<client.sessionBits-1 {
+		panic(fmt.Sprintf("rpc: session %d does not fit in %d session bits", id, client.sessionBits))
+	}
+	return &Session{client: client, id: id}
+}
+
+// ID returns the session's ID.
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// Go is like Client.Go, for a call made by the session.
+func (s *Session) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, session: s.id}
+	call.Error = s.client.checkDeadline(nil, serviceMethod)
+	return s.client.goCall(call, done)
+}
+
+// Call is like Client.Call, for a call made by the session.
+func (s *Session) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return s.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext is like Client.CallContext, for a call made by the session.
+func (s *Session) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	return s.client.CallContext(context.WithValue(ctx, sessionKey{}, s.id), serviceMethod, args, reply)
+}
+
+type sessionKey struct{}
+
+func sessionFromContext(ctx context.Context) uint64 {
+	id, _ := ctx.Value(sessionKey{}).(uint64)
+	return id
+}
+
+// nextSeq returns the sequence number of the next call of session. The
+// client's mutex must be held.
+func (client *Client) nextSeq(session uint64) uint64 {
+	if client.sessionBits == 0 {
+		seq := client.seq
+		client.seq++
+		return seq
+	}
+	if client.sessionSeqs == nil {
+		client.sessionSeqs = make(map[uint64]uint64)
+	}
+	n := client.sessionSeqs[session]
+	client.sessionSeqs[session] = n + 1
+	shift := 64 - client.sessionBits
+	return session<<shift | n&(1<<shift-1)
+}
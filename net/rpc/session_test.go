@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+// seqRecordingCodec records the sequence numbers of the requests written.
+type seqRecordingCodec struct {
+	ClientCodec
+	mu   sync.Mutex
+	seqs []uint64
+}
+
+func (c *seqRecordingCodec) WriteRequest(r *Request, body interface{}) error {
+	c.mu.Lock()
+	c.seqs = append(c.seqs, r.Seq)
+	c.mu.Unlock()
+	return c.ClientCodec.WriteRequest(r, body)
+}
+
+func TestSessionSeqs(t *testing.T) {
+	_, addr, _ := startNewServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := &seqRecordingCodec{ClientCodec: newGobClientCodec(conn)}
+	const bits = 8
+	client := NewClientWithOpts(codec, WithSessionBits(bits))
+	defer client.Close()
+
+	catalog, health := client.Session(1), client.Session(255)
+	var reply Reply
+	calls := []func() error{
+		func() error { return client.Call("Arith.Add", Args{1, 2}, &reply) },
+		func() error { return catalog.Call("Arith.Add", Args{1, 2}, &reply) },
+		func() error { return health.CallContext(context.Background(), "Arith.Add", Args{1, 2}, &reply) },
+		func() error { return catalog.CallContext(context.Background(), "Arith.Add", Args{1, 2}, &reply) },
+		func() error { return (<-health.Go("Arith.Add", Args{1, 2}, &reply, nil).Done).Error },
+	}
+	for _, call := range calls {
+		if err := call(); err != nil {
+			t.Fatal(err)
+		}
+		if reply.C != 3 {
+			t.Fatalf("got %d", reply.C)
+		}
+	}
+
+	want := []struct{ session, seq uint64 }{{0, 0}, {1, 0}, {255, 0}, {1, 1}, {255, 1}}
+	if len(codec.seqs) != len(want) {
+		t.Fatalf("got seqs %v", codec.seqs)
+	}
+	for i, w := range want {
+		seq := codec.seqs[i]
+		if SeqSession(seq, bits) != w.session || seq&(1<<(64-bits)-1) != w.seq {
+			t.Errorf("call %d: seq %#x, want session %d and seq %d", i, seq, w.session, w.seq)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("session 256 did not panic")
+		}
+	}()
+	client.Session(256)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrServerClosed is returned by ServeRequestAsync and sent to clients for
+// requests that arrive after Shutdown has been called.
+var ErrServerClosed = errors.New("rpc: server closed")
+
+// closedError returns the error serveRequest returns once the server is shut
+// down: io.EOF when serving synchronously, as ServeRequest always has at the
+// end of a connection, and ErrServerClosed otherwise.
+func closedError(bodyRead func()) error {
+	if bodyRead == nil {
+		return io.EOF
+	}
+	return ErrServerClosed
+}
+
+// shutdownPollInterval is how often Shutdown checks for in-flight requests.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully shuts down the server. It stops serving new requests,
+// waits for in-flight requests to complete and then closes every codec the
+// server has served. If ctx is done before the in-flight requests complete,
+// the codecs are closed anyway and ctx.Err() is returned.
+//
+// Once Shutdown has been called, ServeRequest closes the codec and returns
+// io.EOF without reading from it, as it does when a client closes its
+// connection, while ServeRequestAsync returns ErrServerClosed. Because
+// Shutdown closes codecs from its own goroutine, a codec's Close must be
+// safe to call concurrently with the goroutine serving it.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	server.inShutdown = true
+	server.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !server.idle() {
+		select {
+		case <-ctx.Done():
+			server.closeCodecs()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	server.closeCodecs()
+	return nil
+}
+
+func (server *Server) shuttingDown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.inShutdown
+}
+
+func (server *Server) idle() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.inFlight == 0
+}
+
+// beginRequest counts a request as in flight. It reports false if the server
+// is shutting down, in which case the request must not be served.
+func (server *Server) beginRequest() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.inShutdown {
+		return false
+	}
+	server.inFlight++
+	return true
+}
+
+func (server *Server) endRequest() {
+	server.mu.Lock()
+	server.inFlight--
+	server.mu.Unlock()
+}
+
+// CodeUnavailable is the code of the errors sent for calls to services
+// drained with DrainService. Clients can test for it with ErrorCode.
+const CodeUnavailable = "unavailable"
+
+// DrainService stops serving the service registered under name, leaving
+// the server's other services running: calls to the service are refused
+// with a retryable error coded CodeUnavailable, and DrainService waits for
+// the calls in flight to complete. If ctx is done first, it returns
+// ctx.Err(), and the service stays drained. ResumeService serves it again.
+func (server *Server) DrainService(ctx context.Context, name string) error {
+	svc, err := server.lookupService(name)
+	if err != nil {
+		return err
+	}
+	svc.draining.Store(true)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for svc.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// ResumeService serves again the service drained with DrainService.
+func (server *Server) ResumeService(name string) error {
+	svc, err := server.lookupService(name)
+	if err != nil {
+		return err
+	}
+	svc.draining.Store(false)
+	return nil
+}
+
+func (server *Server) lookupService(name string) (*service, error) {
+	svci, ok := server.serviceMap.Load(name)
+	if !ok {
+		return nil, errors.New("rpc: can't find service " + name)
+	}
+	return svci.(*service), nil
+}
+
+// begin counts a request to the service as in flight, unless the service is
+// drained, in which case it returns the error to send the client.
+func (s *service) begin() error {
+	// Counting the request before checking lets DrainService, which sets
+	// draining before it counts, wait for every request that got through.
+	s.inFlight.Add(1)
+	if s.draining.Load() {
+		s.inFlight.Add(-1)
+		return &CodedError{
+			Code:      CodeUnavailable,
+			Message:   "rpc: service " + s.name + " is unavailable",
+			Retryable: true,
+		}
+	}
+	return nil
+}
+
+func (s *service) end() {
+	s.inFlight.Add(-1)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Blocker is a service whose Block method waits until it is released.
+type Blocker struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlocker() *Blocker {
+	return &Blocker{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (b *Blocker) Block(args *Args, reply *Reply) error {
+	b.started <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func startBlockerServer(t *testing.T) (*Server, *Blocker, *Client) {
+	srv := NewServer()
+	blocker := newBlocker()
+	if err := srv.Register(blocker); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return srv, blocker, client
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv, blocker, client := startBlockerServer(t)
+
+	slow := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- srv.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v without waiting for the in-flight call", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(blocker.release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("expected clean shutdown, got %v", err)
+	}
+	if call := <-slow.Done; call.Error != nil {
+		t.Errorf("expected in-flight call to complete, got %v", call.Error)
+	}
+
+	// The connection has been closed by the server.
+	if err := client.Call("Blocker.Block", &Args{}, new(Reply)); err == nil {
+		t.Error("expected error calling a shut down server")
+	}
+	if err := srv.ServeRequest(&CodecEmulator{server: srv}); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	srv, blocker, client := startBlockerServer(t)
+	defer close(blocker.release)
+
+	slow := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if call := <-slow.Done; call.Error == nil {
+		t.Error("expected the in-flight call to fail once its connection was closed")
+	}
+}
+
+func TestDrainService(t *testing.T) {
+	srv := NewServer()
+	blocker := newBlocker()
+	if err := srv.RegisterAll(blocker, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	slow := client.Go("Blocker.Block", &Args{}, new(Reply), nil)
+	<-blocker.started
+
+	// Draining waits for the call in flight.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := srv.DrainService(ctx, "Blocker"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	err = client.Call("Blocker.Block", &Args{}, new(Reply))
+	if ErrorCode(err) != CodeUnavailable || !IsRetryable(err) {
+		t.Errorf("expected a retryable unavailable error, got %v", err)
+	}
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("expected other services to be served, got %d, %v", reply.C, err)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- srv.DrainService(context.Background(), "Blocker") }()
+	close(blocker.release)
+	if call := <-slow.Done; call.Error != nil {
+		t.Errorf("expected the call in flight to complete, got %v", call.Error)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.ResumeService("Blocker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Blocker.Block", &Args{}, new(Reply)); err != nil {
+		t.Errorf("expected the resumed service to be served, got %v", err)
+	}
+	if err := srv.DrainService(context.Background(), "Missing"); err == nil {
+		t.Error("expected an unknown service to be reported")
+	}
+}
+
+// closeRecorder is a CodecEmulator recording whether it was closed.
+type closeRecorder struct {
+	CodecEmulator
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestServeRequestAfterShutdown(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	codec := new(closeRecorder)
+	if err := srv.ServeRequest(codec); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+	if !codec.closed {
+		t.Error("expected the codec to be closed")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"encoding/gob"
+	"net"
+	"reflect"
+	"time"
+)
+
+// SlowCallInfo describes a call whose handler ran for longer than the
+// threshold set with WithSlowRequestThreshold.
+type SlowCallInfo struct {
+	ServiceMethod string
+	Duration      time.Duration // from the start of the handler to its return
+	// Peer is the client the request came from, or nil if it is unknown, as
+	// for InvokeMethod without a sourceAddr.
+	Peer net.Addr
+	// ArgBytes is the size of the argument encoded with gob, or -1 if it
+	// cannot be. It is computed only for slow calls, so it costs nothing
+	// for the others.
+	ArgBytes int64
+	Err      error // returned by the handler
+}
+
+// WithSlowRequestThreshold makes the server call fn for each call whose
+// handler runs for threshold or longer, to find the methods and arguments
+// responsible for tail latency. fn is called on the goroutine serving the
+// request, before its response is written, so it should not block.
+func WithSlowRequestThreshold(threshold time.Duration, fn func(SlowCallInfo)) func(*Server) {
+	return func(s *Server) {
+		s.slowCalls = &slowCallHook{threshold: threshold, fn: fn}
+	}
+}
+
+type slowCallHook struct {
+	threshold time.Duration
+	fn        func(SlowCallInfo)
+}
+
+// check calls the hook if a call took at least its threshold. It does
+// nothing on a nil hook.
+func (h *slowCallHook) check(ctx context.Context, serviceMethod string, d time.Duration, argv reflect.Value, err error) {
+	if h == nil || d < h.threshold {
+		return
+	}
+	info := SlowCallInfo{
+		ServiceMethod: serviceMethod,
+		Duration:      d,
+		ArgBytes:      encodedSize(argv),
+		Err:           err,
+	}
+	if p, ok := PeerFromContext(ctx); ok {
+		info.Peer = p
+	}
+	h.fn(info)
+}
+
+// encodedSize returns the size of v encoded with gob, or -1 if it cannot be
+// encoded.
+func encodedSize(v reflect.Value) int64 {
+	var w countingWriter
+	if !v.IsValid() || gob.NewEncoder(&w).EncodeValue(v) != nil {
+		return -1
+	}
+	return int64(w)
+}
+
+// countingWriter discards what is written to it, counting the bytes.
+type countingWriter int64
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	*w += countingWriter(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestThreshold(t *testing.T) {
+	var mu sync.Mutex
+	var slow []SlowCallInfo
+	srv := NewServerWithOpts(WithSlowRequestThreshold(100*time.Millisecond, func(info SlowCallInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		slow = append(slow, info)
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Call("Arith.SleepMilli", &Args{A: 0}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Arith.SleepMilli", &Args{A: 150}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(slow) != 1 {
+		t.Fatalf("expected one slow call, got %+v", slow)
+	}
+	info := slow[0]
+	if info.ServiceMethod != "Arith.SleepMilli" || info.Duration < 150*time.Millisecond || info.Err != nil {
+		t.Errorf("unexpected slow call %+v", info)
+	}
+	if info.Peer == nil || info.Peer.Network() != "tcp" {
+		t.Errorf("expected the client's address, got %v", info.Peer)
+	}
+	if info.ArgBytes <= 0 {
+		t.Errorf("expected the argument size, got %d", info.ArgBytes)
+	}
+
+}
@@ -0,0 +1,260 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram
+// buckets used unless WithLatencyBuckets is given.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// ServerStats holds the statistics of each method the server has handled
+// calls to, keyed by service method.
+type ServerStats map[string]MethodStats
+
+// MethodStats counts the calls to a method and how long they took.
+type MethodStats struct {
+	Calls   uint64
+	Errors  uint64 // calls whose handler returned an error
+	Latency Histogram
+}
+
+// Histogram counts durations in buckets.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, in increasing
+	// order.
+	Bounds []time.Duration
+	// Counts holds the number of durations in each bucket. It has one more
+	// element than Bounds, counting the durations above the last bound.
+	Counts []uint64
+	Sum    time.Duration
+}
+
+// Count returns the number of durations counted.
+func (h Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Quantile returns an estimate of the q quantile, from 0 to 1, of the
+// durations: the upper bound of the bucket it falls in. Durations above the
+// last bound are reported as the last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 || len(h.Bounds) == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var n uint64
+	for i, c := range h.Counts[:len(h.Bounds)] {
+		n += c
+		if n >= rank {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })
+	h.Counts[i]++
+	h.Sum += d
+}
+
+// MetricsSink receives a record of each call the server handles, for export
+// to a metrics system.
+type MetricsSink interface {
+	// RecordCall is called once the handler of a call to serviceMethod has
+	// returned err after latency. It is called from the request's goroutine,
+	// so it must not block.
+	RecordCall(serviceMethod string, latency time.Duration, err error)
+}
+
+// WithMetricsSink adds a sink receiving a record of each call. Server.Stats
+// is kept whether or not sinks are added.
+func WithMetricsSink(sink MetricsSink) func(*Server) {
+	return func(s *Server) {
+		s.metricsSinks = append(s.metricsSinks, sink)
+	}
+}
+
+// WithLatencyBuckets sets the upper bounds, in increasing order, of the
+// buckets of the latency histograms reported by Server.Stats.
+func WithLatencyBuckets(bounds ...time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.methodStats.bounds = append([]time.Duration(nil), bounds...)
+	}
+}
+
+// Stats returns the statistics of each method called so far. They cover
+// the handlers of requests served locally, as InvokeMethod and
+// RequestTimingStats do, not requests forwarded elsewhere.
+func (server *Server) Stats() ServerStats {
+	return server.methodStats.snapshot()
+}
+
+type methodStatsTracker struct {
+	bounds  []time.Duration // nil for DefaultLatencyBuckets
+	methods sync.Map        // map[string]*methodStatsEntry
+}
+
+type methodStatsEntry struct {
+	mu    sync.Mutex // protects stats
+	stats MethodStats
+}
+
+func (t *methodStatsTracker) record(serviceMethod string, latency time.Duration, err error) {
+	v, ok := t.methods.Load(serviceMethod)
+	if !ok {
+		bounds := t.bounds
+		if bounds == nil {
+			bounds = DefaultLatencyBuckets
+		}
+		entry := &methodStatsEntry{stats: MethodStats{Latency: Histogram{
+			Bounds: bounds,
+			Counts: make([]uint64, len(bounds)+1),
+		}}}
+		v, _ = t.methods.LoadOrStore(serviceMethod, entry)
+	}
+	entry := v.(*methodStatsEntry)
+	entry.mu.Lock()
+	entry.stats.Calls++
+	if err != nil {
+		entry.stats.Errors++
+	}
+	entry.stats.Latency.observe(latency)
+	entry.mu.Unlock()
+}
+
+func (t *methodStatsTracker) snapshot() ServerStats {
+	stats := make(ServerStats)
+	t.methods.Range(func(key, value any) bool {
+		entry := value.(*methodStatsEntry)
+		entry.mu.Lock()
+		s := entry.stats
+		s.Latency.Counts = append([]uint64(nil), s.Latency.Counts...)
+		entry.mu.Unlock()
+		stats[key.(string)] = s
+		return true
+	})
+	return stats
+}
+
+// recordCall records a call in the server's stats and sinks.
+func (server *Server) recordCall(serviceMethod string, latency time.Duration, err error) {
+	server.methodStats.record(serviceMethod, latency, err)
+	for _, sink := range server.metricsSinks {
+		sink.RecordCall(serviceMethod, latency, err)
+	}
+}
+
+// GoMetrics is the part of the API of github.com/hashicorp/go-metrics (and
+// github.com/armon/go-metrics) used by GoMetricsSink. Both *metrics.Metrics
+// and metrics.MetricSink implement it.
+type GoMetrics interface {
+	IncrCounter(key []string, val float32)
+	AddSample(key []string, val float32)
+}
+
+// GoMetricsSink returns a MetricsSink reporting to m. For each call it
+// increments the counter prefix.<service method>.calls, and
+// prefix.<service method>.errors if the call failed, and adds the latency in
+// milliseconds as a sample of prefix.<service method>.latency.
+func GoMetricsSink(m GoMetrics, prefix ...string) MetricsSink {
+	return goMetricsSink{m: m, prefix: append([]string(nil), prefix...)}
+}
+
+type goMetricsSink struct {
+	m      GoMetrics
+	prefix []string
+}
+
+func (s goMetricsSink) RecordCall(serviceMethod string, latency time.Duration, err error) {
+	key := func(name string) []string {
+		return append(append(make([]string, 0, len(s.prefix)+2), s.prefix...), serviceMethod, name)
+	}
+	s.m.IncrCounter(key("calls"), 1)
+	if err != nil {
+		s.m.IncrCounter(key("errors"), 1)
+	}
+	s.m.AddSample(key("latency"), float32(latency)/float32(time.Millisecond))
+}
+
+// WritePrometheus writes stats in the Prometheus text exposition format, as
+// the counters rpc_server_calls_total and rpc_server_errors_total and the
+// histogram rpc_server_latency_seconds, labelled by method.
+func (stats ServerStats) WritePrometheus(w io.Writer) error {
+	methods := make([]string, 0, len(stats))
+	for method := range stats {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	var b strings.Builder
+	b.WriteString("# HELP rpc_server_calls_total Calls handled by the server.\n# TYPE rpc_server_calls_total counter\n")
+	for _, method := range methods {
+		fmt.Fprintf(&b, "rpc_server_calls_total{method=%q} %d\n", method, stats[method].Calls)
+	}
+	b.WriteString("# HELP rpc_server_errors_total Calls whose handler returned an error.\n# TYPE rpc_server_errors_total counter\n")
+	for _, method := range methods {
+		fmt.Fprintf(&b, "rpc_server_errors_total{method=%q} %d\n", method, stats[method].Errors)
+	}
+	b.WriteString("# HELP rpc_server_latency_seconds Time taken by handlers.\n# TYPE rpc_server_latency_seconds histogram\n")
+	for _, method := range methods {
+		h := stats[method].Latency
+		var n uint64
+		for i, bound := range h.Bounds {
+			n += h.Counts[i]
+			fmt.Fprintf(&b, "rpc_server_latency_seconds_bucket{method=%q,le=%q} %d\n", method, formatSeconds(bound), n)
+		}
+		n += h.Counts[len(h.Bounds)]
+		fmt.Fprintf(&b, "rpc_server_latency_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, n)
+		fmt.Fprintf(&b, "rpc_server_latency_seconds_sum{method=%q} %s\n", method, formatSeconds(h.Sum))
+		fmt.Fprintf(&b, "rpc_server_latency_seconds_count{method=%q} %d\n", method, n)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// PrometheusHandler returns an http.Handler serving the server's metrics for
+// Prometheus to scrape. See HandleMetrics.
+func PrometheusHandler(server *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		server.writePrometheus(w)
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingGoMetrics struct {
+	mu       sync.Mutex
+	counters map[string]float32
+	samples  map[string]int
+}
+
+func (m *recordingGoMetrics) IncrCounter(key []string, val float32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[strings.Join(key, ".")] += val
+}
+
+func (m *recordingGoMetrics) AddSample(key []string, val float32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples[strings.Join(key, ".")]++
+}
+
+func TestServerStats(t *testing.T) {
+	m := &recordingGoMetrics{counters: make(map[string]float32), samples: make(map[string]int)}
+	srv := NewServerWithOpts(
+		WithMetricsSink(GoMetricsSink(m, "consul", "rpc")),
+		WithLatencyBuckets(time.Millisecond, time.Hour),
+	)
+	srv.Register(new(Arith))
+
+	add := func(v any) error {
+		*v.(*Args) = Args{A: 1, B: 2}
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := srv.InvokeMethod(context.Background(), "Arith.Add", add, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.Div", func(v any) error { return nil }, nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	stats := srv.Stats()
+	if s := stats["Arith.Add"]; s.Calls != 3 || s.Errors != 0 || s.Latency.Count() != 3 {
+		t.Errorf("Arith.Add: %+v", s)
+	}
+	div := stats["Arith.Div"]
+	if div.Calls != 1 || div.Errors != 1 {
+		t.Errorf("Arith.Div: %+v", div)
+	}
+	if len(div.Latency.Counts) != 3 || div.Latency.Quantile(0.99) > time.Hour {
+		t.Errorf("Arith.Div latency: %+v", div.Latency)
+	}
+
+	if m.counters["consul.rpc.Arith.Add.calls"] != 3 || m.counters["consul.rpc.Arith.Div.errors"] != 1 ||
+		m.counters["consul.rpc.Arith.Add.errors"] != 0 || m.samples["consul.rpc.Arith.Add.latency"] != 3 {
+		t.Errorf("go-metrics got counters %v and samples %v", m.counters, m.samples)
+	}
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler(srv).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`rpc_server_calls_total{method="Arith.Add"} 3`,
+		`rpc_server_errors_total{method="Arith.Div"} 1`,
+		`rpc_server_latency_seconds_bucket{method="Arith.Add",le="3600"} 3`,
+		`rpc_server_latency_seconds_bucket{method="Arith.Add",le="+Inf"} 3`,
+		`rpc_server_latency_seconds_count{method="Arith.Div"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in:\n%s", want, body)
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := Histogram{
+		Bounds: []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond},
+		Counts: []uint64{50, 40, 9, 1},
+	}
+	for q, want := range map[float64]time.Duration{
+		0:    time.Millisecond,
+		0.5:  time.Millisecond,
+		0.9:  10 * time.Millisecond,
+		0.99: 100 * time.Millisecond,
+		1:    100 * time.Millisecond,
+	} {
+		if got := h.Quantile(q); got != want {
+			t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var typeOfStreamer = reflect.TypeOf((*streamer)(nil)).Elem()
+
+var (
+	errStreamUnsupported = errors.New("rpc: stream is not connected")
+	errStreamClosed      = errors.New("rpc: stream closed")
+)
+
+// Stream is the reply argument of streaming methods, which send any number
+// of values to the caller before returning:
+//
+//	func (t *T) Watch(ctx context.Context, args *WatchArgs, stream *rpc.Stream) error
+//
+// Streaming methods are registered like any other method. They are called
+// over a connection with OpenStream, or in process with InvokeMethodStream;
+// Call and InvokeMethod fail for them. Methods sending values of a single
+// type can take a *SendStream[T] instead.
+type Stream struct {
+	ctx    context.Context
+	send   func(interface{}) error
+	cancel func() // called by close, if set, to stop a send waiting for room
+
+	mu     sync.Mutex // held while sending
+	closed bool
+}
+
+// Context returns the context of the call the stream belongs to.
+func (s *Stream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Send delivers v to the caller. It returns the context's error once the call
+// is cancelled, and otherwise the error returned by the caller's send
+// function, or by the codec writing v to the connection, after which the
+// method should stop sending and return.
+func (s *Stream) Send(v interface{}) error {
+	if s.send == nil {
+		return errStreamUnsupported
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Context().Err(); err != nil {
+		return err
+	}
+	if s.closed {
+		return errStreamClosed
+	}
+	return s.send(v)
+}
+
+// close stops the stream from sending, waiting for a send in progress.
+func (s *Stream) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+func (s *Stream) stream() *Stream {
+	return s
+}
+
+// SendStream is a Stream sending values of type T:
+//
+//	func (t *T) Watch(ctx context.Context, args *WatchArgs, stream *rpc.SendStream[Event]) error
+type SendStream[T any] struct {
+	Stream
+}
+
+// Send delivers v to the caller, as Stream.Send does.
+func (s *SendStream[T]) Send(v T) error {
+	return s.Stream.Send(v)
+}
+
+// streamer is implemented by *Stream and by the *SendStream types that embed
+// it.
+type streamer interface {
+	stream() *Stream
+}
+
+func (m *methodType) isStream() bool {
+	return m.ReplyType.Implements(typeOfStreamer)
+}
+
+// newStream returns the reply value of a call to the streaming method m,
+// with its stream bound to ctx and send.
+func (m *methodType) newStream(ctx context.Context, send func(interface{}) error) (reflect.Value, *Stream) {
+	replyv := reflect.New(m.ReplyType.Elem())
+	stream := replyv.Interface().(streamer).stream()
+	stream.ctx = ctx
+	stream.send = send
+	return replyv, stream
+}
+
+// InvokeMethodStream calls the streaming method serviceMethod like
+// InvokeMethod, passing every value the method sends to send. It returns
+// once the method returns, or with ctx.Err() once ctx is done. send is not
+// called after InvokeMethodStream returns.
+func (server *Server) InvokeMethodStream(
+	ctx context.Context,
+	serviceMethod string,
+	decodeArgFn func(any) error,
+	sourceAddr net.Addr,
+	send func(interface{}) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stream *Stream
+	defer func() {
+		if stream != nil {
+			stream.close()
+		}
+	}()
+	_, err := server.invoke(ctx, serviceMethod, decodeArgFn, sourceAddr, func(m *methodType) reflect.Value {
+		var replyv reflect.Value
+		replyv, stream = m.newStream(ctx, send)
+		return replyv
+	})
+	return err
+}
+
+// emptyBody is the body of the stream messages that carry no value, such as
+// the response ending a stream.
+type emptyBody struct{}
+
+// newConnStream returns the reply value of req, a call to the streaming
+// method m read from codec. The values the method sends are written to
+// codec as responses marked StreamItem.
+func (server *Server) newConnStream(ctx context.Context, sending *writeQueue, m *methodType, req *Request, codec ServerCodec) reflect.Value {
+	serviceMethod, seq := req.ServiceMethod, req.Seq
+	priority := WritePrioritySmall
+	if server.isBulkMethod(serviceMethod) {
+		priority = WritePriorityBulk
+	}
+	replyv, _ := m.newStream(ctx, func(v interface{}) error {
+		resp := server.getResponse()
+		resp.ServiceMethod = serviceMethod
+		resp.Seq = seq
+		resp.StreamItem = true
+		sending.Lock(priority)
+		err := codec.WriteResponse(resp, v)
+		sending.Unlock()
+		server.freeResponse(resp)
+		return err
+	})
+	return replyv
+}
+
+// streamBufferSize is the number of values a RecvStream holds that were
+// received but not yet read.
+const streamBufferSize = 16
+
+// RecvStream receives the values sent by a streaming method called with
+// OpenStream.
+type RecvStream[T any] struct {
+	client *Client
+	call   *Call
+	ctx    context.Context
+	items  chan *T
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	err error // set once the call is done
+}
+
+// OpenStream calls the streaming method serviceMethod over client's
+// connection and returns the stream of values it sends, each decoded into a
+// new T. If ctx has a deadline, it is sent to the server as CallContext
+// does, and the stream fails with ctx.Err() once ctx is done. The codec must
+// carry the Request's Stream and the Response's StreamItem fields, as those
+// of this package, msgpackrpc and protorpc do.
+//
+// Values are read from the connection as they arrive and buffered until
+// Recv is called. Once the buffer is full, reading stops until there is
+// room, which delays the responses of the client's other calls, so streams
+// must be read promptly or closed.
+func OpenStream[T any](ctx context.Context, client *Client, serviceMethod string, args interface{}) (*RecvStream[T], error) {
+	if err := client.checkDeadline(ctx, serviceMethod); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := &RecvStream[T]{
+		client: client,
+		ctx:    ctx,
+		items:  make(chan *T, streamBufferSize),
+		closed: make(chan struct{}),
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		stream:        s,
+	}
+	if md := MetadataFromContext(ctx); md != nil {
+		call.metadata = client.metadata.Merge(md)
+	}
+	call.session = sessionFromContext(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		if call.timeout = time.Until(deadline); call.timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	if err := client.authenticate(ctx, call); err != nil {
+		return nil, err
+	}
+	s.call = call
+	client.send(call)
+	return s, nil
+}
+
+// Recv returns the next value sent by the method. Once the method has
+// returned and every value has been received, Recv returns io.EOF, or the
+// error the method returned.
+func (s *RecvStream[T]) Recv() (T, error) {
+	var zero T
+	select {
+	case v := <-s.items:
+		return *v, nil
+	default:
+	}
+	if s.err != nil {
+		return zero, s.err
+	}
+	select {
+	case v := <-s.items:
+		return *v, nil
+	case call := <-s.call.Done:
+		s.err = call.Error
+		if s.err == nil {
+			s.err = io.EOF
+		}
+		// Every value was queued before the call was done.
+		select {
+		case v := <-s.items:
+			return *v, nil
+		default:
+			return zero, s.err
+		}
+	case <-s.ctx.Done():
+		s.Close()
+		s.err = s.ctx.Err()
+		return zero, s.err
+	case <-s.closed:
+		return zero, errStreamClosed
+	}
+}
+
+// Close stops receiving the stream. Values the method sends afterwards are
+// discarded. The method itself keeps running until it returns or its
+// context expires.
+func (s *RecvStream[T]) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.client.abandon(s.call)
+	})
+	return nil
+}
+
+// receive reads the body of resp, a StreamItem response, with codec and
+// queues it, waiting for room unless the stream is closed.
+func (s *RecvStream[T]) receive(codec ClientCodec, resp *Response) error {
+	if !resp.StreamItem {
+		return codec.ReadResponseBody(nil)
+	}
+	v := new(T)
+	if err := codec.ReadResponseBody(v); err != nil {
+		return err
+	}
+	select {
+	case s.items <- v:
+	case <-s.closed:
+	}
+	return nil
+}
+
+// end does nothing: Recv learns the call is done from its Done channel.
+func (s *RecvStream[T]) end(error) {}
+
+// streamReceiver is implemented by RecvStream and DuplexCall.
+type streamReceiver interface {
+	// receive reads the body of a StreamItem or StreamCredit response
+	// for the call.
+	receive(codec ClientCodec, resp *Response) error
+	// end is called once the call is done, with its error.
+	end(err error)
+}
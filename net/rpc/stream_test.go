@@ -0,0 +1,215 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Counter struct{}
+
+// Count sends the numbers from 1 to n, or until the stream fails.
+func (Counter) Count(ctx context.Context, n int, stream *Stream) error {
+	for i := 1; i <= n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestInvokeMethodStream(t *testing.T) {
+	srv := NewServer()
+	if err := srv.RegisterAll(Counter{}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	decode := func(n int) func(any) error {
+		return func(argvPtr any) error {
+			*(argvPtr.(*int)) = n
+			return nil
+		}
+	}
+
+	var got []int
+	err := srv.InvokeMethodStream(context.Background(), "Counter.Count", decode(3), nil, func(v interface{}) error {
+		got = append(got, v.(int))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", got)
+	}
+
+	// An error from send ends the stream.
+	errStop := errors.New("stop")
+	err = srv.InvokeMethodStream(context.Background(), "Counter.Count", decode(3), nil, func(v interface{}) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("expected the send error, got %v", err)
+	}
+
+	if _, err := srv.InvokeMethod(context.Background(), "Counter.Count", decode(3), nil); err == nil || !strings.Contains(err.Error(), "streaming method") {
+		t.Errorf("expected InvokeMethod to reject a streaming method, got %v", err)
+	}
+	err = srv.InvokeMethodStream(context.Background(), "Arith.Mul", decode(3), nil, func(interface{}) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "not a streaming method") {
+		t.Errorf("expected InvokeMethodStream to reject Arith.Mul, got %v", err)
+	}
+
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	err = client.Call("Counter.Count", 3, new(Stream))
+	if err == nil || !strings.Contains(err.Error(), "can't call streaming method") {
+		t.Errorf("expected streaming method to be rejected on a connection, got %v", err)
+	}
+}
+
+func TestInvokeMethodContext(t *testing.T) {
+	srv := NewServer()
+	srv.Register(new(Arith))
+	decode := func(argvPtr any) error {
+		*(argvPtr.(*Args)) = Args{A: 200}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := srv.InvokeMethod(ctx, "Arith.SleepMilli", decode, nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// The call returns at the deadline even though the handler ignores it.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := srv.InvokeMethod(ctx, "Arith.SleepMilli", decode, nil); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("expected InvokeMethod to return at the deadline, took %v", elapsed)
+	}
+
+	// Requests are refused once the server shuts down.
+	srv.Shutdown(context.Background())
+	if _, err := srv.InvokeMethod(context.Background(), "Arith.SleepMilli", decode, nil); err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+type Watcher struct{}
+
+// Watch sends n replies, then fails if n is odd.
+func (Watcher) Watch(ctx context.Context, n int, stream *SendStream[Reply]) error {
+	for i := 1; i <= n; i++ {
+		if err := stream.Send(Reply{C: i}); err != nil {
+			return err
+		}
+	}
+	if n%2 == 1 {
+		return errors.New("odd")
+	}
+	return nil
+}
+
+func TestOpenStream(t *testing.T) {
+	srv := NewServer()
+	if err := srv.RegisterAll(Watcher{}, new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	recvAll := func(s *RecvStream[Reply]) ([]int, error) {
+		var got []int
+		for {
+			reply, err := s.Recv()
+			if err != nil {
+				return got, err
+			}
+			got = append(got, reply.C)
+		}
+	}
+
+	// More values than the stream buffers.
+	s, err := OpenStream[Reply](ctx, client, "Watcher.Watch", 2*streamBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := recvAll(s)
+	if err != io.EOF || len(got) != 2*streamBufferSize || got[len(got)-1] != 2*streamBufferSize {
+		t.Fatalf("expected values up to %d then EOF, got %v, %v", 2*streamBufferSize, got, err)
+	}
+
+	// Other calls complete while the stream's values are buffered.
+	s, err = OpenStream[Reply](ctx, client, "Watcher.Watch", streamBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := recvAll(s); err != io.EOF || len(got) != streamBufferSize {
+		t.Fatalf("expected %d values then EOF, got %v, %v", streamBufferSize, got, err)
+	}
+
+	// The method's error follows its values.
+	s, err = OpenStream[Reply](ctx, client, "Watcher.Watch", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := recvAll(s); len(got) != 3 || err == nil || err.Error() != "odd" {
+		t.Errorf("expected 3 values and the method's error, got %v, %v", got, err)
+	}
+
+	// Values sent after Close are discarded without stalling the client.
+	s, err = OpenStream[Reply](ctx, client, "Watcher.Watch", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenStream[Reply](ctx, client, "Arith.Add", Args{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Recv(); err == nil || !strings.Contains(err.Error(), "not a streaming method") {
+		t.Errorf("expected OpenStream to reject Arith.Add, got %v", err)
+	}
+
+	var sent []int
+	err = srv.InvokeMethodStream(ctx, "Watcher.Watch", func(argvPtr any) error {
+		*(argvPtr.(*int)) = 2
+		return nil
+	}, nil, func(v interface{}) error {
+		sent = append(sent, v.(Reply).C)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(sent, []int{1, 2}) {
+		t.Errorf("expected InvokeMethodStream to send [1 2], got %v, %v", sent, err)
+	}
+}
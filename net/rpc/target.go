@@ -0,0 +1,183 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SchemeDialer connects to the endpoint a dial target names. address is the
+// part of the target after "scheme://".
+type SchemeDialer func(ctx context.Context, address string) (net.Conn, error)
+
+var schemes = struct {
+	sync.RWMutex
+	m map[string]SchemeDialer
+}{m: map[string]SchemeDialer{
+	"fd":  dialFD,
+	"mem": dialMem,
+}}
+
+// RegisterScheme makes dial targets of the form "scheme://address" connect
+// with dial. The "tcp", "unix", "fd" and "mem" schemes are built in.
+// RegisterScheme panics if scheme is already registered.
+func RegisterScheme(scheme string, dial SchemeDialer) {
+	if dial == nil {
+		panic("rpc: RegisterScheme dialer is nil")
+	}
+	schemes.Lock()
+	defer schemes.Unlock()
+	if _, dup := schemes.m[scheme]; dup || scheme == "tcp" || scheme == "unix" {
+		panic("rpc: RegisterScheme called twice for scheme " + scheme)
+	}
+	schemes.m[scheme] = dial
+}
+
+// WithScheme makes the Dialer connect to targets of the form
+// "scheme://address" with dial, in place of any registered dialer.
+func WithScheme(scheme string, dial SchemeDialer) func(*Dialer) {
+	return func(d *Dialer) {
+		if d.schemes == nil {
+			d.schemes = make(map[string]SchemeDialer)
+		}
+		d.schemes[scheme] = dial
+	}
+}
+
+// DialTarget connects to the RPC server a target names, so that
+// configuration can describe any endpoint as a single string:
+//
+//	host:port, tcp://host:port  a TCP address, dialed with the Dialer's transports
+//	unix:///path/to/socket      a Unix domain socket, likewise
+//	fd://3                      a connected socket the process inherited
+//	mem://name                  a listener returned by ListenMem
+//
+// Other schemes must be registered with RegisterScheme or WithScheme.
+func (d *Dialer) DialTarget(ctx context.Context, target string) (*Client, error) {
+	scheme, address := "tcp", target
+	if i := strings.Index(target, "://"); i >= 0 {
+		scheme, address = target[:i], target[i+len("://"):]
+	}
+	dial := d.schemes[scheme]
+	if dial == nil {
+		if scheme == "tcp" || scheme == "unix" {
+			return d.DialContext(ctx, scheme, address)
+		}
+		schemes.RLock()
+		dial = schemes.m[scheme]
+		schemes.RUnlock()
+	}
+	if dial == nil {
+		return nil, fmt.Errorf("rpc: unknown scheme %q in dial target %q", scheme, target)
+	}
+	conn, err := dial(ctx, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: scheme, Err: err}
+	}
+	return NewClientWithOpts(newGobClientCodec(conn), d.clientOptions...), nil
+}
+
+// DialTarget connects to the RPC server a target names, as described on
+// Dialer.DialTarget.
+func DialTarget(ctx context.Context, target string) (*Client, error) {
+	return new(Dialer).DialTarget(ctx, target)
+}
+
+func dialFD(ctx context.Context, address string) (net.Conn, error) {
+	fd, err := strconv.Atoi(address)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid file descriptor %q", address)
+	}
+	f := os.NewFile(uintptr(fd), "fd://"+address)
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// ErrMemRefused is returned when dialing a mem:// target nothing listens on.
+var ErrMemRefused = errors.New("rpc: no in-memory listener")
+
+var memListeners = struct {
+	sync.Mutex
+	m map[string]*memListener
+}{m: make(map[string]*memListener)}
+
+// ListenMem returns a listener for mem://name targets, which connect over
+// in-memory pipes. It lets tests substitute an in-process server for an
+// address that configuration names, without changing the code that dials.
+// The name is free again once the listener is closed.
+func ListenMem(name string) (net.Listener, error) {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+	if _, ok := memListeners.m[name]; ok {
+		return nil, fmt.Errorf("rpc: mem://%s is already in use", name)
+	}
+	l := &memListener{
+		addr:  MemAddr(name),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	memListeners.m[name] = l
+	return l, nil
+}
+
+func dialMem(ctx context.Context, name string) (net.Conn, error) {
+	memListeners.Lock()
+	l := memListeners.m[name]
+	memListeners.Unlock()
+	if l == nil {
+		return nil, ErrMemRefused
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrMemRefused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// MemAddr is the address of a listener returned by ListenMem.
+type MemAddr string
+
+// Network returns "mem".
+func (a MemAddr) Network() string { return "mem" }
+
+func (a MemAddr) String() string { return string(a) }
+
+type memListener struct {
+	addr      MemAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		memListeners.Lock()
+		delete(memListeners.m, string(l.addr))
+		memListeners.Unlock()
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var registerTestScheme sync.Once
+
+func TestDialTarget(t *testing.T) {
+	srv, addr, _ := startNewServer(t)
+
+	mem, err := ListenMem("arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	go accept(srv, mem)
+	if _, err := ListenMem("arith"); err == nil {
+		t.Error("expected a second listener on the same name to fail")
+	}
+
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "rpc.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close()
+	go accept(srv, unix)
+
+	tcp, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := tcp.(*net.TCPConn).File()
+	tcp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, target := range []string{
+		addr,
+		"tcp://" + addr,
+		"unix://" + unix.Addr().String(),
+		"fd://" + strconv.Itoa(int(f.Fd())),
+		"mem://arith",
+	} {
+		client, err := DialTarget(context.Background(), target)
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		reply := new(Reply)
+		if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil || reply.C != 3 {
+			t.Errorf("%s: got %d, %v", target, reply.C, err)
+		}
+		client.Close()
+	}
+
+	mem.Close()
+	if _, err := DialTarget(context.Background(), "mem://arith"); !errors.Is(err, ErrMemRefused) {
+		t.Errorf("expected a closed listener to refuse, got %v", err)
+	}
+	if _, err := DialTarget(context.Background(), "bogus://x"); err == nil || !strings.Contains(err.Error(), `unknown scheme "bogus"`) {
+		t.Errorf("expected an unknown scheme to fail, got %v", err)
+	}
+}
+
+// dialKey lets each run of TestDialTargetScheme pass its dialer to the
+// scheme registered once for the process.
+type dialKey struct{}
+
+func TestDialTargetScheme(t *testing.T) {
+	srv, _, _ := startNewServer(t)
+	var dialed []string
+	dial := func(ctx context.Context, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		cli, conn := net.Pipe()
+		go serveConn(srv, conn)
+		return cli, nil
+	}
+	registerTestScheme.Do(func() {
+		RegisterScheme("test-registered", func(ctx context.Context, address string) (net.Conn, error) {
+			return ctx.Value(dialKey{}).(SchemeDialer)(ctx, address)
+		})
+	})
+	ctx := context.WithValue(context.Background(), dialKey{}, SchemeDialer(dial))
+	d := NewDialer(WithScheme("test-dialer", dial))
+
+	for _, target := range []string{"test-registered://a", "test-dialer://b"} {
+		client, err := d.DialTarget(ctx, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Call("Arith.Add", Args{1, 2}, new(Reply)); err != nil {
+			t.Error(err)
+		}
+		client.Close()
+	}
+	if strings.Join(dialed, ",") != "a,b" {
+		t.Errorf("dialed %v", dialed)
+	}
+	if _, err := DialTarget(context.Background(), "test-dialer://b"); err == nil {
+		t.Error("expected a Dialer's scheme not to be registered globally")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a scheme twice to panic")
+		}
+	}()
+	RegisterScheme("mem", dial)
+}
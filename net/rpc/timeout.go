@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// ErrHandlerTimeout is returned for requests whose handler did not complete
+// within the timeout set by WithMethodTimeout.
+var ErrHandlerTimeout = errors.New("rpc: handler timed out")
+
+type methodTimeout struct {
+	pattern string
+	timeout time.Duration
+}
+
+// WithMethodTimeout bounds the time handlers for methods matching pattern may
+// run. Patterns use path.Match syntax against "Service.Method", so
+// "Catalog.*" matches every method of the Catalog service. When several
+// patterns match, the one registered first applies.
+//
+// The handler's context is cancelled when the timeout expires, and the
+// client receives an error wrapping ErrHandlerTimeout without waiting for
+// the handler to return. A handler that ignores its context keeps running in
+// the background, but no longer holds up the connection, nor the slots and
+// admission it was granted by WithMaxConcurrentRequests and admission
+// controllers.
+func WithMethodTimeout(pattern string, d time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.methodTimeouts = append(s.methodTimeouts, methodTimeout{pattern: pattern, timeout: d})
+	}
+}
+
+func (server *Server) methodTimeout(serviceMethod string) time.Duration {
+	for _, mt := range server.methodTimeouts {
+		if ok, _ := path.Match(mt.pattern, serviceMethod); ok {
+			return mt.timeout
+		}
+	}
+	return 0
+}
+
+// callWithContext runs call in its own goroutine if ctx can be cancelled,
+// and stops waiting for it once ctx is done.
+func callWithContext(ctx context.Context, call func() error) error {
+	if ctx.Done() == nil {
+		return call()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callWithTimeout runs call in its own goroutine and stops waiting for it
+// once timeout has passed or ctx is done.
+func callWithTimeout(ctx context.Context, serviceMethod string, timeout time.Duration, call func(context.Context) error) error {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s exceeded %v", ErrHandlerTimeout, serviceMethod, timeout)
+	}
+}
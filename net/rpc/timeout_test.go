@@ -0,0 +1,44 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMethodTimeout(t *testing.T) {
+	srv := NewServerWithOpts(
+		WithMethodTimeout("Arith.Sleep*", 20*time.Millisecond),
+		WithMethodTimeout("Arith.*", time.Hour),
+	)
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client := CodecEmulator{server: srv}
+
+	start := time.Now()
+	err := client.Call("Arith.SleepMilli", &Args{A: 500}, new(Reply))
+	if err == nil || !strings.Contains(err.Error(), ErrHandlerTimeout.Error()) {
+		t.Fatalf("expected handler timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("expected the timeout to stop waiting for the handler, took %v", elapsed)
+	}
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d, %v", reply.C, err)
+	}
+
+	decode := func(v any) error { return json.Unmarshal([]byte(`{"A": 500}`), v) }
+	_, err = srv.InvokeMethod(context.Background(), "Arith.SleepMilli", decode, nil)
+	if !errors.Is(err, ErrHandlerTimeout) {
+		t.Errorf("expected InvokeMethod to return ErrHandlerTimeout, got %v", err)
+	}
+}
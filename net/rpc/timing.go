@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// RequestTiming tells a handler how long its request waited before the
+// handler was called, so it can shed work when the server falls behind, for
+// example by answering from a cache.
+type RequestTiming struct {
+	// Arrived is when the request header was read from the connection, or
+	// when InvokeMethod was called.
+	Arrived time.Time
+	// Started is when the handler was called.
+	Started time.Time
+}
+
+// QueueWait returns the time the request waited, while its body was decoded
+// and it was queued, before the handler was called.
+func (t RequestTiming) QueueWait() time.Duration {
+	return t.Started.Sub(t.Arrived)
+}
+
+// Age returns the time since the request arrived.
+func (t RequestTiming) Age() time.Duration {
+	return time.Since(t.Arrived)
+}
+
+// RequestTimingFromContext returns the timing of the request whose handler
+// was passed ctx.
+func RequestTimingFromContext(ctx context.Context) (RequestTiming, bool) {
+	rv := requestValuesFrom(ctx)
+	if rv == nil || !rv.timed {
+		return RequestTiming{}, false
+	}
+	return rv.timing, true
+}
+
+// startTiming returns the RequestTiming of a handler called now with ctx,
+// for a request that arrived at arrived, or now if arrived is zero, and
+// records it for RequestTimingFromContext.
+func startTiming(ctx context.Context, arrived time.Time) RequestTiming {
+	now := time.Now()
+	t := RequestTiming{Arrived: arrived, Started: now}
+	if arrived.IsZero() {
+		t.Arrived = now
+	}
+	if rv := requestValuesFrom(ctx); rv != nil {
+		rv.timing, rv.timed = t, true
+	}
+	return t
+}
+
+// RequestTimingStats reports how long requests waited before their handler
+// was called, and how long handlers took.
+type RequestTimingStats struct {
+	Requests      uint64
+	QueueWait     time.Duration // total time requests waited for their handler
+	MaxQueueWait  time.Duration
+	Processing    time.Duration // total time handlers took
+	MaxProcessing time.Duration
+}
+
+// RequestTimingStats returns the current RequestTimingStats. Its fields are
+// read one at a time, so they may be off by the requests recorded meanwhile.
+func (server *Server) RequestTimingStats() RequestTimingStats {
+	s := &server.timingStats
+	return RequestTimingStats{
+		Requests:      s.requests.Load(),
+		QueueWait:     time.Duration(s.queueWait.Load()),
+		MaxQueueWait:  time.Duration(s.maxQueueWait.Load()),
+		Processing:    time.Duration(s.processing.Load()),
+		MaxProcessing: time.Duration(s.maxProcessing.Load()),
+	}
+}
+
+// requestTimingStats counts the RequestTimingStats of a server with atomic
+// counters, so recording a request takes no lock.
+type requestTimingStats struct {
+	requests      atomic.Uint64
+	queueWait     atomic.Int64
+	maxQueueWait  atomic.Int64
+	processing    atomic.Int64
+	maxProcessing atomic.Int64
+}
+
+func (s *requestTimingStats) record(t RequestTiming, processing time.Duration) {
+	wait := t.QueueWait()
+	s.requests.Add(1)
+	s.queueWait.Add(int64(wait))
+	storeMax(&s.maxQueueWait, int64(wait))
+	s.processing.Add(int64(processing))
+	storeMax(&s.maxProcessing, int64(processing))
+}
+
+// storeMax sets dst to v if v is larger.
+func storeMax(dst *atomic.Int64, v int64) {
+	for {
+		old := dst.Load()
+		if v <= old || dst.CompareAndSwap(old, v) {
+			return
+		}
+	}
+}
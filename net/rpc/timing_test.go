@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type Timed struct{}
+
+// Wait replies with the time the request waited for the handler.
+func (Timed) Wait(ctx context.Context, args int, reply *time.Duration) error {
+	timing, ok := RequestTimingFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	*reply = timing.QueueWait()
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+func TestRequestTiming(t *testing.T) {
+	srv := NewServerWithOpts(WithPreBodyContextInterceptor(func(context.Context, string, net.Addr) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	}))
+	srv.Register(Timed{})
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wait time.Duration
+	if err := client.Call("Timed.Wait", 0, &wait); err != nil {
+		t.Fatal(err)
+	}
+	if wait < 30*time.Millisecond {
+		t.Errorf("expected the interceptor's delay in the queue wait, got %v", wait)
+	}
+
+	// InvokeMethod requests arrive when it is called.
+	reply, err := srv.InvokeMethod(context.Background(), "Timed.Wait", func(any) error { return nil }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := *reply.Interface().(*time.Duration); wait < 30*time.Millisecond {
+		t.Errorf("expected the interceptor's delay in InvokeMethod's queue wait, got %v", wait)
+	}
+
+	stats := srv.RequestTimingStats()
+	if stats.Requests != 2 {
+		t.Errorf("expected 2 requests, got %d", stats.Requests)
+	}
+	if stats.MaxQueueWait < 30*time.Millisecond || stats.MaxProcessing < 10*time.Millisecond || stats.Processing < 20*time.Millisecond {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the handshake of connections served by ServeTLS.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// Peer describes the client a request came from. It is also the source
+// address reported for clients connected over TLS, carrying the connection's
+// TLS state, so interceptors and handlers can identify clients by the
+// certificates they presented, and for clients connected over Unix sockets
+// set up with Handshake, carrying their credentials.
+type Peer struct {
+	Addr  net.Addr
+	TLS   *tls.ConnectionState // nil unless the client connected over TLS
+	Creds *PeerCreds           // nil unless known, see PeerCredsOf
+}
+
+// Network returns the network of the peer's address.
+func (p *Peer) Network() string {
+	return p.Addr.Network()
+}
+
+// String returns the peer's address.
+func (p *Peer) String() string {
+	return p.Addr.String()
+}
+
+// Certificate returns the leaf of the peer's verified certificate chain, or
+// nil if the peer presented no certificate that was verified.
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 || len(p.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return p.TLS.VerifiedChains[0][0]
+}
+
+// NegotiatedProtocol returns the application protocol negotiated with ALPN,
+// or the empty string if there was none.
+func (p *Peer) NegotiatedProtocol() string {
+	if p.TLS == nil {
+		return ""
+	}
+	return p.TLS.NegotiatedProtocol
+}
+
+// asPeer returns the peer at sourceAddr, or nil if sourceAddr is nil.
+func asPeer(sourceAddr net.Addr) *Peer {
+	if sourceAddr == nil {
+		return nil
+	}
+	if p, ok := sourceAddr.(*Peer); ok {
+		return p
+	}
+	return &Peer{Addr: sourceAddr}
+}
+
+// PeerFromContext returns the client a request came from, as passed to
+// handlers that take a context. TLS is set if the client connected over TLS.
+// It reports false if the request's source is unknown.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	if rv := requestValuesFrom(ctx); rv != nil && rv.peer != nil {
+		return rv.peer, true
+	}
+	return nil, false
+}
+
+// SourceAddrOf returns the address a ServerCodec serving conn should report
+// as its SourceAddr: a *Peer if conn is a TLS connection whose handshake is
+// complete or a Unix socket whose peer's credentials were read by
+// Handshake, and conn.RemoteAddr() otherwise.
+func SourceAddrOf(conn net.Conn) net.Addr {
+	if rc, ok := conn.(*retryConn); ok {
+		conn = rc.Conn
+	}
+	if cc, ok := conn.(*credsConn); ok {
+		return &Peer{Addr: conn.RemoteAddr(), Creds: cc.creds}
+	}
+	tc, ok := conn.(interface {
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok {
+		return conn.RemoteAddr()
+	}
+	state := tc.ConnectionState()
+	if !state.HandshakeComplete {
+		return conn.RemoteAddr()
+	}
+	return &Peer{Addr: conn.RemoteAddr(), TLS: &state}
+}
+
+// PeerCertificate returns the verified certificate of the client at
+// sourceAddr, as passed to a PreBodyInterceptor, or nil if there is none.
+func PeerCertificate(sourceAddr net.Addr) *x509.Certificate {
+	if p, ok := sourceAddr.(*Peer); ok {
+		return p.Certificate()
+	}
+	return nil
+}
+
+// ServeTLS accepts connections on l, performs a TLS handshake on each using
+// config and serves their requests concurrently with ServeRequestAsync. To
+// require clients to present a verified certificate, set config.ClientAuth
+// to tls.RequireAndVerifyClientCert and config.ClientCAs to the accepted
+// authorities; connections whose handshake fails are closed. ServeTLS
+// blocks until l fails or the server shuts down, in which case it returns
+// ErrServerClosed. The phases of setting up each connection are recorded,
+// as with TrackConnSetup. The server's ConnHandshake, if any, runs before
+// the TLS handshake, and is timed as part of ConnPhaseTLSHandshake.
+func (server *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if server.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go server.serveTLSConn(conn, config, server.TrackConnSetup(conn))
+	}
+}
+
+func (server *Server) serveTLSConn(raw net.Conn, config *tls.Config, setup *ConnSetup) {
+	setup.Done(ConnPhaseAccept, nil)
+	raw, connCtx, err := server.Handshake(raw)
+	if err != nil {
+		setup.Done(ConnPhaseTLSHandshake, err)
+		return
+	}
+	// Writes are retried below TLS, which fails for good once a write
+	// has failed.
+	conn := tls.Server(server.WrapConn(raw), config)
+	ctx, cancel := context.WithTimeout(connCtx, tlsHandshakeTimeout)
+	err = conn.HandshakeContext(ctx)
+	cancel()
+	setup.Done(ConnPhaseTLSHandshake, err)
+	if err != nil {
+		server.logger().Debug("rpc: TLS handshake failed", "sourceAddr", conn.RemoteAddr(), "error", err)
+		conn.Close()
+		return
+	}
+	codec := newGobServerCodec(conn)
+	defer codec.Close()
+	setup.Ready(codec)
+	for server.ServeRequestAsync(connCtx, codec) == nil {
+	}
+}
+
+// DialTLS connects to an RPC server at the specified network address over
+// TLS. To authenticate to servers requiring client certificates, set
+// config.Certificates.
+func DialTLS(network, address string, config *tls.Config) (*Client, error) {
+	return dialWith(TLSTransport(config), network, address)
+}
+
+// DialHTTPPathTLS connects to an HTTP RPC server over TLS at the specified
+// network address and path.
+func DialHTTPPathTLS(network, address, path string, config *tls.Config) (*Client, error) {
+	return dialWith(HTTPConnectTransport(path, config), network, address)
+}
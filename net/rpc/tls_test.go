@@ -0,0 +1,220 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	t      *testing.T
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{t: t, pool: x509.NewCertPool()}
+	ca.cert, ca.key = ca.issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	ca.pool.AddCert(ca.cert)
+	return ca
+}
+
+func (ca *testCA) issue(template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	ca.serial++
+	template.SerialNumber = big.NewInt(ca.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return cert, key
+}
+
+func (ca *testCA) leaf(name string, usage x509.ExtKeyUsage) tls.Certificate {
+	cert, key := ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	})
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func TestServeTLS(t *testing.T) {
+	ca := newTestCA(t)
+	var mu sync.Mutex
+	var seen []string
+	srv := NewServerWithOpts(WithPreBodyInterceptor(func(serviceMethod string, sourceAddr net.Addr) error {
+		cert := PeerCertificate(sourceAddr)
+		if cert == nil {
+			return errors.New("no certificate")
+		}
+		mu.Lock()
+		seen = append(seen, cert.Subject.CommonName)
+		mu.Unlock()
+		if cert.Subject.CommonName != "allowed" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{ca.leaf("server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.ServeTLS(l, config) }()
+
+	dial := func(certs ...tls.Certificate) (*Client, error) {
+		return DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool, ServerName: "server", Certificates: certs})
+	}
+	client, err := dial(ca.leaf("allowed", x509.ExtKeyUsageClientAuth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Fatalf("Add: got %d, %v", reply.C, err)
+	}
+
+	denied, err := dial(ca.leaf("denied", x509.ExtKeyUsageClientAuth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Close()
+	if err := denied.Call("Arith.Add", Args{1, 2}, reply); err == nil || err.Error() != "forbidden" {
+		t.Errorf("expected the interceptor to refuse the request, got %v", err)
+	}
+
+	// Without a certificate the handshake fails, which a TLS 1.3 client only
+	// notices once it reads from the connection.
+	anonymous, err := dial()
+	if err == nil {
+		defer anonymous.Close()
+		err = anonymous.Call("Arith.Add", Args{1, 2}, reply)
+	}
+	if err == nil {
+		t.Error("expected a client without a certificate to be refused")
+	}
+
+	mu.Lock()
+	if len(seen) != 2 || seen[0] != "allowed" || seen[1] != "denied" {
+		t.Errorf("unexpected peers %v", seen)
+	}
+	mu.Unlock()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestSourceAddrOf(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if _, ok := SourceAddrOf(c1).(*Peer); ok {
+		t.Error("expected a plain connection's address")
+	}
+	if PeerCertificate(c1.RemoteAddr()) != nil {
+		t.Error("expected no certificate")
+	}
+}
+
+type PeerInfo struct{}
+
+func (PeerInfo) Name(ctx context.Context, args struct{}, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return errors.New("no peer")
+	}
+	*reply = p.String()
+	if cert := p.Certificate(); cert != nil {
+		*reply = cert.Subject.CommonName + " " + p.NegotiatedProtocol()
+	}
+	return nil
+}
+
+func TestPeerFromContext(t *testing.T) {
+	ca := newTestCA(t)
+	srv := NewServer()
+	if err := srv.Register(PeerInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{ca.leaf("server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+		NextProtos:   []string{"consul-rpc"},
+	}
+	go srv.ServeTLS(l, config)
+
+	client, err := DialTLS("tcp", addr, &tls.Config{
+		RootCAs:      ca.pool,
+		ServerName:   "server",
+		Certificates: []tls.Certificate{ca.leaf("agent", x509.ExtKeyUsageClientAuth)},
+		NextProtos:   []string{"consul-rpc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var name string
+	if err := client.Call("PeerInfo.Name", struct{}{}, &name); err != nil || name != "agent consul-rpc" {
+		t.Errorf("expected the client's certificate and protocol, got %q, %v", name, err)
+	}
+
+	// Plain connections carry just the address.
+	l, addr = listenTCP(t)
+	go accept(srv, l)
+	plain, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if err := plain.Call("PeerInfo.Name", struct{}{}, &name); err != nil || name == "" {
+		t.Errorf("expected the client's address, got %q, %v", name, err)
+	}
+}
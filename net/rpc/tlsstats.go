@@ -0,0 +1,204 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// TLSMetrics records the TLS handshakes of RPC connections: how long they
+// take, how many resume an earlier session, and which versions and cipher
+// suites are negotiated. Connections are recorded when they are accepted
+// through Listener, dialed through Transport, or handshaken with Handshake.
+// A TLSMetrics may be shared by any number of listeners and dialers.
+//
+// Clients only resume sessions if their tls.Config has a ClientSessionCache.
+type TLSMetrics struct {
+	mu    sync.Mutex // protects stats
+	stats TLSStats
+}
+
+// TLSStats summarizes the handshakes recorded by a TLSMetrics.
+type TLSStats struct {
+	Handshakes       uint64        // successful handshakes
+	Failures         uint64        // handshakes that returned an error
+	Resumed          uint64        // successful handshakes that resumed a session
+	HandshakeTime    time.Duration // total time spent in successful handshakes
+	MaxHandshakeTime time.Duration
+	Versions         map[string]uint64 // handshakes by negotiated version
+	CipherSuites     map[string]uint64 // handshakes by negotiated cipher suite
+}
+
+// ResumptionRate returns the fraction of successful handshakes that resumed
+// a session.
+func (s TLSStats) ResumptionRate() float64 {
+	if s.Handshakes == 0 {
+		return 0
+	}
+	return float64(s.Resumed) / float64(s.Handshakes)
+}
+
+// NewTLSMetrics returns a TLSMetrics with nothing recorded.
+func NewTLSMetrics() *TLSMetrics {
+	return new(TLSMetrics)
+}
+
+// Stats returns a copy of the handshakes recorded so far.
+func (m *TLSMetrics) Stats() TLSStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Versions = make(map[string]uint64, len(m.stats.Versions))
+	for k, v := range m.stats.Versions {
+		stats.Versions[k] = v
+	}
+	stats.CipherSuites = make(map[string]uint64, len(m.stats.CipherSuites))
+	for k, v := range m.stats.CipherSuites {
+		stats.CipherSuites[k] = v
+	}
+	return stats
+}
+
+// Handshake runs the TLS handshake on conn and records it. If the handshake
+// has already been run, it is not recorded again.
+func (m *TLSMetrics) Handshake(ctx context.Context, conn *tls.Conn) error {
+	if conn.ConnectionState().HandshakeComplete {
+		return nil
+	}
+	start := time.Now()
+	err := conn.HandshakeContext(ctx)
+	m.record(conn.ConnectionState(), time.Since(start), err)
+	return err
+}
+
+func (m *TLSMetrics) record(state tls.ConnectionState, took time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.stats.Failures++
+		return
+	}
+	m.stats.Handshakes++
+	if state.DidResume {
+		m.stats.Resumed++
+	}
+	m.stats.HandshakeTime += took
+	if took > m.stats.MaxHandshakeTime {
+		m.stats.MaxHandshakeTime = took
+	}
+	if m.stats.Versions == nil {
+		m.stats.Versions = make(map[string]uint64)
+		m.stats.CipherSuites = make(map[string]uint64)
+	}
+	m.stats.Versions[tlsVersionName(state.Version)]++
+	m.stats.CipherSuites[tls.CipherSuiteName(state.CipherSuite)]++
+}
+
+// Listener returns a listener that accepts TLS connections on inner using
+// config. Each connection's handshake is run and recorded on its first read
+// or write, so a slow client does not hold up Accept.
+func (m *TLSMetrics) Listener(inner net.Listener, config *tls.Config) net.Listener {
+	return &tlsMetricsListener{Listener: inner, m: m, config: config}
+}
+
+// Transport is like TLSTransport, but records the handshake of each
+// connection it dials.
+func (m *TLSMetrics) Transport(config *tls.Config) Transport {
+	return Transport{
+		Name: "tls",
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			raw, err := d.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			conn := tls.Client(raw, tlsClientConfig(config, address))
+			if err := m.Handshake(ctx, conn); err != nil {
+				raw.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+}
+
+// tlsClientConfig returns config with ServerName set from address if it was
+// not set, as tls.Dial does.
+func tlsClientConfig(config *tls.Config, address string) *tls.Config {
+	if config == nil {
+		config = new(tls.Config)
+	}
+	if config.ServerName != "" || config.InsecureSkipVerify {
+		return config
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+type tlsMetricsListener struct {
+	net.Listener
+	m      *TLSMetrics
+	config *tls.Config
+}
+
+func (l *tlsMetricsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tlsMetricsConn{Conn: tls.Server(conn, l.config), m: l.m}, nil
+}
+
+// tlsMetricsConn runs and records its handshake on first use.
+type tlsMetricsConn struct {
+	*tls.Conn
+	m    *TLSMetrics
+	once sync.Once
+	err  error
+}
+
+func (c *tlsMetricsConn) handshake() error {
+	c.once.Do(func() {
+		c.err = c.m.Handshake(context.Background(), c.Conn)
+	})
+	return c.err
+}
+
+func (c *tlsMetricsConn) Read(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *tlsMetricsConn) Write(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
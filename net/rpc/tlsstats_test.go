@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testTLSCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSMetrics(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	serverMetrics, clientMetrics := NewTLSMetrics(), NewTLSMetrics()
+	l, addr := listenTCP(t)
+	go accept(srv, serverMetrics.Listener(l, &tls.Config{
+		Certificates: []tls.Certificate{testTLSCertificate(t)},
+	}))
+
+	d := NewDialer(WithTransports(clientMetrics.Transport(&tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	})))
+	for i := 0; i < 2; i++ {
+		client, err := d.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := new(Reply)
+		if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil || reply.C != 3 {
+			t.Fatalf("Add: got %d, %v", reply.C, err)
+		}
+		client.Close()
+	}
+
+	stats := clientMetrics.Stats()
+	if stats.Handshakes != 2 || stats.Resumed != 1 || stats.ResumptionRate() != 0.5 {
+		t.Errorf("expected the second handshake to resume, got %+v", stats)
+	}
+	if stats.Versions["TLS 1.3"] != 2 || len(stats.CipherSuites) != 1 || stats.MaxHandshakeTime <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	waitFor(t, func() bool { return serverMetrics.Stats().Handshakes == 2 })
+	if stats := serverMetrics.Stats(); stats.Resumed != 1 {
+		t.Errorf("expected the server to see the resumption, got %+v", stats)
+	}
+
+	// Failed handshakes are counted.
+	bad := NewDialer(WithTransports(clientMetrics.Transport(&tls.Config{ServerName: "example.com"})))
+	if _, err := bad.DialContext(context.Background(), "tcp", addr); err == nil {
+		t.Fatal("expected the certificate to be refused")
+	}
+	if stats := clientMetrics.Stats(); stats.Failures != 1 {
+		t.Errorf("expected a failure, got %+v", stats)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport is one way of connecting to an RPC server. A Dialer tries its
+// transports in order until one connects, so clients behind networks that
+// block some of them still reach the server. Transports this package does not
+// provide, such as tunnels over SSH, yamux streams or QUIC, can be added by
+// setting Dial, and Listen for servers to accept their connections with
+// ListenAndServe.
+type Transport struct {
+	// Name identifies the transport in errors.
+	Name string
+	// Timeout, if positive, bounds the time an attempt to connect with this
+	// transport may take.
+	Timeout time.Duration
+	// Dial connects to the server at address. The returned connection must
+	// be ready to carry the RPC protocol.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Listen, if set, listens at address for the connections Dial makes.
+	// The connections the listener accepts must be ready to carry the RPC
+	// protocol.
+	Listen func(ctx context.Context, network, address string) (net.Listener, error)
+}
+
+// TCPTransport connects directly over the network.
+func TCPTransport() Transport {
+	var d net.Dialer
+	var lc net.ListenConfig
+	return Transport{Name: "tcp", Dial: d.DialContext, Listen: lc.Listen}
+}
+
+// TLSTransport connects directly over the network and performs a TLS
+// handshake using config. Its listener performs the server side of the
+// handshake, so config must then hold the server's certificate.
+func TLSTransport(config *tls.Config) Transport {
+	d := tls.Dialer{Config: config}
+	return Transport{
+		Name: "tls",
+		Dial: d.DialContext,
+		Listen: func(ctx context.Context, network, address string) (net.Listener, error) {
+			var lc net.ListenConfig
+			l, err := lc.Listen(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return tls.NewListener(l, config), nil
+		},
+	}
+}
+
+// HTTPConnectTransport connects to an HTTP server that hands CONNECT requests
+// for path to the RPC server, as DialHTTPPath does. If config is non-nil the
+// HTTP connection uses TLS.
+func HTTPConnectTransport(path string, config *tls.Config) Transport {
+	return Transport{
+		Name: "http-connect",
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var conn net.Conn
+			var err error
+			if config != nil {
+				d := tls.Dialer{Config: config}
+				conn, err = d.DialContext(ctx, network, address)
+			} else {
+				var d net.Dialer
+				conn, err = d.DialContext(ctx, network, address)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if deadline, ok := ctx.Deadline(); ok {
+				conn.SetDeadline(deadline)
+				defer conn.SetDeadline(time.Time{})
+			}
+			if err := httpConnect(conn, network, address, path); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+}
+
+// Dialer connects to RPC servers, falling back from one transport to the
+// next. Its zero value connects over TCP.
+type Dialer struct {
+	transports    []Transport
+	clientOptions []func(*Client)
+	schemes       map[string]SchemeDialer
+}
+
+// WithTransports sets the transports the Dialer tries, in order.
+func WithTransports(transports ...Transport) func(*Dialer) {
+	return func(d *Dialer) {
+		d.transports = append(d.transports, transports...)
+	}
+}
+
+// WithDialClientOptions sets the options of the Clients the Dialer returns.
+func WithDialClientOptions(options ...func(*Client)) func(*Dialer) {
+	return func(d *Dialer) {
+		d.clientOptions = append(d.clientOptions, options...)
+	}
+}
+
+// NewDialer returns a Dialer with the given options.
+func NewDialer(options ...func(*Dialer)) *Dialer {
+	d := new(Dialer)
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// DialContext connects to an RPC server at the specified network address,
+// trying each of the Dialer's transports in turn. If every transport fails,
+// the returned error joins the error of each attempt.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (*Client, error) {
+	transports := d.transports
+	if len(transports) == 0 {
+		transports = []Transport{TCPTransport()}
+	}
+	var errs []error
+	for _, t := range transports {
+		conn, err := dialTransport(ctx, t, network, address)
+		if err == nil {
+			return NewClientWithOpts(newGobClientCodec(conn), d.clientOptions...), nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// DialContext connects to an RPC server at the specified network address
+// over TCP, giving up when ctx is done.
+func DialContext(ctx context.Context, network, address string) (*Client, error) {
+	return new(Dialer).DialContext(ctx, network, address)
+}
+
+// ListenAndServe listens at address with t and serves the connections it
+// accepts, as Accept does.
+func (server *Server) ListenAndServe(ctx context.Context, t Transport, network, address string) error {
+	if t.Listen == nil {
+		return fmt.Errorf("rpc: transport %s cannot listen", t.Name)
+	}
+	l, err := t.Listen(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	return server.Accept(ctx, l)
+}
+
+// Accept accepts connections on l and serves each with ServeConnContext,
+// with a context carrying the values of ctx, so that values the caller
+// scopes to the listener reach context-aware handlers. It closes l and
+// returns ctx.Err() once ctx is done, and blocks until then, until l fails
+// or until the server shuts down, in which case it returns ErrServerClosed.
+// Temporary errors, such as running out of file descriptors, do not fail l:
+// Accept logs them and tries again after a delay that doubles from 5ms up
+// to a second. Connections outlive ctx: Shutdown closes them.
+func (server *Server) Accept(ctx context.Context, l net.Listener) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-stop:
+		}
+	}()
+	connCtx := withValuesOf(context.Background(), ctx)
+	var delay time.Duration // after a temporary error
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if server.shuttingDown() {
+				return ErrServerClosed
+			}
+			if temp, ok := err.(interface{ Temporary() bool }); !ok || !temp.Temporary() {
+				return err
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			server.logger().Warn("rpc: accept failed, retrying", "error", err, "delay", delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			continue
+		}
+		delay = 0
+		go server.ServeConnContext(connCtx, conn)
+	}
+}
+
+// ServeConn serves conn with ServeConnContext and context.Background().
+func (server *Server) ServeConn(conn net.Conn) {
+	server.ServeConnContext(context.Background(), conn)
+}
+
+// ServeConnContext runs Handshake on conn, which a listener has just
+// returned, and serves its requests with ServeCodecContext and the codec
+// NewClient uses, until the connection closes or ctx is done. The contexts
+// of its requests derive from ctx, with the values of the context the
+// server's ConnHandshake returned added, so that values of the connection,
+// such as the identity of its peer or the protocol version it negotiated,
+// reach context-aware handlers. Writes to conn follow the server's write
+// retry policy. It returns the error of the handshake, or that of
+// ServeCodecContext.
+func (server *Server) ServeConnContext(ctx context.Context, conn net.Conn) error {
+	conn, connCtx, err := server.Handshake(conn)
+	if err != nil {
+		return err
+	}
+	return server.ServeCodecContext(withValuesOf(ctx, connCtx), newGobServerCodec(server.WrapConn(conn)))
+}
+
+// valuesContext is a Context with the values of another added, which are
+// looked up first.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// withValuesOf returns ctx with the values of values added, keeping the
+// deadline and cancellation of ctx only.
+func withValuesOf(ctx, values context.Context) context.Context {
+	if values == nil || values == context.Background() {
+		return ctx
+	}
+	return valuesContext{Context: ctx, values: values}
+}
+
+// dialWith connects to the server at address with t, for the Dial functions
+// that take no context.
+func dialWith(t Transport, network, address string) (*Client, error) {
+	conn, err := dialTransport(context.Background(), t, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+func dialTransport(ctx context.Context, t Transport, network, address string) (net.Conn, error) {
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+	return t.Dial(ctx, network, address)
+}
+
+func newGobClientCodec(conn io.ReadWriteCloser) ClientCodec {
+	counted := &writeCountingConn{ReadWriteCloser: conn}
+	encBuf := bufio.NewWriter(counted)
+	return &gobClientCodec{counted, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+}
+
+// httpConnect asks the HTTP server on conn to switch to the RPC protocol.
+func httpConnect(conn net.Conn, network, address, path string) error {
+	io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n")
+
+	// Require successful HTTP response
+	// before switching to RPC protocol.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status == connected {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	return &net.OpError{
+		Op:   "dial-http",
+		Net:  network + " " + address,
+		Addr: nil,
+		Err:  err,
+	}
+}
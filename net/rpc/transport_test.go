@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialerFallback(t *testing.T) {
+	_, _, httpAddr := startNewServer(t)
+
+	blocked := Transport{
+		Name:    "blocked",
+		Timeout: 20 * time.Millisecond,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	tlsTransport := TLSTransport(&tls.Config{InsecureSkipVerify: true})
+	tlsTransport.Timeout = time.Second
+
+	d := NewDialer(WithTransports(blocked, tlsTransport, HTTPConnectTransport(newHttpPath, nil)))
+	client, err := d.DialContext(context.Background(), "tcp", httpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 15 {
+		t.Errorf("expected 15, got %d", reply.C)
+	}
+
+	d = NewDialer(WithTransports(blocked, HTTPConnectTransport("/missing", nil)))
+	_, err = d.DialContext(context.Background(), "tcp", httpAddr)
+	if err == nil {
+		t.Fatal("expected every transport to fail")
+	}
+	for _, name := range []string{"blocked: context deadline exceeded", "http-connect:"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected the error to report %q, got %v", name, err)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	_, addr, _ := startNewServer(t)
+	client, err := DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DialContext(ctx, "tcp", addr); err == nil {
+		t.Error("expected a cancelled context to stop the dial")
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	for _, transport := range []Transport{PipeTransport(), TCPTransport()} {
+		t.Run(transport.Name, func(t *testing.T) {
+			l, err := transport.Listen(context.Background(), "tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() { served <- srv.Accept(ctx, l) }()
+
+			d := NewDialer(WithTransports(transport))
+			client, err := d.DialContext(context.Background(), "tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			reply := new(Reply)
+			if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.C != 15 {
+				t.Errorf("expected 15, got %d", reply.C)
+			}
+
+			cancel()
+			if err := <-served; err != context.Canceled {
+				t.Errorf("Accept: got %v, want %v", err, context.Canceled)
+			}
+			if _, err := d.DialContext(context.Background(), "tcp", l.Addr().String()); err == nil {
+				t.Error("expected dialing a closed listener to fail")
+			}
+		})
+	}
+
+	err := srv.ListenAndServe(context.Background(), HTTPConnectTransport("/", nil), "tcp", "127.0.0.1:0")
+	if err == nil || !strings.Contains(err.Error(), "cannot listen") {
+		t.Errorf("ListenAndServe with a transport without Listen: got %v", err)
+	}
+}
+
+type connValueKey string
+
+type ConnValues struct{}
+
+func (ConnValues) Get(ctx context.Context, key string, reply *string) error {
+	*reply, _ = ctx.Value(connValueKey(key)).(string)
+	return nil
+}
+
+func TestServeConnContext(t *testing.T) {
+	srv := NewServerWithOpts(WithConnHandshake(func(conn net.Conn) (net.Conn, context.Context, error) {
+		return nil, context.WithValue(context.Background(), connValueKey("version"), "2"), nil
+	}))
+	if err := srv.Register(ConnValues{}); err != nil {
+		t.Fatal(err)
+	}
+	get := func(client *Client, key string) string {
+		var value string
+		if err := client.Call("ConnValues.Get", key, &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	// Values of the context given to Accept and of the handshake reach
+	// handlers.
+	transport := PipeTransport()
+	l, err := transport.Listen(context.Background(), "pipe", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), connValueKey("listener"), "pipe"))
+	served := make(chan error, 1)
+	go func() { served <- srv.Accept(ctx, l) }()
+	client, err := NewDialer(WithTransports(transport)).DialContext(context.Background(), "pipe", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if got := get(client, "listener"); got != "pipe" {
+		t.Errorf("listener value: got %q, want %q", got, "pipe")
+	}
+	if got := get(client, "version"); got != "2" {
+		t.Errorf("handshake value: got %q, want %q", got, "2")
+	}
+	// Connections outlive the context of Accept.
+	cancel()
+	<-served
+	if got := get(client, "version"); got != "2" {
+		t.Errorf("after Accept returned: got %q, want %q", got, "2")
+	}
+
+	// The connections of ServeConnContext end with its context.
+	clientConn, serverConn := net.Pipe()
+	ctx, cancel = context.WithCancel(context.WithValue(context.Background(), connValueKey("conn"), "1"))
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeConnContext(ctx, serverConn) }()
+	client = NewClient(clientConn)
+	defer client.Close()
+	if got := get(client, "conn"); got != "1" {
+		t.Errorf("conn value: got %q, want %q", got, "1")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("ServeConnContext: got %v, want %v", err, context.Canceled)
+	}
+}
+
+// flakyListener fails with a temporary error before each of its connections.
+type flakyListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failed = !l.failed; l.failed {
+		return nil, timeoutError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptRetriesTemporaryErrors(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Accept(ctx, &flakyListener{Listener: l}) }()
+
+	for i := 0; i < 2; i++ {
+		client, err := Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply Reply
+		err = client.Call("Arith.Add", Args{1, 2}, &reply)
+		client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
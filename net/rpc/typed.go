@@ -0,0 +1,116 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// RegisterMethod registers fn as serviceMethod, of the form
+// "Service.Method", on srv. Requests for it are decoded into a new *Req and
+// answered with the *Resp fn fills in, as for a method of a registered
+// receiver, but fn is called directly rather than through reflection, and
+// the compiler checks its signature. Several methods of a service may be
+// registered this way, or with Server.RegisterFunc, at any time; a service
+// registered with Register cannot be given more methods.
+func RegisterMethod[Req, Resp any](srv *Server, serviceMethod string, fn func(context.Context, *Req, *Resp) error) error {
+	if fn == nil {
+		return errors.New("rpc.RegisterMethod: nil function for " + serviceMethod)
+	}
+	argType := reflect.TypeOf((*Req)(nil))
+	replyType := reflect.TypeOf((*Resp)(nil))
+	return srv.registerMethod("rpc.RegisterMethod", serviceMethod, &methodType{
+		ArgType:    argType,
+		ReplyType:  replyType,
+		HasContext: true,
+		invoke: func(ctx context.Context, argv, replyv reflect.Value) error {
+			return fn(ctx, argv.Interface().(*Req), replyv.Interface().(*Resp))
+		},
+		argPool:   newValuePool(argType.Elem()),
+		replyPool: newValuePool(replyType.Elem()),
+	})
+}
+
+// registerMethod adds mtype to the service of serviceMethod, creating the
+// service if it does not exist, on behalf of the function named caller. The
+// method tables of such services are copied on write, under server.mu, so
+// that requests may read them while methods are added.
+func (server *Server) registerMethod(caller, serviceMethod string, mtype *methodType) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		s := caller + ": service/method ill-formed: " + serviceMethod
+		server.logger().Error(s)
+		return errors.New(s)
+	}
+	sname, mname := serviceMethod[:dot], serviceMethod[dot+1:]
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	svci, ok := server.serviceMap.Load(sname)
+	if !ok {
+		s := &service{name: sname, logger: server.logger()}
+		methods := map[string]*methodType{mname: mtype}
+		s.typed.Store(&methods)
+		server.serviceMap.Store(sname, s)
+		return nil
+	}
+	s := svci.(*service)
+	old := s.typed.Load()
+	if old == nil {
+		return errors.New("rpc: service already defined: " + sname)
+	}
+	if _, dup := (*old)[mname]; dup {
+		return errors.New("rpc: method already defined: " + serviceMethod)
+	}
+	methods := make(map[string]*methodType, len(*old)+1)
+	for name, m := range *old {
+		methods[name] = m
+	}
+	methods[mname] = mtype
+	s.typed.Store(&methods)
+	return nil
+}
+
+// TypedCall calls serviceMethod with req through client, as with
+// Client.CallContext, and returns the reply, so that callers get their
+// types checked by the compiler rather than passing interface{} values:
+//
+//	reply, err := rpc.TypedCall[Args, Reply](client, ctx, "Arith.Add", Args{7, 8})
+func TypedCall[Req, Resp any](client *Client, ctx context.Context, serviceMethod string, req Req) (*Resp, error) {
+	reply := new(Resp)
+	if err := client.CallContext(ctx, serviceMethod, req, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// InvokeMethodTyped is like Server.InvokeMethod but takes the argument and
+// returns the reply as values of their types. The argument is copied into
+// the one passed to the method rather than decoded, so that a caller
+// forwarding a request in process, as a method registered with
+// RegisterMethod, pays no reflection at all. It fails if Req and Resp are not
+// the argument and reply types of the method.
+func InvokeMethodTyped[Req, Resp any](srv *Server, ctx context.Context, serviceMethod string, req Req, sourceAddr net.Addr) (*Resp, error) {
+	replyType := reflect.TypeOf((*Resp)(nil))
+	if _, mtype, err := srv.findMethod(serviceMethod); err == nil && mtype.ReplyType != replyType {
+		return nil, fmt.Errorf("rpc: reply type of %s is %s, not %s", serviceMethod, mtype.ReplyType, replyType)
+	}
+	replyv, err := srv.InvokeMethod(ctx, serviceMethod, func(arg any) error {
+		p, ok := arg.(*Req)
+		if !ok {
+			return fmt.Errorf("rpc: argument of %s is a %T, not a %s", serviceMethod, arg, reflect.TypeOf(p))
+		}
+		*p = req
+		return nil
+	}, sourceAddr)
+	if err != nil {
+		return nil, err
+	}
+	return replyv.Interface().(*Resp), nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegisterMethod(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	add := func(ctx context.Context, args *Args, reply *Reply) error {
+		reply.C = args.A + args.B
+		return nil
+	}
+	if err := RegisterMethod(srv, "Typed.Add", add); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Typed.Add", Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d", reply.C)
+	}
+
+	// Methods can be added to a service that is already served.
+	err = RegisterMethod(srv, "Typed.Div", func(ctx context.Context, args *Args, reply *Reply) error {
+		if args.B == 0 {
+			return errors.New("divide by zero")
+		}
+		reply.C = args.A / args.B
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Typed.Div", Args{7, 0}, reply); err == nil || err.Error() != "divide by zero" {
+		t.Errorf("Div: expected divide by zero, got %v", err)
+	}
+	if err := client.Call("Typed.Add", Args{1, 2}, reply); err != nil || reply.C != 3 {
+		t.Errorf("Add: expected 3, got %d, %v", reply.C, err)
+	}
+	if n := len(srv.Describe()); n != 2 {
+		t.Errorf("expected 2 services described, got %d", n)
+	}
+
+	for _, tt := range []struct {
+		serviceMethod, err string
+	}{
+		{"Typed.Add", "method already defined"},
+		{"Arith.Sub", "service already defined"},
+		{"Typed", "ill-formed"},
+		{"Typed.", "ill-formed"},
+	} {
+		if err := RegisterMethod(srv, tt.serviceMethod, add); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected %q, got %v", tt.serviceMethod, tt.err, err)
+		}
+	}
+}
+
+func TestTypedCall(t *testing.T) {
+	client, err := Dial("tcp", startAsyncServer(t, NewServer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := TypedCall[Args, Reply](client, context.Background(), "Arith.Add", Args{7, 8}); err == nil {
+		t.Error("expected an error calling an unregistered service")
+	}
+
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client, err = Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply, err := TypedCall[Args, Reply](client, context.Background(), "Arith.Add", Args{7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 15 {
+		t.Errorf("Add: expected 15, got %d", reply.C)
+	}
+}
+
+func TestInvokeMethodTyped(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	err := RegisterMethod(srv, "Typed.Add", func(ctx context.Context, args *Args, reply *Reply) error {
+		reply.C = args.A + args.B
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"Arith.Add", "Typed.Add"} {
+		reply, err := InvokeMethodTyped[Args, Reply](srv, context.Background(), method, Args{7, 8}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.C != 15 {
+			t.Errorf("%s: expected 15, got %d", method, reply.C)
+		}
+	}
+
+	if _, err := InvokeMethodTyped[Args, Args](srv, context.Background(), "Arith.Add", Args{7, 8}, nil); err == nil || !strings.Contains(err.Error(), "reply type") {
+		t.Errorf("expected a wrong reply type to fail, got %v", err)
+	}
+	if _, err := InvokeMethodTyped[Reply, Reply](srv, context.Background(), "Arith.Add", Reply{}, nil); err == nil || !strings.Contains(err.Error(), "argument") {
+		t.Errorf("expected a wrong argument type to fail, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Resettable is implemented by argument and reply types whose values the
+// server may reuse across calls, with WithValuePooling. Reset must return
+// the value to its zero state: codecs leave fields the client did not send
+// as they are, so a field Reset missed leaks into the next call.
+type Resettable interface {
+	Reset()
+}
+
+var typeOfResettable = reflect.TypeOf((*Resettable)(nil)).Elem()
+
+// WithValuePooling makes the server reuse the argument and reply values of
+// methods whose argument or reply type, as a pointer, implements
+// Resettable, rather than allocating them for every call. Once the
+// response is written, the values are reset and kept for later calls, so
+// handlers, interceptors and observers must not keep them, or anything
+// they point to, after returning. Values of methods with a timeout set by
+// WithMethodTimeout are not reused, since their handler may outlive the
+// call, and neither are those of calls made with InvokeMethod, whose reply
+// is returned to the caller.
+func WithValuePooling() func(*Server) {
+	return func(s *Server) {
+		s.valuePooling = true
+	}
+}
+
+// newValuePool returns a pool of pointers to values of t, or nil if a
+// pointer to t does not implement Resettable.
+func newValuePool(t reflect.Type) *sync.Pool {
+	if !reflect.PointerTo(t).Implements(typeOfResettable) {
+		return nil
+	}
+	return &sync.Pool{New: func() interface{} {
+		return reflect.New(t).Interface()
+	}}
+}
+
+// newArg returns a pointer to a new argument value for m, taken from its
+// pool if pooled is set, and whether the method takes the value rather than
+// the pointer.
+func (m *methodType) newArg(pooled bool) (reflect.Value, bool) {
+	if pooled && m.argPool != nil {
+		return reflect.ValueOf(m.argPool.Get()), m.ArgType.Kind() != reflect.Ptr
+	}
+	return interpretArgumentValue(m.ArgType)
+}
+
+// newReply returns a new reply value for m, taken from its pool if pooled is
+// set.
+func (m *methodType) newReply(pooled bool) reflect.Value {
+	if pooled && m.replyPool != nil {
+		return reflect.ValueOf(m.replyPool.Get())
+	}
+	return interpretReplyValue(m.ReplyType)
+}
+
+// releaseValues resets argv and replyv, as returned by newArg and newReply,
+// and returns them to their pools.
+func (m *methodType) releaseValues(argv, replyv reflect.Value) {
+	if m.argPool != nil && argv.IsValid() {
+		if argv.Kind() != reflect.Ptr {
+			argv = argv.Addr()
+		}
+		arg := argv.Interface()
+		arg.(Resettable).Reset()
+		m.argPool.Put(arg)
+	}
+	if m.replyPool != nil && replyv.IsValid() && replyv.Type() == m.ReplyType {
+		reply := replyv.Interface()
+		reply.(Resettable).Reset()
+		m.replyPool.Put(reply)
+	}
+}
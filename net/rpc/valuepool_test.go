@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+var pooledResets atomic.Int64
+
+type PooledArgs struct {
+	A, B int
+}
+
+func (a *PooledArgs) Reset() {
+	*a = PooledArgs{}
+	pooledResets.Add(1)
+}
+
+type PooledReply struct {
+	Sum   int
+	Dirty bool // set if the reply was not zero when the handler got it
+}
+
+func (r *PooledReply) Reset() {
+	*r = PooledReply{}
+	pooledResets.Add(1)
+}
+
+type Pooled int
+
+func (p *Pooled) Sum(args PooledArgs, reply *PooledReply) error {
+	reply.Dirty = *reply != PooledReply{}
+	reply.Sum = args.A + args.B
+	return nil
+}
+
+func TestValuePooling(t *testing.T) {
+	srv := NewServerWithOpts(WithValuePooling())
+	if err := srv.Register(new(Pooled)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	before := pooledResets.Load()
+	// gob leaves the zero A of the second call undecoded, so a value that
+	// was not reset would add the A of the first.
+	for _, args := range []PooledArgs{{A: 1, B: 2}, {A: 0, B: 3}, {A: 4, B: 0}} {
+		reply := new(PooledReply)
+		if err := client.Call("Pooled.Sum", args, reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Sum != args.A+args.B || reply.Dirty {
+			t.Errorf("%+v: got %+v", args, reply)
+		}
+	}
+	if got := pooledResets.Load() - before; got != 6 {
+		t.Errorf("expected 6 values reset, got %d", got)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Reply verification is a testing aid that catches codec asymmetry: reply
+// types that encode without error but do not decode back to the value the
+// server sent. A client with verification enabled asks the server to remember
+// a digest of each reply; once it has decoded the reply, it sends its own
+// digest back in a control frame and the server compares the two. It doubles
+// the work done per call and is meant for CI and soak tests, not production.
+//
+// Digests cover exported struct fields only, treat zero values, nil and empty
+// slices and maps alike, and do not distinguish integer widths, since the
+// codecs do not preserve those distinctions.
+
+// verifyReplyMethod is the ServiceMethod of the control frame a client sends
+// to report the digest of a decoded reply. The frame's Seq is that of the
+// verified call and it receives no response.
+const verifyReplyMethod = "_rpc.VerifyReply"
+
+// ReplyMismatch describes a reply that the client decoded to a different
+// value than the server sent.
+type ReplyMismatch struct {
+	ServiceMethod string
+	Seq           uint64
+	SourceAddr    net.Addr
+	Sent          uint64 // digest of the reply the server encoded
+	Received      uint64 // digest of the reply the client decoded
+}
+
+// WithReplyVerification makes the server honor clients that asked for reply
+// verification with Client.VerifyReplies. onMismatch is called for every
+// reply that did not survive the round trip; if it is nil, mismatches are
+// logged.
+func WithReplyVerification(onMismatch func(ReplyMismatch)) func(*Server) {
+	return func(s *Server) {
+		s.replyVerifier = &replyVerifier{
+			onMismatch: onMismatch,
+			pending:    make(map[ServerCodec]map[uint64]sentReply),
+		}
+	}
+}
+
+// VerifyReplies enables or disables reply verification for calls sent after
+// it returns. Servers without reply verification ignore the request.
+func (client *Client) VerifyReplies(enable bool) {
+	client.reqMutex.Lock()
+	client.verifyReplies = enable
+	client.reqMutex.Unlock()
+}
+
+// replyDigest is the body of a verifyReplyMethod control frame.
+type replyDigest struct {
+	Digest uint64
+}
+
+// sendReplyDigest sends the control frame reporting the digest of the reply
+// to call seq.
+func (client *Client) sendReplyDigest(seq, digest uint64) {
+	client.reqMutex.Lock()
+	defer client.reqMutex.Unlock()
+	client.mutex.Lock()
+	done := client.shutdown || client.closing
+	client.mutex.Unlock()
+	if done {
+		return
+	}
+	client.request = Request{ServiceMethod: verifyReplyMethod, Seq: seq}
+	err := client.codec.WriteRequest(&client.request, &replyDigest{Digest: digest})
+	if debugLog && err != nil {
+		log.Println("rpc: writing reply digest:", err)
+	}
+}
+
+type sentReply struct {
+	serviceMethod string
+	digest        uint64
+}
+
+type replyVerifier struct {
+	onMismatch func(ReplyMismatch)
+
+	mu      sync.Mutex // protects pending
+	pending map[ServerCodec]map[uint64]sentReply
+}
+
+// expect records the digest of the reply about to be sent for req.
+func (v *replyVerifier) expect(codec ServerCodec, req *Request, reply interface{}) {
+	sent := sentReply{serviceMethod: req.ServiceMethod, digest: digestReply(reply)}
+	v.mu.Lock()
+	seqs := v.pending[codec]
+	if seqs == nil {
+		seqs = make(map[uint64]sentReply)
+		v.pending[codec] = seqs
+	}
+	seqs[req.Seq] = sent
+	v.mu.Unlock()
+}
+
+// check compares the digest the client reported for seq with the one sent.
+func (v *replyVerifier) check(logger Logger, codec ServerCodec, seq, received uint64) {
+	v.mu.Lock()
+	sent, ok := v.pending[codec][seq]
+	delete(v.pending[codec], seq)
+	v.mu.Unlock()
+	if !ok || sent.digest == received {
+		return
+	}
+	mismatch := ReplyMismatch{
+		ServiceMethod: sent.serviceMethod,
+		Seq:           seq,
+		SourceAddr:    codec.SourceAddr(),
+		Sent:          sent.digest,
+		Received:      received,
+	}
+	if v.onMismatch == nil {
+		logger.Warn("rpc: reply decoded differently than it was sent", "serviceMethod", mismatch.ServiceMethod, "seq", seq, "sourceAddr", mismatch.SourceAddr)
+		return
+	}
+	v.onMismatch(mismatch)
+}
+
+// forget drops the digests still pending for codec.
+func (v *replyVerifier) forget(codec ServerCodec) {
+	v.mu.Lock()
+	delete(v.pending, codec)
+	v.mu.Unlock()
+}
+
+// isReplyDigest reports whether req is a reply verification control frame
+// this server should handle.
+func (server *Server) isReplyDigest(req *Request) bool {
+	return server.replyVerifier != nil && req.ServiceMethod == verifyReplyMethod
+}
+
+// verifyReply reads the body of a reply verification control frame and
+// checks the digest it carries.
+func (server *Server) verifyReply(codec ServerCodec, req *Request, bodyRead func()) error {
+	defer server.freeRequest(req)
+	var d replyDigest
+	if err := codec.ReadRequestBody(&d); err != nil {
+		return err
+	}
+	if bodyRead != nil {
+		bodyRead()
+	}
+	server.replyVerifier.check(server.logger(), codec, req.Seq, d.Digest)
+	return nil
+}
+
+// maxDigestDepth bounds the recursion of digestReply on cyclic values.
+const maxDigestDepth = 64
+
+// digestReply returns a digest of the wire-visible content of v.
+func digestReply(v interface{}) uint64 {
+	var buf bytes.Buffer
+	writeDigest(&buf, reflect.ValueOf(v), 0)
+	h := fnv.New64a()
+	h.Write(buf.Bytes())
+	return h.Sum64()
+}
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+
+func writeDigest(buf *bytes.Buffer, v reflect.Value, depth int) {
+	if depth > maxDigestDepth || isEmptyValue(v, depth) {
+		buf.WriteByte(0)
+		return
+	}
+	if v.Type().Implements(binaryMarshalerType) && v.CanInterface() {
+		if data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+			writeDigestBytes(buf, 'b', data)
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		writeDigest(buf, v.Elem(), depth+1)
+	case reflect.Bool:
+		buf.WriteByte('t')
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n < 0 {
+			writeDigestUint(buf, 'i', uint64(n))
+		} else {
+			writeDigestUint(buf, 'u', uint64(n))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeDigestUint(buf, 'u', v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeDigestUint(buf, 'f', math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		writeDigestUint(buf, 'c', math.Float64bits(real(v.Complex())))
+		writeDigestUint(buf, 'c', math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		writeDigestBytes(buf, 's', []byte(v.String()))
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			writeDigestBytes(buf, 's', data)
+			return
+		}
+		writeDigestUint(buf, 'l', uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			writeDigest(buf, v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		// Map iteration order is random, so entries are sorted by their
+		// encoded form.
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var entry bytes.Buffer
+			writeDigest(&entry, iter.Key(), depth+1)
+			writeDigest(&entry, iter.Value(), depth+1)
+			entries = append(entries, entry.String())
+		}
+		sort.Strings(entries)
+		writeDigestUint(buf, 'm', uint64(len(entries)))
+		for _, entry := range entries {
+			buf.WriteString(entry)
+		}
+	case reflect.Struct:
+		buf.WriteByte('{')
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || isEmptyValue(v.Field(i), depth+1) {
+				continue
+			}
+			writeDigestBytes(buf, 'n', []byte(f.Name))
+			writeDigest(buf, v.Field(i), depth+1)
+		}
+		buf.WriteByte('}')
+	default:
+		// Channels and functions are not encoded.
+		buf.WriteByte(0)
+	}
+}
+
+func writeDigestUint(buf *bytes.Buffer, tag byte, n uint64) {
+	var num [8]byte
+	binary.BigEndian.PutUint64(num[:], n)
+	buf.WriteByte(tag)
+	buf.Write(num[:])
+}
+
+func writeDigestBytes(buf *bytes.Buffer, tag byte, data []byte) {
+	writeDigestUint(buf, tag, uint64(len(data)))
+	buf.Write(data)
+}
+
+// isEmptyValue reports whether v is a value the codecs may omit or decode as
+// nil: a zero value, an empty slice or map, a pointer to an empty value, or a
+// struct whose exported fields are all empty.
+func isEmptyValue(v reflect.Value, depth int) bool {
+	if !v.IsValid() || v.IsZero() || depth > maxDigestDepth {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return isEmptyValue(v.Elem(), depth+1)
+	case reflect.Struct:
+		if v.Type().Implements(binaryMarshalerType) {
+			return false
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() && !isEmptyValue(v.Field(i), depth+1) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+// Lossy drops B when encoded, so it does not survive a round trip.
+type Lossy struct {
+	A, B int
+}
+
+func (l Lossy) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(l.A)
+	return buf.Bytes(), err
+}
+
+func (l *Lossy) GobDecode(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&l.A)
+}
+
+type LossyService struct{}
+
+func (LossyService) Get(args *Args, reply *Lossy) error {
+	reply.A, reply.B = args.A, args.B
+	return nil
+}
+
+func TestReplyVerification(t *testing.T) {
+	mismatches := make(chan ReplyMismatch, 10)
+	srv := NewServerWithOpts(WithReplyVerification(func(m ReplyMismatch) {
+		mismatches <- m
+	}))
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Register(LossyService{}); err != nil {
+		t.Fatal(err)
+	}
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+	client.VerifyReplies(true)
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	lossy := new(Lossy)
+	if err := client.Call("LossyService.Get", &Args{1, 2}, lossy); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-mismatches:
+		if m.ServiceMethod != "LossyService.Get" {
+			t.Errorf("unexpected mismatch for %s", m.ServiceMethod)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a mismatch for the lossy reply")
+	}
+
+	// Wait for every digest to be checked before asserting there was no
+	// mismatch for Arith.Add.
+	for {
+		srv.replyVerifier.mu.Lock()
+		pending := 0
+		for _, seqs := range srv.replyVerifier.pending {
+			pending += len(seqs)
+		}
+		srv.replyVerifier.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case m := <-mismatches:
+		t.Errorf("unexpected mismatch for %s", m.ServiceMethod)
+	default:
+	}
+}
+
+func TestReplyVerificationNotNegotiated(t *testing.T) {
+	// A server without reply verification ignores the client's request.
+	srv, _, client := startBlockerServer(t)
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	client.VerifyReplies(true)
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 15 {
+		t.Errorf("expected 15, got %d", reply.C)
+	}
+}
+
+func TestDigestReply(t *testing.T) {
+	type inner struct {
+		N int
+	}
+	type outer struct {
+		S      []int
+		M      map[string]int
+		P      *inner
+		I      interface{}
+		hidden int
+	}
+	same := [][2]interface{}{
+		{&outer{}, &outer{S: []int{}, M: map[string]int{}, P: &inner{}}},
+		{&outer{hidden: 1}, &outer{}},
+		{&outer{M: map[string]int{"a": 1, "b": 2}}, &outer{M: map[string]int{"b": 2, "a": 1}}},
+		{&outer{I: int(3)}, &outer{I: uint64(3)}},
+		{int8(-1), int64(-1)},
+	}
+	for _, pair := range same {
+		if digestReply(pair[0]) != digestReply(pair[1]) {
+			t.Errorf("expected %#v and %#v to have the same digest", pair[0], pair[1])
+		}
+	}
+	different := [][2]interface{}{
+		{&outer{S: []int{1}}, &outer{S: []int{2}}},
+		{&outer{P: &inner{N: 1}}, &outer{}},
+		{&outer{I: "1"}, &outer{I: 1}},
+		{time.Unix(1, 0), time.Unix(2, 0)},
+	}
+	for _, pair := range different {
+		if digestReply(pair[0]) == digestReply(pair[1]) {
+			t.Errorf("expected %#v and %#v to have different digests", pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webSocketGUID is appended to the key of a handshake to compute its
+// Sec-WebSocket-Accept, as RFC 6455 specifies.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocketHandler serves RPC connections tunneled over WebSocket, for
+// clients behind proxies that only pass HTTP and for browser-based
+// tooling. Each connection carries the same stream of requests and
+// responses as a TCP connection would, in binary messages; message
+// boundaries are ignored.
+type WebSocketHandler struct {
+	Server *Server
+	// NewCodec returns the codec serving a connection. If nil, the gob
+	// codec NewClient uses serves it. Browsers can use the JSON codec of
+	// the jsonrpc package.
+	NewCodec func(conn net.Conn) ServerCodec
+	// CheckOrigin reports whether to accept a handshake from a browser
+	// page at the request's Origin. If nil, only requests without an
+	// Origin or whose Origin has the host of the request are accepted, so
+	// that other sites cannot call the server with their visitors'
+	// credentials.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// ServeHTTP performs the WebSocket handshake and serves the connection's
+// requests concurrently with ServeRequestAsync until it closes.
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "rpc: expected a WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "rpc: unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "rpc: missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	checkOrigin := h.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "rpc: origin not allowed", http.StatusForbidden)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "rpc: connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		h.Server.logger().Debug("rpc: hijacking WebSocket connection", "sourceAddr", r.RemoteAddr, "error", err)
+		return
+	}
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &webSocketConn{Conn: conn, r: brw.Reader, tls: r.TLS}
+	newCodec := h.NewCodec
+	if newCodec == nil {
+		newCodec = newGobServerCodec
+	}
+	codec := newCodec(ws)
+	defer codec.Close()
+	for h.Server.ServeRequestAsync(context.Background(), codec) == nil {
+	}
+}
+
+// sameOrigin reports whether r has no Origin or one with the host of r.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerHasToken reports whether the comma-separated header name of h
+// contains token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WebSocketConfig configures the WebSocket connections of clients.
+type WebSocketConfig struct {
+	// TLSConfig is used for wss:// URLs. If nil, the default configuration
+	// is used, verifying the server's certificate for the URL's host.
+	TLSConfig *tls.Config
+	// Header is sent with the handshake, for example to authenticate to a
+	// proxy in front of the server.
+	Header http.Header
+}
+
+// WebSocketTransport connects to the WebSocketHandler at rawURL, a ws:// or
+// wss:// URL, regardless of the network and address it is asked to dial.
+// config may be nil.
+func WebSocketTransport(rawURL string, config *WebSocketConfig) Transport {
+	return Transport{
+		Name: "websocket",
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialWebSocket(ctx, rawURL, config)
+		},
+	}
+}
+
+// DialWebSocket connects to the WebSocketHandler at rawURL, a ws:// or
+// wss:// URL, and returns a client using the gob codec with options.
+// config may be nil.
+func DialWebSocket(ctx context.Context, rawURL string, config *WebSocketConfig, options ...func(*Client)) (*Client, error) {
+	conn, err := dialWebSocket(ctx, rawURL, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithOpts(newGobClientCodec(conn), options...), nil
+}
+
+func dialWebSocket(ctx context.Context, rawURL string, config *WebSocketConfig) (net.Conn, error) {
+	if config == nil {
+		config = new(WebSocketConfig)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var secure bool
+	switch u.Scheme {
+	case "ws", "http":
+	case "wss", "https":
+		secure = true
+	default:
+		return nil, errors.New("rpc: unsupported WebSocket URL scheme " + u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	var conn net.Conn
+	if secure {
+		tlsConfig := config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		}
+		d := tls.Dialer{Config: tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	br, err := webSocketHandshake(conn, u, config.Header)
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "dial-websocket", Net: "tcp", Addr: conn.RemoteAddr(), Err: err}
+	}
+	return &webSocketConn{Conn: conn, r: br, client: true}, nil
+}
+
+// webSocketHandshake asks the server on conn to switch to WebSocket,
+// returning the reader of what it sends next.
+func webSocketHandshake(conn net.Conn, u *url.URL, header http.Header) (*bufio.Reader, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header.Clone(),
+		Host:       u.Host,
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, errors.New("invalid Sec-WebSocket-Accept")
+	}
+	return br, nil
+}
+
+// webSocketConn carries a byte stream in the messages of a WebSocket
+// connection: each Write is sent as a binary message, and Read returns
+// the payloads of the data frames received, in order.
+type webSocketConn struct {
+	net.Conn
+	r      *bufio.Reader
+	client bool                 // frames written are masked, as clients must
+	tls    *tls.ConnectionState // of the HTTP request, on the server
+
+	readMu    sync.Mutex // protects following
+	remaining uint64     // bytes left in the payload of the frame being read
+	mask      [4]byte    // of the frame being read, if masked
+	masked    bool
+	maskPos   int
+	readErr   error
+
+	writeMu sync.Mutex // held while writing a frame
+	closed  bool       // a close frame was sent
+}
+
+// ConnectionState returns the TLS state of the HTTP request the
+// connection was upgraded from, so that SourceAddrOf reports it.
+func (c *webSocketConn) ConnectionState() tls.ConnectionState {
+	if c.tls == nil {
+		return tls.ConnectionState{}
+	}
+	return *c.tls
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if err := c.nextFrame(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= uint64(n)
+	if c.masked {
+		for i := range p[:n] {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until that of a data frame, answering the
+// control frames read on the way.
+func (c *webSocketConn) nextFrame() error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return err
+		}
+		opcode := hdr[0] & 0xf
+		c.masked = hdr[1]&0x80 != 0
+		length := uint64(hdr[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if c.masked {
+			if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+				return err
+			}
+		}
+		c.maskPos = 0
+
+		switch opcode {
+		case wsContinuation, wsText, wsBinary:
+			c.remaining = length
+			return nil
+		case wsClose, wsPing, wsPong:
+			if length > 125 {
+				return errors.New("rpc: WebSocket control frame too long")
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.r, payload); err != nil {
+				return err
+			}
+			if c.masked {
+				for i := range payload {
+					payload[i] ^= c.mask[i&3]
+				}
+			}
+			switch opcode {
+			case wsClose:
+				// Echo the status code, if any, and stop.
+				if len(payload) > 2 {
+					payload = payload[:2]
+				}
+				c.writeFrame(wsClose, payload)
+				return io.EOF
+			case wsPing:
+				if _, err := c.writeFrame(wsPong, payload); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("rpc: unknown WebSocket opcode %d", opcode)
+		}
+	}
+}
+
+// Write sends p as one binary message.
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	return c.writeFrame(wsBinary, p)
+}
+
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+	frame := make([]byte, 2, 14+len(payload))
+	frame[0] = 0x80 | opcode // FIN
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		frame[1] |= 0x80
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return 0, err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i&3])
+		}
+	}
+	if _, err := c.Conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// Close sends a close frame, without waiting for the peer's, and closes
+// the connection.
+func (c *webSocketConn) Close() error {
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000: normal closure
+	return c.Conn.Close()
+}
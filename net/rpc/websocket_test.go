@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type WebSocketEcho struct{}
+
+func (WebSocketEcho) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func TestWebSocket(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Register(WebSocketEcho{}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(&WebSocketHandler{Server: srv})
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/rpc"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := DialWebSocket(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		var reply Reply
+		if err := client.Call("Arith.Add", Args{i, 1000}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.C != i+1000 {
+			t.Errorf("Add: got %d, want %d", reply.C, i+1000)
+		}
+	}
+	// A large argument spans frames of 64-bit lengths on the way.
+	var echo string
+	big := strings.Repeat("x", 1<<17)
+	if err := client.Call("WebSocketEcho.Echo", big, &echo); err != nil {
+		t.Fatal(err)
+	}
+	if echo != big {
+		t.Errorf("Echo: got %d bytes, want %d", len(echo), len(big))
+	}
+
+	d := NewDialer(WithTransports(WebSocketTransport(url, &WebSocketConfig{Header: http.Header{"X-Test": {"1"}}})))
+	client2, err := d.DialContext(ctx, "tcp", "ignored:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	var reply Reply
+	if err := client2.Call("Arith.Mul", Args{6, 7}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 42 {
+		t.Errorf("Mul: got %d, want 42", reply.C)
+	}
+}
+
+func TestWebSocketRejected(t *testing.T) {
+	ts := httptest.NewServer(&WebSocketHandler{Server: NewServer()})
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin handshake: got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := DialWebSocket(ctx, ts.URL+"/", &WebSocketConfig{Header: http.Header{"Origin": {"https://evil.example"}}}); err == nil {
+		t.Error("DialWebSocket: expected the cross-origin handshake to fail")
+	}
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example of RFC 6455, section 1.3.
+	if got, want := webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
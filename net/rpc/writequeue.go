@@ -0,0 +1,217 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"path"
+	"sync"
+	"time"
+)
+
+// WritePriority orders responses waiting to be written to the same
+// connection. Lower values are written first.
+type WritePriority int
+
+const (
+	// WritePriorityControl is used for error responses, including requests
+	// rejected during shutdown.
+	WritePriorityControl WritePriority = iota
+	// WritePrioritySmall is used for ordinary responses.
+	WritePrioritySmall
+	// WritePriorityBulk is used for responses of methods registered with
+	// WithBulkMethod and for forwarded responses larger than
+	// bulkResponseSize.
+	WritePriorityBulk
+
+	numWritePriorities = iota
+)
+
+const (
+	// DefaultWriteQueueLimit is the number of responses that may wait to be
+	// written to one connection before handlers finishing later block.
+	DefaultWriteQueueLimit = 64
+
+	// maxBypass is the number of times a waiting response may be passed
+	// over by responses of higher priority before it is written next.
+	maxBypass = 8
+
+	// bulkResponseSize is the encoded size above which forwarded responses
+	// are written as bulk.
+	bulkResponseSize = 64 << 10
+)
+
+// WithWriteQueueLimit sets the number of responses that may wait to be
+// written to a single connection. Handlers that finish while the queue is
+// full block until there is room. Zero or less uses DefaultWriteQueueLimit.
+func WithWriteQueueLimit(n int) func(*Server) {
+	return func(s *Server) {
+		s.writeQueueLimit = n
+	}
+}
+
+// WithBulkMethod writes responses of methods matching pattern with
+// WritePriorityBulk, behind control frames and small responses waiting on
+// the same connection. Patterns use path.Match syntax against
+// "Service.Method".
+func WithBulkMethod(pattern string) func(*Server) {
+	return func(s *Server) {
+		s.bulkMethods = append(s.bulkMethods, pattern)
+	}
+}
+
+func (server *Server) isBulkMethod(serviceMethod string) bool {
+	for _, pattern := range server.bulkMethods {
+		if ok, _ := path.Match(pattern, serviceMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePriorityStats reports the responses written with one priority.
+type WritePriorityStats struct {
+	Writes   uint64
+	Wait     time.Duration // total time responses waited to be written
+	MaxWait  time.Duration
+	Promoted uint64 // responses written early to avoid starving them
+}
+
+// WriteQueueStats reports the state of the server's per-connection write
+// queues.
+type WriteQueueStats struct {
+	Depth      int // responses currently waiting, across all connections
+	MaxDepth   int // the most responses ever waiting on one connection
+	Priorities [numWritePriorities]WritePriorityStats
+}
+
+// WriteQueueStats returns the current WriteQueueStats.
+func (server *Server) WriteQueueStats() WriteQueueStats {
+	server.writeStats.mu.Lock()
+	defer server.writeStats.mu.Unlock()
+	return server.writeStats.stats
+}
+
+type writeQueueStats struct {
+	mu    sync.Mutex // protects stats
+	stats WriteQueueStats
+}
+
+func (s *writeQueueStats) enqueued(depth int) {
+	s.mu.Lock()
+	s.stats.Depth++
+	if depth > s.stats.MaxDepth {
+		s.stats.MaxDepth = depth
+	}
+	s.mu.Unlock()
+}
+
+func (s *writeQueueStats) written(priority WritePriority, queued, promoted bool, wait time.Duration) {
+	s.mu.Lock()
+	if queued {
+		s.stats.Depth--
+	}
+	p := &s.stats.Priorities[priority]
+	p.Writes++
+	p.Wait += wait
+	if wait > p.MaxWait {
+		p.MaxWait = wait
+	}
+	if promoted {
+		p.Promoted++
+	}
+	s.mu.Unlock()
+}
+
+// writeQueue serializes the responses written to one connection. When
+// several are waiting, the one with the best priority is written first,
+// unless a response has been passed over maxBypass times, in which case it
+// goes next.
+type writeQueue struct {
+	stats *writeQueueStats
+	limit int
+
+	mu      sync.Mutex // protects following
+	room    *sync.Cond // signaled when a waiting response is dequeued
+	busy    bool
+	waiting []*queuedWrite // in arrival order
+}
+
+type queuedWrite struct {
+	priority WritePriority
+	bypassed int
+	promoted bool
+	ready    chan struct{}
+}
+
+func newWriteQueue(limit int, stats *writeQueueStats) *writeQueue {
+	if limit <= 0 {
+		limit = DefaultWriteQueueLimit
+	}
+	q := &writeQueue{stats: stats, limit: limit}
+	q.room = sync.NewCond(&q.mu)
+	return q
+}
+
+// Lock waits until a response of priority p may be written. Every Lock must
+// be followed by a call to Unlock once the response is written.
+func (q *writeQueue) Lock(p WritePriority) {
+	start := time.Now()
+	q.mu.Lock()
+	for q.busy && len(q.waiting) >= q.limit {
+		q.room.Wait()
+	}
+	if !q.busy {
+		// Nothing is waiting while the connection is idle.
+		q.busy = true
+		q.mu.Unlock()
+		q.stats.written(p, false, false, time.Since(start))
+		return
+	}
+	w := &queuedWrite{priority: p, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	q.stats.enqueued(len(q.waiting))
+	q.mu.Unlock()
+
+	<-w.ready
+	q.stats.written(p, true, w.promoted, time.Since(start))
+}
+
+// Unlock hands the connection to the next waiting response, if any.
+func (q *writeQueue) Unlock() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) == 0 {
+		q.busy = false
+		return
+	}
+	// Responses ahead of the first one with the best priority have a worse
+	// priority, so they are the only ones that can have been passed over.
+	next := q.best()
+	for i, w := range q.waiting[:next] {
+		if w.bypassed >= maxBypass {
+			next = i
+			w.promoted = true
+			break
+		}
+	}
+	w := q.waiting[next]
+	for _, earlier := range q.waiting[:next] {
+		earlier.bypassed++
+	}
+	q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+	q.room.Signal()
+	close(w.ready)
+}
+
+// best returns the index of the first waiting response with the best
+// priority.
+func (q *writeQueue) best() int {
+	best := 0
+	for i, w := range q.waiting {
+		if w.priority < q.waiting[best].priority {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,160 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until q has n responses waiting.
+func waitQueued(t *testing.T, q *writeQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mu.Lock()
+		waiting := len(q.waiting)
+		q.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiting responses, got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queueWrites starts a writer for each priority, one at a time so they are
+// queued in order, and returns the order in which they were let through.
+func queueWrites(t *testing.T, q *writeQueue, priorities []WritePriority) func() []WritePriority {
+	var (
+		mu    sync.Mutex
+		order []WritePriority
+		wg    sync.WaitGroup
+	)
+	for i, p := range priorities {
+		wg.Add(1)
+		go func(p WritePriority) {
+			defer wg.Done()
+			q.Lock(p)
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+			q.Unlock()
+		}(p)
+		waitQueued(t, q, i+1)
+	}
+	return func() []WritePriority {
+		wg.Wait()
+		return order
+	}
+}
+
+func TestWriteQueuePriority(t *testing.T) {
+	var stats writeQueueStats
+	q := newWriteQueue(0, &stats)
+	q.Lock(WritePrioritySmall)
+
+	wait := queueWrites(t, q, []WritePriority{WritePriorityBulk, WritePrioritySmall, WritePriorityControl, WritePrioritySmall})
+	q.Unlock()
+	order := wait()
+
+	expected := []WritePriority{WritePriorityControl, WritePrioritySmall, WritePrioritySmall, WritePriorityBulk}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected write order %v, got %v", expected, order)
+	}
+	s := stats.stats
+	if s.Depth != 0 || s.MaxDepth != 4 {
+		t.Errorf("expected depth 0 and max depth 4, got %d and %d", s.Depth, s.MaxDepth)
+	}
+	if s.Priorities[WritePrioritySmall].Writes != 3 || s.Priorities[WritePriorityBulk].Writes != 1 {
+		t.Errorf("unexpected write counts %+v", s.Priorities)
+	}
+	if s.Priorities[WritePriorityBulk].MaxWait == 0 {
+		t.Error("expected the bulk response's wait to be recorded")
+	}
+}
+
+func TestWriteQueueStarvation(t *testing.T) {
+	var stats writeQueueStats
+	q := newWriteQueue(0, &stats)
+	q.Lock(WritePrioritySmall)
+
+	priorities := []WritePriority{WritePriorityBulk}
+	for i := 0; i < maxBypass+2; i++ {
+		priorities = append(priorities, WritePriorityControl)
+	}
+	wait := queueWrites(t, q, priorities)
+	q.Unlock()
+	order := wait()
+
+	for i, p := range order {
+		if p == WritePriorityBulk {
+			if i != maxBypass {
+				t.Errorf("expected bulk response to be written after %d others, was %d", maxBypass, i)
+			}
+			break
+		}
+	}
+	if promoted := stats.stats.Priorities[WritePriorityBulk].Promoted; promoted != 1 {
+		t.Errorf("expected 1 promoted response, got %d", promoted)
+	}
+}
+
+func TestWriteQueueLimit(t *testing.T) {
+	var stats writeQueueStats
+	q := newWriteQueue(1, &stats)
+	q.Lock(WritePrioritySmall)
+
+	wait := queueWrites(t, q, []WritePriority{WritePrioritySmall})
+	blocked := make(chan struct{})
+	go func() {
+		q.Lock(WritePriorityControl)
+		q.Unlock()
+		close(blocked)
+	}()
+
+	select {
+	case <-blocked:
+		t.Fatal("expected writer to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.Unlock()
+	wait()
+	<-blocked
+}
+
+func TestWriteQueueStatsServer(t *testing.T) {
+	srv := NewServerWithOpts(WithBulkMethod("Arith.Mul"))
+	srv.Register(new(Arith))
+	l, addr := listenTCP(t)
+	go accept(srv, l)
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Arith.Mul", &Args{7, 8}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Arith.Div", Args{7, 0}, reply); err == nil {
+		t.Fatal("expected divide by zero")
+	}
+
+	stats := srv.WriteQueueStats()
+	for p, ps := range stats.Priorities {
+		if ps.Writes != 1 {
+			t.Errorf("expected 1 write with priority %d, got %d", p, ps.Writes)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// WriteRetryPolicy configures retries of response writes that fail with a
+// transient error before writing anything. Retries happen below the codec,
+// on the connection. A write that failed part way is not retried: the peer
+// has read part of a message, and the rest of the stream would no longer
+// line up with what it expects.
+type WriteRetryPolicy struct {
+	// MaxAttempts is the number of times a write is attempted, including the
+	// first. Values below 2 disable retries.
+	MaxAttempts int
+	// Backoff returns the delay before the given retry, counting from 1. It
+	// defaults to ExponentialBackoff(10*time.Millisecond, time.Second).
+	Backoff func(retry int) time.Duration
+	// Timeout, if positive, is set as the connection's write deadline before
+	// every attempt, so a peer that stops reading is retried rather than
+	// blocking the connection forever.
+	Timeout time.Duration
+	// IsTransient reports whether a write that failed with err may be
+	// retried. It defaults to IsTransientWriteError.
+	IsTransient func(err error) bool
+}
+
+// WriteRetryStats counts the response writes that failed.
+type WriteRetryStats struct {
+	Retries   uint64 // attempts made after a transient failure
+	Recovered uint64 // writes that succeeded after failing
+	Fatal     uint64 // writes that failed for good
+}
+
+// IsTransientWriteError reports whether err is a timeout, or an error the
+// kernel reports when a write would block or it is short of buffers. Errors
+// that mean the peer is gone, such as a reset connection, are not transient.
+func IsTransientWriteError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EWOULDBLOCK) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.EINTR)
+}
+
+// WithWriteRetry makes the connections the server serves with
+// ServeConnContext, and so Accept, and with ServeTLS retry writes that fail
+// with a transient error before writing anything. Connections served
+// otherwise can be wrapped with WrapConn.
+func WithWriteRetry(policy WriteRetryPolicy) func(*Server) {
+	return func(s *Server) {
+		if policy.Backoff == nil {
+			policy.Backoff = ExponentialBackoff(10*time.Millisecond, time.Second)
+		}
+		if policy.IsTransient == nil {
+			policy.IsTransient = IsTransientWriteError
+		}
+		s.writeRetry = &writeRetrier{policy: policy}
+	}
+}
+
+// WrapConn returns conn wrapped to apply the server's write retry policy, for
+// connections the server does not accept itself. The server's codecs should
+// be created on the returned connection. Without WithWriteRetry, conn is
+// returned unchanged.
+func (server *Server) WrapConn(conn net.Conn) net.Conn {
+	if server.writeRetry == nil {
+		return conn
+	}
+	return &retryConn{Conn: conn, r: server.writeRetry}
+}
+
+// WriteRetryStats returns the failed writes counted on the connections the
+// write retry policy applies to.
+func (server *Server) WriteRetryStats() WriteRetryStats {
+	if server.writeRetry == nil {
+		return WriteRetryStats{}
+	}
+	r := server.writeRetry
+	return WriteRetryStats{
+		Retries:   r.retries.Load(),
+		Recovered: r.recovered.Load(),
+		Fatal:     r.fatal.Load(),
+	}
+}
+
+type writeRetrier struct {
+	policy WriteRetryPolicy
+
+	retries   atomic.Uint64
+	recovered atomic.Uint64
+	fatal     atomic.Uint64
+}
+
+type retryConn struct {
+	net.Conn
+	r *writeRetrier
+}
+
+func (c *retryConn) Write(p []byte) (int, error) {
+	policy := &c.r.policy
+	for attempt := 1; ; attempt++ {
+		if policy.Timeout > 0 {
+			c.Conn.SetWriteDeadline(time.Now().Add(policy.Timeout))
+		}
+		n, err := c.Conn.Write(p)
+		if err == nil {
+			if attempt > 1 {
+				c.r.recovered.Add(1)
+			}
+			return n, nil
+		}
+		if n > 0 || attempt >= policy.MaxAttempts || !policy.IsTransient(err) {
+			c.r.fatal.Add(1)
+			return n, err
+		}
+		c.r.retries.Add(1)
+		time.Sleep(policy.Backoff(attempt))
+	}
+}
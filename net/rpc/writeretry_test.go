@@ -0,0 +1,141 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// stallingConn writes at most chunk bytes per call. It fails the first
+// failures calls with err, after writing partial bytes of them.
+type stallingConn struct {
+	net.Conn
+	buf       bytes.Buffer
+	chunk     int
+	partial   int
+	failures  int
+	err       error
+	deadlines int
+}
+
+func (c *stallingConn) Write(p []byte) (int, error) {
+	if c.failures > 0 {
+		c.failures--
+		c.buf.Write(p[:c.partial])
+		return c.partial, c.err
+	}
+	n := len(p)
+	if n > c.chunk {
+		n = c.chunk
+	}
+	c.buf.Write(p[:n])
+	if n < len(p) {
+		return n, errors.New("short write")
+	}
+	return n, nil
+}
+
+func (c *stallingConn) SetWriteDeadline(time.Time) error {
+	c.deadlines++
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWriteRetry(t *testing.T) {
+	srv := NewServerWithOpts(WithWriteRetry(WriteRetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+		Timeout:     time.Second,
+	}))
+
+	// A write that times out before writing anything is made again.
+	stalled := &stallingConn{chunk: 8, failures: 2, err: timeoutError{}}
+	conn := srv.WrapConn(stalled)
+	n, err := conn.Write([]byte("abcdefgh"))
+	if err != nil || n != 8 {
+		t.Fatalf("expected the write to recover, got %d, %v", n, err)
+	}
+	if got := stalled.buf.String(); got != "abcdefgh" {
+		t.Errorf("expected each byte written once, got %q", got)
+	}
+	if stalled.deadlines != 3 {
+		t.Errorf("expected a write deadline per attempt, got %d", stalled.deadlines)
+	}
+
+	// One that times out part way through is not.
+	partial := &stallingConn{chunk: 8, partial: 3, failures: 1, err: timeoutError{}}
+	conn = srv.WrapConn(partial)
+	if n, err := conn.Write([]byte("abcdefgh")); n != 3 || err == nil {
+		t.Errorf("expected the partial write to fail, got %d, %v", n, err)
+	}
+	if got := partial.buf.String(); got != "abc" {
+		t.Errorf("expected only the partial write, got %q", got)
+	}
+
+	// Transient errors are retried up to MaxAttempts; others are not.
+	conn = srv.WrapConn(&stallingConn{chunk: 8, failures: 5, err: syscall.EAGAIN})
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("expected EAGAIN after the last attempt, got %v", err)
+	}
+	conn = srv.WrapConn(&stallingConn{chunk: 8, failures: 1, err: syscall.ECONNRESET})
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected ECONNRESET, got %v", err)
+	}
+
+	stats := srv.WriteRetryStats()
+	expected := WriteRetryStats{Retries: 4, Recovered: 1, Fatal: 3}
+	if stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	if plain := NewServer(); plain.WrapConn(stalled) != net.Conn(stalled) {
+		t.Error("expected WrapConn to return the connection without WithWriteRetry")
+	}
+}
+
+// blockedConn fails its first failures writes with EAGAIN, writing nothing.
+type blockedConn struct {
+	net.Conn
+	failures int
+}
+
+func (c *blockedConn) Write(p []byte) (int, error) {
+	if c.failures > 0 {
+		c.failures--
+		return 0, syscall.EAGAIN
+	}
+	return c.Conn.Write(p)
+}
+
+func TestWriteRetryServe(t *testing.T) {
+	srv := NewServerWithOpts(WithWriteRetry(WriteRetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+	}))
+	srv.Register(new(Arith))
+	cli, conn := net.Pipe()
+	go srv.ServeConn(&blockedConn{Conn: conn, failures: 1})
+
+	client := NewClient(cli)
+	defer client.Close()
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{1, 2}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 3 {
+		t.Errorf("expected 3, got %d", reply.C)
+	}
+	// The server retried the blocked write.
+	waitFor(t, func() bool { return srv.WriteRetryStats().Recovered == 1 })
+}
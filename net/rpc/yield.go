@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// defaultYieldQuantum is the time a handler runs before CheckCancel yields,
+// unless set with WithYieldQuantum.
+const defaultYieldQuantum = 10 * time.Millisecond
+
+// WithYieldQuantum sets how long a handler runs before CheckCancel gives
+// its slot of the WithMaxConcurrentRequests limit to a waiting request. A
+// shorter quantum lets queued requests start sooner, at the cost of more
+// switching between handlers.
+func WithYieldQuantum(quantum time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.yieldQuantum = quantum
+	}
+}
+
+// CheckCancel returns ctx.Err() once the call ctx belongs to is cancelled
+// or times out, so that CPU-bound handlers, which never block on anything
+// watching ctx, can stop working for callers that gave up. Such handlers
+// should call it regularly, for example with CancelChecker.
+//
+// It also lets other requests run. The calling goroutine yields the
+// processor, and on a server with a WithMaxConcurrentRequests limit, a
+// handler that has run for longer than its quantum (see WithYieldQuantum)
+// while requests wait for a slot gives its slot up and waits for one again,
+// behind them, so that a few hot methods cannot hold every slot. CheckCancel
+// returns ctx.Err() if the call is cancelled while waiting.
+func CheckCancel(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var ys *yieldState
+	if rv := requestValuesFrom(ctx); rv != nil {
+		ys = rv.yield
+	}
+	if ys == nil {
+		runtime.Gosched()
+		return nil
+	}
+	return ys.yield(ctx)
+}
+
+// CancelChecker returns a function for the loops of CPU-bound handlers to
+// call on every iteration. It calls CheckCancel on every n-th call, and
+// otherwise only returns the error CheckCancel last returned, so that
+// iterations too short to check each time can still be checked regularly.
+func CancelChecker(ctx context.Context, n int) func() error {
+	if n < 1 {
+		n = 1
+	}
+	calls := 0
+	var err error
+	return func() error {
+		if err != nil {
+			return err
+		}
+		if calls++; calls%n == 0 {
+			err = CheckCancel(ctx)
+		}
+		return err
+	}
+}
+
+// yieldState records the slot of the global concurrency limit a handler
+// holds, so that CheckCancel can give it up and take it back.
+type yieldState struct {
+	quantum time.Duration
+	limits  *requestLimits
+
+	mu      sync.Mutex // protects following
+	limiter *concurrencyLimiter
+	held    bool      // the handler holds a slot of limiter
+	done    bool      // the request's slots were released
+	since   time.Time // when the handler last took its slot
+}
+
+// setYield makes the concurrency limits record the slot they admit the
+// request of rv to, if the server has a global limit.
+func (server *Server) setYield(rv *requestValues) {
+	if server.limits == nil || server.limits.global == nil {
+		return
+	}
+	quantum := server.yieldQuantum
+	if quantum <= 0 {
+		quantum = defaultYieldQuantum
+	}
+	rv.yield = &yieldState{quantum: quantum, limits: server.limits}
+}
+
+// hold records that the handler holds a slot of limiter.
+func (ys *yieldState) hold(limiter *concurrencyLimiter) {
+	ys.mu.Lock()
+	ys.limiter = limiter
+	ys.held = true
+	ys.since = time.Now()
+	ys.mu.Unlock()
+}
+
+// release ends the request, reporting whether it still holds its slot,
+// which the caller must then release.
+func (ys *yieldState) release() bool {
+	ys.mu.Lock()
+	defer ys.mu.Unlock()
+	held := ys.held
+	ys.held = false
+	ys.done = true
+	return held
+}
+
+func (ys *yieldState) yield(ctx context.Context) error {
+	ys.mu.Lock()
+	limiter := ys.limiter
+	if !ys.held || time.Since(ys.since) < ys.quantum || limiter.waiting.Load() == 0 {
+		ys.mu.Unlock()
+		runtime.Gosched()
+		return nil
+	}
+	ys.held = false
+	ys.mu.Unlock()
+	limiter.release()
+	ys.limits.yields.Add(1)
+	runtime.Gosched()
+
+	// Wait for a slot again. The request took its place in the queue when
+	// it arrived, so the queue's bound does not apply.
+	limiter.waiting.Add(1)
+	defer limiter.waiting.Add(-1)
+	select {
+	case limiter.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	ys.mu.Lock()
+	defer ys.mu.Unlock()
+	if ys.done {
+		// The request ended while the handler, which ignored its
+		// context, waited.
+		limiter.release()
+		return ctx.Err()
+	}
+	ys.held = true
+	ys.since = time.Now()
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Spinner has a CPU-bound method that runs until Stop is called.
+type Spinner struct {
+	stop chan struct{}
+}
+
+func (s *Spinner) Spin(ctx context.Context, args struct{}, reply *int) error {
+	check := CancelChecker(ctx, 100)
+	for {
+		select {
+		case <-s.stop:
+			return nil
+		default:
+		}
+		if err := check(); err != nil {
+			return err
+		}
+		*reply++
+	}
+}
+
+func (s *Spinner) Stop(args struct{}, reply *struct{}) error {
+	close(s.stop)
+	return nil
+}
+
+func TestCheckCancel(t *testing.T) {
+	srv := NewServerWithOpts(WithMaxConcurrentRequests(1, -1), WithYieldQuantum(time.Millisecond))
+	spinner := &Spinner{stop: make(chan struct{})}
+	if err := srv.Register(spinner); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial("tcp", startAsyncServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// A spinning handler gives its slot to the request that stops it.
+	spin := client.Go("Spinner.Spin", struct{}{}, new(int), nil)
+	waitFor(t, func() bool { return srv.ConcurrencyStats().Running == 1 })
+	if err := client.Call("Spinner.Stop", struct{}{}, new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := (<-spin.Done).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stats := srv.ConcurrencyStats(); stats.Yields == 0 || stats.Running != 0 {
+		t.Errorf("expected the handler to yield and release its slot, got %+v", stats)
+	}
+
+	// Handlers stop once their caller gives up.
+	spinner.stop = make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.CallContext(ctx, "Spinner.Spin", struct{}{}, new(int)); err == nil {
+		t.Fatal("expected the call to time out")
+	}
+	waitFor(t, func() bool { return srv.ConcurrencyStats().Running == 0 })
+}
+
+func TestCancelChecker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	check := CancelChecker(ctx, 3)
+	for i := 0; i < 3; i++ {
+		if err := check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	if err := check(); err != nil {
+		t.Fatalf("expected the error to wait for the third call, got %v", err)
+	}
+	check()
+	if err := check(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := check(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the error to stick, got %v", err)
+	}
+}